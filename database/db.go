@@ -14,6 +14,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"gorm.io/driver/sqlite"
@@ -32,12 +33,25 @@ func initModels() error {
 	models := []any{
 		&model.User{},
 		&model.Inbound{},
+		&model.InboundClient{},
+		&model.ClientUser{},
 		&model.Outbound{},
 		&model.OutboundTraffics{},
 		&model.Setting{},
 		&model.InboundClientIps{},
 		&xray.ClientTraffic{},
 		&model.HistoryOfSeeders{},
+		&model.APIToken{},
+		&model.AuditLog{},
+		&model.TrafficHistory{},
+		&model.SubAccessLog{},
+		&model.ClientConnection{},
+		&model.IpBan{},
+		&model.XrayCrash{},
+		&model.SubToken{},
+		&model.EndpointProfile{},
+		&model.Webhook{},
+		&model.WebhookDelivery{},
 	}
 	for _, model := range models {
 		if err := db.AutoMigrate(model); err != nil {
@@ -112,6 +126,73 @@ func runSeeders(isUsersEmpty bool) error {
 	return nil
 }
 
+// seedExternalTrafficWebhook turns the former external traffic inform setting into a legacy
+// traffic.updated webhook, which keeps posting the bare traffic body, and removes the setting.
+func seedExternalTrafficWebhook() error {
+	var seedersHistory []string
+	db.Model(&model.HistoryOfSeeders{}).Pluck("seeder_name", &seedersHistory)
+	if slices.Contains(seedersHistory, "ExternalTrafficWebhook") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		keys := []string{"externalTrafficInformEnable", "externalTrafficInformURI"}
+		var settings []model.Setting
+		if err := tx.Where("key IN ?", keys).Find(&settings).Error; err != nil {
+			return err
+		}
+		values := make(map[string]string, len(settings))
+		for _, setting := range settings {
+			values[setting.Key] = setting.Value
+		}
+		if uri := values["externalTrafficInformURI"]; uri != "" {
+			webhook := &model.Webhook{
+				Name:   "External traffic inform",
+				Url:    uri,
+				Secret: random.Seq(32),
+				Events: model.WebhookEventTrafficUpdated,
+				Legacy: true,
+				Enable: values["externalTrafficInformEnable"] == "true",
+			}
+			if err := tx.Create(webhook).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("key IN ?", keys).Delete(model.Setting{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.HistoryOfSeeders{SeederName: "ExternalTrafficWebhook"}).Error
+	})
+}
+
+// initInboundClients fills the clients table from the settings of inbounds that have no client
+// rows yet, e.g. after an upgrade that did not run the migrate command.
+func initInboundClients() error {
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).
+		Where("NOT EXISTS (SELECT 1 FROM inbound_clients WHERE inbound_clients.inbound_id = inbounds.id)").
+		Find(&inbounds).Error
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, inbound := range inbounds {
+			clients, err := model.ParseInboundClients(inbound)
+			if err != nil {
+				log.Printf("Unable to read the clients of inbound %d: %v", inbound.Id, err)
+				continue
+			}
+			if len(clients) == 0 {
+				continue
+			}
+			if err := tx.CreateInBatches(clients, 100).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // isTableEmpty returns true if the named table contains zero rows.
 func isTableEmpty(tableName string) (bool, error) {
 	var count int64
@@ -155,7 +236,13 @@ func InitDB(dbPath string) error {
 	if err := initUser(); err != nil {
 		return err
 	}
-	return runSeeders(isUsersEmpty)
+	if err := runSeeders(isUsersEmpty); err != nil {
+		return err
+	}
+	if err := seedExternalTrafficWebhook(); err != nil {
+		return err
+	}
+	return initInboundClients()
 }
 
 // CloseDB closes the database connection if it exists.
@@ -2,7 +2,11 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
 	"github.com/mhsanaei/3x-ui/v2/xray"
@@ -24,11 +28,121 @@ const (
 	WireGuard    Protocol = "wireguard"
 )
 
+// Role constants for panel users
+const (
+	RoleOwner    = "owner"    // Full access to the panel, its settings and user management
+	RoleOperator = "operator" // Manages clients of every inbound, no access to settings or the database
+	RoleReadOnly = "readonly" // View-only access to inbounds and server status
+	RoleReseller = "reseller" // Manages only the inbounds it owns
+)
+
 // User represents a user account in the 3x-ui panel.
 type User struct {
 	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Role     string `json:"role" gorm:"default:owner"`
+}
+
+// IsValidRole reports whether role is one of the known user roles.
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleOwner, RoleOperator, RoleReadOnly, RoleReseller:
+		return true
+	}
+	return false
+}
+
+// HasRole reports whether the user has one of the given roles.
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessAllInbounds reports whether the user may see inbounds owned by other users.
+func (u *User) CanAccessAllInbounds() bool {
+	return u.Role != RoleReseller
+}
+
+// API token scopes. A write scope also grants the matching read scope.
+const (
+	ScopeInboundsRead    = "inbounds:read"
+	ScopeInboundsWrite   = "inbounds:write"
+	ScopeClientsWrite    = "clients:write"
+	ScopeOutboundsRead   = "outbounds:read"
+	ScopeOutboundsWrite  = "outbounds:write"
+	ScopeServerRead      = "server:read"
+	ScopeServerAdmin     = "server:admin"
+	ScopeUsersAdmin      = "users:admin"
+	ScopeAuditRead       = "audit:read"
+	ScopeConnectionsRead = "connections:read" // Query the client connection log
+	ScopeWebhooksAdmin   = "webhooks:admin"
+	ScopeNodeSub         = "node:sub" // Pull subscription links for aggregation by another panel
+)
+
+// scopeImplies lists the scopes that are implicitly granted by another scope.
+var scopeImplies = map[string][]string{
+	ScopeInboundsWrite:  {ScopeInboundsRead},
+	ScopeClientsWrite:   {ScopeInboundsRead},
+	ScopeOutboundsWrite: {ScopeOutboundsRead},
+	ScopeServerAdmin:    {ScopeServerRead},
+}
+
+// IsValidScope reports whether scope is one of the known API token scopes.
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopeInboundsRead, ScopeInboundsWrite, ScopeClientsWrite,
+		ScopeOutboundsRead, ScopeOutboundsWrite,
+		ScopeServerRead, ScopeServerAdmin, ScopeUsersAdmin, ScopeAuditRead, ScopeConnectionsRead, ScopeWebhooksAdmin, ScopeNodeSub:
+		return true
+	}
+	return false
+}
+
+// APIToken is a named, revocable bearer token for the panel API.
+// Only the SHA-256 hash of the token is stored; it acts on behalf of its user
+// and is limited to both the user's role and the token's scopes.
+type APIToken struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId     int    `json:"userId" gorm:"index"`
+	Name       string `json:"name" form:"name"`
+	TokenHash  string `json:"-" gorm:"unique"`
+	Prefix     string `json:"prefix"`                       // First characters of the token, to tell tokens apart
+	Scopes     string `json:"scopes" form:"scopes"`         // Comma-separated list of scopes
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Expiration timestamp in milliseconds, 0 means never
+	LastUsed   int64  `json:"lastUsed" gorm:"default:0"`    // Last use timestamp in milliseconds
+	LastUsedIp string `json:"lastUsedIp"`                   // Remote address of the last call
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// GetScopes returns the token scopes as a slice.
+func (t *APIToken) GetScopes() []string {
+	var scopes []string
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether the token grants the given scope, directly or implicitly.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, granted := range t.GetScopes() {
+		if granted == scope || slices.Contains(scopeImplies[granted], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the token has expired at the given time in milliseconds.
+func (t *APIToken) IsExpired(now int64) bool {
+	return t.ExpiryTime > 0 && t.ExpiryTime <= now
 }
 
 // Inbound represents an Xray inbound configuration with traffic statistics and settings.
@@ -54,6 +168,10 @@ type Inbound struct {
 	StreamSettings string   `json:"streamSettings" form:"streamSettings"`
 	Tag            string   `json:"tag" form:"tag" gorm:"unique"`
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
+
+	// Subscription template overrides, empty to use the templates of the settings
+	SubRemarkTemplate string `json:"subRemarkTemplate" form:"subRemarkTemplate"`
+	SubJsonTemplate   string `json:"subJsonTemplate" form:"subJsonTemplate"`
 }
 
 // OutboundTraffics tracks traffic statistics for Xray outbound connections.
@@ -92,6 +210,253 @@ type InboundClientIps struct {
 	Ips         string `json:"ips" form:"ips"`
 }
 
+// Actor types of audit log entries
+const (
+	AuditActorUser   = "user"   // Panel user with a session login
+	AuditActorToken  = "token"  // API token
+	AuditActorTgBot  = "tgbot"  // Telegram bot admin
+	AuditActorLdap   = "ldap"   // LDAP sync job
+	AuditActorSystem = "system" // Other background jobs
+)
+
+// AuditLog records an administrative action together with who performed it.
+type AuditLog struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time      int64  `json:"time" gorm:"index"`      // Timestamp in milliseconds
+	ActorType string `json:"actorType" gorm:"index"` // One of the AuditActor* constants
+	Actor     string `json:"actor" gorm:"index"`     // Username, token name, Telegram chat id or job name
+	UserId    int    `json:"userId"`                 // Panel user behind the actor, 0 if none
+	Action    string `json:"action" gorm:"index"`    // e.g. inbound.update or client.resetTraffic
+	Target    string `json:"target" gorm:"index"`    // e.g. inbound:3, client:alice or settings
+	Before    string `json:"before"`                 // JSON of the changed fields before the action
+	After     string `json:"after"`                  // JSON of the changed fields after the action
+	Ip        string `json:"ip"`                     // Source IP of the request, if any
+}
+
+// Webhook events
+const (
+	WebhookEventClientCreated     = "client.created"      // A client was added to an inbound
+	WebhookEventClientDepleted    = "client.depleted"     // A client used up its traffic quota and was disabled
+	WebhookEventClientExpired     = "client.expired"      // A client reached its expiry time and was disabled
+	WebhookEventClientDisabled    = "client.disabled"     // A client was disabled, automatically or manually
+	WebhookEventInboundUpdated    = "inbound.updated"     // An inbound configuration was changed
+	WebhookEventXrayCrashed       = "xray.crashed"        // Xray was found crashed
+	WebhookEventXrayCrashLoop     = "xray.crashloop"      // Xray crashed too often in a row, restarts are backed off
+	WebhookEventLoginFailed       = "login.failed"        // A panel login attempt failed
+	WebhookEventLdapSyncCompleted = "ldap.sync.completed" // An LDAP sync run finished
+	WebhookEventTrafficUpdated    = "traffic.updated"     // Traffic deltas were collected from Xray, every 10 seconds
+	WebhookEventSubAnomaly        = "sub.anomaly"         // A subscription was fetched from suspiciously many IPs
+	WebhookEventPing              = "ping"                // Test delivery, only sent on request
+)
+
+// webhookEvents lists the events a webhook can subscribe to.
+var webhookEvents = []string{
+	WebhookEventClientCreated, WebhookEventClientDepleted, WebhookEventClientExpired, WebhookEventClientDisabled,
+	WebhookEventInboundUpdated, WebhookEventXrayCrashed, WebhookEventXrayCrashLoop, WebhookEventLoginFailed, WebhookEventLdapSyncCompleted,
+	WebhookEventTrafficUpdated, WebhookEventSubAnomaly,
+}
+
+// IsValidWebhookEvent reports whether a webhook can subscribe to the event. "*" subscribes to all
+// events but traffic.updated.
+func IsValidWebhookEvent(event string) bool {
+	return event == "*" || slices.Contains(webhookEvents, event)
+}
+
+// Webhook is an HTTP endpoint that receives signed event notifications.
+type Webhook struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" form:"name"`
+	Url       string `json:"url" form:"url"`
+	Secret    string `json:"secret,omitempty" form:"secret"` // Key of the HMAC-SHA256 payload signature, only returned on creation
+	Events    string `json:"events" form:"events"`           // Comma-separated list of events, * for all events but traffic.updated
+	Legacy    bool   `json:"legacy" form:"legacy"`           // Post only the event data without the X-XUI headers, like the former external traffic inform
+	Enable    bool   `json:"enable" form:"enable"`
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// Subscribes reports whether the webhook wants to receive the event. "*" does not cover the ping
+// and the frequent traffic updates, these are only received when subscribed to by name.
+func (w *Webhook) Subscribes(event string) bool {
+	for _, e := range strings.Split(w.Events, ",") {
+		e = strings.TrimSpace(e)
+		if e == event || (e == "*" && event != WebhookEventPing && event != WebhookEventTrafficUpdated) {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook delivery states
+const (
+	WebhookDeliveryPending = "pending" // Waiting for its first or next attempt
+	WebhookDeliverySuccess = "success" // Accepted by the endpoint with a 2xx response
+	WebhookDeliveryFailed  = "failed"  // Given up after the maximum number of attempts
+)
+
+// WebhookDelivery is one event sent to one webhook. Pending deliveries form the retry
+// queue, finished ones the delivery log.
+type WebhookDelivery struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	WebhookId    int    `json:"webhookId" gorm:"index"`
+	Event        string `json:"event"`
+	Payload      string `json:"payload"`
+	Status       string `json:"status" gorm:"index"` // One of the WebhookDelivery* constants
+	Attempts     int    `json:"attempts"`
+	NextAttempt  int64  `json:"nextAttempt" gorm:"index"` // Timestamp in milliseconds of the next attempt
+	ResponseCode int    `json:"responseCode"`
+	LastError    string `json:"lastError"`
+	CreatedAt    int64  `json:"createdAt" gorm:"autoCreateTime:milli;index"`
+	UpdatedAt    int64  `json:"updatedAt" gorm:"autoUpdateTime:milli"`
+}
+
+// Kinds of traffic history series
+const (
+	TrafficKindClient   = "client"   // Name is the client email
+	TrafficKindInbound  = "inbound"  // Name is the inbound tag
+	TrafficKindOutbound = "outbound" // Name is the outbound tag
+)
+
+// TrafficHistory stores the traffic of a client, inbound or outbound within one time bucket.
+// Buckets are kept at several resolutions, coarser ones are rolled up from finer ones.
+type TrafficHistory struct {
+	Id   int    `json:"-" gorm:"primaryKey;autoIncrement"`
+	Kind string `json:"kind" gorm:"uniqueIndex:idx_traffic_history_bucket"` // One of the TrafficKind* constants
+	Name string `json:"name" gorm:"uniqueIndex:idx_traffic_history_bucket"` // Client email or inbound/outbound tag
+	Step int64  `json:"step" gorm:"uniqueIndex:idx_traffic_history_bucket"` // Bucket width in seconds
+	Time int64  `json:"time" gorm:"uniqueIndex:idx_traffic_history_bucket"` // Bucket start timestamp in milliseconds
+	Up   int64  `json:"up"`
+	Down int64  `json:"down"`
+}
+
+// SubAccessLog records one fetch of a subscription.
+type SubAccessLog struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time      int64  `json:"time" gorm:"index"`  // Timestamp in milliseconds
+	SubId     string `json:"subId" gorm:"index"` // Requested subscription ID
+	Ip        string `json:"ip"`                 // Source IP of the request
+	Network   string `json:"network"`            // Source network, the /24 of IPv4 or the /48 of IPv6 addresses, standing in for the ASN
+	UserAgent string `json:"userAgent"`
+	Format    string `json:"format"` // html, base64, plain, json, clash or singbox
+	Status    int    `json:"status"` // HTTP status of the response
+	Size      int    `json:"size"`   // Response body size in bytes
+}
+
+// ClientConnection records one connection of a client read from the Xray access log.
+type ClientConnection struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time        int64  `json:"time" gorm:"index"`  // Timestamp in milliseconds
+	Email       string `json:"email" gorm:"index"` // Email of the client
+	SourceIp    string `json:"sourceIp"`
+	Network     string `json:"network"`             // tcp or udp
+	Domain      string `json:"domain" gorm:"index"` // Destination domain, empty if the destination is an IP
+	Ip          string `json:"ip" gorm:"index"`     // Destination IP, empty if the destination is a domain
+	Port        int    `json:"port"`                // Destination port
+	InboundTag  string `json:"inboundTag"`
+	OutboundTag string `json:"outboundTag"`
+}
+
+// IpBan blocks a source IP of a client that exceeded its IP limit, enforced with Xray routing rules.
+type IpBan struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" gorm:"uniqueIndex:idx_ip_ban"` // Email of the client
+	Ip        string `json:"ip" gorm:"uniqueIndex:idx_ip_ban"`    // Blocked source IP
+	CreatedAt int64  `json:"createdAt"`                           // Timestamp in milliseconds of the first ban
+	ExpiresAt int64  `json:"expiresAt" gorm:"index"`              // Timestamp in milliseconds
+}
+
+// XrayCrash records a crash of the Xray process detected by the supervisor.
+type XrayCrash struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time       int64  `json:"time" gorm:"index"` // Timestamp in milliseconds
+	ExitCode   int    `json:"exitCode"`          // -1 if Xray was killed by a signal
+	Error      string `json:"error"`
+	LastLines  string `json:"lastLines"`  // Last output lines of the process, newline-separated
+	ConfigHash string `json:"configHash"` // SHA-256 of the configuration Xray ran with
+	RolledBack bool   `json:"rolledBack"` // The supervisor rolled back to the last good configuration after this crash
+}
+
+// SubToken is a former subscription ID that keeps resolving to the current subscription ID of its
+// clients until it expires, so that subscribers can pick up a rotated link.
+type SubToken struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Token     string `json:"token" gorm:"unique"`    // Former subscription ID
+	SubId     string `json:"subId" gorm:"index"`     // Current subscription ID
+	ExpiresAt int64  `json:"expiresAt" gorm:"index"` // Timestamp in milliseconds
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// Subscription formats that User-Agent rules or the format query parameter of the subscription
+// URL can select.
+const (
+	SubFormatBase64  = "base64"
+	SubFormatPlain   = "plain"
+	SubFormatJson    = "json"
+	SubFormatClash   = "clash"
+	SubFormatSingbox = "singbox"
+)
+
+// SubFormats lists the subscription formats.
+var SubFormats = []string{SubFormatBase64, SubFormatPlain, SubFormatJson, SubFormatClash, SubFormatSingbox}
+
+// Endpoint profile security modes, like the forceTls of external proxies.
+const (
+	EndpointSecuritySame = "same" // Keep the security of the inbound
+	EndpointSecurityTls  = "tls"
+	EndpointSecurityNone = "none"
+)
+
+// EndpointProfile is a named server override for subscriptions, e.g. a CDN or relay in front of
+// the panel. Subscriptions get an extra link with the overrides for every inbound and client the
+// profile applies to; empty fields keep the values of the inbound.
+type EndpointProfile struct {
+	Id            int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name          string `json:"name" form:"name" gorm:"unique"`
+	Address       string `json:"address" form:"address"` // Server address, empty for the host of the subscription request
+	Port          int    `json:"port" form:"port"`       // Server port, 0 for the inbound port
+	Security      string `json:"security" form:"security"`
+	Sni           string `json:"sni" form:"sni"`
+	Host          string `json:"host" form:"host"` // Host header of the transport, authority for gRPC
+	Alpn          string `json:"alpn" form:"alpn"` // Comma-separated ALPN protocols
+	Fingerprint   string `json:"fingerprint" form:"fingerprint"`
+	RemarkSuffix  string `json:"remarkSuffix" form:"remarkSuffix"`   // Appended to the link remarks, the name if empty
+	InboundIds    string `json:"inboundIds" form:"inboundIds"`       // Comma-separated inbound IDs, empty for all inbounds
+	ClientUserIds string `json:"clientUserIds" form:"clientUserIds"` // Comma-separated client user IDs, empty for all clients
+	Enable        bool   `json:"enable" form:"enable"`
+	CreatedAt     int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// GetInboundIds returns the IDs of the inbounds the profile is attached to.
+func (p *EndpointProfile) GetInboundIds() []int {
+	return splitIds(p.InboundIds)
+}
+
+// GetClientUserIds returns the IDs of the client users the profile is attached to.
+func (p *EndpointProfile) GetClientUserIds() []int {
+	return splitIds(p.ClientUserIds)
+}
+
+// AppliesToInbound reports whether the profile is attached to the inbound or to all inbounds.
+func (p *EndpointProfile) AppliesToInbound(inboundId int) bool {
+	return p.InboundIds == "" || slices.Contains(p.GetInboundIds(), inboundId)
+}
+
+// AppliesToClientUser reports whether the profile is attached to the client user or to all
+// clients. Clients without a user have the user ID 0 and only get profiles for all clients.
+func (p *EndpointProfile) AppliesToClientUser(userId int) bool {
+	return p.ClientUserIds == "" || (userId > 0 && slices.Contains(p.GetClientUserIds(), userId))
+}
+
+// splitIds parses a comma-separated list of IDs, skipping invalid entries.
+func splitIds(list string) []int {
+	var ids []int
+	for _, item := range strings.Split(list, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(item)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // HistoryOfSeeders tracks which database seeders have been executed to prevent re-running.
 type HistoryOfSeeders struct {
 	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -152,3 +517,166 @@ type Client struct {
 	CreatedAt  int64  `json:"created_at,omitempty"`         // Creation timestamp
 	UpdatedAt  int64  `json:"updated_at,omitempty"`         // Last update timestamp
 }
+
+// InboundClient is a client of an inbound stored in its own table. The clients array of
+// Inbound.Settings is generated from these rows, ordered by Position.
+type InboundClient struct {
+	Id         int    `json:"-" gorm:"primaryKey;autoIncrement"`
+	InboundId  int    `json:"inboundId" gorm:"index:idx_inbound_client_position,priority:1"`
+	Position   int    `json:"-" gorm:"index:idx_inbound_client_position,priority:2"` // Index in the clients array of the inbound settings
+	Uuid       string `json:"id" gorm:"index"`
+	Security   string `json:"security"`
+	Password   string `json:"password" gorm:"index"`
+	Flow       string `json:"flow"`
+	Email      string `json:"email" gorm:"index;index:idx_inbound_clients_email_lower,expression:lower(email)"`
+	LimitIP    int    `json:"limitIp"`
+	TotalGB    int64  `json:"totalGB"`
+	ExpiryTime int64  `json:"expiryTime" gorm:"index"`
+	Enable     bool   `json:"enable"`
+	TgID       int64  `json:"tgId" gorm:"index"`
+	SubID      string `json:"subId" gorm:"index"`
+	Comment    string `json:"comment"`
+	Reset      int    `json:"reset"`
+	UserId     int    `json:"userId" gorm:"index"` // ClientUser the client belongs to, 0 for none
+	CreatedAt  int64  `json:"created_at" gorm:"autoCreateTime:false"`
+	UpdatedAt  int64  `json:"updated_at" gorm:"autoUpdateTime:false"`
+	Settings   string `json:"-"` // Original JSON object of the client, keeps the fields without a column
+}
+
+// NewInboundClient creates the row of a client object taken from the clients array of inbound settings.
+func NewInboundClient(inboundId int, position int, raw map[string]any) *InboundClient {
+	c := &InboundClient{InboundId: inboundId, Position: position}
+	c.SetSettings(raw)
+	return c
+}
+
+// ParseInboundClients parses the clients array of the inbound settings into client rows.
+func ParseInboundClients(inbound *Inbound) ([]*InboundClient, error) {
+	var settings struct {
+		Clients []any `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, err
+	}
+	clients := make([]*InboundClient, 0, len(settings.Clients))
+	for _, raw := range settings.Clients {
+		if c, ok := raw.(map[string]any); ok {
+			clients = append(clients, NewInboundClient(inbound.Id, len(clients), c))
+		}
+	}
+	return clients, nil
+}
+
+// SetSettings replaces the client with a client object of inbound settings.
+func (c *InboundClient) SetSettings(raw map[string]any) {
+	b, _ := json.Marshal(raw)
+	client := Client{}
+	// Fields of an unexpected type are left empty, like GetClients does
+	json.Unmarshal(b, &client)
+	if _, ok := raw["enable"]; !ok {
+		// Xray always served clients without the flag
+		client.Enable = true
+	}
+	c.SetClient(&client)
+	c.Settings = string(b)
+}
+
+// SetClient copies the fields of a client into the columns.
+func (c *InboundClient) SetClient(client *Client) {
+	c.Uuid = client.ID
+	c.Security = client.Security
+	c.Password = client.Password
+	c.Flow = client.Flow
+	c.Email = client.Email
+	c.LimitIP = client.LimitIP
+	c.TotalGB = client.TotalGB
+	c.ExpiryTime = client.ExpiryTime
+	c.Enable = client.Enable
+	c.TgID = client.TgID
+	c.SubID = client.SubID
+	c.Comment = client.Comment
+	c.Reset = client.Reset
+	c.CreatedAt = client.CreatedAt
+	c.UpdatedAt = client.UpdatedAt
+}
+
+// Client returns the columns as a Client.
+func (c *InboundClient) Client() Client {
+	return Client{
+		ID:         c.Uuid,
+		Security:   c.Security,
+		Password:   c.Password,
+		Flow:       c.Flow,
+		Email:      c.Email,
+		LimitIP:    c.LimitIP,
+		TotalGB:    c.TotalGB,
+		ExpiryTime: c.ExpiryTime,
+		Enable:     c.Enable,
+		TgID:       c.TgID,
+		SubID:      c.SubID,
+		Comment:    c.Comment,
+		Reset:      c.Reset,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+	}
+}
+
+// Key returns the value identifying the client in the API of the protocol: the password
+// for trojan, the email for shadowsocks and the id otherwise.
+func (c *InboundClient) Key(protocol Protocol) string {
+	switch protocol {
+	case Trojan:
+		return c.Password
+	case Shadowsocks:
+		return c.Email
+	default:
+		return c.Uuid
+	}
+}
+
+// ClientMap returns the client object for the clients array of the inbound settings: the
+// original object with the values of the columns. Empty columns the original object did not
+// have are left out, so protocols keep their own set of fields.
+func (c *InboundClient) ClientMap() map[string]any {
+	m := map[string]any{}
+	json.Unmarshal([]byte(c.Settings), &m)
+	if m == nil {
+		m = map[string]any{}
+	}
+	b, _ := json.Marshal(c.Client())
+	columns := map[string]any{}
+	json.Unmarshal(b, &columns)
+	for key, value := range columns {
+		_, ok := m[key]
+		if ok || key == "email" || key == "enable" || (value != "" && value != float64(0) && value != false) {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// ClientKeyColumn returns the inbound_clients column holding the client key of the protocol, see InboundClient.Key.
+func ClientKeyColumn(protocol Protocol) string {
+	switch protocol {
+	case Trojan:
+		return "password"
+	case Shadowsocks:
+		return "email"
+	default:
+		return "uuid"
+	}
+}
+
+// ClientUser is a person owning clients on several inbounds. The traffic limit, expiry time
+// and IP limit of the user are shared by all of its clients, which also share its subscription.
+type ClientUser struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name" gorm:"unique"`
+	SubID      string `json:"subId" form:"subId" gorm:"unique"`
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`       // Traffic limit in bytes of all clients together, 0 for unlimited
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Expiration timestamp in milliseconds, 0 for never
+	LimitIP    int    `json:"limitIp" form:"limitIp"`       // Number of distinct IPs of all clients together, 0 for unlimited
+	Enable     bool   `json:"enable" form:"enable"`
+	Comment    string `json:"comment" form:"comment"`
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
@@ -4,6 +4,7 @@ package sub
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
 	"html/template"
 	"io"
@@ -72,6 +73,22 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 
 	engine := gin.Default()
 
+	// The client IP is only taken from the headers of requests of trusted proxies
+	trustedProxies, err := s.settingService.GetSubTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	var proxies []string
+	for proxy := range strings.SplitSeq(trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := engine.SetTrustedProxies(proxies); err != nil {
+		return nil, err
+	}
+	engine.RemoteIPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+
 	subDomain, err := s.settingService.GetSubDomain()
 	if err != nil {
 		return nil, err
@@ -97,6 +114,28 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		return nil, err
 	}
 
+	ClashPath, err := s.settingService.GetSubClashPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine if Clash/Mihomo subscription endpoint is enabled
+	subClashEnable, err := s.settingService.GetSubClashEnable()
+	if err != nil {
+		return nil, err
+	}
+
+	SingboxPath, err := s.settingService.GetSubSingboxPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine if sing-box subscription endpoint is enabled
+	subSingboxEnable, err := s.settingService.GetSubSingboxEnable()
+	if err != nil {
+		return nil, err
+	}
+
 	// Set base_path based on LinksPath for template rendering
 	// Ensure LinksPath ends with "/" for proper asset URL generation
 	basePath := LinksPath
@@ -148,11 +187,70 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		SubJsonRules = ""
 	}
 
+	SubSingboxDns, err := s.settingService.GetSubSingboxDns()
+	if err != nil {
+		SubSingboxDns = ""
+	}
+
+	SubSingboxRules, err := s.settingService.GetSubSingboxRules()
+	if err != nil {
+		SubSingboxRules = ""
+	}
+
+	SubFormatRules, err := s.settingService.GetSubFormatRules()
+	if err != nil {
+		SubFormatRules = ""
+	}
+
 	SubTitle, err := s.settingService.GetSubTitle()
 	if err != nil {
 		SubTitle = ""
 	}
 
+	SubRemarkTemplate, err := s.settingService.GetSubRemarkTemplate()
+	if err != nil {
+		SubRemarkTemplate = ""
+	}
+
+	SubTitleTemplate, err := s.settingService.GetSubTitleTemplate()
+	if err != nil {
+		SubTitleTemplate = ""
+	}
+
+	SubJsonTemplate, err := s.settingService.GetSubJsonTemplate()
+	if err != nil {
+		SubJsonTemplate = ""
+	}
+
+	SubNodes, err := s.settingService.GetSubNodes()
+	if err != nil {
+		SubNodes = ""
+	}
+
+	SubNodeCacheTtl, err := s.settingService.GetSubNodeCacheTtl()
+	if err != nil {
+		SubNodeCacheTtl = 60
+	}
+
+	var SubPageBranding SubPageBranding
+	SubPageBranding.Logo, _ = s.settingService.GetSubPageLogo()
+	SubPageBranding.Color, _ = s.settingService.GetSubPageColor()
+	SubPageBranding.Support, _ = s.settingService.GetSubPageSupport()
+	SubPageBranding.Announcement, _ = s.settingService.GetSubPageAnnouncement()
+
+	SubPageTranslations, err := s.settingService.GetSubPageTranslations()
+	if err != nil {
+		SubPageTranslations = ""
+	}
+
+	var SubSignKey ed25519.PrivateKey
+	if SubSignEnable, _ := s.settingService.GetSubSignEnable(); SubSignEnable {
+		SubSignKey, err = s.settingService.GetSubSignKey()
+		if err != nil {
+			logger.Warning("Unable to load the subscription signing key, subscriptions are served unsigned:", err)
+		}
+	}
+
 	// set per-request localizer from headers/cookies
 	engine.Use(locale.LocalizerMiddleware())
 
@@ -230,8 +328,11 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	g := engine.Group("/")
 
 	s.sub = NewSUBController(
-		g, LinksPath, JsonPath, subJsonEnable, Encrypt, ShowInfo, RemarkModel, SubUpdates,
-		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle)
+		g, LinksPath, JsonPath, subJsonEnable, ClashPath, subClashEnable, SingboxPath, subSingboxEnable,
+		Encrypt, ShowInfo, RemarkModel, SubUpdates,
+		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubSingboxDns, SubSingboxRules, SubTitle, SubFormatRules,
+		SubRemarkTemplate, SubTitleTemplate, SubJsonTemplate, SubNodes, SubNodeCacheTtl,
+		SubPageBranding, SubPageTranslations, SubSignKey)
 
 	return engine, nil
 }
@@ -0,0 +1,313 @@
+package sub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// clashRules routes private networks directly and everything else through the proxy group.
+var clashRules = []string{
+	"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+	"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+	"MATCH,PROXY",
+}
+
+// SubClashService handles Clash/Mihomo YAML subscription generation.
+type SubClashService struct {
+	inboundService service.InboundService
+	SubService     *SubService
+	subJsonService *SubJsonService
+}
+
+// NewSubClashService creates a new Clash/Mihomo subscription service. The stream settings are
+// extracted the same way as for the JSON subscription.
+func NewSubClashService(subJsonService *SubJsonService) *SubClashService {
+	return &SubClashService{
+		SubService:     subJsonService.SubService,
+		subJsonService: subJsonService,
+	}
+}
+
+// GetClash generates a Clash/Mihomo configuration for the given subscription ID and host.
+func (s *SubClashService) GetClash(subId string, host string) (string, string, error) {
+	inbounds, err := s.SubService.getInboundsBySubId(subId)
+	if err != nil || len(inbounds) == 0 {
+		return "", "", err
+	}
+
+	var clientTraffics []xray.ClientTraffic
+	var proxies []yaml.MapSlice
+	names := make(map[string]bool)
+	var proxyNames []string
+	profiles := s.SubService.getEndpointProfiles(subId)
+
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			logger.Error("SubClashService - GetClients: Unable to get clients from inbound")
+		}
+		if clients == nil {
+			continue
+		}
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.SubService.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+
+		expanded := s.SubService.endpointInbounds(inbound, profiles, host)
+		for _, client := range clients {
+			if client.Enable && client.SubID == subId {
+				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
+				for _, endpoint := range expanded {
+					for _, proxy := range s.getProxies(endpoint, client, host) {
+						name := uniqueRemark(names, proxy[0].Value.(string))
+						proxy[0].Value = name
+						proxyNames = append(proxyNames, name)
+						proxies = append(proxies, proxy)
+					}
+				}
+			}
+		}
+	}
+
+	if len(proxies) == 0 {
+		return "", "", nil
+	}
+
+	traffic := s.SubService.sumClientTraffics(subId, clientTraffics)
+
+	config := yaml.MapSlice{
+		{Key: "mixed-port", Value: 7890},
+		{Key: "allow-lan", Value: false},
+		{Key: "mode", Value: "rule"},
+		{Key: "log-level", Value: "info"},
+		{Key: "proxies", Value: proxies},
+		{Key: "proxy-groups", Value: []yaml.MapSlice{
+			{
+				{Key: "name", Value: "PROXY"},
+				{Key: "type", Value: "select"},
+				{Key: "proxies", Value: append([]string{"AUTO"}, proxyNames...)},
+			},
+			{
+				{Key: "name", Value: "AUTO"},
+				{Key: "type", Value: "url-test"},
+				{Key: "url", Value: "https://www.gstatic.com/generate_204"},
+				{Key: "interval", Value: 300},
+				{Key: "proxies", Value: proxyNames},
+			},
+		}},
+		{Key: "rules", Value: clashRules},
+	}
+	result, err := yaml.MarshalWithOptions(config, yaml.IndentSequence(true))
+	if err != nil {
+		return "", "", err
+	}
+
+	header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
+	return string(result), header, nil
+}
+
+// getProxies builds the Mihomo proxies of a client, one for each external proxy of the inbound.
+func (s *SubClashService) getProxies(inbound *model.Inbound, client model.Client, host string) []yaml.MapSlice {
+	var proxies []yaml.MapSlice
+	stream := s.subJsonService.streamData(inbound.StreamSettings)
+
+	externalProxies, ok := stream["externalProxy"].([]any)
+	if !ok || len(externalProxies) == 0 {
+		externalProxies = []any{
+			map[string]any{
+				"forceTls": "same",
+				"dest":     host,
+				"port":     float64(inbound.Port),
+				"remark":   "",
+			},
+		}
+	}
+
+	for _, ep := range externalProxies {
+		extPrxy := ep.(map[string]any)
+		security, _ := stream["security"].(string)
+		switch extPrxy["forceTls"].(string) {
+		case "tls":
+			security = "tls"
+		case "none":
+			security = "none"
+		}
+
+		proxy := yaml.MapSlice{
+			{Key: "name", Value: s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string))},
+			{Key: "type", Value: ""},
+			{Key: "server", Value: extPrxy["dest"].(string)},
+			{Key: "port", Value: int(extPrxy["port"].(float64))},
+			{Key: "udp", Value: true},
+		}
+
+		switch inbound.Protocol {
+		case model.VMESS:
+			cipher := client.Security
+			if cipher == "" {
+				cipher = "auto"
+			}
+			proxy[1].Value = "vmess"
+			proxy = append(proxy,
+				yaml.MapItem{Key: "uuid", Value: client.ID},
+				yaml.MapItem{Key: "alterId", Value: 0},
+				yaml.MapItem{Key: "cipher", Value: cipher})
+		case model.VLESS:
+			proxy[1].Value = "vless"
+			proxy = append(proxy, yaml.MapItem{Key: "uuid", Value: client.ID})
+			if client.Flow != "" {
+				proxy = append(proxy, yaml.MapItem{Key: "flow", Value: client.Flow})
+			}
+		case model.Trojan:
+			proxy[1].Value = "trojan"
+			proxy = append(proxy, yaml.MapItem{Key: "password", Value: client.Password})
+		case model.Shadowsocks:
+			var inboundSettings map[string]any
+			json.Unmarshal([]byte(inbound.Settings), &inboundSettings)
+			method, _ := inboundSettings["method"].(string)
+			password := client.Password
+			// server password in multi-user 2022 protocols
+			if strings.HasPrefix(method, "2022") {
+				if serverPassword, ok := inboundSettings["password"].(string); ok {
+					password = fmt.Sprintf("%s:%s", serverPassword, client.Password)
+				}
+			}
+			proxy[1].Value = "ss"
+			proxy = append(proxy,
+				yaml.MapItem{Key: "cipher", Value: method},
+				yaml.MapItem{Key: "password", Value: password})
+		default:
+			return nil
+		}
+
+		// Mihomo has no transport options for shadowsocks other than plugins
+		if inbound.Protocol == model.Shadowsocks {
+			if network, _ := stream["network"].(string); network != "" && network != "tcp" {
+				logger.Warningf("SubClashService - network %v of inbound %d is not supported by Mihomo", network, inbound.Id)
+				return nil
+			}
+			proxies = append(proxies, proxy)
+			continue
+		}
+
+		proxy = append(proxy, s.clashTls(inbound.Protocol, security, stream)...)
+		network, ok := s.clashNetwork(stream)
+		if !ok {
+			logger.Warningf("SubClashService - network %v of inbound %d is not supported by Mihomo", stream["network"], inbound.Id)
+			return nil
+		}
+		proxies = append(proxies, append(proxy, network...))
+	}
+
+	return proxies
+}
+
+// clashTls converts the TLS or Reality settings of a stream to Mihomo proxy options.
+func (s *SubClashService) clashTls(protocol model.Protocol, security string, stream map[string]any) yaml.MapSlice {
+	var options yaml.MapSlice
+	// trojan always uses TLS and names the server name option sni
+	serverNameKey := "servername"
+	if protocol == model.Trojan {
+		serverNameKey = "sni"
+	} else if security == "tls" || security == "reality" {
+		options = append(options, yaml.MapItem{Key: "tls", Value: true})
+	}
+
+	switch security {
+	case "tls":
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		if serverName, _ := tlsSettings["serverName"].(string); serverName != "" {
+			options = append(options, yaml.MapItem{Key: serverNameKey, Value: serverName})
+		}
+		if alpn, _ := tlsSettings["alpn"].([]any); len(alpn) > 0 {
+			options = append(options, yaml.MapItem{Key: "alpn", Value: alpn})
+		}
+		if fingerprint, _ := tlsSettings["fingerprint"].(string); fingerprint != "" {
+			options = append(options, yaml.MapItem{Key: "client-fingerprint", Value: fingerprint})
+		}
+		if allowInsecure, _ := tlsSettings["allowInsecure"].(bool); allowInsecure {
+			options = append(options, yaml.MapItem{Key: "skip-cert-verify", Value: true})
+		}
+	case "reality":
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		if serverName, _ := realitySettings["serverName"].(string); serverName != "" {
+			options = append(options, yaml.MapItem{Key: serverNameKey, Value: serverName})
+		}
+		fingerprint, _ := realitySettings["fingerprint"].(string)
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		options = append(options,
+			yaml.MapItem{Key: "client-fingerprint", Value: fingerprint},
+			yaml.MapItem{Key: "reality-opts", Value: yaml.MapSlice{
+				{Key: "public-key", Value: realitySettings["publicKey"]},
+				{Key: "short-id", Value: realitySettings["shortId"]},
+			}})
+	}
+	return options
+}
+
+// clashNetwork converts the transport settings of a stream to Mihomo proxy options.
+// Returns false for transports Mihomo does not support.
+func (s *SubClashService) clashNetwork(stream map[string]any) (yaml.MapSlice, bool) {
+	network, _ := stream["network"].(string)
+	switch network {
+	case "", "tcp":
+		tcp, _ := stream["tcpSettings"].(map[string]any)
+		header, _ := tcp["header"].(map[string]any)
+		if typeStr, _ := header["type"].(string); typeStr != "http" {
+			return yaml.MapSlice{{Key: "network", Value: "tcp"}}, true
+		}
+		request, _ := header["request"].(map[string]any)
+		httpOpts := yaml.MapSlice{{Key: "method", Value: "GET"}}
+		if paths, _ := request["path"].([]any); len(paths) > 0 {
+			httpOpts = append(httpOpts, yaml.MapItem{Key: "path", Value: paths})
+		}
+		if host := searchHost(request["headers"]); host != "" {
+			httpOpts = append(httpOpts, yaml.MapItem{Key: "headers", Value: map[string][]string{"Host": {host}}})
+		}
+		return yaml.MapSlice{
+			{Key: "network", Value: "http"},
+			{Key: "http-opts", Value: httpOpts},
+		}, true
+	case "ws", "httpupgrade":
+		settings, _ := stream[network+"Settings"].(map[string]any)
+		wsOpts := yaml.MapSlice{{Key: "path", Value: settings["path"]}}
+		host, _ := settings["host"].(string)
+		if host == "" {
+			host = searchHost(settings["headers"])
+		}
+		if host != "" {
+			wsOpts = append(wsOpts, yaml.MapItem{Key: "headers", Value: map[string]string{"Host": host}})
+		}
+		if network == "httpupgrade" {
+			wsOpts = append(wsOpts, yaml.MapItem{Key: "v2ray-http-upgrade", Value: true})
+		}
+		return yaml.MapSlice{
+			{Key: "network", Value: "ws"},
+			{Key: "ws-opts", Value: wsOpts},
+		}, true
+	case "grpc":
+		grpc, _ := stream["grpcSettings"].(map[string]any)
+		return yaml.MapSlice{
+			{Key: "network", Value: "grpc"},
+			{Key: "grpc-opts", Value: yaml.MapSlice{{Key: "grpc-service-name", Value: grpc["serviceName"]}}},
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,128 @@
+package sub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/op/go-logging"
+)
+
+func TestSubClash(t *testing.T) {
+	t.Setenv("XUI_LOG_FOLDER", t.TempDir())
+	logger.InitLogger(logging.ERROR)
+	defer logger.CloseLogger()
+	if err := database.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	defer database.CloseDB()
+
+	inbounds := &service.InboundService{}
+	for _, inbound := range []*model.Inbound{
+		{Port: 20001, Protocol: model.VLESS, Remark: "vless",
+			Settings: `{"clients":[{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01","email":"c-vless","subId":"clash","enable":true}],"decryption":"none"}`,
+			StreamSettings: `{"network":"ws","security":"tls","wsSettings":{"path":"/ws","host":"cdn.example.com"},
+				"tlsSettings":{"serverName":"vless.example.com","settings":{"fingerprint":"chrome"}}}`},
+		{Port: 20002, Protocol: model.VMESS, Remark: "vmess",
+			Settings:       `{"clients":[{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","email":"c-vmess","subId":"clash","enable":true}]}`,
+			StreamSettings: `{"network":"tcp","security":"none","tcpSettings":{"header":{"type":"none"}}}`},
+		{Port: 20003, Protocol: model.Trojan, Remark: "trojan",
+			Settings:       `{"clients":[{"password":"trojan-pass","email":"c-trojan","subId":"clash","enable":true}]}`,
+			StreamSettings: `{"network":"grpc","security":"tls","grpcSettings":{"serviceName":"svc"},"tlsSettings":{"serverName":"trojan.example.com"}}`},
+		{Port: 20004, Protocol: model.Shadowsocks, Remark: "ss",
+			Settings:       `{"method":"aes-256-gcm","password":"","network":"tcp,udp","clients":[{"method":"","password":"ss-pass","email":"c-ss","subId":"clash","enable":true}]}`,
+			StreamSettings: `{"network":"tcp","security":"none"}`},
+	} {
+		inbound.Enable = true
+		inbound.Tag = "inbound-" + inbound.Remark
+		if _, _, err := inbounds.AddInbound(inbound); err != nil {
+			t.Fatalf("add %s inbound: %v", inbound.Remark, err)
+		}
+	}
+
+	subService := NewSubService(false, "-ieo", "", "")
+	clash := NewSubClashService(NewSubJsonService("", "", "", "", "", subService))
+	result, header, err := clash.GetClash("clash", "sub.example.com")
+	if err != nil || result == "" {
+		t.Fatalf("expected a clash config (%v)", err)
+	}
+	if !strings.HasPrefix(header, "upload=0; download=0;") {
+		t.Fatalf("unexpected header %q", header)
+	}
+
+	var config struct {
+		Proxies []struct {
+			Name       string `yaml:"name"`
+			Type       string `yaml:"type"`
+			Server     string `yaml:"server"`
+			Port       int    `yaml:"port"`
+			Uuid       string `yaml:"uuid"`
+			Password   string `yaml:"password"`
+			Cipher     string `yaml:"cipher"`
+			Network    string `yaml:"network"`
+			Tls        bool   `yaml:"tls"`
+			Servername string `yaml:"servername"`
+			Sni        string `yaml:"sni"`
+			WsOpts     struct {
+				Path    string            `yaml:"path"`
+				Headers map[string]string `yaml:"headers"`
+			} `yaml:"ws-opts"`
+			GrpcOpts struct {
+				ServiceName string `yaml:"grpc-service-name"`
+			} `yaml:"grpc-opts"`
+		} `yaml:"proxies"`
+		ProxyGroups []struct {
+			Name    string   `yaml:"name"`
+			Proxies []string `yaml:"proxies"`
+		} `yaml:"proxy-groups"`
+		Rules []string `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal([]byte(result), &config); err != nil {
+		t.Fatalf("parse clash config: %v\n%s", err, result)
+	}
+	if len(config.Proxies) != 4 || len(config.ProxyGroups) != 2 || len(config.ProxyGroups[0].Proxies) != 5 ||
+		config.Rules[len(config.Rules)-1] != "MATCH,PROXY" {
+		t.Fatalf("unexpected clash config\n%s", result)
+	}
+
+	for _, proxy := range config.Proxies {
+		if proxy.Server != "sub.example.com" {
+			t.Fatalf("unexpected server of %s: %q", proxy.Name, proxy.Server)
+		}
+		switch proxy.Type {
+		case "vless":
+			if proxy.Port != 20001 || proxy.Uuid != "0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01" || !proxy.Tls ||
+				proxy.Servername != "vless.example.com" || proxy.Network != "ws" || proxy.WsOpts.Path != "/ws" ||
+				proxy.WsOpts.Headers["Host"] != "cdn.example.com" {
+				t.Fatalf("unexpected vless proxy %+v", proxy)
+			}
+		case "vmess":
+			if proxy.Port != 20002 || proxy.Uuid != "0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02" || proxy.Cipher != "auto" ||
+				proxy.Tls || proxy.Network != "tcp" {
+				t.Fatalf("unexpected vmess proxy %+v", proxy)
+			}
+		case "trojan":
+			if proxy.Port != 20003 || proxy.Password != "trojan-pass" || proxy.Sni != "trojan.example.com" ||
+				proxy.Network != "grpc" || proxy.GrpcOpts.ServiceName != "svc" {
+				t.Fatalf("unexpected trojan proxy %+v", proxy)
+			}
+		case "ss":
+			if proxy.Port != 20004 || proxy.Cipher != "aes-256-gcm" || proxy.Password != "ss-pass" || proxy.Network != "" {
+				t.Fatalf("unexpected ss proxy %+v", proxy)
+			}
+		default:
+			t.Fatalf("unexpected proxy type %q", proxy.Type)
+		}
+	}
+
+	if result, _, err := clash.GetClash("missing", "sub.example.com"); err != nil || result != "" {
+		t.Fatalf("expected no config for an unknown subscription, got %q (%v)", result, err)
+	}
+}
@@ -1,11 +1,19 @@
 package sub
 
 import (
+	"crypto/ed25519"
 	"encoding/base64"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,19 +24,45 @@ type SUBController struct {
 	subPath        string
 	subJsonPath    string
 	jsonEnabled    bool
+	subClashPath   string
+	clashEnabled   bool
+	subSingboxPath string
+	singboxEnabled bool
 	subEncrypt     bool
 	updateInterval string
+	formatRules    []SubFormatRule
 
-	subService     *SubService
-	subJsonService *SubJsonService
+	pageBranding     SubPageBranding
+	pageTranslations map[string]subPageTranslation
+
+	signPrivateKey ed25519.PrivateKey  // nil if subscription signing is disabled
+	signPublicKey  *service.SubSignKey // nil if subscription signing is disabled
+
+	subService        *SubService
+	subJsonService    *SubJsonService
+	subClashService   *SubClashService
+	subSingboxService *SubSingboxService
+	subNodeService    *SubNodeService
+	subAccessService  service.SubAccessService
+	apiTokenService   service.APITokenService
 }
 
+// Context keys of subscription requests.
+const (
+	subIdKey     = "subId"     // Current subscription ID of the request, see resolveSubId
+	subFormatKey = "subFormat" // Format of the response for the access log
+)
+
 // NewSUBController creates a new subscription controller with the given configuration.
 func NewSUBController(
 	g *gin.RouterGroup,
 	subPath string,
 	jsonPath string,
 	jsonEnabled bool,
+	clashPath string,
+	clashEnabled bool,
+	singboxPath string,
+	singboxEnabled bool,
 	encrypt bool,
 	showInfo bool,
 	rModel string,
@@ -37,41 +71,131 @@ func NewSUBController(
 	jsonNoise string,
 	jsonMux string,
 	jsonRules string,
+	singboxDns string,
+	singboxRules string,
 	subTitle string,
+	formatRules string,
+	remarkTemplate string,
+	titleTemplate string,
+	jsonTemplate string,
+	nodes string,
+	nodeCacheTtl int,
+	pageBranding SubPageBranding,
+	pageTranslations string,
+	signKey ed25519.PrivateKey,
 ) *SUBController {
-	sub := NewSubService(showInfo, rModel)
+	sub := NewSubService(showInfo, rModel, remarkTemplate, titleTemplate)
+	subJson := NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, jsonTemplate, sub)
 	a := &SUBController{
 		subTitle:       subTitle,
 		subPath:        subPath,
 		subJsonPath:    jsonPath,
 		jsonEnabled:    jsonEnabled,
+		subClashPath:   clashPath,
+		clashEnabled:   clashEnabled,
+		subSingboxPath: singboxPath,
+		singboxEnabled: singboxEnabled,
 		subEncrypt:     encrypt,
 		updateInterval: update,
+		formatRules:    parseSubFormatRules(formatRules),
+
+		pageBranding:     pageBranding,
+		pageTranslations: parseSubPageTranslations(pageTranslations),
 
-		subService:     sub,
-		subJsonService: NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, sub),
+		subService:        sub,
+		subJsonService:    subJson,
+		subClashService:   NewSubClashService(subJson),
+		subSingboxService: NewSubSingboxService(singboxDns, singboxRules, subJson),
+		subNodeService:    NewSubNodeService(nodes, nodeCacheTtl),
+	}
+	if signKey != nil {
+		a.signPrivateKey = signKey
+		a.signPublicKey = service.NewSubSignKey(signKey)
 	}
 	a.initRouter(g)
 	return a
 }
 
-// initRouter registers HTTP routes for subscription links and JSON endpoints
-// on the provided router group.
+// initRouter registers HTTP routes for subscription links, JSON, Clash/Mihomo and sing-box endpoints,
+// the node API and the signing key on the provided router group.
 func (a *SUBController) initRouter(g *gin.RouterGroup) {
-	gLink := g.Group(a.subPath)
+	g.GET(subNodePath+":subid", a.resolveSubId, a.nodeSub)
+	if a.signPublicKey != nil {
+		g.GET(subSignKeyPath, a.signKey)
+	}
+	gLink := g.Group(a.subPath, a.resolveSubId, a.logAccess)
 	gLink.GET(":subid", a.subs)
+	gLink.GET(":subid/qr", a.subQr)
 	if a.jsonEnabled {
-		gJson := g.Group(a.subJsonPath)
+		gJson := g.Group(a.subJsonPath, a.resolveSubId, a.logAccess)
 		gJson.GET(":subid", a.subJsons)
 	}
+	if a.clashEnabled {
+		gClash := g.Group(a.subClashPath, a.resolveSubId, a.logAccess)
+		gClash.GET(":subid", a.subClash)
+	}
+	if a.singboxEnabled {
+		gSingbox := g.Group(a.subSingboxPath, a.resolveSubId, a.logAccess)
+		gSingbox.GET(":subid", a.subSingbox)
+	}
+}
+
+// resolveSubId stores the current subscription ID of the request, which differs from the
+// requested one for a rotated subscription ID that is still in its grace period.
+func (a *SUBController) resolveSubId(c *gin.Context) {
+	c.Set(subIdKey, a.subService.inboundService.ResolveSubId(c.Param("subid")))
+}
+
+// logAccess records the subscription fetch in the access log once the handler has responded.
+// Requests for unknown subscription IDs are answered with an error status and not recorded.
+func (a *SUBController) logAccess(c *gin.Context) {
+	c.Next()
+	if c.Writer.Status() >= http.StatusBadRequest {
+		return
+	}
+	a.subAccessService.Record(&model.SubAccessLog{
+		SubId:     c.GetString(subIdKey),
+		Ip:        getRemoteIp(c),
+		UserAgent: c.GetHeader("User-Agent"),
+		Format:    c.GetString(subFormatKey),
+		Status:    c.Writer.Status(),
+		Size:      max(c.Writer.Size(), 0),
+	})
+}
+
+// getRemoteIp returns the client IP of the request. The X-Real-IP and X-Forwarded-For headers are
+// only used for requests of the trusted proxies of the subscription server.
+func getRemoteIp(c *gin.Context) string {
+	return c.ClientIP()
 }
 
 // subs handles HTTP requests for subscription links, returning either HTML page or base64-encoded subscription data.
+// The format query parameter or a User-Agent rule can switch the response to another subscription format.
 func (a *SUBController) subs(c *gin.Context) {
-	subId := c.Param("subid")
+	if len(a.formatRules) > 0 {
+		c.Header("Vary", "User-Agent")
+	}
+	format, ok := a.subFormat(c)
+	if !ok {
+		c.String(400, "Error!")
+		return
+	}
+	switch format {
+	case SubFormatJson:
+		a.subJsons(c)
+		return
+	case SubFormatClash:
+		a.subClash(c)
+		return
+	case SubFormatSingbox:
+		a.subSingbox(c)
+		return
+	}
+
+	subId := c.GetString(subIdKey)
 	scheme, host, hostWithPort, hostHeader := a.subService.ResolveRequest(c)
-	subs, lastOnline, traffic, err := a.subService.GetSubs(subId, host)
-	if err != nil || len(subs) == 0 {
+	subs, lastOnline, traffic := a.getLinks(subId, host)
+	if len(subs) == 0 {
 		c.String(400, "Error!")
 	} else {
 		result := ""
@@ -79,28 +203,19 @@ func (a *SUBController) subs(c *gin.Context) {
 			result += sub + "\n"
 		}
 
+		// The page data as JSON, for embedding the subscription in other sites
+		if format == "" && strings.EqualFold(c.Query("view"), "json") {
+			c.Set(subFormatKey, "page")
+			c.Header("Access-Control-Allow-Origin", "*")
+			c.JSON(200, a.buildPage(c, subId, scheme, hostWithPort, hostHeader, traffic, lastOnline, subs))
+			return
+		}
+
 		// If the request expects HTML (e.g., browser) or explicitly asked (?html=1 or ?view=html), render the info page here
 		accept := c.GetHeader("Accept")
-		if strings.Contains(strings.ToLower(accept), "text/html") || c.Query("html") == "1" || strings.EqualFold(c.Query("view"), "html") {
-			// Build page data in service
-			subURL, subJsonURL := a.subService.BuildURLs(scheme, hostWithPort, a.subPath, a.subJsonPath, subId)
-			if !a.jsonEnabled {
-				subJsonURL = ""
-			}
-			// Get base_path from context (set by middleware)
-			basePath, exists := c.Get("base_path")
-			if !exists {
-				basePath = "/"
-			}
-			// Add subId to base_path for asset URLs
-			basePathStr := basePath.(string)
-			if basePathStr == "/" {
-				basePathStr = "/" + subId + "/"
-			} else {
-				// Remove trailing slash if exists, add subId, then add trailing slash
-				basePathStr = strings.TrimRight(basePathStr, "/") + "/" + subId + "/"
-			}
-			page := a.subService.BuildPageData(subId, hostHeader, traffic, lastOnline, subs, subURL, subJsonURL, basePathStr)
+		if format == "" && (strings.Contains(strings.ToLower(accept), "text/html") || c.Query("html") == "1" || strings.EqualFold(c.Query("view"), "html")) {
+			c.Set(subFormatKey, "html")
+			page := a.buildPage(c, subId, scheme, hostWithPort, hostHeader, traffic, lastOnline, subs)
 			c.HTML(200, "subpage.html", gin.H{
 				"title":        "subscription.title",
 				"cur_ver":      config.GetVersion(),
@@ -121,25 +236,184 @@ func (a *SUBController) subs(c *gin.Context) {
 				"subUrl":       page.SubUrl,
 				"subJsonUrl":   page.SubJsonUrl,
 				"result":       page.Result,
+				"branding":     page.Branding,
+				"page":         page,
 			})
 			return
 		}
 
 		// Add headers
 		header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
-		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
+		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subService.GetProfileTitle(subId, host, a.subTitle))
+
+		if format == SubFormatBase64 || (format == "" && a.subEncrypt) {
+			c.Set(subFormatKey, SubFormatBase64)
+			a.writeSub(c, "text/plain; charset=utf-8", []byte(base64.StdEncoding.EncodeToString([]byte(result))))
+		} else {
+			c.Set(subFormatKey, SubFormatPlain)
+			a.writeSub(c, "text/plain; charset=utf-8", []byte(result))
+		}
+	}
+}
+
+// getLinks retrieves the links of a subscription with those of the remote nodes, if any. Nodes
+// are only asked for subscription IDs of local clients, so unknown IDs never fan out to them.
+func (a *SUBController) getLinks(subId string, host string) ([]string, int64, xray.ClientTraffic) {
+	subs, lastOnline, traffic, err := a.subService.GetSubs(subId, host)
+	if a.subNodeService != nil && (err == nil || a.subService.HasSubId(subId)) {
+		subs, lastOnline, traffic = a.subNodeService.Merge(subId, subs, lastOnline, traffic, err == nil)
+	}
+	return subs, lastOnline, traffic
+}
 
-		if a.subEncrypt {
-			c.String(200, base64.StdEncoding.EncodeToString([]byte(result)))
+// buildPage builds the data of the subscription page with the branding for the language of the
+// visitor, which the lang query parameter can override.
+func (a *SUBController) buildPage(c *gin.Context, subId, scheme, hostWithPort, hostHeader string, traffic xray.ClientTraffic, lastOnline int64, subs []string) PageData {
+	subURL, subJsonURL := a.subService.BuildURLs(scheme, hostWithPort, a.subPath, a.subJsonPath, subId)
+	if !a.jsonEnabled {
+		subJsonURL = ""
+	}
+	// Get base_path from context (set by middleware)
+	basePath, exists := c.Get("base_path")
+	if !exists {
+		basePath = "/"
+	}
+	// Add subId to base_path for asset URLs
+	basePathStr := basePath.(string)
+	if basePathStr == "/" {
+		basePathStr = "/" + subId + "/"
+	} else {
+		// Remove trailing slash if exists, add subId, then add trailing slash
+		basePathStr = strings.TrimRight(basePathStr, "/") + "/" + subId + "/"
+	}
+	page := a.subService.BuildPageData(subId, hostHeader, traffic, lastOnline, subs, subURL, subJsonURL, basePathStr)
+
+	languages := c.Query("lang")
+	if languages == "" {
+		if cookie, err := c.Cookie("lang"); err == nil {
+			languages = cookie
 		} else {
-			c.String(200, result)
+			languages = c.GetHeader("Accept-Language")
 		}
 	}
+	page.Branding = a.brandingFor(languages)
+	if page.Branding.Title == "" {
+		page.Branding.Title = a.subTitle
+	}
+	page.Apps = a.subPageApps(subURL, page.Branding.Title+" "+subId)
+	page.QrUrl = strings.TrimRight(subURL, "/") + "/qr"
+	return page
+}
+
+// subQr handles HTTP requests for QR codes of the subscription URL or, with the link query
+// parameter, of one of its links. The format query parameter selects png (default) or svg and
+// size the image size in pixels.
+func (a *SUBController) subQr(c *gin.Context) {
+	c.Set(subFormatKey, "qr")
+	subId := c.GetString(subIdKey)
+	scheme, host, hostWithPort, _ := a.subService.ResolveRequest(c)
+	subs, _, _ := a.getLinks(subId, host)
+	if len(subs) == 0 {
+		c.String(400, "Error!")
+		return
+	}
+	content, _ := a.subService.BuildURLs(scheme, hostWithPort, a.subPath, a.subJsonPath, subId)
+	if link := c.Query("link"); link != "" {
+		index, err := strconv.Atoi(link)
+		if err != nil || index < 0 || index >= len(subs) {
+			c.String(400, "Error!")
+			return
+		}
+		content = subs[index]
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if err != nil || size < 64 || size > 1024 {
+		c.String(400, "Error!")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	switch c.DefaultQuery("format", "png") {
+	case "png":
+		image, err := qrPng(content, size)
+		if err != nil {
+			c.String(400, "Error!")
+			return
+		}
+		c.Data(200, "image/png", image)
+	case "svg":
+		image, err := qrSvg(content, size)
+		if err != nil {
+			c.String(400, "Error!")
+			return
+		}
+		c.Data(200, "image/svg+xml", image)
+	default:
+		c.String(400, "Error!")
+	}
+}
+
+// nodeSub handles node API requests of other panels that aggregate this subscription. It answers
+// with the local links and traffic only, so nodes that list each other do not loop. Requests
+// need an API token of a user that can access all inbounds with the node:sub scope.
+func (a *SUBController) nodeSub(c *gin.Context) {
+	plain, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	token, user, err := a.apiTokenService.Authenticate(strings.TrimSpace(plain), getRemoteIp(c))
+	if err == nil && (!token.HasScope(model.ScopeNodeSub) || !user.CanAccessAllInbounds()) {
+		err = common.NewError("API token does not grant", model.ScopeNodeSub)
+	}
+	if err != nil {
+		logger.Warningf("SUBController - node API request rejected, IP: %s: %v", getRemoteIp(c), err)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	subId := c.GetString(subIdKey)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	links, lastOnline, traffic, err := a.subService.GetSubs(subId, host)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if links == nil {
+		links = []string{}
+	}
+	c.JSON(http.StatusOK, &SubNodeResult{Links: links, LastOnline: lastOnline, Traffic: traffic})
+}
+
+// subFormat picks the format of a subscription request from the format query parameter or, failing
+// that, from the User-Agent rules. An empty format keeps the default response of subs. Returns false
+// if the requested format is unknown or its endpoint is disabled; a disabled format of a User-Agent
+// rule falls back to the default response instead.
+func (a *SUBController) subFormat(c *gin.Context) (string, bool) {
+	if format := c.Query("format"); format != "" {
+		return format, a.formatEnabled(format)
+	}
+	if format := matchSubFormat(a.formatRules, c.GetHeader("User-Agent")); a.formatEnabled(format) {
+		return format, true
+	}
+	return "", true
+}
+
+// formatEnabled reports whether a subscription format is known and its endpoint is enabled.
+func (a *SUBController) formatEnabled(format string) bool {
+	switch format {
+	case SubFormatBase64, SubFormatPlain:
+		return true
+	case SubFormatJson:
+		return a.jsonEnabled
+	case SubFormatClash:
+		return a.clashEnabled
+	case SubFormatSingbox:
+		return a.singboxEnabled
+	}
+	return false
 }
 
 // subJsons handles HTTP requests for JSON subscription configurations.
 func (a *SUBController) subJsons(c *gin.Context) {
-	subId := c.Param("subid")
+	c.Set(subFormatKey, SubFormatJson)
+	subId := c.GetString(subIdKey)
 	_, host, _, _ := a.subService.ResolveRequest(c)
 	jsonSub, header, err := a.subJsonService.GetJson(subId, host)
 	if err != nil || len(jsonSub) == 0 {
@@ -147,9 +421,43 @@ func (a *SUBController) subJsons(c *gin.Context) {
 	} else {
 
 		// Add headers
-		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
+		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subService.GetProfileTitle(subId, host, a.subTitle))
+
+		a.writeSub(c, "text/plain; charset=utf-8", []byte(jsonSub))
+	}
+}
+
+// subClash handles HTTP requests for Clash/Mihomo YAML subscription configurations.
+func (a *SUBController) subClash(c *gin.Context) {
+	c.Set(subFormatKey, SubFormatClash)
+	subId := c.GetString(subIdKey)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	clashSub, header, err := a.subClashService.GetClash(subId, host)
+	if err != nil || len(clashSub) == 0 {
+		c.String(400, "Error!")
+	} else {
+
+		// Add headers
+		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subService.GetProfileTitle(subId, host, a.subTitle))
+
+		a.writeSub(c, "text/yaml; charset=utf-8", []byte(clashSub))
+	}
+}
+
+// subSingbox handles HTTP requests for sing-box profile subscriptions.
+func (a *SUBController) subSingbox(c *gin.Context) {
+	c.Set(subFormatKey, SubFormatSingbox)
+	subId := c.GetString(subIdKey)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	singboxSub, header, err := a.subSingboxService.GetSingbox(subId, host)
+	if err != nil || len(singboxSub) == 0 {
+		c.String(400, "Error!")
+	} else {
+
+		// Add headers
+		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subService.GetProfileTitle(subId, host, a.subTitle))
 
-		c.String(200, jsonSub)
+		a.writeSub(c, "text/plain; charset=utf-8", []byte(singboxSub))
 	}
 }
 
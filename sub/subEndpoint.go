@@ -0,0 +1,144 @@
+package sub
+
+import (
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// getEndpointProfiles retrieves the endpoint profiles that apply to the clients of a subscription.
+// Errors are logged, the subscription is then served without profiles.
+func (s *SubService) getEndpointProfiles(subId string) []*model.EndpointProfile {
+	profiles, err := s.endpointProfileService.GetSubProfiles(subId)
+	if err != nil {
+		logger.Warning("SubService - GetSubProfiles:", err)
+		return nil
+	}
+	return profiles
+}
+
+// endpointInbounds returns the inbound followed by a copy of it for each endpoint profile that
+// applies to it. A copy carries the overrides of its profile in the stream settings and the profile
+// as its only external proxy, so every subscription format renders it like any other inbound.
+func (s *SubService) endpointInbounds(inbound *model.Inbound, profiles []*model.EndpointProfile, host string) []*model.Inbound {
+	inbounds := []*model.Inbound{inbound}
+	for _, profile := range profiles {
+		if !profile.AppliesToInbound(inbound.Id) {
+			continue
+		}
+		var stream map[string]any
+		if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil || stream == nil {
+			continue
+		}
+		applyEndpointProfile(stream, profile)
+
+		address, port := profile.Address, profile.Port
+		if address == "" {
+			address = host
+		}
+		if port == 0 {
+			port = inbound.Port
+		}
+		// the remark tells the links of the profiles apart
+		remark := profile.RemarkSuffix
+		if remark == "" {
+			remark = profile.Name
+		}
+		stream["externalProxy"] = []any{
+			map[string]any{
+				"forceTls": model.EndpointSecuritySame,
+				"dest":     address,
+				"port":     float64(port),
+				"remark":   remark,
+			},
+		}
+		streamSettings, err := json.Marshal(stream)
+		if err != nil {
+			continue
+		}
+		endpoint := *inbound
+		endpoint.StreamSettings = string(streamSettings)
+		inbounds = append(inbounds, &endpoint)
+	}
+	return inbounds
+}
+
+// applyEndpointProfile writes the security, SNI, ALPN, fingerprint and host header overrides of
+// a profile into the stream settings of an inbound.
+func applyEndpointProfile(stream map[string]any, profile *model.EndpointProfile) {
+	switch profile.Security {
+	case model.EndpointSecurityTls:
+		if stream["security"] != "tls" {
+			stream["security"] = "tls"
+			stream["tlsSettings"] = map[string]any{}
+			delete(stream, "realitySettings")
+		}
+	case model.EndpointSecurityNone:
+		stream["security"] = "none"
+		delete(stream, "tlsSettings")
+		delete(stream, "realitySettings")
+	}
+
+	switch stream["security"] {
+	case "tls":
+		tlsSettings := endpointSettingsMap(stream, "tlsSettings")
+		if profile.Sni != "" {
+			tlsSettings["serverName"] = profile.Sni
+		}
+		if profile.Alpn != "" {
+			var alpn []any
+			for _, item := range strings.Split(profile.Alpn, ",") {
+				alpn = append(alpn, item)
+			}
+			tlsSettings["alpn"] = alpn
+		}
+		if profile.Fingerprint != "" {
+			endpointSettingsMap(tlsSettings, "settings")["fingerprint"] = profile.Fingerprint
+		}
+	case "reality":
+		realitySettings := endpointSettingsMap(stream, "realitySettings")
+		if profile.Sni != "" {
+			realitySettings["serverNames"] = []any{profile.Sni}
+		}
+		if profile.Fingerprint != "" {
+			endpointSettingsMap(realitySettings, "settings")["fingerprint"] = profile.Fingerprint
+		}
+	}
+
+	if profile.Host == "" {
+		return
+	}
+	network, _ := stream["network"].(string)
+	switch network {
+	case "ws", "httpupgrade", "xhttp":
+		endpointSettingsMap(stream, network+"Settings")["host"] = profile.Host
+	case "grpc":
+		endpointSettingsMap(stream, "grpcSettings")["authority"] = profile.Host
+	case "tcp":
+		tcp := endpointSettingsMap(stream, "tcpSettings")
+		header := endpointSettingsMap(tcp, "header")
+		if header["type"] != "http" {
+			return
+		}
+		headers := endpointSettingsMap(endpointSettingsMap(header, "request"), "headers")
+		for key := range headers {
+			if strings.EqualFold(key, "host") {
+				delete(headers, key)
+			}
+		}
+		headers["Host"] = []any{profile.Host}
+	}
+}
+
+// endpointSettingsMap returns the object under key, creating it if it is missing.
+func endpointSettingsMap(parent map[string]any, key string) map[string]any {
+	value, ok := parent[key].(map[string]any)
+	if !ok {
+		value = map[string]any{}
+		parent[key] = value
+	}
+	return value
+}
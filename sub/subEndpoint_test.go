@@ -0,0 +1,46 @@
+package sub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestEndpointInbounds(t *testing.T) {
+	s := &SubService{}
+	inbound := &model.Inbound{Id: 1, Port: 443, Protocol: model.VLESS, StreamSettings: `{"network":"ws","security":"tls"}`}
+	profiles := []*model.EndpointProfile{
+		{Name: "cdn", Address: "cdn.example.com", RemarkSuffix: "CDN", Enable: true},
+		{Name: "relay", Port: 8443, Enable: true},
+		{Name: "other inbound", InboundIds: "2", Enable: true},
+	}
+
+	inbounds := s.endpointInbounds(inbound, profiles, "sub.example.com")
+	if len(inbounds) != 3 || inbounds[0] != inbound {
+		t.Fatalf("expected the inbound and one copy per applying profile, got %d", len(inbounds))
+	}
+	tests := []struct {
+		dest   string
+		port   float64
+		remark string
+	}{
+		{dest: "cdn.example.com", port: 443, remark: "CDN"},
+		{dest: "sub.example.com", port: 8443, remark: "relay"},
+	}
+	for i, test := range tests {
+		var stream struct {
+			ExternalProxy []struct {
+				Dest   string  `json:"dest"`
+				Port   float64 `json:"port"`
+				Remark string  `json:"remark"`
+			} `json:"externalProxy"`
+		}
+		if err := json.Unmarshal([]byte(inbounds[i+1].StreamSettings), &stream); err != nil || len(stream.ExternalProxy) != 1 {
+			t.Fatalf("unexpected stream settings %s (%v)", inbounds[i+1].StreamSettings, err)
+		}
+		if proxy := stream.ExternalProxy[0]; proxy.Dest != test.dest || proxy.Port != test.port || proxy.Remark != test.remark {
+			t.Fatalf("unexpected external proxy %+v, want %+v", proxy, test)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package sub
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// Subscription formats that can be selected by User-Agent rules or the format query parameter.
+const (
+	SubFormatBase64  = model.SubFormatBase64
+	SubFormatPlain   = model.SubFormatPlain
+	SubFormatJson    = model.SubFormatJson
+	SubFormatClash   = model.SubFormatClash
+	SubFormatSingbox = model.SubFormatSingbox
+)
+
+// SubFormatRule selects a subscription format for clients whose User-Agent contains UserAgent.
+type SubFormatRule struct {
+	UserAgent string `json:"userAgent"`
+	Format    string `json:"format"`
+}
+
+// parseSubFormatRules parses the User-Agent rule table of the subscription settings.
+func parseSubFormatRules(rules string) []SubFormatRule {
+	if rules == "" {
+		return nil
+	}
+	var result []SubFormatRule
+	if err := json.Unmarshal([]byte(rules), &result); err != nil {
+		logger.Warning("SUBController - invalid subscription format rules:", err)
+		return nil
+	}
+	return result
+}
+
+// matchSubFormat returns the format of the first rule that matches the User-Agent, ignoring case,
+// or an empty string if no rule matches.
+func matchSubFormat(rules []SubFormatRule, userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return ""
+	}
+	for _, rule := range rules {
+		if rule.UserAgent != "" && strings.Contains(userAgent, strings.ToLower(rule.UserAgent)) {
+			return rule.Format
+		}
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package sub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+)
+
+func TestSubFormat(t *testing.T) {
+	t.Setenv("XUI_LOG_FOLDER", t.TempDir())
+	logger.InitLogger(logging.ERROR)
+	defer logger.CloseLogger()
+	gin.SetMode(gin.TestMode)
+	rules := parseSubFormatRules(`[{"userAgent":"Clash","format":"clash"},{"userAgent":"sing-box","format":"singbox"},
+		{"userAgent":"v2rayN","format":"base64"},{"userAgent":"v2ray","format":"plain"},{"userAgent":"xray","format":"json"}]`)
+	if len(rules) != 5 {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+	a := &SUBController{formatRules: rules, clashEnabled: true, singboxEnabled: true}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		query     string
+		format    string
+		ok        bool
+	}{
+		{name: "no rule", userAgent: "curl/8.0", format: "", ok: true},
+		{name: "no user agent", format: "", ok: true},
+		{name: "rule ignores case", userAgent: "clash.meta/1.18", format: SubFormatClash, ok: true},
+		{name: "first matching rule", userAgent: "v2rayNG/1.9", format: SubFormatBase64, ok: true},
+		{name: "later rule", userAgent: "v2rayA/2.0", format: SubFormatPlain, ok: true},
+		{name: "sing-box", userAgent: "SFA/1.10 sing-box", format: SubFormatSingbox, ok: true},
+		{name: "rule to disabled format", userAgent: "Xray/25.1", format: "", ok: true},
+		{name: "query overrides rule", userAgent: "clash.meta", query: "plain", format: SubFormatPlain, ok: true},
+		{name: "query for disabled format", query: "json", format: SubFormatJson, ok: false},
+		{name: "unknown query format", query: "yaml", format: "yaml", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			target := "/sub/id"
+			if test.query != "" {
+				target += "?format=" + test.query
+			}
+			c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+			c.Request.Header.Set("User-Agent", test.userAgent)
+			format, ok := a.subFormat(c)
+			if format != test.format || ok != test.ok {
+				t.Fatalf("expected %q (%v), got %q (%v)", test.format, test.ok, format, ok)
+			}
+		})
+	}
+
+	if rules := parseSubFormatRules(`{"userAgent":"clash"}`); rules != nil {
+		t.Fatalf("expected invalid rules to be ignored, got %+v", rules)
+	}
+}
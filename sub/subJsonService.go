@@ -24,13 +24,15 @@ type SubJsonService struct {
 	fragment         string
 	noises           string
 	mux              string
+	jsonTemplate     string
 
 	inboundService service.InboundService
 	SubService     *SubService
 }
 
 // NewSubJsonService creates a new JSON subscription service with the given configuration.
-func NewSubJsonService(fragment string, noises string, mux string, rules string, subService *SubService) *SubJsonService {
+// An empty jsonTemplate keeps the default profile.
+func NewSubJsonService(fragment string, noises string, mux string, rules string, jsonTemplate string, subService *SubService) *SubJsonService {
 	var configJson map[string]any
 	var defaultOutbounds []json_util.RawMessage
 	json.Unmarshal([]byte(defaultJson), &configJson)
@@ -65,6 +67,7 @@ func NewSubJsonService(fragment string, noises string, mux string, rules string,
 		fragment:         fragment,
 		noises:           noises,
 		mux:              mux,
+		jsonTemplate:     jsonTemplate,
 		SubService:       subService,
 	}
 }
@@ -77,9 +80,9 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 	}
 
 	var header string
-	var traffic xray.ClientTraffic
 	var clientTraffics []xray.ClientTraffic
 	var configArray []json_util.RawMessage
+	profiles := s.SubService.getEndpointProfiles(subId)
 
 	// Prepare Inbounds
 	for _, inbound := range inbounds {
@@ -99,11 +102,14 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 			}
 		}
 
+		expanded := s.SubService.endpointInbounds(inbound, profiles, host)
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
-				newConfigs := s.getConfig(inbound, client, host)
-				configArray = append(configArray, newConfigs...)
+				for _, endpoint := range expanded {
+					newConfigs := s.getConfig(endpoint, client, host)
+					configArray = append(configArray, newConfigs...)
+				}
 			}
 		}
 	}
@@ -112,28 +118,7 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 		return "", "", nil
 	}
 
-	// Prepare statistics
-	for index, clientTraffic := range clientTraffics {
-		if index == 0 {
-			traffic.Up = clientTraffic.Up
-			traffic.Down = clientTraffic.Down
-			traffic.Total = clientTraffic.Total
-			if clientTraffic.ExpiryTime > 0 {
-				traffic.ExpiryTime = clientTraffic.ExpiryTime
-			}
-		} else {
-			traffic.Up += clientTraffic.Up
-			traffic.Down += clientTraffic.Down
-			if traffic.Total == 0 || clientTraffic.Total == 0 {
-				traffic.Total = 0
-			} else {
-				traffic.Total += clientTraffic.Total
-			}
-			if clientTraffic.ExpiryTime != traffic.ExpiryTime {
-				traffic.ExpiryTime = 0
-			}
-		}
-	}
+	traffic := s.SubService.sumClientTraffics(subId, clientTraffics)
 
 	// Combile outbounds
 	var finalJson []byte
@@ -196,12 +181,18 @@ func (s *SubJsonService) getConfig(inbound *model.Inbound, client model.Client,
 		}
 
 		newOutbounds = append(newOutbounds, s.defaultOutbounds...)
+		remark := s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string))
+		if newConfig, ok := s.genTemplateConfig(inbound, client, host, extPrxy["remark"].(string), remark, newOutbounds); ok {
+			newJsonArray = append(newJsonArray, newConfig)
+			continue
+		}
+
 		newConfigJson := make(map[string]any)
 		for key, value := range s.configJson {
 			newConfigJson[key] = value
 		}
 		newConfigJson["outbounds"] = newOutbounds
-		newConfigJson["remarks"] = s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string))
+		newConfigJson["remarks"] = remark
 
 		newConfig, _ := json.MarshalIndent(newConfigJson, "", "  ")
 		newJsonArray = append(newJsonArray, newConfig)
@@ -210,6 +201,30 @@ func (s *SubJsonService) getConfig(inbound *model.Inbound, client model.Client,
 	return newJsonArray
 }
 
+// genTemplateConfig renders the JSON profile template of the inbound, or else of the settings.
+// Returns false if there is no template or it fails to render.
+func (s *SubJsonService) genTemplateConfig(inbound *model.Inbound, client model.Client, host string, extra string, remark string, outbounds []json_util.RawMessage) (json_util.RawMessage, bool) {
+	text := inbound.SubJsonTemplate
+	if text == "" {
+		text = s.jsonTemplate
+	}
+	if text == "" || len(outbounds) == 0 {
+		return nil, false
+	}
+	allOutbounds, _ := json.Marshal(outbounds)
+	data := service.NewSubTemplateData(client.SubID, host, inbound, &client, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
+	data.Extra = extra
+	data.Remark = remark
+	data.Outbound = string(outbounds[0])
+	data.Outbounds = string(allOutbounds)
+	result, err := service.RenderSubTemplate(service.SubTemplateJson, text, data)
+	if err != nil {
+		logger.Warning("SubJsonService - JSON profile template:", err)
+		return nil, false
+	}
+	return json_util.RawMessage(result), true
+}
+
 func (s *SubJsonService) streamData(stream string) map[string]any {
 	var streamSettings map[string]any
 	json.Unmarshal([]byte(stream), &streamSettings)
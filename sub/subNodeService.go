@@ -0,0 +1,221 @@
+package sub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// subNodePath is the path of the node API on the subscription server, under which other panels
+// pull the links of a subscription for aggregation.
+const subNodePath = "/node/sub/"
+
+const (
+	subNodeTimeout     = 5 * time.Second
+	subNodeStaleLimit  = 24 * time.Hour // How long an offline node is served from its last answer
+	subNodeMaxBodySize = 4 << 20
+	subNodeCacheSize   = 4096 // Most node answers cached, the oldest are evicted first
+)
+
+// SubNode is a remote 3x-ui panel whose subscription links are merged into the local subscription.
+// Url is the base URL of its subscription server and Token an API token with the node:sub scope.
+type SubNode struct {
+	Name  string `json:"name"`
+	Url   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// SubNodeResult is the answer of the node API for a subscription ID. The traffic only covers the
+// clients of the answering node.
+type SubNodeResult struct {
+	Links      []string           `json:"links"`
+	LastOnline int64              `json:"lastOnline"`
+	Traffic    xray.ClientTraffic `json:"traffic"`
+}
+
+// subNodeCacheEntry is a cached node answer, fetchedAt is when it was pulled.
+type subNodeCacheEntry struct {
+	result    *SubNodeResult
+	fetchedAt time.Time
+}
+
+// SubNodeService merges the links of the same subscription ID from remote panels into the local
+// link subscriptions. Node answers are cached for the cache TTL; nodes that are offline are served
+// from their last answer for up to a day and skipped otherwise, so one node never breaks the
+// subscription. JSON, Clash and sing-box subscriptions are built from the local inbounds only.
+type SubNodeService struct {
+	nodes  []SubNode
+	ttl    time.Duration
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*subNodeCacheEntry
+}
+
+// NewSubNodeService creates a node service from the node list of the subscription settings and the
+// cache TTL in seconds. It returns nil if no valid node is configured.
+func NewSubNodeService(nodes string, cacheTtl int) *SubNodeService {
+	parsed := parseSubNodes(nodes)
+	if len(parsed) == 0 {
+		return nil
+	}
+	return &SubNodeService{
+		nodes:  parsed,
+		ttl:    time.Duration(max(cacheTtl, 0)) * time.Second,
+		client: &http.Client{Timeout: subNodeTimeout},
+		cache:  make(map[string]*subNodeCacheEntry),
+	}
+}
+
+// parseSubNodes parses the node list of the subscription settings, skipping nodes without a URL.
+func parseSubNodes(nodes string) []SubNode {
+	if nodes == "" {
+		return nil
+	}
+	var parsed []SubNode
+	if err := json.Unmarshal([]byte(nodes), &parsed); err != nil {
+		logger.Warning("SUBController - invalid subscription nodes:", err)
+		return nil
+	}
+	result := make([]SubNode, 0, len(parsed))
+	for _, node := range parsed {
+		node.Url = strings.TrimRight(strings.TrimSpace(node.Url), "/")
+		if node.Url == "" {
+			continue
+		}
+		if node.Name == "" {
+			node.Name = node.Url
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// Merge adds the links of all nodes for a subscription ID to the local links and sums up the
+// traffic. local tells whether the subscription has enabled local inbounds, otherwise the traffic of
+// the nodes is used alone. Nodes are queried in parallel and keep their configured order.
+func (s *SubNodeService) Merge(subId string, links []string, lastOnline int64, traffic xray.ClientTraffic, local bool) ([]string, int64, xray.ClientTraffic) {
+	results := make([]*SubNodeResult, len(s.nodes))
+	var wg sync.WaitGroup
+	for i := range s.nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.get(&s.nodes[i], subId)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result == nil || len(result.Links) == 0 {
+			continue
+		}
+		links = append(links, result.Links...)
+		lastOnline = max(lastOnline, result.LastOnline)
+		if local {
+			traffic = mergeNodeTraffic(traffic, result.Traffic)
+		} else {
+			traffic = result.Traffic
+			local = true
+		}
+	}
+	return links, lastOnline, traffic
+}
+
+// mergeNodeTraffic adds up the traffic of two nodes like the traffic of the clients of a
+// subscription: the total and the expiry time are only kept if both nodes have the same kind.
+func mergeNodeTraffic(traffic xray.ClientTraffic, other xray.ClientTraffic) xray.ClientTraffic {
+	traffic.Up += other.Up
+	traffic.Down += other.Down
+	if traffic.Total == 0 || other.Total == 0 {
+		traffic.Total = 0
+	} else {
+		traffic.Total += other.Total
+	}
+	if traffic.ExpiryTime != other.ExpiryTime {
+		traffic.ExpiryTime = 0
+	}
+	return traffic
+}
+
+// get returns the answer of a node for a subscription ID from the cache or the node itself.
+// A failed pull falls back to the last answer if it is not older than subNodeStaleLimit. The cache
+// drops answers older than subNodeStaleLimit and keeps at most subNodeCacheSize answers.
+func (s *SubNodeService) get(node *SubNode, subId string) *SubNodeResult {
+	key := node.Url + "\x00" + subId
+	s.mu.Lock()
+	entry := s.cache[key]
+	s.mu.Unlock()
+	if entry != nil && time.Since(entry.fetchedAt) < s.ttl {
+		return entry.result
+	}
+
+	result, err := s.fetch(node, subId)
+	if err != nil {
+		logger.Warningf("SubNodeService - unable to pull subscription from node %s: %v", node.Name, err)
+		if entry != nil && time.Since(entry.fetchedAt) < subNodeStaleLimit {
+			return entry.result
+		}
+		return nil
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for k, e := range s.cache {
+		if now.Sub(e.fetchedAt) >= subNodeStaleLimit {
+			delete(s.cache, k)
+		}
+	}
+	for len(s.cache) >= subNodeCacheSize {
+		oldest := ""
+		for k, e := range s.cache {
+			if oldest == "" || e.fetchedAt.Before(s.cache[oldest].fetchedAt) {
+				oldest = k
+			}
+		}
+		delete(s.cache, oldest)
+	}
+	s.cache[key] = &subNodeCacheEntry{result: result, fetchedAt: now}
+	s.mu.Unlock()
+	return result
+}
+
+// fetch pulls the links of a subscription ID from the node API of a node. An unknown
+// subscription ID is an empty answer, not an error.
+func (s *SubNodeService) fetch(node *SubNode, subId string) (*SubNodeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), subNodeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.Url+subNodePath+url.PathEscape(subId), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+node.Token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return &SubNodeResult{}, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	result := &SubNodeResult{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, subNodeMaxBodySize)).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
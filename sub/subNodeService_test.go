@@ -0,0 +1,121 @@
+package sub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/op/go-logging"
+)
+
+func TestMergeNodeTraffic(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  xray.ClientTraffic
+		total int64
+		exp   int64
+	}{
+		{name: "limited", a: xray.ClientTraffic{Up: 1, Down: 2, Total: 10, ExpiryTime: 5}, b: xray.ClientTraffic{Up: 3, Down: 4, Total: 20, ExpiryTime: 5}, total: 30, exp: 5},
+		{name: "one unlimited", a: xray.ClientTraffic{Up: 1, Down: 2, Total: 10}, b: xray.ClientTraffic{Up: 3, Down: 4}, total: 0, exp: 0},
+		{name: "different expiry", a: xray.ClientTraffic{Up: 1, Down: 2, Total: 10, ExpiryTime: 5}, b: xray.ClientTraffic{Up: 3, Down: 4, Total: 10, ExpiryTime: 6}, total: 20, exp: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			traffic := mergeNodeTraffic(test.a, test.b)
+			if traffic.Up != 4 || traffic.Down != 6 || traffic.Total != test.total || traffic.ExpiryTime != test.exp {
+				t.Fatalf("unexpected traffic %+v", traffic)
+			}
+		})
+	}
+}
+
+func TestSubNodeMerge(t *testing.T) {
+	t.Setenv("XUI_LOG_FOLDER", t.TempDir())
+	logger.InitLogger(logging.ERROR)
+	defer logger.CloseLogger()
+
+	var online atomic.Bool
+	online.Store(true)
+	var pulls atomic.Int32
+	node := func(links ...string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pulls.Add(1)
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !online.Load() {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			if len(links) == 0 || !strings.HasSuffix(r.URL.Path, subNodePath+"shared") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(&SubNodeResult{Links: links, LastOnline: 7,
+				Traffic: xray.ClientTraffic{Up: 10, Down: 20, Total: 100}})
+		}))
+	}
+	first, second, empty := node("vless://first"), node("trojan://second"), node()
+	defer first.Close()
+	defer second.Close()
+	defer empty.Close()
+	nodes := fmt.Sprintf(`[{"name":"first","url":"%s/","token":"token"},{"url":" %s","token":"token"},{"url":"%s","token":"token"},{"name":"no url"}]`,
+		first.URL, second.URL, empty.URL)
+	s := NewSubNodeService(nodes, 60)
+	if s == nil || len(s.nodes) != 3 || s.nodes[0].Url != first.URL || s.nodes[1].Name != second.URL {
+		t.Fatalf("unexpected nodes %+v", s)
+	}
+	if NewSubNodeService(`[{"name":"no url"}]`, 60) != nil || NewSubNodeService("not json", 60) != nil {
+		t.Fatal("expected no node service without valid nodes")
+	}
+
+	local := xray.ClientTraffic{Up: 1, Down: 2, Total: 50}
+	tests := []struct {
+		name       string
+		subId      string
+		local      bool
+		online     bool
+		links      string
+		lastOnline int64
+		traffic    xray.ClientTraffic
+		pulls      int32
+	}{
+		{name: "merged in node order", subId: "shared", local: true, online: true, links: "local,vless://first,trojan://second",
+			lastOnline: 7, traffic: xray.ClientTraffic{Up: 21, Down: 42, Total: 250}, pulls: 3},
+		{name: "served from cache", subId: "shared", local: true, online: true, links: "local,vless://first,trojan://second",
+			lastOnline: 7, traffic: xray.ClientTraffic{Up: 21, Down: 42, Total: 250}, pulls: 0},
+		{name: "unknown on the nodes", subId: "other", local: true, online: true, links: "local",
+			lastOnline: 1, traffic: local, pulls: 3},
+		{name: "traffic of the nodes alone", subId: "shared", local: false, online: true, links: "local,vless://first,trojan://second",
+			lastOnline: 7, traffic: xray.ClientTraffic{Up: 20, Down: 40, Total: 200}, pulls: 0},
+		{name: "offline without an answer", subId: "remote", local: true, online: false, links: "local",
+			lastOnline: 1, traffic: local, pulls: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			online.Store(test.online)
+			pulls.Store(0)
+			links, lastOnline, traffic := s.Merge(test.subId, []string{"local"}, 1, local, test.local)
+			if strings.Join(links, ",") != test.links || lastOnline != test.lastOnline || traffic != test.traffic ||
+				pulls.Load() != test.pulls {
+				t.Fatalf("unexpected merge %v %d %+v after %d pulls", links, lastOnline, traffic, pulls.Load())
+			}
+		})
+	}
+
+	// offline nodes are served from their last answer
+	online.Store(false)
+	s.ttl = 0
+	links, _, traffic := s.Merge("shared", nil, 0, xray.ClientTraffic{}, false)
+	if strings.Join(links, ",") != "vless://first,trojan://second" || traffic.Total != 200 || traffic.Up != 20 {
+		t.Fatalf("expected the last answers of offline nodes, got %v %+v", links, traffic)
+	}
+}
@@ -0,0 +1,167 @@
+package sub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/text/language"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// SubPageBranding holds the branding of the subscription page. Empty fields keep the defaults.
+type SubPageBranding struct {
+	Title        string `json:"title"`
+	Logo         string `json:"logo"`
+	Color        string `json:"color"`
+	Support      string `json:"support"`
+	Announcement string `json:"announcement"`
+}
+
+// SubPageApp is a client app offered on the subscription page. ImportUrl adds the subscription
+// to the app through its deep link, if it has one, otherwise the page copies the subscription URL;
+// DownloadUrl is the page to get the app.
+type SubPageApp struct {
+	Name        string   `json:"name"`
+	Platforms   []string `json:"platforms"` // android, ios, windows, macos and linux
+	ImportUrl   string   `json:"importUrl,omitempty"`
+	DownloadUrl string   `json:"downloadUrl,omitempty"`
+}
+
+// subPageTranslation overrides the branding of the subscription page for one language.
+type subPageTranslation struct {
+	Title        string `json:"title"`
+	Announcement string `json:"announcement"`
+	Support      string `json:"support"`
+}
+
+// parseSubPageTranslations parses the per-language texts of the subscription settings, keyed by
+// language tags in lower case.
+func parseSubPageTranslations(translations string) map[string]subPageTranslation {
+	if translations == "" {
+		return nil
+	}
+	var parsed map[string]subPageTranslation
+	if err := json.Unmarshal([]byte(translations), &parsed); err != nil {
+		logger.Warning("SUBController - invalid subscription page translations:", err)
+		return nil
+	}
+	result := make(map[string]subPageTranslation, len(parsed))
+	for lang, translation := range parsed {
+		result[strings.ToLower(lang)] = translation
+	}
+	return result
+}
+
+// brandingFor returns the branding of the subscription page for the languages of a visitor, in the
+// form of a lang cookie or an Accept-Language header. The first language with a translation, by its
+// full tag or by its base language, overrides the texts.
+func (a *SUBController) brandingFor(languages string) SubPageBranding {
+	branding := a.pageBranding
+	if len(a.pageTranslations) == 0 {
+		return branding
+	}
+	tags, _, _ := language.ParseAcceptLanguage(languages)
+	for _, tag := range tags {
+		base, _ := tag.Base()
+		translation, ok := a.pageTranslations[strings.ToLower(tag.String())]
+		if !ok {
+			translation, ok = a.pageTranslations[base.String()]
+		}
+		if !ok {
+			continue
+		}
+		if translation.Title != "" {
+			branding.Title = translation.Title
+		}
+		if translation.Announcement != "" {
+			branding.Announcement = translation.Announcement
+		}
+		if translation.Support != "" {
+			branding.Support = translation.Support
+		}
+		break
+	}
+	return branding
+}
+
+// subPageApps returns the client apps offered on the subscription page with their import links.
+// Apps that need the Clash/Mihomo or sing-box format are only offered if it is enabled.
+func (a *SUBController) subPageApps(subURL string, name string) []SubPageApp {
+	encoded := url.QueryEscape(subURL)
+	fragment := "#" + url.PathEscape(name)
+	apps := []SubPageApp{
+		{Name: "v2rayNG", Platforms: []string{"android"},
+			ImportUrl: "v2rayng://install-config?url=" + encoded, DownloadUrl: "https://github.com/2dust/v2rayNG/releases"},
+		{Name: "v2rayN", Platforms: []string{"windows", "macos", "linux"},
+			DownloadUrl: "https://github.com/2dust/v2rayN/releases"},
+		{Name: "Hiddify", Platforms: []string{"android", "ios", "windows", "macos", "linux"},
+			ImportUrl: "hiddify://import/" + subURL + fragment, DownloadUrl: "https://github.com/hiddify/hiddify-app/releases"},
+		{Name: "V2Box", Platforms: []string{"android", "ios"},
+			ImportUrl: "v2box://install-sub?url=" + encoded + "&name=" + url.QueryEscape(name)},
+		{Name: "Happ", Platforms: []string{"android", "ios"},
+			ImportUrl: "happ://add/" + encoded},
+		{Name: "V2RayTun", Platforms: []string{"android", "ios"}},
+		{Name: "NPV Tunnel", Platforms: []string{"android", "ios"}},
+		{Name: "Shadowrocket", Platforms: []string{"ios"},
+			ImportUrl:   "shadowrocket://add/sub/" + base64.StdEncoding.EncodeToString([]byte(subURL+"?flag=shadowrocket")) + "?remark=" + url.QueryEscape(name),
+			DownloadUrl: "https://apps.apple.com/app/shadowrocket/id932747118"},
+		{Name: "Streisand", Platforms: []string{"ios"},
+			ImportUrl: "streisand://import/" + encoded},
+	}
+	if a.singboxEnabled {
+		apps = append(apps, SubPageApp{Name: "sing-box", Platforms: []string{"android", "ios", "macos"},
+			ImportUrl:   "sing-box://import-remote-profile?url=" + url.QueryEscape(withFormat(subURL, SubFormatSingbox)) + fragment,
+			DownloadUrl: "https://sing-box.sagernet.org/clients/"})
+	}
+	if a.clashEnabled {
+		apps = append(apps,
+			SubPageApp{Name: "Clash Verge Rev", Platforms: []string{"windows", "macos", "linux"},
+				ImportUrl:   "clash://install-config?url=" + url.QueryEscape(withFormat(subURL, SubFormatClash)) + "&name=" + url.QueryEscape(name),
+				DownloadUrl: "https://github.com/clash-verge-rev/clash-verge-rev/releases"},
+			SubPageApp{Name: "FlClash", Platforms: []string{"android", "windows", "macos", "linux"},
+				ImportUrl:   "clash://install-config?url=" + url.QueryEscape(withFormat(subURL, SubFormatClash)) + "&name=" + url.QueryEscape(name),
+				DownloadUrl: "https://github.com/chen08209/FlClash/releases"})
+	}
+	return apps
+}
+
+// withFormat adds the format query parameter to a subscription URL.
+func withFormat(subURL string, format string) string {
+	if strings.Contains(subURL, "?") {
+		return subURL + "&format=" + format
+	}
+	return subURL + "?format=" + format
+}
+
+// qrPng encodes content as a QR code PNG image of size pixels.
+func qrPng(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+// qrSvg encodes content as a QR code SVG image of size pixels, one path for all dark modules.
+func qrSvg(content string, size int) ([]byte, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := code.Bitmap()
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x, y)
+			}
+		}
+	}
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	svg.WriteString(`<rect width="100%" height="100%" fill="#fff"/>`)
+	fmt.Fprintf(&svg, `<path fill="#000" d="%s"/></svg>`, path.String())
+	return []byte(svg.String()), nil
+}
@@ -0,0 +1,165 @@
+package sub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+)
+
+func TestParseSubPageTranslations(t *testing.T) {
+	t.Setenv("XUI_LOG_FOLDER", t.TempDir())
+	logger.InitLogger(logging.ERROR)
+	defer logger.CloseLogger()
+
+	translations := parseSubPageTranslations(`{"pt-BR":{"title":"Assinatura"},"de":{"support":"t.me/de"}}`)
+	if len(translations) != 2 || translations["pt-br"].Title != "Assinatura" || translations["de"].Support != "t.me/de" {
+		t.Fatalf("unexpected translations %+v", translations)
+	}
+	if translations := parseSubPageTranslations(""); translations != nil {
+		t.Fatalf("expected no translations, got %+v", translations)
+	}
+	if translations := parseSubPageTranslations(`["de"]`); translations != nil {
+		t.Fatalf("expected invalid translations to be ignored, got %+v", translations)
+	}
+}
+
+func TestBrandingFor(t *testing.T) {
+	a := &SUBController{
+		pageBranding: SubPageBranding{Title: "VPN", Support: "t.me/support", Announcement: "Hello"},
+		pageTranslations: map[string]subPageTranslation{
+			"pt-br": {Title: "VPN Brasil"},
+			"pt":    {Title: "VPN Portugal", Announcement: "Olá"},
+			"de":    {Announcement: "Hallo"},
+		},
+	}
+	tests := []struct {
+		name      string
+		languages string
+		branding  SubPageBranding
+	}{
+		{name: "no language", languages: "", branding: a.pageBranding},
+		{name: "untranslated language", languages: "fr-FR,fr;q=0.9", branding: a.pageBranding},
+		{name: "full tag", languages: "pt-BR", branding: SubPageBranding{Title: "VPN Brasil", Support: "t.me/support", Announcement: "Hello"}},
+		{name: "base language", languages: "pt-PT", branding: SubPageBranding{Title: "VPN Portugal", Support: "t.me/support", Announcement: "Olá"}},
+		{name: "first translated language", languages: "fr,de;q=0.8,pt;q=0.5", branding: SubPageBranding{Title: "VPN", Support: "t.me/support", Announcement: "Hallo"}},
+		{name: "quality order", languages: "pt;q=0.5,de;q=0.8", branding: SubPageBranding{Title: "VPN", Support: "t.me/support", Announcement: "Hallo"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if branding := a.brandingFor(test.languages); branding != test.branding {
+				t.Fatalf("expected %+v, got %+v", test.branding, branding)
+			}
+		})
+	}
+	if branding := (&SUBController{pageBranding: a.pageBranding}).brandingFor("de"); branding != a.pageBranding {
+		t.Fatalf("expected the branding without translations, got %+v", branding)
+	}
+}
+
+func TestSubPage(t *testing.T) {
+	t.Setenv("XUI_LOG_FOLDER", t.TempDir())
+	logger.InitLogger(logging.ERROR)
+	defer logger.CloseLogger()
+	if err := database.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	defer database.CloseDB()
+	gin.SetMode(gin.TestMode)
+
+	inbound := &model.Inbound{Enable: true, Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001", Remark: "main",
+		StreamSettings: `{"network":"tcp","security":"none"}`,
+		Settings: `{"clients":[
+			{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01","email":"alice","enable":true,"subId":"page"},
+			{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","email":"alice-2","enable":true,"subId":"page"}
+		],"decryption":"none"}`}
+	if _, _, err := (&service.InboundService{}).AddInbound(inbound); err != nil {
+		t.Fatalf("add inbound: %v", err)
+	}
+	engine := gin.New()
+	NewSUBController(engine.Group("/"), "/sub/", "/json/", false, "/clash/", false, "/singbox/", false, false, false, "-ieo", "12",
+		"", "", "", "", "", "", "VPN", "", "", "", "", "", 0,
+		SubPageBranding{Announcement: "Hello"}, `{"de":{"announcement":"Hallo"},"fr":{"announcement":"Bonjour"}}`, nil)
+	get := func(target string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = "sub.example.com"
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("page data", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			target       string
+			header       map[string]string
+			announcement string
+		}{
+			{name: "default", target: "/sub/page?view=json", announcement: "Hello"},
+			{name: "accept language", target: "/sub/page?view=json", header: map[string]string{"Accept-Language": "de-DE,de;q=0.9"}, announcement: "Hallo"},
+			{name: "cookie over accept language", target: "/sub/page?view=json",
+				header: map[string]string{"Accept-Language": "de", "Cookie": "lang=fr"}, announcement: "Bonjour"},
+			{name: "lang over accept language", target: "/sub/page?view=json&lang=fr", header: map[string]string{"Accept-Language": "de"}, announcement: "Bonjour"},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				w := get(test.target, test.header)
+				var page PageData
+				if err := json.Unmarshal(w.Body.Bytes(), &page); w.Code != http.StatusOK || err != nil {
+					t.Fatalf("unexpected response %d %s (%v)", w.Code, w.Body.String(), err)
+				}
+				if page.SId != "page" || len(page.Result) != 2 || page.Branding.Title != "VPN" || page.Branding.Announcement != test.announcement ||
+					page.QrUrl != "http://sub.example.com/sub/page/qr" || len(page.Apps) == 0 || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+					t.Fatalf("unexpected page data %+v", page)
+				}
+			})
+		}
+		if w := get("/sub/unknown?view=json", nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected unknown subscriptions to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("qr", func(t *testing.T) {
+		tests := []struct {
+			query       string
+			status      int
+			contentType string
+		}{
+			{query: "", status: http.StatusOK, contentType: "image/png"},
+			{query: "?link=1&format=svg", status: http.StatusOK, contentType: "image/svg+xml"},
+			{query: "?link=2", status: http.StatusBadRequest},
+			{query: "?link=-1", status: http.StatusBadRequest},
+			{query: "?link=first", status: http.StatusBadRequest},
+			{query: "?size=64", status: http.StatusOK, contentType: "image/png"},
+			{query: "?size=1024&format=png", status: http.StatusOK, contentType: "image/png"},
+			{query: "?size=63", status: http.StatusBadRequest},
+			{query: "?size=1025", status: http.StatusBadRequest},
+			{query: "?format=gif", status: http.StatusBadRequest},
+		}
+		for _, test := range tests {
+			t.Run(test.query, func(t *testing.T) {
+				w := get("/sub/page/qr"+test.query, nil)
+				if w.Code != test.status || (test.contentType != "" && w.Header().Get("Content-Type") != test.contentType) {
+					t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+				}
+			})
+		}
+		svg := get("/sub/page/qr?format=svg&size=128", nil).Body.String()
+		if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="128" height="128"`) {
+			t.Fatalf("unexpected svg %s", svg)
+		}
+	})
+}
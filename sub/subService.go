@@ -22,19 +22,27 @@ import (
 
 // SubService provides business logic for generating subscription links and managing subscription data.
 type SubService struct {
-	address        string
-	showInfo       bool
-	remarkModel    string
-	datepicker     string
-	inboundService service.InboundService
-	settingService service.SettingService
+	address           string
+	showInfo          bool
+	remarkModel       string
+	remarkTemplate    string
+	titleTemplate     string
+	datepicker        string
+	inboundService    service.InboundService
+	settingService    service.SettingService
+	clientUserService service.ClientUserService
+
+	endpointProfileService service.EndpointProfileService
 }
 
 // NewSubService creates a new subscription service with the given configuration.
-func NewSubService(showInfo bool, remarkModel string) *SubService {
+// Empty templates keep the remark model and the subscription title.
+func NewSubService(showInfo bool, remarkModel string, remarkTemplate string, titleTemplate string) *SubService {
 	return &SubService{
-		showInfo:    showInfo,
-		remarkModel: remarkModel,
+		showInfo:       showInfo,
+		remarkModel:    remarkModel,
+		remarkTemplate: remarkTemplate,
+		titleTemplate:  titleTemplate,
 	}
 }
 
@@ -58,6 +66,7 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 	if err != nil {
 		s.datepicker = "gregorian"
 	}
+	profiles := s.getEndpointProfiles(subId)
 	for _, inbound := range inbounds {
 		clients, err := s.inboundService.GetClients(inbound)
 		if err != nil {
@@ -74,10 +83,12 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 				inbound.StreamSettings = streamSettings
 			}
 		}
+		expanded := s.endpointInbounds(inbound, profiles, host)
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
-				link := s.getLink(inbound, client.Email)
-				result = append(result, link)
+				for _, endpoint := range expanded {
+					result = append(result, s.getLink(endpoint, client.Email))
+				}
 				ct := s.getClientTraffics(inbound.ClientStats, client.Email)
 				clientTraffics = append(clientTraffics, ct)
 				if ct.LastOnline > lastOnline {
@@ -87,7 +98,15 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 		}
 	}
 
-	// Prepare statistics
+	traffic = s.sumClientTraffics(subId, clientTraffics)
+	return result, lastOnline, traffic, nil
+}
+
+// sumClientTraffics adds up the traffic of the clients of a subscription. The total and the
+// expiry time are only kept if all clients have one, and are replaced with the shared limits of
+// the user if the subscription belongs to one.
+func (s *SubService) sumClientTraffics(subId string, clientTraffics []xray.ClientTraffic) xray.ClientTraffic {
+	var traffic xray.ClientTraffic
 	for index, clientTraffic := range clientTraffics {
 		if index == 0 {
 			traffic.Up = clientTraffic.Up
@@ -109,26 +128,55 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 			}
 		}
 	}
-	return result, lastOnline, traffic, nil
+	s.applyClientUserLimits(subId, &traffic)
+	return traffic
+}
+
+// applyClientUserLimits replaces the summed limits of the clients with the shared limits of
+// the user if the subscription belongs to one.
+func (s *SubService) applyClientUserLimits(subId string, traffic *xray.ClientTraffic) {
+	user, err := s.clientUserService.GetClientUserBySubId(subId)
+	if err != nil {
+		logger.Warning("SubService - GetClientUserBySubId:", err)
+		return
+	}
+	if user != nil {
+		traffic.Total = user.TotalGB
+		traffic.ExpiryTime = user.ExpiryTime
+	}
+}
+
+// uniqueRemark returns the remark, numbered if it is already in names, and adds it to names.
+// Clients that refer to configs by name, like Clash and sing-box, need unique names.
+func uniqueRemark(names map[string]bool, remark string) string {
+	name := remark
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s %d", remark, i)
+	}
+	names[name] = true
+	return name
 }
 
 func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Where(`id in (
-		SELECT DISTINCT inbounds.id
-		FROM inbounds,
-			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client 
-		WHERE
-			protocol in ('vmess','vless','trojan','shadowsocks')
-			AND JSON_EXTRACT(client.value, '$.subId') = ? AND enable = ?
-	)`, subId, true).Find(&inbounds).Error
+	err := db.Model(model.Inbound{}).Preload("ClientStats").
+		Where("id IN (?)", db.Model(model.InboundClient{}).Select("inbound_id").Where("sub_id = ?", subId)).
+		Where("protocol IN ? AND enable = ?", []string{"vmess", "vless", "trojan", "shadowsocks"}, true).
+		Find(&inbounds).Error
 	if err != nil {
 		return nil, err
 	}
 	return inbounds, nil
 }
 
+// HasSubId reports whether a client of any inbound, enabled or not, has the subscription ID.
+func (s *SubService) HasSubId(subId string) bool {
+	var count int64
+	err := database.GetDB().Model(model.InboundClient{}).Where("sub_id = ?", subId).Limit(1).Count(&count).Error
+	return err == nil && count > 0
+}
+
 func (s *SubService) getClientTraffics(traffics []xray.ClientTraffic, email string) xray.ClientTraffic {
 	for _, traffic := range traffics {
 		if traffic.Email == email {
@@ -885,6 +933,9 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 }
 
 func (s *SubService) genRemark(inbound *model.Inbound, email string, extra string) string {
+	if remark, ok := s.genTemplateRemark(inbound, email, extra); ok {
+		return remark
+	}
 	separationChar := string(s.remarkModel[0])
 	orderChars := s.remarkModel[1:]
 	orders := map[byte]string{
@@ -969,6 +1020,67 @@ func (s *SubService) genRemark(inbound *model.Inbound, email string, extra strin
 	return strings.Join(remark, separationChar)
 }
 
+// genTemplateRemark renders the remark template of the inbound, or else of the settings.
+// Returns false if there is no template or it fails to render.
+func (s *SubService) genTemplateRemark(inbound *model.Inbound, email string, extra string) (string, bool) {
+	text := inbound.SubRemarkTemplate
+	if text == "" {
+		text = s.remarkTemplate
+	}
+	if text == "" {
+		return "", false
+	}
+	data := s.templateData(inbound, email)
+	data.Extra = extra
+	remark, err := service.RenderSubTemplate(service.SubTemplateRemark, text, data)
+	if err != nil {
+		logger.Warning("SubService - remark template:", err)
+		return "", false
+	}
+	return remark, true
+}
+
+// templateData builds the template variables of a client of an inbound.
+func (s *SubService) templateData(inbound *model.Inbound, email string) *service.SubTemplateData {
+	var client *model.Client
+	clients, _ := s.inboundService.GetClients(inbound)
+	for i := range clients {
+		if clients[i].Email == email {
+			client = &clients[i]
+			break
+		}
+	}
+	subId := ""
+	if client != nil {
+		subId = client.SubID
+	}
+	return service.NewSubTemplateData(subId, s.address, inbound, client, s.getClientTraffics(inbound.ClientStats, email))
+}
+
+// GetProfileTitle returns the profile title of a subscription, rendered from the title template
+// if there is one.
+func (s *SubService) GetProfileTitle(subId string, host string, title string) string {
+	if s.titleTemplate == "" {
+		return title
+	}
+	db := database.GetDB()
+	var clientTraffics []xray.ClientTraffic
+	err := db.Model(xray.ClientTraffic{}).
+		Where("email IN (?)", db.Model(model.InboundClient{}).Select("email").Where("sub_id = ? AND enable = ?", subId, true)).
+		Find(&clientTraffics).Error
+	if err != nil {
+		logger.Warning("SubService - title template:", err)
+		return title
+	}
+	data := service.NewSubTemplateData(subId, host, nil, nil, s.sumClientTraffics(subId, clientTraffics))
+	result, err := service.RenderSubTemplate(service.SubTemplateTitle, s.titleTemplate, data)
+	if err != nil {
+		logger.Warning("SubService - title template:", err)
+		return title
+	}
+	return result
+}
+
 func searchKey(data any, key string) (any, bool) {
 	switch val := data.(type) {
 	case map[string]any:
@@ -1012,25 +1124,28 @@ func searchHost(headers any) string {
 }
 
 // PageData is a view model for subpage.html
-// PageData contains data for rendering the subscription information page.
+// PageData contains data for rendering the subscription information page, which is also served as JSON.
 type PageData struct {
-	Host         string
-	BasePath     string
-	SId          string
-	Download     string
-	Upload       string
-	Total        string
-	Used         string
-	Remained     string
-	Expire       int64
-	LastOnline   int64
-	Datepicker   string
-	DownloadByte int64
-	UploadByte   int64
-	TotalByte    int64
-	SubUrl       string
-	SubJsonUrl   string
-	Result       []string
+	Host         string          `json:"host"`
+	BasePath     string          `json:"-"`
+	SId          string          `json:"subId"`
+	Download     string          `json:"download"`
+	Upload       string          `json:"upload"`
+	Total        string          `json:"total"`
+	Used         string          `json:"used"`
+	Remained     string          `json:"remained"`
+	Expire       int64           `json:"expire"` // Expiry time in seconds, 0 for never
+	LastOnline   int64           `json:"lastOnline"`
+	Datepicker   string          `json:"datepicker"`
+	DownloadByte int64           `json:"downloadByte"`
+	UploadByte   int64           `json:"uploadByte"`
+	TotalByte    int64           `json:"totalByte"`
+	SubUrl       string          `json:"subUrl"`
+	SubJsonUrl   string          `json:"subJsonUrl"`
+	Result       []string        `json:"links"`
+	QrUrl        string          `json:"qrUrl"` // QR code of the subscription URL, add link=N for the N-th link and format=svg for SVG
+	Branding     SubPageBranding `json:"branding"`
+	Apps         []SubPageApp    `json:"apps"`
 }
 
 // ResolveRequest extracts scheme and host info from request/headers consistently.
@@ -0,0 +1,69 @@
+package sub
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subSignKeyPath is where the sub server publishes the public key that verifies signed subscriptions.
+const subSignKeyPath = "/.well-known/3x-ui-subscription-key"
+
+// subSignatureHeader carries the Ed25519 signature of the exact response body, in the form
+// keyId=<key ID>; alg=ed25519; sig=<standard base64 signature>.
+const subSignatureHeader = "Subscription-Signature"
+
+// signKey handles HTTP requests for the public subscription signing key.
+func (a *SUBController) signKey(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.JSON(http.StatusOK, a.signPublicKey)
+}
+
+// writeSub writes a subscription body with an ETag and, if signing is enabled, its signature.
+// A request whose If-None-Match matches the ETag gets 304 Not Modified without a body, so clients
+// that poll on the update interval only download changed subscriptions. The ETag depends on the
+// signing key, so a new key makes clients download the body with its new signature. It also
+// depends on the usage and title headers, which change while the body may stay the same.
+func (a *SUBController) writeSub(c *gin.Context, contentType string, body []byte) {
+	keyId := ""
+	if a.signPublicKey != nil {
+		keyId = a.signPublicKey.KeyId
+	}
+	header := c.Writer.Header()
+	hash := sha256.New()
+	for _, value := range []string{keyId, header.Get("Subscription-Userinfo"), header.Get("Profile-Title")} {
+		hash.Write([]byte(value + "\x00"))
+	}
+	hash.Write(body)
+	sum := hash.Sum(nil)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if a.signPrivateKey != nil {
+		signature := ed25519.Sign(a.signPrivateKey, body)
+		c.Header(subSignatureHeader, "keyId="+keyId+"; alg=ed25519; sig="+base64.StdEncoding.EncodeToString(signature))
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// etagMatches reports whether an If-None-Match header matches an ETag, using the weak comparison.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
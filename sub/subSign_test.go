@@ -0,0 +1,114 @@
+package sub
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		match       bool
+	}{
+		{ifNoneMatch: "", match: false},
+		{ifNoneMatch: `"abc"`, match: true},
+		{ifNoneMatch: `W/"abc"`, match: true},
+		{ifNoneMatch: `"xyz", "abc"`, match: true},
+		{ifNoneMatch: `"xyz"`, match: false},
+		{ifNoneMatch: `abc`, match: false},
+		{ifNoneMatch: `*`, match: true},
+	}
+	for _, test := range tests {
+		if match := etagMatches(test.ifNoneMatch, etag); match != test.match {
+			t.Fatalf("If-None-Match %q: expected %v, got %v", test.ifNoneMatch, test.match, match)
+		}
+	}
+}
+
+func TestWriteSub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &SUBController{}
+	signed := &SUBController{signPrivateKey: key, signPublicKey: service.NewSubSignKey(key)}
+	body := []byte("vless://link\n")
+
+	userinfo := "upload=1; download=2; total=0; expire=0"
+	write := func(a *SUBController, ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Header("Subscription-Userinfo", userinfo)
+		c.Request = httptest.NewRequest(http.MethodGet, "/sub/id", nil)
+		if ifNoneMatch != "" {
+			c.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		a.writeSub(c, "text/plain; charset=utf-8", body)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+	plainEtag := write(plain, "").Header().Get("ETag")
+	signedEtag := write(signed, "").Header().Get("ETag")
+	if plainEtag == "" || plainEtag == signedEtag {
+		t.Fatalf("expected the ETag to depend on the signing key, got %s and %s", plainEtag, signedEtag)
+	}
+	userinfo = "upload=5; download=2; total=0; expire=0"
+	if usedEtag := write(plain, plainEtag).Header().Get("ETag"); usedEtag == plainEtag {
+		t.Fatal("expected the ETag to change with the usage header")
+	}
+	userinfo = "upload=1; download=2; total=0; expire=0"
+
+	tests := []struct {
+		name        string
+		controller  *SUBController
+		ifNoneMatch string
+		status      int
+		signature   bool
+	}{
+		{name: "plain", controller: plain, status: http.StatusOK},
+		{name: "plain unchanged", controller: plain, ifNoneMatch: plainEtag, status: http.StatusNotModified},
+		{name: "plain with the signed etag", controller: plain, ifNoneMatch: signedEtag, status: http.StatusOK},
+		{name: "signed", controller: signed, status: http.StatusOK, signature: true},
+		{name: "signed unchanged", controller: signed, ifNoneMatch: "W/" + signedEtag, status: http.StatusNotModified},
+		{name: "signed after a key change", controller: signed, ifNoneMatch: plainEtag, status: http.StatusOK, signature: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := write(test.controller, test.ifNoneMatch)
+			if w.Code != test.status || w.Header().Get("Cache-Control") != "no-cache" {
+				t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+			}
+			if test.status == http.StatusNotModified {
+				if w.Body.Len() != 0 || w.Header().Get(subSignatureHeader) != "" {
+					t.Fatalf("expected no body and signature, got %q", w.Body.String())
+				}
+				return
+			}
+			if w.Body.String() != string(body) {
+				t.Fatalf("unexpected body %q", w.Body.String())
+			}
+			header := w.Header().Get(subSignatureHeader)
+			if !test.signature {
+				if header != "" {
+					t.Fatalf("unexpected signature %q", header)
+				}
+				return
+			}
+			prefix := "keyId=" + test.controller.signPublicKey.KeyId + "; alg=ed25519; sig="
+			signature, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, prefix))
+			if !strings.HasPrefix(header, prefix) || err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), body, signature) {
+				t.Fatalf("invalid signature header %q (%v)", header, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package sub
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+//go:embed default_singbox.json
+var defaultSingbox string
+
+// SubSingboxService handles sing-box profile subscription generation.
+type SubSingboxService struct {
+	configJson map[string]any
+
+	inboundService service.InboundService
+	SubService     *SubService
+	subJsonService *SubJsonService
+}
+
+// NewSubSingboxService creates a new sing-box subscription service. dns replaces the dns section
+// of the default profile and rules are put in front of its route rules, after the sniff and
+// hijack-dns actions. The stream settings are extracted the same way as for the JSON subscription.
+func NewSubSingboxService(dns string, rules string, subJsonService *SubJsonService) *SubSingboxService {
+	var configJson map[string]any
+	json.Unmarshal([]byte(defaultSingbox), &configJson)
+
+	if dns != "" {
+		var newDns map[string]any
+		if err := json.Unmarshal([]byte(dns), &newDns); err == nil {
+			configJson["dns"] = newDns
+		} else {
+			logger.Warning("SubSingboxService - invalid DNS template:", err)
+		}
+	}
+
+	if rules != "" {
+		var newRules []any
+		if err := json.Unmarshal([]byte(rules), &newRules); err == nil {
+			route, _ := configJson["route"].(map[string]any)
+			defaultRules, _ := route["rules"].([]any)
+			index := 0
+			for index < len(defaultRules) {
+				rule, _ := defaultRules[index].(map[string]any)
+				if action, _ := rule["action"].(string); action == "" || action == "route" {
+					break
+				}
+				index++
+			}
+			route["rules"] = append(append(defaultRules[:index:index], newRules...), defaultRules[index:]...)
+		} else {
+			logger.Warning("SubSingboxService - invalid route rules:", err)
+		}
+	}
+
+	return &SubSingboxService{
+		configJson:     configJson,
+		SubService:     subJsonService.SubService,
+		subJsonService: subJsonService,
+	}
+}
+
+// GetSingbox generates a sing-box profile for the given subscription ID and host.
+func (s *SubSingboxService) GetSingbox(subId string, host string) (string, string, error) {
+	inbounds, err := s.SubService.getInboundsBySubId(subId)
+	if err != nil {
+		return "", "", err
+	}
+	wgInbounds, err := s.getWireguardInbounds(subId)
+	if err != nil {
+		return "", "", err
+	}
+
+	var clientTraffics []xray.ClientTraffic
+	var outbounds []any
+	var endpoints []any
+	var tags []string
+	names := make(map[string]bool)
+	profiles := s.SubService.getEndpointProfiles(subId)
+
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			logger.Error("SubSingboxService - GetClients: Unable to get clients from inbound")
+		}
+		if clients == nil {
+			continue
+		}
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.SubService.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+
+		expanded := s.SubService.endpointInbounds(inbound, profiles, host)
+		for _, client := range clients {
+			if client.Enable && client.SubID == subId {
+				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
+				for _, endpoint := range expanded {
+					for _, outbound := range s.getOutbounds(endpoint, client, host) {
+						outbound["tag"] = uniqueRemark(names, outbound["tag"].(string))
+						tags = append(tags, outbound["tag"].(string))
+						outbounds = append(outbounds, outbound)
+					}
+				}
+			}
+		}
+	}
+
+	for _, inbound := range wgInbounds {
+		for _, endpoint := range s.getWireguardEndpoints(inbound, subId, host) {
+			endpoint["tag"] = uniqueRemark(names, endpoint["tag"].(string))
+			tags = append(tags, endpoint["tag"].(string))
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	if len(tags) == 0 {
+		return "", "", nil
+	}
+
+	traffic := s.SubService.sumClientTraffics(subId, clientTraffics)
+
+	newOutbounds := []any{
+		map[string]any{
+			"type":      "selector",
+			"tag":       "proxy",
+			"outbounds": append([]string{"auto"}, tags...),
+			"default":   "auto",
+		},
+		map[string]any{
+			"type":      "urltest",
+			"tag":       "auto",
+			"outbounds": tags,
+			"url":       "https://www.gstatic.com/generate_204",
+			"interval":  "5m",
+		},
+	}
+	newOutbounds = append(newOutbounds, outbounds...)
+	newOutbounds = append(newOutbounds, map[string]any{"type": "direct", "tag": "direct"})
+
+	newConfigJson := make(map[string]any)
+	for key, value := range s.configJson {
+		newConfigJson[key] = value
+	}
+	newConfigJson["outbounds"] = newOutbounds
+	if len(endpoints) > 0 {
+		newConfigJson["endpoints"] = endpoints
+	}
+
+	finalJson, err := json.MarshalIndent(newConfigJson, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+
+	header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
+	return string(finalJson), header, nil
+}
+
+// getOutbounds builds the sing-box outbounds of a client, one for each external proxy of the inbound.
+func (s *SubSingboxService) getOutbounds(inbound *model.Inbound, client model.Client, host string) []map[string]any {
+	var outbounds []map[string]any
+	stream := s.subJsonService.streamData(inbound.StreamSettings)
+
+	externalProxies, ok := stream["externalProxy"].([]any)
+	if !ok || len(externalProxies) == 0 {
+		externalProxies = []any{
+			map[string]any{
+				"forceTls": "same",
+				"dest":     host,
+				"port":     float64(inbound.Port),
+				"remark":   "",
+			},
+		}
+	}
+
+	transport, ok := s.singboxTransport(stream)
+	// sing-box has no transport options for shadowsocks other than plugins
+	if !ok || (inbound.Protocol == model.Shadowsocks && transport != nil) {
+		logger.Warningf("SubSingboxService - network %v of inbound %d is not supported by sing-box", stream["network"], inbound.Id)
+		return nil
+	}
+
+	for _, ep := range externalProxies {
+		extPrxy := ep.(map[string]any)
+		security, _ := stream["security"].(string)
+		switch extPrxy["forceTls"].(string) {
+		case "tls":
+			security = "tls"
+		case "none":
+			security = "none"
+		}
+
+		outbound := map[string]any{
+			"tag":         s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string)),
+			"server":      extPrxy["dest"].(string),
+			"server_port": int(extPrxy["port"].(float64)),
+		}
+
+		switch inbound.Protocol {
+		case model.VMESS:
+			cipher := client.Security
+			if cipher == "" {
+				cipher = "auto"
+			}
+			outbound["type"] = "vmess"
+			outbound["uuid"] = client.ID
+			outbound["security"] = cipher
+			outbound["alter_id"] = 0
+		case model.VLESS:
+			outbound["type"] = "vless"
+			outbound["uuid"] = client.ID
+			outbound["packet_encoding"] = "xudp"
+			if client.Flow != "" {
+				outbound["flow"] = client.Flow
+			}
+		case model.Trojan:
+			outbound["type"] = "trojan"
+			outbound["password"] = client.Password
+		case model.Shadowsocks:
+			var inboundSettings map[string]any
+			json.Unmarshal([]byte(inbound.Settings), &inboundSettings)
+			method, _ := inboundSettings["method"].(string)
+			password := client.Password
+			// server password in multi-user 2022 protocols
+			if strings.HasPrefix(method, "2022") {
+				if serverPassword, ok := inboundSettings["password"].(string); ok {
+					password = fmt.Sprintf("%s:%s", serverPassword, client.Password)
+				}
+			}
+			outbound["type"] = "shadowsocks"
+			outbound["method"] = method
+			outbound["password"] = password
+		default:
+			return nil
+		}
+
+		if inbound.Protocol != model.Shadowsocks {
+			if tls := s.singboxTls(security, stream); tls != nil {
+				outbound["tls"] = tls
+			}
+			if transport != nil {
+				outbound["transport"] = transport
+			}
+		}
+		outbounds = append(outbounds, outbound)
+	}
+
+	return outbounds
+}
+
+// singboxTls converts the TLS or Reality settings of a stream to a sing-box TLS object.
+// Returns nil if the stream does not use TLS.
+func (s *SubSingboxService) singboxTls(security string, stream map[string]any) map[string]any {
+	tls := map[string]any{"enabled": true}
+	switch security {
+	case "tls":
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		if serverName, _ := tlsSettings["serverName"].(string); serverName != "" {
+			tls["server_name"] = serverName
+		}
+		if alpn, _ := tlsSettings["alpn"].([]any); len(alpn) > 0 {
+			tls["alpn"] = alpn
+		}
+		if allowInsecure, _ := tlsSettings["allowInsecure"].(bool); allowInsecure {
+			tls["insecure"] = true
+		}
+		if fingerprint, _ := tlsSettings["fingerprint"].(string); fingerprint != "" {
+			tls["utls"] = map[string]any{"enabled": true, "fingerprint": fingerprint}
+		}
+	case "reality":
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		if serverName, _ := realitySettings["serverName"].(string); serverName != "" {
+			tls["server_name"] = serverName
+		}
+		// sing-box requires uTLS for Reality
+		fingerprint, _ := realitySettings["fingerprint"].(string)
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		tls["utls"] = map[string]any{"enabled": true, "fingerprint": fingerprint}
+		tls["reality"] = map[string]any{
+			"enabled":    true,
+			"public_key": realitySettings["publicKey"],
+			"short_id":   realitySettings["shortId"],
+		}
+	default:
+		return nil
+	}
+	return tls
+}
+
+// singboxTransport converts the transport settings of a stream to a sing-box V2Ray transport.
+// Returns nil for plain TCP and false for transports sing-box does not support.
+func (s *SubSingboxService) singboxTransport(stream map[string]any) (map[string]any, bool) {
+	network, _ := stream["network"].(string)
+	switch network {
+	case "", "tcp":
+		tcp, _ := stream["tcpSettings"].(map[string]any)
+		header, _ := tcp["header"].(map[string]any)
+		if typeStr, _ := header["type"].(string); typeStr != "http" {
+			return nil, true
+		}
+		request, _ := header["request"].(map[string]any)
+		transport := map[string]any{"type": "http"}
+		if paths, _ := request["path"].([]any); len(paths) > 0 {
+			transport["path"] = paths[0]
+		}
+		if host := searchHost(request["headers"]); host != "" {
+			transport["host"] = []string{host}
+		}
+		return transport, true
+	case "ws":
+		ws, _ := stream["wsSettings"].(map[string]any)
+		transport := map[string]any{"type": "ws", "path": ws["path"]}
+		host, _ := ws["host"].(string)
+		if host == "" {
+			host = searchHost(ws["headers"])
+		}
+		if host != "" {
+			transport["headers"] = map[string]any{"Host": host}
+		}
+		return transport, true
+	case "httpupgrade":
+		httpupgrade, _ := stream["httpupgradeSettings"].(map[string]any)
+		transport := map[string]any{"type": "httpupgrade", "path": httpupgrade["path"]}
+		if host, _ := httpupgrade["host"].(string); host != "" {
+			transport["host"] = host
+		}
+		return transport, true
+	case "grpc":
+		grpc, _ := stream["grpcSettings"].(map[string]any)
+		return map[string]any{"type": "grpc", "service_name": grpc["serviceName"]}, true
+	}
+	return nil, false
+}
+
+// getWireguardInbounds retrieves the enabled WireGuard inbounds with peers of the subscription.
+func (s *SubSingboxService) getWireguardInbounds(subId string) ([]*model.Inbound, error) {
+	var inbounds []*model.Inbound
+	err := database.GetDB().Model(model.Inbound{}).
+		Where("protocol = ? AND enable = ?", model.WireGuard, true).
+		Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	var result []*model.Inbound
+	for _, inbound := range inbounds {
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		if len(s.wireguardPeers(settings, subId)) > 0 {
+			result = append(result, inbound)
+		}
+	}
+	return result, nil
+}
+
+// wireguardPeers returns the indexes of the peers of WireGuard inbound settings that belong to the subscription.
+func (s *SubSingboxService) wireguardPeers(settings map[string]any, subId string) []int {
+	peers, _ := settings["peers"].([]any)
+	var indexes []int
+	for index, p := range peers {
+		peer, _ := p.(map[string]any)
+		if peerSubId, _ := peer["subId"].(string); peerSubId != "" && peerSubId == subId {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// getWireguardEndpoints builds a sing-box WireGuard endpoint for each peer of the subscription.
+func (s *SubSingboxService) getWireguardEndpoints(inbound *model.Inbound, subId string, host string) []map[string]any {
+	var settings map[string]any
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	secretKey, _ := settings["secretKey"].(string)
+	privateKey, err := base64.StdEncoding.DecodeString(secretKey)
+	if err != nil {
+		logger.Warningf("SubSingboxService - invalid secret key of inbound %d: %v", inbound.Id, err)
+		return nil
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		logger.Warningf("SubSingboxService - invalid secret key of inbound %d: %v", inbound.Id, err)
+		return nil
+	}
+
+	peers, _ := settings["peers"].([]any)
+	var endpoints []map[string]any
+	for _, index := range s.wireguardPeers(settings, subId) {
+		peer := peers[index].(map[string]any)
+		serverPeer := map[string]any{
+			"address":     host,
+			"port":        inbound.Port,
+			"public_key":  base64.StdEncoding.EncodeToString(publicKey),
+			"allowed_ips": []string{"0.0.0.0/0", "::/0"},
+		}
+		if psk, _ := peer["preSharedKey"].(string); psk != "" {
+			serverPeer["pre_shared_key"] = psk
+		}
+		if keepAlive, _ := peer["keepAlive"].(float64); keepAlive > 0 {
+			serverPeer["persistent_keepalive_interval"] = int(keepAlive)
+		}
+		endpoint := map[string]any{
+			"type":        "wireguard",
+			"tag":         s.SubService.genRemark(inbound, "", strconv.Itoa(index+1)),
+			"address":     peer["allowedIPs"],
+			"private_key": peer["privateKey"],
+			"peers":       []any{serverPeer},
+		}
+		if mtu, _ := settings["mtu"].(float64); mtu > 0 {
+			endpoint["mtu"] = int(mtu)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
@@ -0,0 +1,65 @@
+package sub
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSingboxStream(t *testing.T) {
+	s := &SubSingboxService{}
+	tests := []struct {
+		name      string
+		security  string
+		stream    string
+		transport string // JSON of the transport, empty for none
+		tls       string // JSON of the TLS object, empty for none
+		supported bool
+	}{
+		{name: "tcp", security: "none", stream: `{"network":"tcp"}`, supported: true},
+		{name: "tcp http header", security: "none",
+			stream:    `{"network":"tcp","tcpSettings":{"header":{"type":"http","request":{"path":["/a","/b"],"headers":{"Host":["h.example.com"]}}}}}`,
+			transport: `{"host":["h.example.com"],"path":"/a","type":"http"}`, supported: true},
+		{name: "ws tls", security: "tls",
+			stream:    `{"network":"ws","wsSettings":{"path":"/ws","host":"cdn.example.com"},"tlsSettings":{"serverName":"example.com","alpn":["h2"],"fingerprint":"firefox"}}`,
+			transport: `{"headers":{"Host":"cdn.example.com"},"path":"/ws","type":"ws"}`,
+			tls:       `{"alpn":["h2"],"enabled":true,"server_name":"example.com","utls":{"enabled":true,"fingerprint":"firefox"}}`, supported: true},
+		{name: "httpupgrade", security: "none",
+			stream:    `{"network":"httpupgrade","httpupgradeSettings":{"path":"/up","host":"up.example.com"}}`,
+			transport: `{"host":"up.example.com","path":"/up","type":"httpupgrade"}`, supported: true},
+		{name: "grpc reality", security: "reality",
+			stream:    `{"network":"grpc","grpcSettings":{"serviceName":"svc"},"realitySettings":{"serverName":"r.example.com","publicKey":"pk","shortId":"ab"}}`,
+			transport: `{"service_name":"svc","type":"grpc"}`,
+			tls:       `{"enabled":true,"reality":{"enabled":true,"public_key":"pk","short_id":"ab"},"server_name":"r.example.com","utls":{"enabled":true,"fingerprint":"chrome"}}`, supported: true},
+		{name: "xhttp", security: "none", stream: `{"network":"xhttp"}`, supported: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stream map[string]any
+			if err := json.Unmarshal([]byte(test.stream), &stream); err != nil {
+				t.Fatal(err)
+			}
+			transport, supported := s.singboxTransport(stream)
+			if supported != test.supported {
+				t.Fatalf("expected supported %v, got %v", test.supported, supported)
+			}
+			if got := singboxJson(t, transport); got != test.transport {
+				t.Fatalf("unexpected transport %s, want %s", got, test.transport)
+			}
+			if got := singboxJson(t, s.singboxTls(test.security, stream)); got != test.tls {
+				t.Fatalf("unexpected tls %s, want %s", got, test.tls)
+			}
+		})
+	}
+}
+
+// singboxJson returns the JSON of a sing-box object, or an empty string for nil.
+func singboxJson(t *testing.T, object map[string]any) string {
+	if object == nil {
+		return ""
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
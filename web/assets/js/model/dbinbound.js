@@ -12,6 +12,8 @@ class DBInbound {
         this.expiryTime = 0;
         this.trafficReset = "never";
         this.lastTrafficResetTime = 0;
+        this.subRemarkTemplate = "";
+        this.subJsonTemplate = "";
 
         this.listen = "";
         this.port = 0;
@@ -28,14 +28,14 @@ class AllSetting {
         this.xrayTemplateConfig = "";
         this.subEnable = true;
         this.subJsonEnable = false;
+        this.subClashEnable = false;
+        this.subSingboxEnable = false;
         this.subTitle = "";
         this.subListen = "";
         this.subPort = 2096;
         this.subPath = "/sub/";
         this.subJsonPath = "/json/";
         this.subDomain = "";
-        this.externalTrafficInformEnable = false;
-        this.externalTrafficInformURI = "";
         this.subCertFile = "";
         this.subKeyFile = "";
         this.subUpdates = 12;
@@ -47,8 +47,42 @@ class AllSetting {
         this.subJsonNoises = "";
         this.subJsonMux = "";
         this.subJsonRules = "";
+        this.subClashPath = "/clash/";
+        this.subClashURI = "";
+        this.subSingboxPath = "/singbox/";
+        this.subSingboxURI = "";
+        this.subSingboxDns = "";
+        this.subSingboxRules = "";
+        this.subRemarkTemplate = "";
+        this.subTitleTemplate = "";
+        this.subJsonTemplate = "";
+        this.subNodes = "";
+        this.subNodeCacheTtl = 60;
+        this.subPageLogo = "";
+        this.subPageColor = "";
+        this.subPageSupport = "";
+        this.subPageAnnouncement = "";
+        this.subPageTranslations = "";
+        this.subSignEnable = false;
+        this.subTrustedProxies = "127.0.0.1,::1";
+        this.subFormatRules = '';
 
         this.timeLocation = "Local";
+        this.auditRetentionDays = 90;
+        this.trafficHistoryDays = 365;
+        this.subAccessLogDays = 30;
+        this.subAnomalyIps = 10;
+        this.connectionLogEnable = false;
+        this.connectionLogDays = 7;
+        this.connectionLogMaxRecords = 1000000;
+        this.xrayStableMinutes = 5;
+        this.xrayCrashLoopCount = 5;
+        this.xrayRollbackEnable = true;
+        this.ipLimitBackend = "fail2ban";
+        this.ipLimitBanMinutes = 30;
+        this.metricsEnable = false;
+        this.metricsToken = "";
+        this.metricsClientLimit = 500;
 
         // LDAP settings
         this.ldapEnable = false;
@@ -5,6 +5,16 @@
   const textarea = document.getElementById('subscription-links');
   const rawLinks = (textarea?.value || '').split('\n').filter(Boolean);
 
+  // Branding, client apps and QR endpoint, the same data the page serves with ?view=json
+  let page = {};
+  try {
+    page = JSON.parse(document.getElementById('subscription-page')?.textContent || '{}') || {};
+  } catch (e) {
+    console.warn(e);
+  }
+  page.branding = page.branding || {};
+  page.apps = page.apps || [];
+
   const data = {
     sId: el.getAttribute('data-sid') || '',
     subUrl: el.getAttribute('data-sub-url') || '',
@@ -49,6 +59,14 @@
     window.location.href = url;
   }
 
+  const platformInfo = [
+    { key: 'android', name: 'Android', icon: 'android' },
+    { key: 'ios', name: 'iOS', icon: 'apple' },
+    { key: 'windows', name: 'Windows', icon: 'windows' },
+    { key: 'macos', name: 'macOS', icon: 'apple' },
+    { key: 'linux', name: 'Linux', icon: 'desktop' },
+  ];
+
   function drawQR(value) {
     try {
       new QRious({ element: document.getElementById('qrcode'), value, size: 220 });
@@ -90,6 +108,7 @@
     data: {
       themeSwitcher,
       app: data,
+      page,
       links: rawLinks,
       lang: '',
       viewportWidth: (typeof window !== 'undefined' ? window.innerWidth : 1024),
@@ -125,33 +144,28 @@
         const trafficOk = !this.app.totalByte || (this.app.uploadByte + this.app.downloadByte) <= this.app.totalByte;
         return expiryOk && trafficOk;
       },
-      shadowrocketUrl() {
-        const rawUrl = this.app.subUrl + '?flag=shadowrocket';
-        const base64Url = btoa(rawUrl);
-        const remark = encodeURIComponent(this.app.sId || 'Subscription');
-        return `shadowrocket://add/sub/${base64Url}?remark=${remark}`;
-      },
-      v2boxUrl() {
-        return `v2box://install-sub?url=${encodeURIComponent(this.app.subUrl)}&name=${encodeURIComponent(this.app.sId)}`;
+      platforms() {
+        return platformInfo
+          .map(p => ({ ...p, apps: this.page.apps.filter(a => (a.platforms || []).includes(p.key)) }))
+          .filter(p => p.apps.length > 0);
       },
-      streisandUrl() {
-        return `streisand://import/${encodeURIComponent(this.app.subUrl)}`;
+      accentStyle() {
+        const color = this.page.branding.color;
+        return color ? { backgroundColor: color, borderColor: color } : {};
       },
-      v2raytunUrl() {
-        return this.app.subUrl; 
-      },
-      npvtunUrl() {
-        return this.app.subUrl; 
-      },
-	  happUrl() {
-		return `happ://add/${encodeURIComponent(this.app.subUrl)}`;
-	  }
     },
     methods: {
       renderLink,
       copy,
       open,
       linkName,
+      importApp(item) {
+        if (item.importUrl) {
+          open(item.importUrl);
+        } else {
+          copy(this.app.subUrl);
+        }
+      },
       i18nLabel(key) {
         return '{{ i18n "' + key + '" }}';
       },
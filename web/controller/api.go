@@ -2,7 +2,10 @@ package controller
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -12,10 +15,19 @@ import (
 // APIController handles the main API routes for the 3x-ui panel, including inbounds, outbounds, and server management.
 type APIController struct {
 	BaseController
-	inboundController  *InboundController
-	outboundController *OutboundController
-	serverController   *ServerController
-	Tgbot              service.Tgbot
+	inboundController         *InboundController
+	outboundController        *OutboundController
+	serverController          *ServerController
+	userController            *UserController
+	apiTokenController        *APITokenController
+	auditController           *AuditController
+	connectionLogController   *ConnectionLogController
+	webhookController         *WebhookController
+	clientUserController      *ClientUserController
+	clientController          *ClientController
+	endpointProfileController *EndpointProfileController
+	Tgbot                     service.Tgbot
+	apiTokenService           service.APITokenService
 }
 
 // NewAPIController creates a new APIController instance and initializes its routes.
@@ -26,9 +38,24 @@ func NewAPIController(g *gin.RouterGroup) *APIController {
 }
 
 // checkAPIAuth is a middleware that returns 404 for unauthenticated API requests
-// to hide the existence of API endpoints from unauthorized users
+// to hide the existence of API endpoints from unauthorized users.
+// Requests may authenticate either with a session cookie or an "Authorization: Bearer" API token.
 func (a *APIController) checkAPIAuth(c *gin.Context) {
-	if !session.IsLogin(c) {
+	if plain, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		token, user, err := a.apiTokenService.Authenticate(strings.TrimSpace(plain), getRemoteIp(c))
+		if err != nil {
+			logger.Warningf("API token rejected for %s %s, IP: %s: %v", c.Request.Method, c.Request.URL.Path, getRemoteIp(c), err)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Set(apiTokenKey, token)
+		session.SetLoginUser(c, user)
+		c.Next()
+		logger.Infof("API token \"%s\" (id %d) of %s: %s %s -> %d, IP: %s",
+			token.Name, token.Id, user.Username, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), getRemoteIp(c))
+		return
+	}
+	if !refreshLoginUser(c) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
@@ -49,12 +76,51 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	outbounds := api.Group("/outbounds")
 	a.outboundController = NewOutboundController(outbounds)
 
+	// Clients API
+	clients := api.Group("/clients")
+	a.clientController = NewClientController(clients)
+
+	// Client users API
+	clientUsers := api.Group("/clientUsers")
+	clientUsers.Use(checkRole(model.RoleOwner, model.RoleOperator))
+	a.clientUserController = NewClientUserController(clientUsers)
+
+	// Endpoint profiles API
+	endpointProfiles := api.Group("/endpointProfiles")
+	endpointProfiles.Use(checkRole(model.RoleOwner, model.RoleOperator))
+	a.endpointProfileController = NewEndpointProfileController(endpointProfiles)
+
 	// Server API
 	server := api.Group("/server")
 	a.serverController = NewServerController(server)
 
+	// Users API
+	users := api.Group("/users")
+	users.Use(checkRole(model.RoleOwner), checkScope(model.ScopeUsersAdmin))
+	a.userController = NewUserController(users)
+
+	// API tokens can only be managed from an interactive login
+	tokens := api.Group("/tokens")
+	tokens.Use(checkSession)
+	a.apiTokenController = NewAPITokenController(tokens)
+
+	// Audit log API
+	audit := api.Group("/audit")
+	audit.Use(checkRole(model.RoleOwner), checkScope(model.ScopeAuditRead))
+	a.auditController = NewAuditController(audit)
+
+	// Client connection log API
+	connections := api.Group("/connections")
+	connections.Use(checkRole(model.RoleOwner), checkScope(model.ScopeConnectionsRead))
+	a.connectionLogController = NewConnectionLogController(connections)
+
+	// Webhooks API
+	webhooks := api.Group("/webhooks")
+	webhooks.Use(checkRole(model.RoleOwner), checkScope(model.ScopeWebhooksAdmin))
+	a.webhookController = NewWebhookController(webhooks)
+
 	// Extra routes
-	api.GET("/backuptotgbot", a.BackuptoTgbot)
+	api.GET("/backuptotgbot", checkRole(model.RoleOwner), checkScope(model.ScopeServerAdmin), a.BackuptoTgbot)
 }
 
 // BackuptoTgbot sends a backup of the panel data to Telegram bot admins.
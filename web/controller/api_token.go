@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenForm represents the form for creating an API token.
+type apiTokenForm struct {
+	Name       string `json:"name" form:"name"`
+	Scopes     string `json:"scopes" form:"scopes"`         // Comma-separated list of scopes
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Expiration timestamp in milliseconds, 0 means never
+}
+
+// APITokenController handles management of the API tokens of the logged-in user.
+// Owners can see and revoke the tokens of every user.
+type APITokenController struct {
+	apiTokenService service.APITokenService
+}
+
+// NewAPITokenController creates a new APITokenController and sets up its routes.
+func NewAPITokenController(g *gin.RouterGroup) *APITokenController {
+	a := &APITokenController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for API token management.
+func (a *APITokenController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getTokens)
+
+	g.POST("/add", a.addToken)
+	g.POST("/del/:id", a.delToken)
+}
+
+// scopeUserId returns the user whose tokens the logged-in user manages, or 0 for all users.
+func (a *APITokenController) scopeUserId(c *gin.Context) int {
+	user := session.GetLoginUser(c)
+	if user.HasRole(model.RoleOwner) {
+		return 0
+	}
+	return user.Id
+}
+
+// getTokens retrieves the list of API tokens.
+func (a *APITokenController) getTokens(c *gin.Context) {
+	tokens, err := a.apiTokenService.GetTokens(a.scopeUserId(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.apiTokens.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, tokens, nil)
+}
+
+// addToken creates a new API token for the logged-in user and returns the plain token once.
+func (a *APITokenController) addToken(c *gin.Context) {
+	form := &apiTokenForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.apiTokens.toasts.tokenCreateSuccess"), err)
+		return
+	}
+	user := session.GetLoginUser(c)
+	plain, token, err := a.apiTokenService.AddToken(user.Id, form.Name, strings.Split(form.Scopes, ","), form.ExpiryTime)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	audit(c, "token.add", fmt.Sprintf("token:%d", token.Id), nil, token)
+	jsonMsgObj(c, I18nWeb(c, "pages.apiTokens.toasts.tokenCreateSuccess"), gin.H{
+		"token": plain,
+		"info":  token,
+	}, nil)
+}
+
+// delToken revokes an API token by its ID.
+func (a *APITokenController) delToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.apiTokens.toasts.tokenDeleteSuccess"), err)
+		return
+	}
+	err = a.apiTokenService.DelToken(id, a.scopeUserId(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	audit(c, "token.del", fmt.Sprintf("token:%d", id), nil, nil)
+	jsonMsgObj(c, I18nWeb(c, "pages.apiTokens.toasts.tokenDeleteSuccess"), id, nil)
+}
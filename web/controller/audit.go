@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditController exposes the audit log of administrative actions. All routes are restricted to owners.
+type AuditController struct {
+	auditService service.AuditService
+}
+
+// NewAuditController creates a new AuditController and sets up its routes.
+func NewAuditController(g *gin.RouterGroup) *AuditController {
+	a := &AuditController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for the audit log.
+func (a *AuditController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getLogs)
+}
+
+// getLogs retrieves a page of audit log entries matching the query filters.
+func (a *AuditController) getLogs(c *gin.Context) {
+	filter := &service.AuditFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.audit.toasts.obtain"), err)
+		return
+	}
+	logs, total, err := a.auditService.GetLogs(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.audit.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, gin.H{"logs": logs, "total": total}, nil)
+}
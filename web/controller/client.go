@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rotateSubForm represents the form for rotating the subscription ID of a client.
+type rotateSubForm struct {
+	GraceHours int `json:"graceHours" form:"graceHours"` // Hours the old ID stays valid, 0 revokes it right away
+}
+
+// revokeSubTokenForm represents the form for revoking a former subscription ID of a client.
+type revokeSubTokenForm struct {
+	Token string `json:"token" form:"token"`
+}
+
+// ClientController handles operations on clients across inbounds.
+type ClientController struct {
+	inboundService service.InboundService
+	xrayService    service.XrayService
+}
+
+// NewClientController creates a new ClientController and sets up its routes.
+func NewClientController(g *gin.RouterGroup) *ClientController {
+	a := &ClientController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for client operations. Resellers only reach the clients of their own inbounds.
+func (a *ClientController) initRouter(g *gin.RouterGroup) {
+	writeClients := checkScope(model.ScopeClientsWrite)
+
+	g.Use(checkRole(model.RoleOwner, model.RoleOperator, model.RoleReseller))
+
+	g.GET("/export", checkScope(model.ScopeInboundsRead), a.exportClients)
+	g.GET("/subTokens/:email", checkScope(model.ScopeInboundsRead), a.getSubTokens)
+
+	g.POST("/bulk", writeClients, a.bulk)
+	g.POST("/import", writeClients, a.importClients)
+	g.POST("/rotateSub/:email", writeClients, a.rotateSub)
+	g.POST("/revokeSubToken/:email", writeClients, a.revokeSubToken)
+}
+
+// checkClientAccess responds with an error and returns false if the logged-in user may not access the client.
+func (a *ClientController) checkClientAccess(c *gin.Context, email string) bool {
+	if err := a.inboundService.CheckClientAccess(session.GetLoginUser(c), email); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.login.permissionDenied"), err)
+		return false
+	}
+	return true
+}
+
+// bulk applies an action to the clients matching a selector, or only lists them with dryRun.
+func (a *ClientController) bulk(c *gin.Context) {
+	req := &service.ClientBulkRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.bulkError"), err)
+		return
+	}
+	if user := session.GetLoginUser(c); !user.CanAccessAllInbounds() {
+		req.Selector.UserId = user.Id
+	}
+	result, needRestart, err := a.inboundService.BulkClients(req)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.bulkError"), err)
+		return
+	}
+	if !req.DryRun {
+		audit(c, "client.bulk", "clients", result.Clients, req)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.clients.toasts.bulkSuccess"), result, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// exportClients downloads the clients of the inbounds in the comma-separated inboundIds query,
+// or of all inbounds, as a csv or json file depending on the format query.
+func (a *ClientController) exportClients(c *gin.Context) {
+	var inboundIds []int
+	for _, field := range strings.Split(c.Query("inboundIds"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.clients.toasts.exportError"), err)
+			return
+		}
+		inboundIds = append(inboundIds, id)
+	}
+	format := c.DefaultQuery("format", service.ClientFormatCSV)
+	userId := 0
+	if user := session.GetLoginUser(c); !user.CanAccessAllInbounds() {
+		userId = user.Id
+	}
+	data, err := a.inboundService.ExportClients(inboundIds, format, userId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.exportError"), err)
+		return
+	}
+	audit(c, "client.export", "clients", nil, gin.H{"inboundIds": inboundIds, "format": format})
+
+	contentType := "text/csv"
+	if format == service.ClientFormatJSON {
+		contentType = "application/json"
+	}
+	c.Header("Content-Disposition", "attachment; filename=clients."+format)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// importClients imports a client csv or json file into an inbound and responds with the
+// validation report. Nothing is imported with dryRun or if the file has invalid records.
+func (a *ClientController) importClients(c *gin.Context) {
+	req := &service.ClientImportRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.importError"), err)
+		return
+	}
+	if err := a.inboundService.CheckInboundAccess(session.GetLoginUser(c), req.InboundId); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.login.permissionDenied"), err)
+		return
+	}
+	report, needRestart, err := a.inboundService.ImportClients(req)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.importError"), err)
+		return
+	}
+	switch {
+	case report.Invalid > 0:
+		jsonMsgObj(c, I18nWeb(c, "pages.clients.toasts.importInvalid"), report, fmt.Errorf("%d invalid records", report.Invalid))
+	case report.DryRun:
+		jsonMsgObj(c, I18nWeb(c, "pages.clients.toasts.importChecked"), report, nil)
+	default:
+		if report.Committed {
+			audit(c, "client.import", fmt.Sprintf("inbound:%d", req.InboundId), nil, report)
+		}
+		jsonMsgObj(c, I18nWeb(c, "pages.clients.toasts.importSuccess"), report, nil)
+	}
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// rotateSub gives the subscription of a client a new ID, optionally keeping the old one valid for a grace period.
+func (a *ClientController) rotateSub(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	form := &rotateSubForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.rotateSubError"), err)
+		return
+	}
+	rotation, err := a.inboundService.RotateSubId(email, form.GraceHours)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.rotateSubError"), err)
+		return
+	}
+	audit(c, "client.rotateSub", "client:"+email, gin.H{"subId": rotation.OldSubId},
+		gin.H{"subId": rotation.SubId, "graceHours": form.GraceHours, "emails": rotation.Emails})
+	jsonMsgObj(c, I18nWeb(c, "pages.clients.toasts.rotateSubSuccess"), rotation, nil)
+}
+
+// getSubTokens retrieves the former subscription IDs of a client that are still in their grace period.
+func (a *ClientController) getSubTokens(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	tokens, err := a.inboundService.GetSubTokens(email)
+	jsonObj(c, tokens, err)
+}
+
+// revokeSubToken ends the grace period of a former subscription ID of a client.
+func (a *ClientController) revokeSubToken(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	form := &revokeSubTokenForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.revokeSubTokenError"), err)
+		return
+	}
+	if err := a.inboundService.RevokeSubToken(email, form.Token); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clients.toasts.revokeSubTokenError"), err)
+		return
+	}
+	audit(c, "client.revokeSubToken", "client:"+email, gin.H{"subId": form.Token}, nil)
+	jsonMsg(c, I18nWeb(c, "pages.clients.toasts.revokeSubTokenSuccess"), nil)
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientUserController handles client users, which own clients on several inbounds with shared
+// limits and one subscription. Routes are restricted to owners and operators.
+type ClientUserController struct {
+	clientUserService service.ClientUserService
+	xrayService       service.XrayService
+}
+
+// NewClientUserController creates a new ClientUserController and sets up its routes.
+func NewClientUserController(g *gin.RouterGroup) *ClientUserController {
+	a := &ClientUserController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for client user management.
+func (a *ClientUserController) initRouter(g *gin.RouterGroup) {
+	writeClients := checkScope(model.ScopeClientsWrite)
+
+	g.Use(checkScope(model.ScopeInboundsRead))
+
+	g.GET("/list", a.getClientUsers)
+	g.GET("/get/:id", a.getClientUser)
+
+	g.POST("/add", writeClients, a.addClientUser)
+	g.POST("/update/:id", writeClients, a.updateClientUser)
+	g.POST("/del/:id", writeClients, a.delClientUser)
+	g.POST("/:id/attach/:email", writeClients, a.attachClient)
+	g.POST("/:id/detach/:email", writeClients, a.detachClient)
+	g.POST("/resetTraffic/:id", writeClients, a.resetTraffic)
+}
+
+// getClientUsers retrieves the client users with their clients and traffic.
+func (a *ClientUserController) getClientUsers(c *gin.Context) {
+	users, err := a.clientUserService.GetClientUsers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, users, nil)
+}
+
+// getClientUser retrieves a client user by its ID.
+func (a *ClientUserController) getClientUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.obtain"), err)
+		return
+	}
+	user, err := a.clientUserService.GetClientUser(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, user, nil)
+}
+
+// addClientUser creates a client user. The generated subscription id is returned if none was given.
+func (a *ClientUserController) addClientUser(c *gin.Context) {
+	user := &model.ClientUser{}
+	err := c.ShouldBind(user)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.userCreateSuccess"), err)
+		return
+	}
+	err = a.clientUserService.AddClientUser(user)
+	if err == nil {
+		audit(c, "clientUser.add", fmt.Sprintf("clientUser:%d", user.Id), nil, user)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.clientUsers.toasts.userCreateSuccess"), user, err)
+}
+
+// updateClientUser updates a client user by its ID and applies it to its clients.
+func (a *ClientUserController) updateClientUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.userUpdateSuccess"), err)
+		return
+	}
+	user := &model.ClientUser{}
+	err = c.ShouldBind(user)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.userUpdateSuccess"), err)
+		return
+	}
+	user.Id = id
+	old, _ := a.clientUserService.GetClientUser(id)
+	needRestart, err := a.clientUserService.UpdateClientUser(user)
+	if err == nil {
+		audit(c, "clientUser.update", fmt.Sprintf("clientUser:%d", id), old, user)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.clientUsers.toasts.userUpdateSuccess"), user, err)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// delClientUser deletes a client user by its ID. Its clients are kept with the limits of the user.
+func (a *ClientUserController) delClientUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.userDeleteSuccess"), err)
+		return
+	}
+	old, _ := a.clientUserService.GetClientUser(id)
+	needRestart, err := a.clientUserService.DelClientUser(id)
+	if err == nil {
+		audit(c, "clientUser.del", fmt.Sprintf("clientUser:%d", id), old, nil)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.clientUsers.toasts.userDeleteSuccess"), id, err)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// attachClient makes the client with the email part of a client user.
+func (a *ClientUserController) attachClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.clientAttachSuccess"), err)
+		return
+	}
+	email := c.Param("email")
+	needRestart, err := a.clientUserService.AttachClient(id, email)
+	if err == nil {
+		audit(c, "clientUser.attach", fmt.Sprintf("clientUser:%d", id), nil, gin.H{"email": email})
+	}
+	jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.clientAttachSuccess"), err)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// detachClient removes the client with the email from a client user.
+func (a *ClientUserController) detachClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.clientDetachSuccess"), err)
+		return
+	}
+	email := c.Param("email")
+	needRestart, err := a.clientUserService.DetachClient(id, email)
+	if err == nil {
+		audit(c, "clientUser.detach", fmt.Sprintf("clientUser:%d", id), gin.H{"email": email}, nil)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.clientDetachSuccess"), err)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// resetTraffic resets the traffic of all clients of a client user.
+func (a *ClientUserController) resetTraffic(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.resetTrafficSuccess"), err)
+		return
+	}
+	needRestart, err := a.clientUserService.ResetClientUserTraffic(id)
+	if err == nil {
+		audit(c, "clientUser.resetTraffic", fmt.Sprintf("clientUser:%d", id), nil, nil)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.clientUsers.toasts.resetTrafficSuccess"), err)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConnectionLogController exposes the client connections read from the Xray access log. All routes
+// are restricted to owners.
+type ConnectionLogController struct {
+	connectionLogService service.ConnectionLogService
+}
+
+// NewConnectionLogController creates a new ConnectionLogController and sets up its routes.
+func NewConnectionLogController(g *gin.RouterGroup) *ConnectionLogController {
+	a := &ConnectionLogController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for the connection log.
+func (a *ConnectionLogController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getConnections)
+	g.GET("/destinations/:email", a.getDestinations)
+	g.GET("/topDomains", a.getTopDomains)
+}
+
+// getConnections retrieves a page of client connections matching the query filters.
+func (a *ConnectionLogController) getConnections(c *gin.Context) {
+	filter := &service.ConnectionFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.connections.toasts.obtain"), err)
+		return
+	}
+	connections, total, err := a.connectionLogService.GetConnections(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.connections.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, gin.H{"connections": connections, "total": total}, nil)
+}
+
+// getDestinations summarizes the destinations of a client.
+func (a *ConnectionLogController) getDestinations(c *gin.Context) {
+	filter := &service.DestinationFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.connections.toasts.obtain"), err)
+		return
+	}
+	filter.Email = c.Param("email")
+	destinations, err := a.connectionLogService.GetDestinations(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.connections.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, destinations, nil)
+}
+
+// getTopDomains summarizes the destination domains of all clients, or of the client of the email query.
+func (a *ConnectionLogController) getTopDomains(c *gin.Context) {
+	filter := &service.DestinationFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.connections.toasts.obtain"), err)
+		return
+	}
+	domains, err := a.connectionLogService.GetTopDomains(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.connections.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, domains, nil)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EndpointProfileController handles endpoint profiles, which add links with the address of a CDN
+// or relay to subscriptions. Routes are restricted to owners and operators.
+type EndpointProfileController struct {
+	endpointProfileService service.EndpointProfileService
+}
+
+// NewEndpointProfileController creates a new EndpointProfileController and sets up its routes.
+func NewEndpointProfileController(g *gin.RouterGroup) *EndpointProfileController {
+	a := &EndpointProfileController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for endpoint profile management.
+func (a *EndpointProfileController) initRouter(g *gin.RouterGroup) {
+	writeInbounds := checkScope(model.ScopeInboundsWrite)
+
+	g.Use(checkScope(model.ScopeInboundsRead))
+
+	g.GET("/list", a.getProfiles)
+
+	g.POST("/add", writeInbounds, a.addProfile)
+	g.POST("/update/:id", writeInbounds, a.updateProfile)
+	g.POST("/del/:id", writeInbounds, a.delProfile)
+}
+
+// getProfiles retrieves the list of endpoint profiles.
+func (a *EndpointProfileController) getProfiles(c *gin.Context) {
+	profiles, err := a.endpointProfileService.GetProfiles()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.endpointProfiles.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, profiles, nil)
+}
+
+// addProfile creates a new endpoint profile.
+func (a *EndpointProfileController) addProfile(c *gin.Context) {
+	profile := &model.EndpointProfile{}
+	err := c.ShouldBind(profile)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileCreateSuccess"), err)
+		return
+	}
+	err = a.endpointProfileService.AddProfile(profile)
+	if err == nil {
+		audit(c, "endpointProfile.add", fmt.Sprintf("endpointProfile:%d", profile.Id), nil, profile)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileCreateSuccess"), profile, err)
+}
+
+// updateProfile updates an endpoint profile by its ID.
+func (a *EndpointProfileController) updateProfile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileUpdateSuccess"), err)
+		return
+	}
+	profile := &model.EndpointProfile{}
+	err = c.ShouldBind(profile)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileUpdateSuccess"), err)
+		return
+	}
+	profile.Id = id
+	old, _ := a.endpointProfileService.GetProfile(id)
+	err = a.endpointProfileService.UpdateProfile(profile)
+	if err == nil {
+		audit(c, "endpointProfile.update", fmt.Sprintf("endpointProfile:%d", id), old, profile)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileUpdateSuccess"), profile, err)
+}
+
+// delProfile deletes an endpoint profile by its ID.
+func (a *EndpointProfileController) delProfile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileDeleteSuccess"), err)
+		return
+	}
+	old, _ := a.endpointProfileService.GetProfile(id)
+	err = a.endpointProfileService.DelProfile(id)
+	if err == nil {
+		audit(c, "endpointProfile.del", fmt.Sprintf("endpointProfile:%d", id), old, nil)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.endpointProfiles.toasts.profileDeleteSuccess"), id, err)
+}
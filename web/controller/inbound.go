@@ -3,6 +3,7 @@ package controller
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
@@ -16,6 +17,9 @@ import (
 type InboundController struct {
 	inboundService service.InboundService
 	xrayService    service.XrayService
+	historyService service.TrafficHistoryService
+	accessService  service.SubAccessService
+	ipLimitService service.IpLimitService
 }
 
 // NewInboundController creates a new InboundController and sets up its routes.
@@ -27,35 +31,88 @@ func NewInboundController(g *gin.RouterGroup) *InboundController {
 
 // initRouter initializes the routes for inbound-related operations.
 func (a *InboundController) initRouter(g *gin.RouterGroup) {
+	// Inbounds can be managed by owners and by resellers (their own only),
+	// clients additionally by operators. Read-only users can only view.
+	manageInbounds := checkRole(model.RoleOwner, model.RoleReseller)
+	manageClients := checkRole(model.RoleOwner, model.RoleOperator, model.RoleReseller)
+	writeInbounds := checkScope(model.ScopeInboundsWrite)
+	writeClients := checkScope(model.ScopeClientsWrite)
+
+	g.Use(checkScope(model.ScopeInboundsRead))
 
 	g.GET("/list", a.getInbounds)
 	g.GET("/get/:id", a.getInbound)
 	g.GET("/getClientTraffics/:email", a.getClientTraffics)
 	g.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
-
-	g.POST("/add", a.addInbound)
-	g.POST("/del/:id", a.delInbound)
-	g.POST("/update/:id", a.updateInbound)
+	g.GET("/trafficHistory/:id", a.getInboundTrafficHistory)
+	g.GET("/clientTrafficHistory/:email", a.getClientTrafficHistory)
+	g.GET("/subAccessLog/:email", a.getSubAccessLog)
+	g.GET("/subAnomalies", a.getSubAnomalies)
+	g.GET("/ipBans", a.getIpBans)
+
+	g.POST("/add", manageInbounds, writeInbounds, a.addInbound)
+	g.POST("/del/:id", manageInbounds, writeInbounds, a.delInbound)
+	g.POST("/update/:id", manageInbounds, writeInbounds, a.updateInbound)
 	g.POST("/clientIps/:email", a.getClientIps)
-	g.POST("/clearClientIps/:email", a.clearClientIps)
-	g.POST("/addClient", a.addInboundClient)
-	g.POST("/:id/delClient/:clientId", a.delInboundClient)
-	g.POST("/updateClient/:clientId", a.updateInboundClient)
-	g.POST("/:id/resetClientTraffic/:email", a.resetClientTraffic)
-	g.POST("/resetAllTraffics", a.resetAllTraffics)
-	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
-	g.POST("/delDepletedClients/:id", a.delDepletedClients)
-	g.POST("/import", a.importInbound)
+	g.POST("/clearClientIps/:email", manageClients, writeClients, a.clearClientIps)
+	g.POST("/unbanClientIps/:email", manageClients, writeClients, a.unbanClientIps)
+	g.POST("/addClient", manageClients, writeClients, a.addInboundClient)
+	g.POST("/:id/delClient/:clientId", manageClients, writeClients, a.delInboundClient)
+	g.POST("/updateClient/:clientId", manageClients, writeClients, a.updateInboundClient)
+	g.POST("/:id/resetClientTraffic/:email", manageClients, writeClients, a.resetClientTraffic)
+	g.POST("/resetAllTraffics", checkRole(model.RoleOwner), writeInbounds, a.resetAllTraffics)
+	g.POST("/resetAllClientTraffics/:id", manageClients, writeClients, a.resetAllClientTraffics)
+	g.POST("/delDepletedClients/:id", manageClients, writeClients, a.delDepletedClients)
+	g.POST("/import", manageInbounds, writeInbounds, a.importInbound)
 	g.POST("/onlines", a.onlines)
 	g.POST("/lastOnline", a.lastOnline)
-	g.POST("/updateClientTraffic/:email", a.updateClientTraffic)
-	g.POST("/:id/delClientByEmail/:email", a.delInboundClientByEmail)
+	g.POST("/updateClientTraffic/:email", manageClients, writeClients, a.updateClientTraffic)
+	g.POST("/:id/delClientByEmail/:email", manageClients, writeClients, a.delInboundClientByEmail)
+}
+
+// checkInboundAccess responds with an error and returns false if the logged-in user may not access the inbound.
+func (a *InboundController) checkInboundAccess(c *gin.Context, id int) bool {
+	err := a.inboundService.CheckInboundAccess(session.GetLoginUser(c), id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.login.permissionDenied"), err)
+		return false
+	}
+	return true
+}
+
+// checkClientAccess responds with an error and returns false if the logged-in user may not access the client.
+func (a *InboundController) checkClientAccess(c *gin.Context, email string) bool {
+	err := a.inboundService.CheckClientAccess(session.GetLoginUser(c), email)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.login.permissionDenied"), err)
+		return false
+	}
+	return true
+}
+
+// visibleEmails returns the client emails the logged-in user may see, or nil if all of them are visible.
+func (a *InboundController) visibleEmails(c *gin.Context) (map[string]bool, error) {
+	user := session.GetLoginUser(c)
+	if user.CanAccessAllInbounds() {
+		return nil, nil
+	}
+	inbounds, err := a.inboundService.GetInbounds(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	emails := make(map[string]bool)
+	for _, inbound := range inbounds {
+		for _, stat := range inbound.ClientStats {
+			emails[stat.Email] = true
+		}
+	}
+	return emails, nil
 }
 
 // getInbounds retrieves the list of inbounds for the logged-in user.
 func (a *InboundController) getInbounds(c *gin.Context) {
 	user := session.GetLoginUser(c)
-	inbounds, err := a.inboundService.GetInbounds(user.Id)
+	inbounds, err := a.inboundService.GetInboundsForUser(user)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
 		return
@@ -70,6 +127,9 @@ func (a *InboundController) getInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "get"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
 	inbound, err := a.inboundService.GetInbound(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
@@ -81,6 +141,9 @@ func (a *InboundController) getInbound(c *gin.Context) {
 // getClientTraffics retrieves client traffic information by email.
 func (a *InboundController) getClientTraffics(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 	clientTraffics, err := a.inboundService.GetClientTrafficByEmail(email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
@@ -89,6 +152,116 @@ func (a *InboundController) getClientTraffics(c *gin.Context) {
 	jsonObj(c, clientTraffics, nil)
 }
 
+// getInboundTrafficHistory retrieves the traffic time series of an inbound.
+func (a *InboundController) getInboundTrafficHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainTrafficHistory"), err)
+		return
+	}
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
+	inbound, err := a.inboundService.GetInbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainTrafficHistory"), err)
+		return
+	}
+	a.trafficHistory(c, model.TrafficKindInbound, inbound.Tag)
+}
+
+// getClientTrafficHistory retrieves the traffic time series of a client.
+func (a *InboundController) getClientTrafficHistory(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	a.trafficHistory(c, model.TrafficKindClient, email)
+}
+
+// trafficHistory responds with the traffic history series selected by the query parameters.
+func (a *InboundController) trafficHistory(c *gin.Context, kind string, name string) {
+	query := &service.TrafficHistoryQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainTrafficHistory"), err)
+		return
+	}
+	points, err := a.historyService.GetHistory(kind, name, query)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainTrafficHistory"), err)
+		return
+	}
+	jsonObj(c, points, nil)
+}
+
+// getSubAccessLog retrieves the subscription access log of a client with the stats of its last day.
+func (a *InboundController) getSubAccessLog(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	filter := &service.SubAccessFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainSubAccessLog"), err)
+		return
+	}
+	report, err := a.accessService.GetClientReport(email, filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainSubAccessLog"), err)
+		return
+	}
+	jsonObj(c, report, nil)
+}
+
+// getSubAnomalies retrieves the subscriptions fetched from suspiciously many IPs within the last day.
+// Subscriptions without a visible client are left out.
+func (a *InboundController) getSubAnomalies(c *gin.Context) {
+	anomalies, err := a.accessService.GetAnomalies()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainSubAccessLog"), err)
+		return
+	}
+	emails, err := a.visibleEmails(c)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainSubAccessLog"), err)
+		return
+	}
+	if emails != nil {
+		visible := anomalies[:0]
+		for _, anomaly := range anomalies {
+			anomaly.Emails = slices.DeleteFunc(anomaly.Emails, func(email string) bool { return !emails[email] })
+			if len(anomaly.Emails) > 0 {
+				visible = append(visible, anomaly)
+			}
+		}
+		anomalies = visible
+	}
+	jsonObj(c, anomalies, nil)
+}
+
+// getIpBans retrieves the active IP bans of the visible clients, or of the client given by the
+// email query parameter.
+func (a *InboundController) getIpBans(c *gin.Context) {
+	email := c.Query("email")
+	if email != "" && !a.checkClientAccess(c, email) {
+		return
+	}
+	bans, err := a.ipLimitService.GetBans(email)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainIpBans"), err)
+		return
+	}
+	emails, err := a.visibleEmails(c)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainIpBans"), err)
+		return
+	}
+	if emails != nil {
+		bans = slices.DeleteFunc(bans, func(ban *model.IpBan) bool { return !emails[ban.Email] })
+	}
+	jsonObj(c, bans, nil)
+}
+
 // getClientTrafficsById retrieves client traffic information by inbound ID.
 func (a *InboundController) getClientTrafficsById(c *gin.Context) {
 	id := c.Param("id")
@@ -97,7 +270,14 @@ func (a *InboundController) getClientTrafficsById(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
 		return
 	}
-	jsonObj(c, clientTraffics, nil)
+	user := session.GetLoginUser(c)
+	visible := clientTraffics[:0]
+	for _, traffic := range clientTraffics {
+		if a.inboundService.CheckInboundAccess(user, traffic.InboundId) == nil {
+			visible = append(visible, traffic)
+		}
+	}
+	jsonObj(c, visible, nil)
 }
 
 // addInbound creates a new inbound configuration.
@@ -121,6 +301,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	audit(c, "inbound.add", fmt.Sprintf("inbound:%d", inbound.Id), nil, inbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound, nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -134,11 +315,16 @@ func (a *InboundController) delInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
+	oldInbound, _ := a.inboundService.GetInbound(id)
 	needRestart, err := a.inboundService.DelInbound(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	audit(c, "inbound.del", fmt.Sprintf("inbound:%d", id), oldInbound, nil)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), id, nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -160,11 +346,16 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, inbound.Id) {
+		return
+	}
+	oldInbound, _ := a.inboundService.GetInbound(id)
 	inbound, needRestart, err := a.inboundService.UpdateInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	audit(c, "inbound.update", fmt.Sprintf("inbound:%d", id), oldInbound, inbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), inbound, nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -174,6 +365,9 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 // getClientIps retrieves the IP addresses associated with a client by email.
 func (a *InboundController) getClientIps(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 
 	ips, err := a.inboundService.GetInboundClientIps(email)
 	if err != nil || ips == "" {
@@ -187,15 +381,39 @@ func (a *InboundController) getClientIps(c *gin.Context) {
 // clearClientIps clears the IP addresses for a client by email.
 func (a *InboundController) clearClientIps(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 
 	err := a.inboundService.ClearClientIps(email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.updateSuccess"), err)
 		return
 	}
+	audit(c, "client.clearIps", "client:"+email, nil, nil)
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.logCleanSuccess"), nil)
 }
 
+// unbanClientIps lifts the IP bans of a client by email, or only the ban of the IP given in the
+// ip form field.
+func (a *InboundController) unbanClientIps(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+
+	ip := c.PostForm("ip")
+	count, err := a.ipLimitService.Unban(email, ip)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.unbanSuccess"), err)
+		return
+	}
+	if count > 0 {
+		audit(c, "client.unbanIps", "client:"+email, nil, map[string]any{"ip": ip, "count": count})
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.unbanSuccess"), count, nil)
+}
+
 // addInboundClient adds a new client to an existing inbound.
 func (a *InboundController) addInboundClient(c *gin.Context) {
 	data := &model.Inbound{}
@@ -204,12 +422,19 @@ func (a *InboundController) addInboundClient(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, data.Id) {
+		return
+	}
 
 	needRestart, err := a.inboundService.AddInboundClient(data)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	clients, _ := a.inboundService.GetClients(data)
+	for _, client := range clients {
+		audit(c, "client.add", fmt.Sprintf("inbound:%d/client:%s", data.Id, client.Email), nil, client)
+	}
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -224,12 +449,19 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 		return
 	}
 	clientId := c.Param("clientId")
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
 
+	oldClient, _ := a.inboundService.GetInboundClient(id, clientId)
 	needRestart, err := a.inboundService.DelInboundClient(id, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	if oldClient != nil {
+		audit(c, "client.del", fmt.Sprintf("inbound:%d/client:%s", id, oldClient.Email), oldClient, nil)
+	}
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientDeleteSuccess"), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -246,12 +478,19 @@ func (a *InboundController) updateInboundClient(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, inbound.Id) {
+		return
+	}
 
+	oldClient, _ := a.inboundService.GetInboundClient(inbound.Id, clientId)
 	needRestart, err := a.inboundService.UpdateInboundClient(inbound, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	if clients, _ := a.inboundService.GetClients(inbound); len(clients) > 0 {
+		audit(c, "client.update", fmt.Sprintf("inbound:%d/client:%s", inbound.Id, clients[0].Email), oldClient, clients[0])
+	}
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -266,12 +505,17 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 		return
 	}
 	email := c.Param("email")
+	if !a.checkInboundAccess(c, id) || !a.checkClientAccess(c, email) {
+		return
+	}
 
+	oldTraffic, _ := a.inboundService.GetClientTrafficByEmail(email)
 	needRestart, err := a.inboundService.ResetClientTraffic(id, email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	audit(c, "client.resetTraffic", fmt.Sprintf("inbound:%d/client:%s", id, email), oldTraffic, nil)
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetInboundClientTrafficSuccess"), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -287,6 +531,7 @@ func (a *InboundController) resetAllTraffics(c *gin.Context) {
 	} else {
 		a.xrayService.SetToNeedRestart()
 	}
+	audit(c, "inbound.resetAllTraffics", "inbounds", nil, nil)
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetAllTrafficSuccess"), nil)
 }
 
@@ -297,6 +542,9 @@ func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
 
 	err = a.inboundService.ResetAllClientTraffics(id)
 	if err != nil {
@@ -305,6 +553,7 @@ func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 	} else {
 		a.xrayService.SetToNeedRestart()
 	}
+	audit(c, "inbound.resetAllClientTraffics", fmt.Sprintf("inbound:%d", id), nil, nil)
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetAllClientTrafficSuccess"), nil)
 }
 
@@ -333,6 +582,9 @@ func (a *InboundController) importInbound(c *gin.Context) {
 	needRestart := false
 	inbound, needRestart, err = a.inboundService.AddInbound(inbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound, err)
+	if err == nil {
+		audit(c, "inbound.import", fmt.Sprintf("inbound:%d", inbound.Id), nil, inbound)
+	}
 	if err == nil && needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -345,28 +597,59 @@ func (a *InboundController) delDepletedClients(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
 	err = a.inboundService.DelDepletedClients(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	audit(c, "inbound.delDepletedClients", fmt.Sprintf("inbound:%d", id), nil, nil)
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.delDepletedClientsSuccess"), nil)
 }
 
 // onlines retrieves the list of currently online clients.
 func (a *InboundController) onlines(c *gin.Context) {
-	jsonObj(c, a.inboundService.GetOnlineClients(), nil)
+	onlines := a.inboundService.GetOnlineClients()
+	visible, err := a.visibleEmails(c)
+	if err != nil || visible == nil {
+		jsonObj(c, onlines, err)
+		return
+	}
+	filtered := make([]string, 0, len(onlines))
+	for _, email := range onlines {
+		if visible[email] {
+			filtered = append(filtered, email)
+		}
+	}
+	jsonObj(c, filtered, nil)
 }
 
 // lastOnline retrieves the last online timestamps for clients.
 func (a *InboundController) lastOnline(c *gin.Context) {
 	data, err := a.inboundService.GetClientsLastOnline()
+	if err != nil {
+		jsonObj(c, data, err)
+		return
+	}
+	visible, err := a.visibleEmails(c)
+	if err == nil && visible != nil {
+		for email := range data {
+			if !visible[email] {
+				delete(data, email)
+			}
+		}
+	}
 	jsonObj(c, data, err)
 }
 
 // updateClientTraffic updates the traffic statistics for a client by email.
 func (a *InboundController) updateClientTraffic(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 
 	// Define the request structure for traffic update
 	type TrafficUpdateRequest struct {
@@ -381,11 +664,13 @@ func (a *InboundController) updateClientTraffic(c *gin.Context) {
 		return
 	}
 
+	oldTraffic, _ := a.inboundService.GetClientTrafficByEmail(email)
 	err = a.inboundService.UpdateClientTrafficByEmail(email, request.Upload, request.Download)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	audit(c, "client.updateTraffic", "client:"+email, oldTraffic, gin.H{"up": request.Upload, "down": request.Download})
 
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
 }
@@ -399,11 +684,16 @@ func (a *InboundController) delInboundClientByEmail(c *gin.Context) {
 	}
 
 	email := c.Param("email")
+	if !a.checkInboundAccess(c, inboundId) {
+		return
+	}
+	_, oldClient, _ := a.inboundService.GetClientByEmail(email)
 	needRestart, err := a.inboundService.DelInboundClientByEmail(inboundId, email)
 	if err != nil {
 		jsonMsg(c, "Failed to delete client by email", err)
 		return
 	}
+	audit(c, "client.del", fmt.Sprintf("inbound:%d/client:%s", inboundId, email), oldClient, nil)
 
 	jsonMsg(c, "Client deleted successfully", nil)
 	if needRestart {
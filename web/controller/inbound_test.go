@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+func TestInboundsAreScopedByRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpDir := t.TempDir()
+	if err := database.InitDB(filepath.Join(tmpDir, "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	defer database.CloseDB()
+
+	setupTestLogger(t, tmpDir)
+
+	db := database.GetDB()
+	for _, inbound := range []*model.Inbound{
+		{UserId: 1, Tag: "owner-in", Protocol: model.VMESS, Port: 20001, Settings: `{"clients":[]}`},
+		{UserId: 2, Tag: "reseller-in", Protocol: model.VMESS, Port: 20002, Settings: `{"clients":[]}`},
+	} {
+		if err := db.Create(inbound).Error; err != nil {
+			t.Fatalf("seed inbound: %v", err)
+		}
+	}
+
+	newRouter := func(user *model.User) *gin.Engine {
+		router := gin.New()
+		router.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+		router.Use(func(c *gin.Context) {
+			session.SetLoginUser(c, user)
+			c.Next()
+		})
+		NewInboundController(router.Group("/panel/api/inbounds"))
+		return router
+	}
+
+	listTags := func(router *gin.Engine) []string {
+		req := httptest.NewRequest(http.MethodGet, "/panel/api/inbounds/list", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var response struct {
+			Success bool
+			Obj     []model.Inbound
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &response); err != nil {
+			t.Fatalf("unmarshal response: %v", err)
+		}
+		var tags []string
+		for _, inbound := range response.Obj {
+			tags = append(tags, inbound.Tag)
+		}
+		return tags
+	}
+
+	if tags := listTags(newRouter(&model.User{Id: 1, Role: model.RoleOwner})); len(tags) != 2 {
+		t.Fatalf("owner should see all inbounds, got %v", tags)
+	}
+	if tags := listTags(newRouter(&model.User{Id: 3, Role: model.RoleOperator})); len(tags) != 2 {
+		t.Fatalf("operator should see all inbounds, got %v", tags)
+	}
+	if tags := listTags(newRouter(&model.User{Id: 2, Role: model.RoleReseller})); len(tags) != 1 || tags[0] != "reseller-in" {
+		t.Fatalf("reseller should only see its own inbound, got %v", tags)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/panel/api/inbounds/del/1", strings.NewReader(""))
+	resp := httptest.NewRecorder()
+	newRouter(&model.User{Id: 4, Role: model.RoleReadOnly}).ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("read-only user should not delete inbounds, got status %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/panel/api/inbounds/get/1", nil)
+	resp = httptest.NewRecorder()
+	newRouter(&model.User{Id: 2, Role: model.RoleReseller}).ServeHTTP(resp, req)
+	var response struct{ Success bool }
+	if err := json.Unmarshal(resp.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if response.Success {
+		t.Fatalf("reseller should not read inbounds of other users")
+	}
+}
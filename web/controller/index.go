@@ -5,6 +5,7 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
@@ -26,6 +27,7 @@ type IndexController struct {
 
 	settingService service.SettingService
 	userService    service.UserService
+	webhookService service.WebhookService
 	tgbot          service.Tgbot
 }
 
@@ -79,6 +81,7 @@ func (a *IndexController) login(c *gin.Context) {
 	if user == nil {
 		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, getRemoteIp(c))
 		a.tgbot.UserLoginNotify(safeUser, safePass, getRemoteIp(c), timeStr, 0)
+		a.webhookService.Emit(model.WebhookEventLoginFailed, map[string]any{"username": form.Username, "ip": getRemoteIp(c)})
 		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
 		return
 	}
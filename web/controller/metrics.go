@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController serves Prometheus metrics. It is disabled by default and uses its own
+// bearer token instead of panel logins, so scrapers never hold panel credentials.
+type MetricsController struct {
+	settingService service.SettingService
+	metricsService service.MetricsService
+}
+
+// NewMetricsController creates a new MetricsController and sets up its routes.
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the metrics route.
+func (a *MetricsController) initRouter(g *gin.RouterGroup) {
+	g.GET("/metrics", a.checkMetricsAuth, a.metrics)
+}
+
+// checkMetricsAuth hides the endpoint when metrics are disabled and verifies the bearer token.
+func (a *MetricsController) checkMetricsAuth(c *gin.Context) {
+	enabled, err := a.settingService.GetMetricsEnable()
+	if err != nil || !enabled {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	token, err := a.settingService.GetMetricsToken()
+	if err != nil || token == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		logger.Warningf("metrics: unauthorized scrape from %s", getRemoteIp(c))
+		c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+// metrics renders all metrics in OpenMetrics format.
+func (a *MetricsController) metrics(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := a.metricsService.WriteMetrics(&buf); err != nil {
+		logger.Warning("metrics: render failed:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, service.MetricsContentType, buf.Bytes())
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpDir := t.TempDir()
+	setupTestLogger(t, tmpDir)
+	if err := database.InitDB(filepath.Join(tmpDir, "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	defer database.CloseDB()
+
+	router := gin.New()
+	NewMetricsController(router.Group("/"))
+	scrape := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := scrape(""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected disabled metrics to be hidden, got %d", w.Code)
+	}
+
+	settingService := service.SettingService{}
+	const token = "0123456789abcdef"
+	if err := settingService.SetMetricsEnable(true); err != nil {
+		t.Fatalf("enable metrics: %v", err)
+	}
+	if err := settingService.SetMetricsToken(token); err != nil {
+		t.Fatalf("set metrics token: %v", err)
+	}
+	if w := scrape("Bearer wrong-token"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong token to be rejected, got %d", w.Code)
+	}
+
+	w := scrape("Bearer " + token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected metrics, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"# TYPE xui_xray_starts counter", "xui_clients 0", "# EOF\n"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output misses %q:\n%s", want, body)
+		}
+	}
+}
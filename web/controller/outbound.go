@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"strconv"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
@@ -14,6 +15,7 @@ import (
 type OutboundController struct {
 	outboundService service.OutboundService
 	xrayService     service.XrayService
+	historyService  service.TrafficHistoryService
 }
 
 // NewOutboundController creates a new OutboundController and sets up its routes.
@@ -25,13 +27,19 @@ func NewOutboundController(g *gin.RouterGroup) *OutboundController {
 
 // initRouter initializes the routes for outbound-related operations.
 func (a *OutboundController) initRouter(g *gin.RouterGroup) {
+	// Outbounds are shared by all inbounds, so only owners may change them.
+	g.Use(checkRole(model.RoleOwner, model.RoleOperator, model.RoleReadOnly), checkScope(model.ScopeOutboundsRead))
+	manageOutbounds := checkRole(model.RoleOwner)
+	writeOutbounds := checkScope(model.ScopeOutboundsWrite)
+
 	g.GET("/list", a.getOutbounds)
 	g.GET("/get/:id", a.getOutbound)
 	g.GET("/tags", a.getOutboundTags)
+	g.GET("/trafficHistory/:tag", a.getTrafficHistory)
 
-	g.POST("/add", a.addOutbound)
-	g.POST("/del/:id", a.delOutbound)
-	g.POST("/update/:id", a.updateOutbound)
+	g.POST("/add", manageOutbounds, writeOutbounds, a.addOutbound)
+	g.POST("/del/:id", manageOutbounds, writeOutbounds, a.delOutbound)
+	g.POST("/update/:id", manageOutbounds, writeOutbounds, a.updateOutbound)
 }
 
 // getOutbounds retrieves the list of outbounds for the logged-in user.
@@ -70,6 +78,21 @@ func (a *OutboundController) getOutboundTags(c *gin.Context) {
 	jsonObj(c, tags, nil)
 }
 
+// getTrafficHistory retrieves the traffic time series of an outbound by its tag.
+func (a *OutboundController) getTrafficHistory(c *gin.Context) {
+	query := &service.TrafficHistoryQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainTrafficHistory"), err)
+		return
+	}
+	points, err := a.historyService.GetHistory(model.TrafficKindOutbound, c.Param("tag"), query)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtainTrafficHistory"), err)
+		return
+	}
+	jsonObj(c, points, nil)
+}
+
 // addOutbound creates a new outbound.
 func (a *OutboundController) addOutbound(c *gin.Context) {
 	outbound := &model.Outbound{}
@@ -85,6 +108,7 @@ func (a *OutboundController) addOutbound(c *gin.Context) {
 	err = a.outboundService.AddOutbound(outbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.outbounds.addOutbound"), outbound, err)
 	if err == nil {
+		audit(c, "outbound.add", fmt.Sprintf("outbound:%d", outbound.Id), nil, outbound)
 		a.xrayService.SetToNeedRestart()
 	}
 }
@@ -97,9 +121,11 @@ func (a *OutboundController) delOutbound(c *gin.Context) {
 		return
 	}
 
+	oldOutbound, _ := a.outboundService.GetOutbound(id)
 	err = a.outboundService.DelOutbound(id)
 	jsonMsgObj(c, I18nWeb(c, "pages.outbounds.delOutbound"), id, err)
 	if err == nil {
+		audit(c, "outbound.del", fmt.Sprintf("outbound:%d", id), oldOutbound, nil)
 		a.xrayService.SetToNeedRestart()
 	}
 }
@@ -121,9 +147,11 @@ func (a *OutboundController) updateOutbound(c *gin.Context) {
 		return
 	}
 
+	oldOutbound, _ := a.outboundService.GetOutbound(id)
 	err = a.outboundService.UpdateOutbound(outbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.outbounds.updateOutbound"), outbound, err)
 	if err == nil {
+		audit(c, "outbound.update", fmt.Sprintf("outbound:%d", id), oldOutbound, outbound)
 		a.xrayService.SetToNeedRestart()
 	}
 }
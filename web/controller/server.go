@@ -1,12 +1,14 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/web/global"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
@@ -21,6 +23,7 @@ type ServerController struct {
 
 	serverService  service.ServerService
 	settingService service.SettingService
+	xrayService    service.XrayService
 
 	lastStatus *service.Status
 
@@ -38,26 +41,35 @@ func NewServerController(g *gin.RouterGroup) *ServerController {
 
 // initRouter sets up the routes for server status, Xray management, and utility endpoints.
 func (a *ServerController) initRouter(g *gin.RouterGroup) {
+	// Status and key generators are available to every role; anything exposing
+	// the whole configuration or database, or controlling Xray, is for owners only.
+	owner := checkRole(model.RoleOwner)
+	viewLogs := checkRole(model.RoleOwner, model.RoleOperator)
+	admin := checkScope(model.ScopeServerAdmin)
+
+	g.Use(checkScope(model.ScopeServerRead))
 
 	g.GET("/status", a.status)
 	g.GET("/cpuHistory/:bucket", a.getCpuHistoryBucket)
 	g.GET("/getXrayVersion", a.getXrayVersion)
-	g.GET("/getConfigJson", a.getConfigJson)
-	g.GET("/getDb", a.getDb)
+	g.GET("/getConfigJson", owner, admin, a.getConfigJson)
+	g.GET("/getDb", owner, admin, a.getDb)
 	g.GET("/getNewUUID", a.getNewUUID)
 	g.GET("/getNewX25519Cert", a.getNewX25519Cert)
 	g.GET("/getNewmldsa65", a.getNewmldsa65)
 	g.GET("/getNewmlkem768", a.getNewmlkem768)
 	g.GET("/getNewVlessEnc", a.getNewVlessEnc)
 
-	g.POST("/stopXrayService", a.stopXrayService)
-	g.POST("/restartXrayService", a.restartXrayService)
-	g.POST("/installXray/:version", a.installXray)
-	g.POST("/updateGeofile", a.updateGeofile)
-	g.POST("/updateGeofile/:fileName", a.updateGeofile)
-	g.POST("/logs/:count", a.getLogs)
-	g.POST("/xraylogs/:count", a.getXrayLogs)
-	g.POST("/importDB", a.importDB)
+	g.POST("/stopXrayService", owner, admin, a.stopXrayService)
+	g.POST("/restartXrayService", owner, admin, a.restartXrayService)
+	g.POST("/installXray/:version", owner, admin, a.installXray)
+	g.POST("/updateGeofile", owner, admin, a.updateGeofile)
+	g.POST("/updateGeofile/:fileName", owner, admin, a.updateGeofile)
+	g.POST("/logs/:count", viewLogs, admin, a.getLogs)
+	g.POST("/xraylogs/:count", viewLogs, admin, a.getXrayLogs)
+	g.GET("/xrayCrashes", viewLogs, admin, a.getXrayCrashes)
+	g.GET("/checkXrayConfig", owner, admin, a.checkXrayConfig)
+	g.POST("/importDB", owner, admin, a.importDB)
 	g.POST("/getNewEchCert", a.getNewEchCert)
 }
 
@@ -132,6 +144,9 @@ func (a *ServerController) getXrayVersion(c *gin.Context) {
 func (a *ServerController) installXray(c *gin.Context) {
 	version := c.Param("version")
 	err := a.serverService.UpdateXray(version)
+	if err == nil {
+		audit(c, "xray.install", "xray:"+version, nil, nil)
+	}
 	jsonMsg(c, I18nWeb(c, "pages.index.xraySwitchVersionPopover"), err)
 }
 
@@ -147,6 +162,9 @@ func (a *ServerController) updateGeofile(c *gin.Context) {
 	}
 
 	err := a.serverService.UpdateGeofile(fileName)
+	if err == nil {
+		audit(c, "geofile.update", "geofile:"+fileName, nil, nil)
+	}
 	jsonMsg(c, I18nWeb(c, "pages.index.geofileUpdatePopover"), err)
 }
 
@@ -157,6 +175,7 @@ func (a *ServerController) stopXrayService(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.xray.stopError"), err)
 		return
 	}
+	audit(c, "xray.stop", "xray", nil, nil)
 	jsonMsg(c, I18nWeb(c, "pages.xray.stopSuccess"), err)
 }
 
@@ -164,9 +183,15 @@ func (a *ServerController) stopXrayService(c *gin.Context) {
 func (a *ServerController) restartXrayService(c *gin.Context) {
 	err := a.serverService.RestartXrayService()
 	if err != nil {
+		var configErrors service.XrayConfigErrors
+		if errors.As(err, &configErrors) {
+			jsonMsgObj(c, I18nWeb(c, "pages.xray.restartError"), configErrors, err)
+			return
+		}
 		jsonMsg(c, I18nWeb(c, "pages.xray.restartError"), err)
 		return
 	}
+	audit(c, "xray.restart", "xray", nil, nil)
 	jsonMsg(c, I18nWeb(c, "pages.xray.restartSuccess"), err)
 }
 
@@ -179,6 +204,30 @@ func (a *ServerController) getLogs(c *gin.Context) {
 	jsonObj(c, logs, nil)
 }
 
+// checkXrayConfig validates the Xray config that a restart would start, returning the problems
+// by field in the response object.
+func (a *ServerController) checkXrayConfig(c *gin.Context) {
+	err := a.xrayService.ValidateXrayConfig()
+	var configErrors service.XrayConfigErrors
+	if errors.As(err, &configErrors) {
+		jsonMsgObj(c, I18nWeb(c, "pages.xray.configInvalid"), configErrors, err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.configValid"), service.XrayConfigErrors{}, err)
+}
+
+// getXrayCrashes retrieves the Xray crash history with the state of the supervisor. The count query
+// parameter limits the crashes, 20 by default and 100 at most.
+func (a *ServerController) getXrayCrashes(c *gin.Context) {
+	count, err := strconv.Atoi(c.DefaultQuery("count", "20"))
+	if err != nil || count <= 0 {
+		jsonMsg(c, "invalid count", fmt.Errorf("bad count"))
+		return
+	}
+	report, err := a.xrayService.GetXrayCrashReport(min(count, 100))
+	jsonObj(c, report, err)
+}
+
 // getXrayLogs retrieves Xray logs with filtering options for direct, blocked, and proxy traffic.
 func (a *ServerController) getXrayLogs(c *gin.Context) {
 	count := c.Param("count")
@@ -241,6 +290,7 @@ func (a *ServerController) getDb(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.index.getDatabaseError"), err)
 		return
 	}
+	audit(c, "database.export", "database", nil, nil)
 
 	filename := "x-ui.db"
 
@@ -280,6 +330,7 @@ func (a *ServerController) importDB(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.index.importDatabaseError"), err)
 		return
 	}
+	audit(c, "database.import", "database", nil, nil)
 	jsonObj(c, I18nWeb(c, "pages.index.importDatabaseSuccess"), nil)
 }
 
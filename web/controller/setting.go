@@ -1,9 +1,15 @@
 package controller
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
@@ -20,11 +26,19 @@ type updateUserForm struct {
 	NewPassword string `json:"newPassword" form:"newPassword"`
 }
 
+// subTemplatePreviewForm represents the form for previewing a subscription template.
+type subTemplatePreviewForm struct {
+	Kind     string `json:"kind" form:"kind"`
+	Template string `json:"template" form:"template"`
+	Email    string `json:"email" form:"email"`
+}
+
 // SettingController handles settings and user management operations.
 type SettingController struct {
 	settingService service.SettingService
 	userService    service.UserService
 	panelService   service.PanelService
+	inboundService service.InboundService
 }
 
 // NewSettingController creates a new SettingController and initializes its routes.
@@ -38,12 +52,19 @@ func NewSettingController(g *gin.RouterGroup) *SettingController {
 func (a *SettingController) initRouter(g *gin.RouterGroup) {
 	g = g.Group("/setting")
 
-	g.POST("/all", a.getAllSetting)
+	// Every user may read the UI defaults and change their own credentials,
+	// everything else is for owners.
 	g.POST("/defaultSettings", a.getDefaultSettings)
-	g.POST("/update", a.updateSetting)
 	g.POST("/updateUser", a.updateUser)
-	g.POST("/restartPanel", a.restartPanel)
-	g.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
+
+	owner := g.Group("", checkRole(model.RoleOwner))
+	owner.POST("/all", a.getAllSetting)
+	owner.POST("/update", a.updateSetting)
+	owner.POST("/restartPanel", a.restartPanel)
+	owner.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
+	owner.POST("/subTemplatePreview", a.previewSubTemplate)
+	owner.POST("/subSignKey", a.getSubSignKey)
+	owner.POST("/resetSubSignKey", a.resetSubSignKey)
 }
 
 // getAllSetting retrieves all current settings.
@@ -74,10 +95,35 @@ func (a *SettingController) updateSetting(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 		return
 	}
+	oldSetting, _ := a.settingService.GetAllSetting()
 	err = a.settingService.UpdateAllSetting(allSetting)
+	if err == nil {
+		auditSettings(c, oldSetting, allSetting)
+	}
 	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 }
 
+// auditSettings records an audit entry with the setting key as target for every setting that changed.
+func auditSettings(c *gin.Context, oldSetting *entity.AllSetting, newSetting *entity.AllSetting) {
+	before, after := map[string]any{}, map[string]any{}
+	if data, err := json.Marshal(oldSetting); err == nil {
+		json.Unmarshal(data, &before)
+	}
+	if data, err := json.Marshal(newSetting); err == nil {
+		json.Unmarshal(data, &after)
+	}
+	keys := make([]string, 0, len(after))
+	for key := range after {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if old, ok := before[key]; !ok || !reflect.DeepEqual(old, after[key]) {
+			audit(c, "settings.update", "setting:"+key, gin.H{key: before[key]}, gin.H{key: after[key]})
+		}
+	}
+}
+
 // updateUser updates the current user's username and password.
 func (a *SettingController) updateUser(c *gin.Context) {
 	form := &updateUserForm{}
@@ -97,6 +143,8 @@ func (a *SettingController) updateUser(c *gin.Context) {
 	}
 	err = a.userService.UpdateUser(user.Id, form.NewUsername, form.NewPassword)
 	if err == nil {
+		audit(c, "user.updateCredentials", fmt.Sprintf("user:%d", user.Id),
+			gin.H{"username": user.Username}, gin.H{"username": form.NewUsername})
 		user.Username = form.NewUsername
 		user.Password, _ = crypto.HashPasswordAsBcrypt(form.NewPassword)
 		session.SetLoginUser(c, user)
@@ -107,6 +155,9 @@ func (a *SettingController) updateUser(c *gin.Context) {
 // restartPanel restarts the panel service after a delay.
 func (a *SettingController) restartPanel(c *gin.Context) {
 	err := a.panelService.RestartPanel(time.Second * 3)
+	if err == nil {
+		audit(c, "panel.restart", "panel", nil, nil)
+	}
 	jsonMsg(c, I18nWeb(c, "pages.settings.restartPanelSuccess"), err)
 }
 
@@ -119,3 +170,49 @@ func (a *SettingController) getDefaultXrayConfig(c *gin.Context) {
 	}
 	jsonObj(c, defaultJsonConfig, nil)
 }
+
+// previewSubTemplate renders a subscription template for a client without saving it.
+func (a *SettingController) previewSubTemplate(c *gin.Context) {
+	form := &subTemplatePreviewForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.subTemplatePreview"), err)
+		return
+	}
+	host, _, err := net.SplitHostPort(c.Request.Host)
+	if err != nil {
+		host = c.Request.Host
+	}
+	result, err := a.inboundService.PreviewSubTemplate(form.Kind, form.Template, form.Email, host)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.subTemplatePreview"), err)
+		return
+	}
+	jsonObj(c, result, nil)
+}
+
+// getSubSignKey retrieves the public key that verifies signed subscriptions.
+func (a *SettingController) getSubSignKey(c *gin.Context) {
+	key, err := a.settingService.GetSubSignKey()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.subSignKey"), err)
+		return
+	}
+	jsonObj(c, service.NewSubSignKey(key), nil)
+}
+
+// resetSubSignKey replaces the subscription signing key, which takes effect after a panel restart.
+func (a *SettingController) resetSubSignKey(c *gin.Context) {
+	old, err := a.settingService.GetSubSignKey()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.subSignReset"), err)
+		return
+	}
+	key, err := a.settingService.ResetSubSignKey()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.subSignReset"), err)
+		return
+	}
+	public := service.NewSubSignKey(key)
+	audit(c, "settings.resetSubSignKey", "settings", gin.H{"keyId": service.NewSubSignKey(old).KeyId}, gin.H{"keyId": public.KeyId})
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.subSignReset"), public, nil)
+}
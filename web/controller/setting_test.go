@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+func TestAuditSettingsPerKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpDir := t.TempDir()
+	if err := database.InitDB(filepath.Join(tmpDir, "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	defer database.CloseDB()
+
+	setupTestLogger(t, tmpDir)
+
+	oldSetting := &entity.AllSetting{WebPort: 2053, WebListen: "0.0.0.0", TgBotToken: "old-token"}
+	newSetting := &entity.AllSetting{WebPort: 8443, WebListen: "0.0.0.0", TgBotToken: "new-token"}
+	router := gin.New()
+	router.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+	router.POST("/update", func(c *gin.Context) {
+		session.SetLoginUser(c, &model.User{Id: 1, Username: "admin"})
+		auditSettings(c, oldSetting, newSetting)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/update", nil))
+
+	auditService := service.AuditService{}
+	logs, total, err := auditService.GetLogs(&service.AuditFilter{Action: "settings.update"})
+	if err != nil || total != 2 {
+		t.Fatalf("expected an entry for each changed setting, got %d (%v)", total, err)
+	}
+	targets := []string{logs[0].Target, logs[1].Target}
+	if !strings.Contains(strings.Join(targets, ","), "setting:webPort") || !strings.Contains(strings.Join(targets, ","), "setting:tgBotToken") {
+		t.Fatalf("unexpected targets %v", targets)
+	}
+	for _, log := range logs {
+		if log.Actor != "admin" || strings.Contains(log.Before+log.After, "-token") {
+			t.Fatalf("unexpected entry %+v", log)
+		}
+		if log.Target == "setting:webPort" && (log.Before != `{"webPort":2053}` || log.After != `{"webPort":8443}`) {
+			t.Fatalf("unexpected webPort entry %+v", log)
+		}
+	}
+}
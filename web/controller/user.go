@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userForm represents the form for creating or updating a panel user.
+type userForm struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	Role     string `json:"role" form:"role"`
+}
+
+// UserController handles panel user management. All routes are restricted to owners.
+type UserController struct {
+	userService service.UserService
+}
+
+// NewUserController creates a new UserController and sets up its routes.
+func NewUserController(g *gin.RouterGroup) *UserController {
+	a := &UserController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for user management.
+func (a *UserController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getUsers)
+
+	g.POST("/add", a.addUser)
+	g.POST("/update/:id", a.updateUser)
+	g.POST("/del/:id", a.delUser)
+}
+
+// getUsers retrieves the list of panel users.
+func (a *UserController) getUsers(c *gin.Context) {
+	users, err := a.userService.GetUsers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.users.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, users, nil)
+}
+
+// addUser creates a new panel user.
+func (a *UserController) addUser(c *gin.Context) {
+	form := &userForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.users.toasts.userCreateSuccess"), err)
+		return
+	}
+	user, err := a.userService.AddUser(form.Username, form.Password, form.Role)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	audit(c, "user.add", fmt.Sprintf("user:%d", user.Id), nil, gin.H{"username": user.Username, "role": user.Role})
+	jsonMsgObj(c, I18nWeb(c, "pages.users.toasts.userCreateSuccess"), user, nil)
+}
+
+// updateUser changes the username, role and optionally the password of a panel user.
+func (a *UserController) updateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.users.toasts.userUpdateSuccess"), err)
+		return
+	}
+	form := &userForm{}
+	err = c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.users.toasts.userUpdateSuccess"), err)
+		return
+	}
+	oldUser, _ := a.userService.GetUserById(id)
+	err = a.userService.UpdateUserInfo(id, form.Username, form.Password, form.Role)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	after := gin.H{"username": form.Username, "role": form.Role, "passwordChanged": form.Password != ""}
+	if oldUser != nil {
+		audit(c, "user.update", fmt.Sprintf("user:%d", id), gin.H{"username": oldUser.Username, "role": oldUser.Role, "passwordChanged": false}, after)
+	} else {
+		audit(c, "user.update", fmt.Sprintf("user:%d", id), nil, after)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.users.toasts.userUpdateSuccess"), nil)
+}
+
+// delUser deletes a panel user, handing its inbounds over to the current user.
+func (a *UserController) delUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.users.toasts.userDeleteSuccess"), err)
+		return
+	}
+	user := session.GetLoginUser(c)
+	oldUser, _ := a.userService.GetUserById(id)
+	err = a.userService.DelUser(id, user.Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if oldUser != nil {
+		audit(c, "user.del", fmt.Sprintf("user:%d", id), gin.H{"username": oldUser.Username, "role": oldUser.Role}, nil)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.users.toasts.userDeleteSuccess"), id, nil)
+}
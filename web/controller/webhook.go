@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookController handles management of webhooks and their delivery log. All routes are restricted to owners.
+type WebhookController struct {
+	webhookService service.WebhookService
+}
+
+// NewWebhookController creates a new WebhookController and sets up its routes.
+func NewWebhookController(g *gin.RouterGroup) *WebhookController {
+	a := &WebhookController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for webhook management.
+func (a *WebhookController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getWebhooks)
+	g.GET("/deliveries", a.getDeliveries)
+
+	g.POST("/add", a.addWebhook)
+	g.POST("/update/:id", a.updateWebhook)
+	g.POST("/del/:id", a.delWebhook)
+	g.POST("/test/:id", a.testWebhook)
+	g.POST("/deliveries/retry/:id", a.retryDelivery)
+}
+
+// getWebhooks retrieves the list of webhooks without their secrets.
+func (a *WebhookController) getWebhooks(c *gin.Context) {
+	webhooks, err := a.webhookService.GetWebhooks()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.obtain"), err)
+		return
+	}
+	// Secrets are only returned when a webhook is created
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	jsonObj(c, webhooks, nil)
+}
+
+// addWebhook creates a new webhook. The generated secret is returned if none was given.
+func (a *WebhookController) addWebhook(c *gin.Context) {
+	webhook := &model.Webhook{}
+	err := c.ShouldBind(webhook)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.webhookCreateSuccess"), err)
+		return
+	}
+	err = a.webhookService.AddWebhook(webhook)
+	if err == nil {
+		audit(c, "webhook.add", fmt.Sprintf("webhook:%d", webhook.Id), nil, webhook)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.webhooks.toasts.webhookCreateSuccess"), webhook, err)
+}
+
+// updateWebhook updates a webhook by its ID. An empty secret keeps the current one.
+func (a *WebhookController) updateWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.webhookUpdateSuccess"), err)
+		return
+	}
+	webhook := &model.Webhook{}
+	err = c.ShouldBind(webhook)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.webhookUpdateSuccess"), err)
+		return
+	}
+	webhook.Id = id
+	old, _ := a.webhookService.GetWebhook(id)
+	err = a.webhookService.UpdateWebhook(webhook)
+	if err == nil {
+		audit(c, "webhook.update", fmt.Sprintf("webhook:%d", id), old, webhook)
+	}
+	webhook.Secret = ""
+	jsonMsgObj(c, I18nWeb(c, "pages.webhooks.toasts.webhookUpdateSuccess"), webhook, err)
+}
+
+// delWebhook deletes a webhook and its delivery log by its ID.
+func (a *WebhookController) delWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.webhookDeleteSuccess"), err)
+		return
+	}
+	old, _ := a.webhookService.GetWebhook(id)
+	err = a.webhookService.DelWebhook(id)
+	if err == nil {
+		audit(c, "webhook.del", fmt.Sprintf("webhook:%d", id), old, nil)
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.webhooks.toasts.webhookDeleteSuccess"), id, err)
+}
+
+// testWebhook sends a ping event to a webhook, regardless of its subscriptions.
+func (a *WebhookController) testWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.webhookTestSuccess"), err)
+		return
+	}
+	err = a.webhookService.Ping(id)
+	jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.webhookTestSuccess"), err)
+}
+
+// getDeliveries retrieves a page of webhook deliveries matching the query filters.
+func (a *WebhookController) getDeliveries(c *gin.Context) {
+	filter := &service.WebhookDeliveryFilter{}
+	if err := c.ShouldBindQuery(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.obtain"), err)
+		return
+	}
+	deliveries, total, err := a.webhookService.GetDeliveries(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, gin.H{"deliveries": deliveries, "total": total}, nil)
+}
+
+// retryDelivery queues a finished delivery to be sent again.
+func (a *WebhookController) retryDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.deliveryRetrySuccess"), err)
+		return
+	}
+	err = a.webhookService.RetryDelivery(id)
+	jsonMsg(c, I18nWeb(c, "pages.webhooks.toasts.deliveryRetrySuccess"), err)
+}
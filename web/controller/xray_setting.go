@@ -116,9 +116,19 @@ func (a *XraySettingController) updateSetting(c *gin.Context) {
 		ownerID = user.Id
 	}
 
+	oldTemplate, _ := a.SettingService.GetXrayConfigTemplate()
 	err := a.XraySettingService.ApplyAdvancedSetting(xraySetting, &a.InboundService, &a.OutboundService, ownerID)
 	if err == nil {
+		newTemplate, _ := a.SettingService.GetXrayConfigTemplate()
+		audit(c, "xray.updateTemplate", "xrayTemplate", oldTemplate, newTemplate)
 		a.XrayService.SetToNeedRestart()
+
+		// The template is saved either way, but a running Xray keeps the previous config until it is valid
+		var configErrors service.XrayConfigErrors
+		if errors.As(a.XrayService.ValidateXrayConfig(), &configErrors) {
+			jsonMsgObj(c, I18nWeb(c, "pages.xray.configSavedInvalid"), configErrors, nil)
+			return
+		}
 	}
 	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 }
@@ -283,6 +293,9 @@ func (a *XraySettingController) warp(c *gin.Context) {
 		license := c.PostForm("license")
 		resp, err = a.WarpService.SetWarpLicense(license)
 	}
+	if err == nil && action != "data" && action != "config" {
+		audit(c, "warp."+action, "warp", nil, nil)
+	}
 
 	jsonObj(c, resp, err)
 }
@@ -305,5 +318,6 @@ func (a *XraySettingController) resetOutboundsTraffic(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.resetOutboundTrafficError"), err)
 		return
 	}
+	audit(c, "outbound.resetTraffic", "outboundTag:"+tag, nil, nil)
 	jsonObj(c, "", nil)
 }
@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,11 +29,14 @@ func (a *XUIController) initRouter(g *gin.RouterGroup) {
 	g.GET("/", a.index)
 	g.GET("/inbounds", a.inbounds)
 	g.GET("/outbounds", a.outbounds)
-	g.GET("/settings", a.settings)
-	g.GET("/xray", a.xraySettings)
+
+	// Panel and Xray settings, including the config templates, are reserved for owners.
+	owner := g.Group("", checkRole(model.RoleOwner))
+	owner.GET("/settings", a.settings)
+	owner.GET("/xray", a.xraySettings)
 
 	a.settingController = NewSettingController(g)
-	a.xraySettingController = NewXraySettingController(g)
+	a.xraySettingController = NewXraySettingController(owner)
 }
 
 // index renders the main panel index page.
@@ -3,11 +3,16 @@ package entity
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"math"
 	"net"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 )
 
@@ -53,29 +58,71 @@ type AllSetting struct {
 	TwoFactorEnable bool   `json:"twoFactorEnable" form:"twoFactorEnable"` // Enable two-factor authentication
 	TwoFactorToken  string `json:"twoFactorToken" form:"twoFactorToken"`   // Two-factor authentication token
 
+	// Metrics settings
+	MetricsEnable      bool   `json:"metricsEnable" form:"metricsEnable"`           // Serve Prometheus metrics on /metrics
+	MetricsToken       string `json:"metricsToken" form:"metricsToken"`             // Bearer token required to scrape /metrics
+	MetricsClientLimit int    `json:"metricsClientLimit" form:"metricsClientLimit"` // Maximum number of clients exported, 0 disables client metrics
+
+	// History settings
+	AuditRetentionDays      int  `json:"auditRetentionDays" form:"auditRetentionDays"`           // Days to keep audit log entries, 0 keeps them forever
+	TrafficHistoryDays      int  `json:"trafficHistoryDays" form:"trafficHistoryDays"`           // Days to keep daily traffic history, 0 keeps it forever
+	SubAccessLogDays        int  `json:"subAccessLogDays" form:"subAccessLogDays"`               // Days to keep subscription access log entries, 0 keeps them forever
+	SubAnomalyIps           int  `json:"subAnomalyIps" form:"subAnomalyIps"`                     // Distinct IPs per day that flag a subscription as anomalous, 0 disables flagging
+	ConnectionLogEnable     bool `json:"connectionLogEnable" form:"connectionLogEnable"`         // Store the client connections of the Xray access log
+	ConnectionLogDays       int  `json:"connectionLogDays" form:"connectionLogDays"`             // Days to keep client connections, 0 keeps them until the record limit
+	ConnectionLogMaxRecords int  `json:"connectionLogMaxRecords" form:"connectionLogMaxRecords"` // Most client connections kept, the oldest are removed first
+
+	// Xray supervisor settings
+	XrayStableMinutes  int  `json:"xrayStableMinutes" form:"xrayStableMinutes"`   // Minutes a configuration must run to become the rollback target
+	XrayCrashLoopCount int  `json:"xrayCrashLoopCount" form:"xrayCrashLoopCount"` // Crashes in a row that trip the crash loop alert and rollback, 0 disables both
+	XrayRollbackEnable bool `json:"xrayRollbackEnable" form:"xrayRollbackEnable"` // Roll back to the last good configuration when the crash loop trips
+
+	// IP limit settings
+	IpLimitBackend    string `json:"ipLimitBackend" form:"ipLimitBackend"`       // fail2ban, or xray to block excess IPs with Xray routing rules
+	IpLimitBanMinutes int    `json:"ipLimitBanMinutes" form:"ipLimitBanMinutes"` // Minutes an excess IP is blocked by the xray backend
+
 	// Subscription server settings
-	SubEnable                   bool   `json:"subEnable" form:"subEnable"`                                     // Enable subscription server
-	SubJsonEnable               bool   `json:"subJsonEnable" form:"subJsonEnable"`                             // Enable JSON subscription endpoint
-	SubTitle                    string `json:"subTitle" form:"subTitle"`                                       // Subscription title
-	SubListen                   string `json:"subListen" form:"subListen"`                                     // Subscription server listen IP
-	SubPort                     int    `json:"subPort" form:"subPort"`                                         // Subscription server port
-	SubPath                     string `json:"subPath" form:"subPath"`                                         // Base path for subscription URLs
-	SubDomain                   string `json:"subDomain" form:"subDomain"`                                     // Domain for subscription server validation
-	SubCertFile                 string `json:"subCertFile" form:"subCertFile"`                                 // SSL certificate file for subscription server
-	SubKeyFile                  string `json:"subKeyFile" form:"subKeyFile"`                                   // SSL private key file for subscription server
-	SubUpdates                  int    `json:"subUpdates" form:"subUpdates"`                                   // Subscription update interval in minutes
-	ExternalTrafficInformEnable bool   `json:"externalTrafficInformEnable" form:"externalTrafficInformEnable"` // Enable external traffic reporting
-	ExternalTrafficInformURI    string `json:"externalTrafficInformURI" form:"externalTrafficInformURI"`       // URI for external traffic reporting
-	SubEncrypt                  bool   `json:"subEncrypt" form:"subEncrypt"`                                   // Encrypt subscription responses
-	SubShowInfo                 bool   `json:"subShowInfo" form:"subShowInfo"`                                 // Show client information in subscriptions
-	SubURI                      string `json:"subURI" form:"subURI"`                                           // Subscription server URI
-	SubJsonPath                 string `json:"subJsonPath" form:"subJsonPath"`                                 // Path for JSON subscription endpoint
-	SubJsonURI                  string `json:"subJsonURI" form:"subJsonURI"`                                   // JSON subscription server URI
-	SubJsonFragment             string `json:"subJsonFragment" form:"subJsonFragment"`                         // JSON subscription fragment configuration
-	SubJsonNoises               string `json:"subJsonNoises" form:"subJsonNoises"`                             // JSON subscription noise configuration
-	SubJsonMux                  string `json:"subJsonMux" form:"subJsonMux"`                                   // JSON subscription mux configuration
-	SubJsonRules                string `json:"subJsonRules" form:"subJsonRules"`    
-	
+	SubEnable           bool   `json:"subEnable" form:"subEnable"`             // Enable subscription server
+	SubJsonEnable       bool   `json:"subJsonEnable" form:"subJsonEnable"`     // Enable JSON subscription endpoint
+	SubTitle            string `json:"subTitle" form:"subTitle"`               // Subscription title
+	SubListen           string `json:"subListen" form:"subListen"`             // Subscription server listen IP
+	SubPort             int    `json:"subPort" form:"subPort"`                 // Subscription server port
+	SubPath             string `json:"subPath" form:"subPath"`                 // Base path for subscription URLs
+	SubDomain           string `json:"subDomain" form:"subDomain"`             // Domain for subscription server validation
+	SubCertFile         string `json:"subCertFile" form:"subCertFile"`         // SSL certificate file for subscription server
+	SubKeyFile          string `json:"subKeyFile" form:"subKeyFile"`           // SSL private key file for subscription server
+	SubUpdates          int    `json:"subUpdates" form:"subUpdates"`           // Subscription update interval in minutes
+	SubEncrypt          bool   `json:"subEncrypt" form:"subEncrypt"`           // Encrypt subscription responses
+	SubShowInfo         bool   `json:"subShowInfo" form:"subShowInfo"`         // Show client information in subscriptions
+	SubURI              string `json:"subURI" form:"subURI"`                   // Subscription server URI
+	SubJsonPath         string `json:"subJsonPath" form:"subJsonPath"`         // Path for JSON subscription endpoint
+	SubJsonURI          string `json:"subJsonURI" form:"subJsonURI"`           // JSON subscription server URI
+	SubJsonFragment     string `json:"subJsonFragment" form:"subJsonFragment"` // JSON subscription fragment configuration
+	SubJsonNoises       string `json:"subJsonNoises" form:"subJsonNoises"`     // JSON subscription noise configuration
+	SubJsonMux          string `json:"subJsonMux" form:"subJsonMux"`           // JSON subscription mux configuration
+	SubJsonRules        string `json:"subJsonRules" form:"subJsonRules"`
+	SubClashEnable      bool   `json:"subClashEnable" form:"subClashEnable"`           // Enable Clash/Mihomo subscription endpoint
+	SubClashPath        string `json:"subClashPath" form:"subClashPath"`               // Path for Clash/Mihomo subscription endpoint
+	SubClashURI         string `json:"subClashURI" form:"subClashURI"`                 // Clash/Mihomo subscription server URI
+	SubSingboxEnable    bool   `json:"subSingboxEnable" form:"subSingboxEnable"`       // Enable sing-box subscription endpoint
+	SubSingboxPath      string `json:"subSingboxPath" form:"subSingboxPath"`           // Path for sing-box subscription endpoint
+	SubSingboxURI       string `json:"subSingboxURI" form:"subSingboxURI"`             // sing-box subscription server URI
+	SubSingboxDns       string `json:"subSingboxDns" form:"subSingboxDns"`             // sing-box subscription DNS section
+	SubSingboxRules     string `json:"subSingboxRules" form:"subSingboxRules"`         // sing-box subscription route rules
+	SubFormatRules      string `json:"subFormatRules" form:"subFormatRules"`           // User-Agent rules that pick the format of the subscription URL
+	SubRemarkTemplate   string `json:"subRemarkTemplate" form:"subRemarkTemplate"`     // Template for link remarks, empty to use the remark model
+	SubTitleTemplate    string `json:"subTitleTemplate" form:"subTitleTemplate"`       // Template for profile titles, empty to use the subscription title
+	SubJsonTemplate     string `json:"subJsonTemplate" form:"subJsonTemplate"`         // Template for JSON subscription profiles, empty to use the default profile
+	SubNodes            string `json:"subNodes" form:"subNodes"`                       // Remote panels whose links are merged into link subscriptions
+	SubNodeCacheTtl     int    `json:"subNodeCacheTtl" form:"subNodeCacheTtl"`         // Seconds the answers of remote panels are cached
+	SubPageLogo         string `json:"subPageLogo" form:"subPageLogo"`                 // Logo URL of the subscription page
+	SubPageColor        string `json:"subPageColor" form:"subPageColor"`               // Accent color of the subscription page, #rrggbb
+	SubPageSupport      string `json:"subPageSupport" form:"subPageSupport"`           // Support contact URL of the subscription page
+	SubPageAnnouncement string `json:"subPageAnnouncement" form:"subPageAnnouncement"` // Banner text of the subscription page
+	SubPageTranslations string `json:"subPageTranslations" form:"subPageTranslations"` // Per-language title, announcement and support of the subscription page
+	SubSignEnable       bool   `json:"subSignEnable" form:"subSignEnable"`             // Sign subscription bodies with the panel-managed Ed25519 key
+	SubTrustedProxies   string `json:"subTrustedProxies" form:"subTrustedProxies"`     // Comma-separated proxy IPs or CIDRs whose client IP headers are trusted
+
 	// LDAP settings
 	LdapEnable                  bool   `json:"ldapEnable" form:"ldapEnable"`
 	LdapHost                    string `json:"ldapHost" form:"ldapHost"`
@@ -163,6 +210,126 @@ func (s *AllSetting) CheckValid() error {
 		s.SubJsonPath += "/"
 	}
 
+	if !strings.HasPrefix(s.SubClashPath, "/") {
+		s.SubClashPath = "/" + s.SubClashPath
+	}
+	if !strings.HasSuffix(s.SubClashPath, "/") {
+		s.SubClashPath += "/"
+	}
+
+	if !strings.HasPrefix(s.SubSingboxPath, "/") {
+		s.SubSingboxPath = "/" + s.SubSingboxPath
+	}
+	if !strings.HasSuffix(s.SubSingboxPath, "/") {
+		s.SubSingboxPath += "/"
+	}
+	if s.SubSingboxDns != "" && !json.Valid([]byte(s.SubSingboxDns)) {
+		return common.NewError("sing-box DNS section is not valid JSON")
+	}
+	if s.SubSingboxRules != "" && !json.Valid([]byte(s.SubSingboxRules)) {
+		return common.NewError("sing-box route rules are not valid JSON")
+	}
+	if s.SubFormatRules != "" {
+		var rules []struct {
+			UserAgent string `json:"userAgent"`
+			Format    string `json:"format"`
+		}
+		if err := json.Unmarshal([]byte(s.SubFormatRules), &rules); err != nil {
+			return common.NewError("subscription format rules are not valid:", err)
+		}
+		for _, rule := range rules {
+			if !slices.Contains(model.SubFormats, rule.Format) {
+				return common.NewError("unknown subscription format:", rule.Format)
+			}
+		}
+	}
+	if s.SubNodes != "" {
+		var nodes []struct {
+			Url string `json:"url"`
+		}
+		if err := json.Unmarshal([]byte(s.SubNodes), &nodes); err != nil {
+			return common.NewError("subscription nodes are not valid:", err)
+		}
+		for _, node := range nodes {
+			if u, err := url.Parse(node.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return common.NewError("invalid subscription node URL:", node.Url)
+			}
+		}
+	}
+	for proxy := range strings.SplitSeq(s.SubTrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return common.NewError("invalid subscription trusted proxy:", proxy)
+		}
+	}
+	if s.SubNodeCacheTtl < 0 {
+		return common.NewError("subscription node cache TTL can not be negative:", s.SubNodeCacheTtl)
+	}
+	if s.SubPageLogo != "" && !strings.HasPrefix(s.SubPageLogo, "/") && !isUrlWithScheme(s.SubPageLogo, "http", "https") {
+		return common.NewError("invalid subscription page logo URL:", s.SubPageLogo)
+	}
+	if s.SubPageColor != "" && !subPageColorRegex.MatchString(s.SubPageColor) {
+		return common.NewError("subscription page color must be like #1677ff:", s.SubPageColor)
+	}
+	if s.SubPageSupport != "" && !isUrlWithScheme(s.SubPageSupport, "http", "https", "mailto", "tg") {
+		return common.NewError("invalid subscription page support URL:", s.SubPageSupport)
+	}
+	if s.SubPageTranslations != "" {
+		var translations map[string]struct {
+			Title        string `json:"title"`
+			Announcement string `json:"announcement"`
+			Support      string `json:"support"`
+		}
+		if err := json.Unmarshal([]byte(s.SubPageTranslations), &translations); err != nil {
+			return common.NewError("subscription page translations are not valid:", err)
+		}
+		for lang, translation := range translations {
+			if translation.Support != "" && !isUrlWithScheme(translation.Support, "http", "https", "mailto", "tg") {
+				return common.NewError("invalid subscription page support URL for", lang+":", translation.Support)
+			}
+		}
+	}
+
+	if s.AuditRetentionDays < 0 {
+		return common.NewError("audit retention days can not be negative:", s.AuditRetentionDays)
+	}
+	if s.TrafficHistoryDays < 0 {
+		return common.NewError("traffic history days can not be negative:", s.TrafficHistoryDays)
+	}
+	if s.SubAccessLogDays < 0 {
+		return common.NewError("subscription access log days can not be negative:", s.SubAccessLogDays)
+	}
+	if s.SubAnomalyIps < 0 {
+		return common.NewError("subscription anomaly IPs can not be negative:", s.SubAnomalyIps)
+	}
+	if s.ConnectionLogDays < 0 {
+		return common.NewError("connection log days can not be negative:", s.ConnectionLogDays)
+	}
+	if s.ConnectionLogMaxRecords < 1 {
+		return common.NewError("connection log max records must be at least 1:", s.ConnectionLogMaxRecords)
+	}
+	if s.XrayStableMinutes < 1 {
+		return common.NewError("xray stable minutes must be at least 1:", s.XrayStableMinutes)
+	}
+	if s.XrayCrashLoopCount < 0 {
+		return common.NewError("xray crash loop count can not be negative:", s.XrayCrashLoopCount)
+	}
+	if s.IpLimitBackend != "fail2ban" && s.IpLimitBackend != "xray" {
+		return common.NewError("invalid IP limit backend:", s.IpLimitBackend)
+	}
+	if s.IpLimitBanMinutes < 1 {
+		return common.NewError("IP limit ban minutes must be at least 1:", s.IpLimitBanMinutes)
+	}
+
+	if s.MetricsEnable && len(s.MetricsToken) < 16 {
+		return common.NewError("metrics token must be at least 16 characters long")
+	}
+	if s.MetricsClientLimit < 0 {
+		return common.NewError("metrics client limit can not be negative:", s.MetricsClientLimit)
+	}
+
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
@@ -170,3 +337,15 @@ func (s *AllSetting) CheckValid() error {
 
 	return nil
 }
+
+// subPageColorRegex matches the hex colors accepted for the subscription page.
+var subPageColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// isUrlWithScheme reports whether value is an absolute URL with one of the given schemes.
+func isUrlWithScheme(value string, schemes ...string) bool {
+	u, err := url.Parse(value)
+	if err != nil || !slices.Contains(schemes, strings.ToLower(u.Scheme)) {
+		return false
+	}
+	return u.Host != "" || u.Opaque != "" || u.Path != ""
+}
@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// AuditCleanJob removes audit log entries older than the configured retention period.
+type AuditCleanJob struct {
+	auditService service.AuditService
+}
+
+// NewAuditCleanJob creates a new audit log cleanup job instance.
+func NewAuditCleanJob() *AuditCleanJob {
+	return new(AuditCleanJob)
+}
+
+// Run deletes expired audit log entries.
+func (j *AuditCleanJob) Run() {
+	count, err := j.auditService.DelExpiredLogs()
+	if err != nil {
+		logger.Warning("Clear expired audit logs failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Cleared %d expired audit log entries", count)
+	}
+}
@@ -3,7 +3,6 @@ package job
 import (
 	"bufio"
 	"encoding/json"
-	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -15,13 +14,19 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 )
 
 // CheckClientIpJob monitors client IP addresses from access logs and manages IP blocking based on configured limits.
+// IPs beyond the limits are logged for Fail2Ban, or banned by the panel with the xray IP limit backend.
 type CheckClientIpJob struct {
-	lastClear     int64
-	disAllowedIps []string
+	lastClear            int64
+	disAllowedIps        []string
+	xrayBackend          bool                // IPs are banned by the panel instead of Fail2Ban
+	excessIps            map[string][]string // IPs beyond the limit by client email, banned with the xray backend
+	connectionLogService service.ConnectionLogService
+	ipLimitService       service.IpLimitService
 }
 
 var job *CheckClientIpJob
@@ -39,15 +44,16 @@ func (j *CheckClientIpJob) Run() {
 
 	shouldClearAccessLog := false
 	iplimitActive := j.hasLimitIp()
-	f2bInstalled := j.checkFail2BanInstalled()
+	j.xrayBackend = j.ipLimitService.IsXrayBackend()
 	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive)
 
 	if isAccessLogAvailable {
-		if runtime.GOOS == "windows" {
+		if runtime.GOOS == "windows" || j.xrayBackend {
 			if iplimitActive {
 				shouldClearAccessLog = j.processLogFile()
 			}
 		} else {
+			f2bInstalled := j.checkFail2BanInstalled()
 			if iplimitActive {
 				if f2bInstalled {
 					shouldClearAccessLog = j.processLogFile()
@@ -66,21 +72,8 @@ func (j *CheckClientIpJob) Run() {
 }
 
 func (j *CheckClientIpJob) clearAccessLog() {
-	logAccessP, err := os.OpenFile(xray.GetAccessPersistentLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	j.checkError(err)
-	defer logAccessP.Close()
-
-	accessLogPath, err := xray.GetAccessLogPath()
-	j.checkError(err)
-
-	file, err := os.Open(accessLogPath)
-	j.checkError(err)
-	defer file.Close()
-
-	_, err = io.Copy(logAccessP, file)
-	j.checkError(err)
-
-	err = os.Truncate(accessLogPath, 0)
+	// store the client connections that are not read yet before they are truncated
+	err := j.connectionLogService.RotateAccessLog()
 	j.checkError(err)
 
 	j.lastClear = time.Now().Unix()
@@ -95,6 +88,10 @@ func (j *CheckClientIpJob) hasLimitIp() bool {
 		return false
 	}
 
+	if len(j.getClientUserIpLimits()) > 0 {
+		return true
+	}
+
 	for _, inbound := range inbounds {
 		if inbound.Settings == "" {
 			continue
@@ -119,15 +116,19 @@ func (j *CheckClientIpJob) processLogFile() bool {
 
 	ipRegex := regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
 	emailRegex := regexp.MustCompile(`email: (.+)$`)
+	bannedRegex := regexp.MustCompile(`(?:->|>>) ` + regexp.QuoteMeta(service.IpBanOutboundTag) + `\]`)
+	j.excessIps = make(map[string][]string)
 
 	accessLogPath, _ := xray.GetAccessLogPath()
 	file, _ := os.Open(accessLogPath)
 	defer file.Close()
 
-	inboundClientIps := make(map[string]map[string]struct{}, 100)
+	// the line of the first connection from each IP of a client, IPs beyond the limit are those
+	// that showed up last
+	inboundClientIps := make(map[string]map[string]int, 100)
 
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	for lineNumber := 0; scanner.Scan(); lineNumber++ {
 		line := scanner.Text()
 
 		ipMatches := ipRegex.FindStringSubmatch(line)
@@ -141,6 +142,11 @@ func (j *CheckClientIpJob) processLogFile() bool {
 			continue
 		}
 
+		// connections from banned IPs do not count against the limit
+		if bannedRegex.MatchString(line) {
+			continue
+		}
+
 		emailMatches := emailRegex.FindStringSubmatch(line)
 		if len(emailMatches) < 2 {
 			continue
@@ -148,9 +154,11 @@ func (j *CheckClientIpJob) processLogFile() bool {
 		email := emailMatches[1]
 
 		if _, exists := inboundClientIps[email]; !exists {
-			inboundClientIps[email] = make(map[string]struct{})
+			inboundClientIps[email] = make(map[string]int)
+		}
+		if _, exists := inboundClientIps[email][ip]; !exists {
+			inboundClientIps[email][ip] = lineNumber
 		}
-		inboundClientIps[email][ip] = struct{}{}
 	}
 
 	shouldCleanLog := false
@@ -160,7 +168,7 @@ func (j *CheckClientIpJob) processLogFile() bool {
 		for ip := range uniqueIps {
 			ips = append(ips, ip)
 		}
-		sort.Strings(ips)
+		sortIpsBySighting(ips, uniqueIps)
 
 		clientIpsRecord, err := j.getInboundClientIps(email)
 		if err != nil {
@@ -171,9 +179,124 @@ func (j *CheckClientIpJob) processLogFile() bool {
 		shouldCleanLog = j.updateInboundClientIps(clientIpsRecord, email, ips) || shouldCleanLog
 	}
 
+	shouldCleanLog = j.checkClientUserIps(inboundClientIps) || shouldCleanLog
+
+	if j.xrayBackend {
+		for email, ips := range j.excessIps {
+			_, err := j.ipLimitService.BanIps(email, ips)
+			j.checkError(err)
+		}
+	}
+
 	return shouldCleanLog
 }
 
+// addExcessIp records an IP of a client beyond its limit, for Fail2Ban in the IP limit log, or to
+// be banned by the panel with the xray IP limit backend.
+func (j *CheckClientIpJob) addExcessIp(email string, ip string) {
+	if j.xrayBackend {
+		j.excessIps[email] = append(j.excessIps[email], ip)
+		return
+	}
+	log.Printf("[LIMIT_IP] Email = %s || SRC = %s", email, ip)
+}
+
+// sortIpsBySighting orders IPs by the line of their first connection, so that the IPs beyond a
+// limit are the ones that connected most recently.
+func sortIpsBySighting(ips []string, firstSeen map[string]int) {
+	sort.Slice(ips, func(a, b int) bool {
+		return firstSeen[ips[a]] < firstSeen[ips[b]]
+	})
+}
+
+// clientUserIpLimit is the IP limit a client shares with the other clients of its user.
+type clientUserIpLimit struct {
+	Email   string
+	UserId  int
+	LimitIP int
+}
+
+// getClientUserIpLimits returns the shared IP limits of the clients of users by email.
+func (j *CheckClientIpJob) getClientUserIpLimits() map[string]clientUserIpLimit {
+	var rows []clientUserIpLimit
+	err := database.GetDB().Table("inbound_clients").
+		Select("inbound_clients.email, client_users.id AS user_id, client_users.limit_ip").
+		Joins("JOIN client_users ON client_users.id = inbound_clients.user_id").
+		Where("client_users.limit_ip > 0").
+		Scan(&rows).Error
+	if err != nil {
+		j.checkError(err)
+		return nil
+	}
+	limits := make(map[string]clientUserIpLimit, len(rows))
+	for _, row := range rows {
+		limits[row.Email] = row
+	}
+	return limits
+}
+
+// checkClientUserIps enforces the IP limits users share between their clients. The IPs of all
+// clients of a user are counted together, and the IPs beyond the limit are logged for fail2ban
+// with the email of the client that used them first.
+func (j *CheckClientIpJob) checkClientUserIps(clientIps map[string]map[string]int) bool {
+	limits := j.getClientUserIpLimits()
+	if len(limits) == 0 {
+		return false
+	}
+
+	userIps := make(map[int]map[string]string)
+	userFirstSeen := make(map[int]map[string]int)
+	for email, ips := range clientIps {
+		limit, ok := limits[email]
+		if !ok {
+			continue
+		}
+		if _, exists := userIps[limit.UserId]; !exists {
+			userIps[limit.UserId] = make(map[string]string)
+			userFirstSeen[limit.UserId] = make(map[string]int)
+		}
+		for ip, line := range ips {
+			if seen, exists := userFirstSeen[limit.UserId][ip]; !exists || line < seen {
+				userIps[limit.UserId][ip] = email
+				userFirstSeen[limit.UserId][ip] = line
+			}
+		}
+	}
+	if len(userIps) == 0 {
+		return false
+	}
+
+	logIpFile, err := os.OpenFile(xray.GetIPLimitLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Errorf("failed to open IP limit log file: %s", err)
+		return false
+	}
+	defer logIpFile.Close()
+	log.SetOutput(logIpFile)
+	log.SetFlags(log.LstdFlags)
+
+	userLimits := make(map[int]int)
+	for _, limit := range limits {
+		userLimits[limit.UserId] = limit.LimitIP
+	}
+	for userId, emails := range userIps {
+		ips := make([]string, 0, len(emails))
+		for ip := range emails {
+			ips = append(ips, ip)
+		}
+		sortIpsBySighting(ips, userFirstSeen[userId])
+		limitIp := userLimits[userId]
+		if limitIp < len(ips) {
+			logger.Debug("disAllowedIps of user", userId, ":", ips[limitIp:])
+			for _, ip := range ips[limitIp:] {
+				j.addExcessIp(emails[ip], ip)
+			}
+		}
+	}
+
+	return true
+}
+
 func (j *CheckClientIpJob) checkFail2BanInstalled() bool {
 	cmd := "fail2ban-client"
 	args := []string{"-h"}
@@ -285,7 +408,7 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 				if limitIp < len(ips) {
 					j.disAllowedIps = append(j.disAllowedIps, ips[limitIp:]...)
 					for i := limitIp; i < len(ips); i++ {
-						log.Printf("[LIMIT_IP] Email = %s || SRC = %s", clientEmail, ips[i])
+						j.addExcessIp(clientEmail, ips[i])
 					}
 				}
 			}
@@ -312,7 +435,9 @@ func (j *CheckClientIpJob) getInboundByEmail(clientEmail string) (*model.Inbound
 	db := database.GetDB()
 	inbound := &model.Inbound{}
 
-	err := db.Model(&model.Inbound{}).Where("settings LIKE ?", "%"+clientEmail+"%").First(inbound).Error
+	err := db.Model(&model.Inbound{}).
+		Where("id = (?)", db.Model(model.InboundClient{}).Select("inbound_id").Where("email = ?", clientEmail).Limit(1)).
+		First(inbound).Error
 	if err != nil {
 		return nil, err
 	}
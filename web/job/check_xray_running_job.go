@@ -3,14 +3,20 @@
 package job
 
 import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 )
 
-// CheckXrayRunningJob monitors Xray process health and restarts it if it crashes.
+// CheckXrayRunningJob supervises the Xray process: it records crashes, restarts Xray with an
+// exponential backoff, alerts when Xray crash-loops and rolls back to the last good configuration.
 type CheckXrayRunningJob struct {
-	xrayService service.XrayService
-	checkTime   int
+	xrayService    service.XrayService
+	webhookService service.WebhookService
+	tgbotService   service.Tgbot
+	checkTime      int
 }
 
 // NewCheckXrayRunningJob creates a new Xray health check job instance.
@@ -18,19 +24,42 @@ func NewCheckXrayRunningJob() *CheckXrayRunningJob {
 	return new(CheckXrayRunningJob)
 }
 
-// Run checks if Xray has crashed and restarts it after confirming it's down for 2 consecutive checks.
+// Run checks if Xray has crashed, confirming it's down for 2 consecutive checks, and restarts it
+// once the backoff delay has passed. A running Xray is checked for a stable configuration instead.
 func (j *CheckXrayRunningJob) Run() {
 	if !j.xrayService.DidXrayCrash() {
 		j.checkTime = 0
-	} else {
-		j.checkTime++
-		// only restart if it's down 2 times in a row
-		if j.checkTime > 1 {
-			err := j.xrayService.RestartXray(false)
-			j.checkTime = 0
-			if err != nil {
-				logger.Error("Restart xray failed:", err)
-			}
-		}
+		j.xrayService.CheckXrayStable()
+		return
+	}
+	j.checkTime++
+	// only handle it if it's down 2 times in a row
+	if j.checkTime < 2 {
+		return
+	}
+
+	crash, tripped := j.xrayService.RecordXrayCrash()
+	if crash != nil {
+		j.webhookService.Emit(model.WebhookEventXrayCrashed, crash)
+	}
+	if tripped {
+		status := j.xrayService.GetXraySupervisorStatus()
+		j.webhookService.Emit(model.WebhookEventXrayCrashLoop, map[string]any{"status": status, "crash": crash})
+		msg := j.tgbotService.I18nBot("tgbot.messages.xrayCrashLoop",
+			"Crashes=="+strconv.Itoa(status.Crashes),
+			"Error=="+crash.Error)
+		j.tgbotService.SendMsgToTgbotAdmins(msg)
+	}
+	if !j.xrayService.IsXrayRestartDue() {
+		return
+	}
+
+	j.checkTime = 0
+	rolledBack, err := j.xrayService.RestartCrashedXray()
+	if err != nil {
+		logger.Error("Restart xray failed:", err)
+	}
+	if rolledBack {
+		j.tgbotService.SendMsgToTgbotAdmins(j.tgbotService.I18nBot("tgbot.messages.xrayRolledBack"))
 	}
 }
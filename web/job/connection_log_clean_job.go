@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ConnectionLogCleanJob removes client connections past the configured retention period or record limit.
+type ConnectionLogCleanJob struct {
+	connectionLogService service.ConnectionLogService
+}
+
+// NewConnectionLogCleanJob creates a new connection log cleanup job instance.
+func NewConnectionLogCleanJob() *ConnectionLogCleanJob {
+	return new(ConnectionLogCleanJob)
+}
+
+// Run deletes expired client connections.
+func (j *ConnectionLogCleanJob) Run() {
+	count, err := j.connectionLogService.DelExpiredConnections()
+	if err != nil {
+		logger.Warning("Clear expired client connections failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Cleared %d expired client connections", count)
+	}
+}
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ConnectionLogJob stores the client connections written to the Xray access log.
+type ConnectionLogJob struct {
+	connectionLogService service.ConnectionLogService
+}
+
+// NewConnectionLogJob creates a new connection log job instance.
+func NewConnectionLogJob() *ConnectionLogJob {
+	return new(ConnectionLogJob)
+}
+
+// Run reads the new lines of the Xray access log.
+func (j *ConnectionLogJob) Run() {
+	count, err := j.connectionLogService.IngestAccessLog()
+	if err != nil {
+		logger.Warning("Read Xray access log failed:", err)
+	}
+	if count > 0 {
+		logger.Debugf("Stored %d client connections", count)
+	}
+}
@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// IpBanCleanJob lifts the IP bans of clients past the configured ban duration.
+type IpBanCleanJob struct {
+	ipLimitService service.IpLimitService
+}
+
+// NewIpBanCleanJob creates a new IP ban cleanup job instance.
+func NewIpBanCleanJob() *IpBanCleanJob {
+	return new(IpBanCleanJob)
+}
+
+// Run deletes expired IP bans.
+func (j *IpBanCleanJob) Run() {
+	count, err := j.ipLimitService.DelExpiredBans()
+	if err != nil {
+		logger.Warning("Clear expired IP bans failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Lifted %d expired IP bans", count)
+	}
+}
@@ -10,8 +10,6 @@ import (
 	ldaputil "github.com/mhsanaei/3x-ui/v2/util/ldap"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
-	"strconv"
-
 	"github.com/google/uuid"
 )
 
@@ -21,8 +19,13 @@ type LdapSyncJob struct {
 	settingService service.SettingService
 	inboundService service.InboundService
 	xrayService    service.XrayService
+	auditService   service.AuditService
+	webhookService service.WebhookService
 }
 
+// ldapAuditActor identifies changes made by the LDAP sync in the audit log.
+var ldapAuditActor = service.AuditActor{Type: model.AuditActorLdap, Name: "ldap-sync"}
+
 // --- Helper functions for mustGet ---
 func mustGetString(fn func() (string, error)) string {
 	v, err := fn()
@@ -140,12 +143,13 @@ func (j *LdapSyncJob) Run() {
 		if len(newClients) == 0 {
 			continue
 		}
-		payload := &model.Inbound{Id: inboundMap[tag].Id}
-		payload.Settings = j.clientsToJSON(newClients)
-		if _, err := j.inboundService.AddInboundClient(payload); err != nil {
+		if _, err := j.inboundService.AddClients(inboundMap[tag].Id, newClients); err != nil {
 			logger.Warningf("Failed to add clients for tag %s: %v", tag, err)
 		} else {
 			logger.Infof("LDAP auto-create: %d clients for %s", len(newClients), tag)
+			for _, c := range newClients {
+				j.auditService.Record(ldapAuditActor, "client.add", "client:"+c.Email, nil, c)
+			}
 			j.xrayService.SetToNeedRestart()
 		}
 	}
@@ -169,6 +173,22 @@ func (j *LdapSyncJob) Run() {
 			j.deleteClientsNotInLDAP(tag, ldapEmailSet)
 		}
 	}
+
+	j.webhookService.Emit(model.WebhookEventLdapSyncCompleted, map[string]any{
+		"users":    len(flags),
+		"created":  countClients(clientsToCreate),
+		"enabled":  countClients(clientsToEnable),
+		"disabled": countClients(clientsToDisable),
+	})
+}
+
+// countClients returns the number of clients in a per-tag batch.
+func countClients[T any](batch map[string][]T) int {
+	count := 0
+	for _, clients := range batch {
+		count += len(clients)
+	}
+	return count
 }
 
 func splitCsv(s string) []string {
@@ -206,33 +226,21 @@ func (j *LdapSyncJob) buildClient(ib *model.Inbound, email string, defGB, defExp
 	return c
 }
 
-// batchSetEnable enables/disables clients in batch through a single call
+// batchSetEnable enables/disables clients in batch through a single query
 func (j *LdapSyncJob) batchSetEnable(ib *model.Inbound, emails []string, enable bool) {
 	if len(emails) == 0 {
 		return
 	}
 
-	// Prepare JSON for mass update
-	clients := make([]model.Client, 0, len(emails))
-	for _, email := range emails {
-		clients = append(clients, model.Client{
-			Email:  email,
-			Enable: enable,
-		})
-	}
-
-	payload := &model.Inbound{
-		Id:       ib.Id,
-		Settings: j.clientsToJSON(clients),
-	}
-
-	// Use a single AddInboundClient call to update enable
-	if _, err := j.inboundService.AddInboundClient(payload); err != nil {
+	if err := j.inboundService.SetClientsEnable(ib.Id, emails, enable); err != nil {
 		logger.Warningf("Batch set enable failed for inbound %s: %v", ib.Tag, err)
 		return
 	}
 
 	logger.Infof("Batch set enable=%v for %d clients in inbound %s", enable, len(emails), ib.Tag)
+	for _, email := range emails {
+		j.auditService.Record(ldapAuditActor, "client.update", "client:"+email, nil, map[string]any{"enable": enable})
+	}
 	j.xrayService.SetToNeedRestart()
 }
 
@@ -294,6 +302,7 @@ func (j *LdapSyncJob) deleteClientsNotInLDAP(inboundTag string, ldapEmails map[s
 				} else {
 					logger.Infof("Deleted client %s from inbound id=%d(tag=%s)",
 						c.Email, ib.Id, ib.Tag)
+					j.auditService.Record(ldapAuditActor, "client.del", "client:"+c.Email, c, nil)
 					// do not restart here
 					restartNeeded = true
 				}
@@ -308,20 +317,6 @@ func (j *LdapSyncJob) deleteClientsNotInLDAP(inboundTag string, ldapEmails map[s
 	}
 }
 
-// clientsToJSON serializes an array of clients to JSON
-func (j *LdapSyncJob) clientsToJSON(clients []model.Client) string {
-	b := strings.Builder{}
-	b.WriteString("{\"clients\":[")
-	for i, c := range clients {
-		if i > 0 {
-			b.WriteString(",")
-		}
-		b.WriteString(j.clientToJSON(c))
-	}
-	b.WriteString("]}")
-	return b.String()
-}
-
 // ensureClientExists adds client with defaults to inbound tag if not present
 func (j *LdapSyncJob) ensureClientExists(inboundTag string, email string, defGB int, defExpiryDays int, defLimitIP int) {
 	inbounds, err := j.inboundService.GetAllInbounds()
@@ -370,52 +365,11 @@ func (j *LdapSyncJob) ensureClientExists(inboundTag string, email string, defGB
 		newClient.ID = uuid.NewString()
 	}
 
-	// prepare inbound payload with only the new client
-	payload := &model.Inbound{Id: target.Id}
-	payload.Settings = `{"clients":[` + j.clientToJSON(newClient) + `]}`
-
-	if _, err := j.inboundService.AddInboundClient(payload); err != nil {
+	if _, err := j.inboundService.AddClients(target.Id, []model.Client{newClient}); err != nil {
 		logger.Warning("ensureClientExists: add client failed:", err)
 	} else {
 		j.xrayService.SetToNeedRestart()
 		logger.Infof("LDAP auto-create: %s in %s", email, inboundTag)
+		j.auditService.Record(ldapAuditActor, "client.add", "client:"+email, nil, newClient)
 	}
 }
-
-// clientToJSON serializes minimal client fields to JSON object string without extra deps
-func (j *LdapSyncJob) clientToJSON(c model.Client) string {
-	// construct minimal JSON manually to avoid importing json for simple case
-	b := strings.Builder{}
-	b.WriteString("{")
-	if c.ID != "" {
-		b.WriteString("\"id\":\"")
-		b.WriteString(c.ID)
-		b.WriteString("\",")
-	}
-	if c.Password != "" {
-		b.WriteString("\"password\":\"")
-		b.WriteString(c.Password)
-		b.WriteString("\",")
-	}
-	b.WriteString("\"email\":\"")
-	b.WriteString(c.Email)
-	b.WriteString("\",")
-	b.WriteString("\"enable\":")
-	if c.Enable {
-		b.WriteString("true")
-	} else {
-		b.WriteString("false")
-	}
-	b.WriteString(",")
-	b.WriteString("\"limitIp\":")
-	b.WriteString(strconv.Itoa(c.LimitIP))
-	b.WriteString(",")
-	b.WriteString("\"totalGB\":")
-	b.WriteString(strconv.FormatInt(c.TotalGB, 10))
-	if c.ExpiryTime > 0 {
-		b.WriteString(",\"expiryTime\":")
-		b.WriteString(strconv.FormatInt(c.ExpiryTime, 10))
-	}
-	b.WriteString("}")
-	return b.String()
-}
@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SubAccessCleanJob removes subscription access log entries older than the configured retention period.
+type SubAccessCleanJob struct {
+	subAccessService service.SubAccessService
+}
+
+// NewSubAccessCleanJob creates a new subscription access log cleanup job instance.
+func NewSubAccessCleanJob() *SubAccessCleanJob {
+	return new(SubAccessCleanJob)
+}
+
+// Run deletes expired subscription access log entries.
+func (j *SubAccessCleanJob) Run() {
+	count, err := j.subAccessService.DelExpiredLogs()
+	if err != nil {
+		logger.Warning("Clear expired subscription access logs failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Cleared %d expired subscription access log entries", count)
+	}
+}
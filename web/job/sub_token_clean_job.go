@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SubTokenCleanJob removes former subscription IDs whose grace period is over.
+type SubTokenCleanJob struct {
+	inboundService service.InboundService
+}
+
+// NewSubTokenCleanJob creates a new subscription token cleanup job instance.
+func NewSubTokenCleanJob() *SubTokenCleanJob {
+	return new(SubTokenCleanJob)
+}
+
+// Run deletes expired subscription tokens.
+func (j *SubTokenCleanJob) Run() {
+	count, err := j.inboundService.DelExpiredSubTokens()
+	if err != nil {
+		logger.Warning("Clear expired subscription tokens failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Cleared %d expired subscription tokens", count)
+	}
+}
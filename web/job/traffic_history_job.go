@@ -0,0 +1,34 @@
+package job
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// TrafficHistoryJob rolls up the traffic history into coarser buckets and drops expired buckets once a day.
+type TrafficHistoryJob struct {
+	trafficHistoryService service.TrafficHistoryService
+	lastCleanup           time.Time
+}
+
+// NewTrafficHistoryJob creates a new traffic history rollup job instance.
+func NewTrafficHistoryJob() *TrafficHistoryJob {
+	return new(TrafficHistoryJob)
+}
+
+// Run rolls up the recent traffic history and removes expired buckets.
+func (j *TrafficHistoryJob) Run() {
+	if err := j.trafficHistoryService.Rollup(); err != nil {
+		logger.Warning("Roll up traffic history failed:", err)
+	}
+	if time.Since(j.lastCleanup) < 24*time.Hour {
+		return
+	}
+	if err := j.trafficHistoryService.DelExpiredHistory(); err != nil {
+		logger.Warning("Clear expired traffic history failed:", err)
+		return
+	}
+	j.lastCleanup = time.Now()
+}
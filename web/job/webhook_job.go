@@ -0,0 +1,36 @@
+package job
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// WebhookJob retries due webhook deliveries and drops old delivery logs once a day.
+type WebhookJob struct {
+	webhookService service.WebhookService
+	lastCleanup    time.Time
+}
+
+// NewWebhookJob creates a new webhook delivery job instance.
+func NewWebhookJob() *WebhookJob {
+	return new(WebhookJob)
+}
+
+// Run attempts the pending webhook deliveries that are due and removes expired delivery logs.
+func (j *WebhookJob) Run() {
+	j.webhookService.DeliverPending()
+	if time.Since(j.lastCleanup) < 24*time.Hour {
+		return
+	}
+	count, err := j.webhookService.DelExpiredDeliveries()
+	if err != nil {
+		logger.Warning("Clear expired webhook deliveries failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Cleared %d expired webhook deliveries", count)
+	}
+	j.lastCleanup = time.Now()
+}
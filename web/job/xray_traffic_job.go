@@ -1,21 +1,20 @@
 package job
 
 import (
-	"encoding/json"
-
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
-
-	"github.com/valyala/fasthttp"
 )
 
-// XrayTrafficJob collects and processes traffic statistics from Xray, updating the database and optionally informing external APIs.
+// XrayTrafficJob collects and processes traffic statistics from Xray, updating the database and notifying webhooks.
 type XrayTrafficJob struct {
 	settingService  service.SettingService
 	xrayService     service.XrayService
 	inboundService  service.InboundService
 	outboundService service.OutboundService
+	historyService  service.TrafficHistoryService
+	webhookService  service.WebhookService
 }
 
 // NewXrayTrafficJob creates a new traffic collection job instance.
@@ -40,36 +39,28 @@ func (j *XrayTrafficJob) Run() {
 	if err != nil {
 		logger.Warning("add outbound traffic failed:", err)
 	}
-	if ExternalTrafficInformEnable, err := j.settingService.GetExternalTrafficInformEnable(); ExternalTrafficInformEnable {
-		j.informTrafficToExternalAPI(traffics, clientTraffics)
-	} else if err != nil {
-		logger.Warning("get ExternalTrafficInformEnable failed:", err)
+	if err := j.historyService.AddTraffic(traffics, clientTraffics); err != nil {
+		logger.Warning("add traffic history failed:", err)
+	}
+	if hasTrafficDelta(traffics, clientTraffics) {
+		j.webhookService.Emit(model.WebhookEventTrafficUpdated, map[string]any{"clientTraffics": clientTraffics, "inboundTraffics": traffics})
 	}
 	if needRestart0 || needRestart1 {
 		j.xrayService.SetToNeedRestart()
 	}
 }
 
-func (j *XrayTrafficJob) informTrafficToExternalAPI(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
-	informURL, err := j.settingService.GetExternalTrafficInformURI()
-	if err != nil {
-		logger.Warning("get ExternalTrafficInformURI failed:", err)
-		return
-	}
-	requestBody, err := json.Marshal(map[string]any{"clientTraffics": clientTraffics, "inboundTraffics": inboundTraffics})
-	if err != nil {
-		logger.Warning("parse client/inbound traffic failed:", err)
-		return
+// hasTrafficDelta reports whether any inbound, outbound or client transferred data.
+func hasTrafficDelta(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) bool {
+	for _, traffic := range traffics {
+		if traffic.Up > 0 || traffic.Down > 0 {
+			return true
+		}
 	}
-	request := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(request)
-	request.Header.SetMethod("POST")
-	request.Header.SetContentType("application/json; charset=UTF-8")
-	request.SetBody([]byte(requestBody))
-	request.SetRequestURI(informURL)
-	response := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(response)
-	if err := fasthttp.Do(request, response); err != nil {
-		logger.Warning("POST ExternalTrafficInformURI failed:", err)
+	for _, traffic := range clientTraffics {
+		if traffic.Up > 0 || traffic.Down > 0 {
+			return true
+		}
 	}
+	return false
 }
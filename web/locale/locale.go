@@ -4,6 +4,7 @@ package locale
 
 import (
 	"embed"
+	"errors"
 	"io/fs"
 	"os"
 	"strings"
@@ -72,6 +73,7 @@ func createTemplateData(params []string, separator ...string) map[string]any {
 
 // I18n retrieves a localized message for the given key and type.
 // It supports both bot and web contexts, with optional template parameters.
+// Messages missing in the language of the localizer fall back to English.
 // Returns the localized message or an empty string if localization fails.
 func I18n(i18nType I18nType, key string, params ...string) string {
 	var localizer *i18n.Localizer
@@ -97,6 +99,10 @@ func I18n(i18nType I18nType, key string, params ...string) string {
 		MessageID:    key,
 		TemplateData: templateData,
 	})
+	var notFound *i18n.MessageNotFoundErr
+	if errors.As(err, &notFound) && msg != "" {
+		return msg
+	}
 	if err != nil {
 		logger.Errorf("Failed to localize message: %v", err)
 		return ""
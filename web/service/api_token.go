@@ -0,0 +1,124 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+)
+
+const (
+	apiTokenPrefix = "xui_"
+	// lastUsed is only written when it is older than this, to avoid a DB write on every call.
+	apiTokenTouchInterval = time.Minute
+)
+
+// APITokenService provides business logic for API tokens used by headless clients of /panel/api.
+type APITokenService struct {
+	userService UserService
+}
+
+// GetTokens retrieves the API tokens of a user, or of every user if userId is 0.
+func (s *APITokenService) GetTokens(userId int) ([]*model.APIToken, error) {
+	db := database.GetDB().Model(model.APIToken{})
+	if userId != 0 {
+		db = db.Where("user_id = ?", userId)
+	}
+	var tokens []*model.APIToken
+	err := db.Order("id").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// AddToken creates a new API token for a user. The plain token is only returned here,
+// the database keeps its hash.
+func (s *APITokenService) AddToken(userId int, name string, scopes []string, expiryTime int64) (string, *model.APIToken, error) {
+	if name == "" {
+		return "", nil, common.NewError("token name can not be empty")
+	}
+	if len(scopes) == 0 {
+		return "", nil, common.NewError("token needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !model.IsValidScope(scope) {
+			return "", nil, common.NewError("invalid scope:", scope)
+		}
+	}
+	if expiryTime < 0 {
+		return "", nil, common.NewError("invalid expiry time:", expiryTime)
+	}
+
+	plain := apiTokenPrefix + random.Seq(40)
+	token := &model.APIToken{
+		UserId:     userId,
+		Name:       name,
+		TokenHash:  crypto.HashToken(plain),
+		Prefix:     plain[:len(apiTokenPrefix)+6],
+		Scopes:     strings.Join(scopes, ","),
+		ExpiryTime: expiryTime,
+	}
+	err := database.GetDB().Create(token).Error
+	if err != nil {
+		return "", nil, err
+	}
+	return plain, token, nil
+}
+
+// DelToken revokes an API token. If userId is not 0, only that user's token can be deleted.
+func (s *APITokenService) DelToken(id int, userId int) error {
+	db := database.GetDB().Where("id = ?", id)
+	if userId != 0 {
+		db = db.Where("user_id = ?", userId)
+	}
+	result := db.Delete(model.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("token not found:", id)
+	}
+	return nil
+}
+
+// Authenticate resolves a plain token to its record and user.
+// It rejects unknown and expired tokens and records the last use.
+func (s *APITokenService) Authenticate(plain string, remoteIp string) (*model.APIToken, *model.User, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil, nil, common.NewError("invalid API token")
+	}
+	db := database.GetDB()
+	token := &model.APIToken{}
+	err := db.Model(model.APIToken{}).Where("token_hash = ?", crypto.HashToken(plain)).First(token).Error
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, nil, common.NewError("invalid API token")
+		}
+		return nil, nil, err
+	}
+	now := time.Now()
+	if token.IsExpired(now.UnixMilli()) {
+		return nil, nil, common.NewError("API token expired:", token.Name)
+	}
+	user, err := s.userService.GetUserById(token.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if now.UnixMilli()-token.LastUsed > apiTokenTouchInterval.Milliseconds() || token.LastUsedIp != remoteIp {
+		token.LastUsed = now.UnixMilli()
+		token.LastUsedIp = remoteIp
+		err = db.Model(model.APIToken{}).Where("id = ?", token.Id).
+			Updates(map[string]any{"last_used": token.LastUsed, "last_used_ip": remoteIp}).Error
+		if err != nil {
+			logger.Warning("Unable to update API token last use:", err)
+		}
+	}
+	return token, user, nil
+}
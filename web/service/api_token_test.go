@@ -0,0 +1,61 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestAPITokenAuthenticate(t *testing.T) {
+	setupTestDB(t)
+
+	svc := APITokenService{}
+	plain, token, err := svc.AddToken(1, "provisioning", []string{model.ScopeClientsWrite}, 0)
+	if err != nil {
+		t.Fatalf("add token: %v", err)
+	}
+	if token.TokenHash == plain || token.TokenHash == "" {
+		t.Fatalf("token must be stored hashed")
+	}
+
+	got, user, err := svc.Authenticate(plain, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if user.Id != 1 || got.Id != token.Id {
+		t.Fatalf("unexpected token owner %d / token %d", user.Id, got.Id)
+	}
+	if !got.HasScope(model.ScopeInboundsRead) || got.HasScope(model.ScopeInboundsWrite) {
+		t.Fatalf("unexpected scopes for %q", got.Scopes)
+	}
+
+	var stored model.APIToken
+	database.GetDB().First(&stored, token.Id)
+	if stored.LastUsed == 0 || stored.LastUsedIp != "127.0.0.1" {
+		t.Fatalf("last use not recorded: %+v", stored)
+	}
+
+	if _, _, err := svc.Authenticate(plain+"x", "127.0.0.1"); err == nil {
+		t.Fatalf("expected unknown token to be rejected")
+	}
+
+	expired, _, err := svc.AddToken(1, "old", []string{model.ScopeServerRead}, time.Now().Add(-time.Hour).UnixMilli())
+	if err != nil {
+		t.Fatalf("add expired token: %v", err)
+	}
+	if _, _, err := svc.Authenticate(expired, "127.0.0.1"); err == nil {
+		t.Fatalf("expected expired token to be rejected")
+	}
+
+	if err := svc.DelToken(token.Id, 2); err == nil {
+		t.Fatalf("expected other users to be unable to revoke the token")
+	}
+	if err := svc.DelToken(token.Id, 1); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if _, _, err := svc.Authenticate(plain, "127.0.0.1"); err == nil {
+		t.Fatalf("expected revoked token to be rejected")
+	}
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// auditRedactedKeys are object keys whose values are never written to the audit log.
+var auditRedactedKeys = map[string]bool{
+	"password":       true,
+	"ldapPassword":   true,
+	"tgBotToken":     true,
+	"twoFactorToken": true,
+	"metricsToken":   true,
+	"subNodes":       true,
+	"secret":         true,
+	"token":          true,
+	"id":             true, // Client UUIDs, numeric record ids are kept
+	"auth":           true,
+	"pass":           true,
+	"privateKey":     true,
+	"secretKey":      true,
+	"preSharedKey":   true,
+}
+
+// auditEncodedKeys are object keys whose values are JSON documents stored as strings, like the
+// settings of an inbound. They are decoded, so that the secrets inside are redacted too.
+var auditEncodedKeys = map[string]bool{
+	"settings":       true,
+	"streamSettings": true,
+}
+
+// AuditActor identifies who performed an audited action.
+type AuditActor struct {
+	Type   string // One of the model.AuditActor* constants
+	Name   string // Username, token name, Telegram chat id or job name
+	UserId int    // Panel user behind the actor, 0 if none
+	Ip     string // Source IP, if any
+}
+
+// AuditFilter narrows down the audit log entries returned by GetLogs.
+// Empty fields are ignored; Target and Action match by prefix.
+type AuditFilter struct {
+	ActorType string `form:"actorType"`
+	Actor     string `form:"actor"`
+	Action    string `form:"action"`
+	Target    string `form:"target"`
+	From      int64  `form:"from"` // Timestamp in milliseconds
+	To        int64  `form:"to"`   // Timestamp in milliseconds
+	Page      int    `form:"page"`
+	PageSize  int    `form:"pageSize"`
+}
+
+// AuditService records administrative actions and provides access to the audit log.
+type AuditService struct {
+	settingService SettingService
+}
+
+// Record stores an audit log entry. before and after are serialized to JSON; when both are
+// objects only the fields that differ are kept. Failures are logged but never returned,
+// auditing must not break the audited action.
+func (s *AuditService) Record(actor AuditActor, action string, target string, before any, after any) {
+	beforeJson, afterJson := auditDiff(before, after)
+	entry := &model.AuditLog{
+		Time:      time.Now().UnixMilli(),
+		ActorType: actor.Type,
+		Actor:     actor.Name,
+		UserId:    actor.UserId,
+		Action:    action,
+		Target:    target,
+		Before:    beforeJson,
+		After:     afterJson,
+		Ip:        actor.Ip,
+	}
+	if err := database.GetDB().Create(entry).Error; err != nil {
+		logger.Warning("Unable to write audit log:", err)
+	}
+}
+
+// GetLogs retrieves audit log entries matching the filter, newest first, along with the total match count.
+func (s *AuditService) GetLogs(filter *AuditFilter) ([]*model.AuditLog, int64, error) {
+	db := database.GetDB().Model(model.AuditLog{})
+	if filter.ActorType != "" {
+		db = db.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.Actor != "" {
+		db = db.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		db = db.Where("action LIKE ? ESCAPE '\\'", escapeLike(filter.Action)+"%")
+	}
+	if filter.Target != "" {
+		db = db.Where("target LIKE ? ESCAPE '\\'", escapeLike(filter.Target)+"%")
+	}
+	if filter.From > 0 {
+		db = db.Where("time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		db = db.Where("time <= ?", filter.To)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	pageSize := filter.PageSize
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = 100
+	}
+	page := max(filter.Page, 1)
+	var logs []*model.AuditLog
+	err := db.Order("time desc, id desc").Limit(pageSize).Offset((page - 1) * pageSize).Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// DelExpiredLogs removes audit log entries older than the configured retention period.
+func (s *AuditService) DelExpiredLogs() (int64, error) {
+	days, err := s.settingService.GetAuditRetentionDays()
+	if err != nil || days <= 0 {
+		return 0, err
+	}
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+	result := database.GetDB().Where("time < ?", cutoff).Delete(model.AuditLog{})
+	return result.RowsAffected, result.Error
+}
+
+// auditDiff serializes the before and after states for the audit log.
+func auditDiff(before any, after any) (string, string) {
+	beforeMap, beforeIsMap := toAuditMap(before)
+	afterMap, afterIsMap := toAuditMap(after)
+	if beforeIsMap && afterIsMap {
+		for key, value := range beforeMap {
+			if other, ok := afterMap[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeMap, key)
+				delete(afterMap, key)
+			}
+		}
+	}
+	return marshalAudit(before, beforeMap, beforeIsMap), marshalAudit(after, afterMap, afterIsMap)
+}
+
+// toAuditMap converts a value to a redacted JSON object map, if it is one.
+func toAuditMap(value any) (map[string]any, bool) {
+	if value == nil {
+		return nil, false
+	}
+	var raw []byte
+	switch v := value.(type) {
+	case string:
+		raw = []byte(v)
+	case json.RawMessage:
+		raw = v
+	default:
+		var err error
+		raw, err = json.Marshal(v)
+		if err != nil {
+			return nil, false
+		}
+	}
+	var m map[string]any
+	if json.Unmarshal(raw, &m) != nil || m == nil {
+		return nil, false
+	}
+	redactAudit(m)
+	return m, true
+}
+
+// redactAudit masks secret values in nested objects in place.
+func redactAudit(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if _, isNumber := item.(float64); auditRedactedKeys[key] && !isNumber {
+				v[key] = "***"
+				continue
+			}
+			if s, ok := item.(string); ok && auditEncodedKeys[key] {
+				var decoded any
+				if json.Unmarshal([]byte(s), &decoded) == nil {
+					v[key] = decoded
+					item = decoded
+				}
+			}
+			redactAudit(item)
+		}
+	case []any:
+		for _, item := range v {
+			redactAudit(item)
+		}
+	}
+}
+
+func marshalAudit(value any, m map[string]any, isMap bool) string {
+	if value == nil {
+		return ""
+	}
+	if isMap {
+		value = m
+	} else if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// escapeLike escapes the wildcard characters of a SQL LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
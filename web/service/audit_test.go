@@ -0,0 +1,62 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestAuditRecordAndFilter(t *testing.T) {
+	setupTestDB(t)
+
+	svc := AuditService{}
+	actor := AuditActor{Type: model.AuditActorUser, Name: "admin", UserId: 1, Ip: "127.0.0.1"}
+	svc.Record(actor, "client.update", "client:alice",
+		map[string]any{"email": "alice", "totalGB": 10, "password": "old"},
+		map[string]any{"email": "alice", "totalGB": 20, "password": "new"})
+	svc.Record(AuditActor{Type: model.AuditActorTgBot, Name: "42"}, "client.resetTraffic", "client:bob", nil, nil)
+
+	logs, total, err := svc.GetLogs(&AuditFilter{Target: "client:alice"})
+	if err != nil {
+		t.Fatalf("get logs: %v", err)
+	}
+	if total != 1 || len(logs) != 1 {
+		t.Fatalf("expected 1 entry for alice, got %d", total)
+	}
+	entry := logs[0]
+	if strings.Contains(entry.Before, "email") || !strings.Contains(entry.After, `"totalGB":20`) {
+		t.Fatalf("expected only changed fields, got before=%s after=%s", entry.Before, entry.After)
+	}
+	if strings.Contains(entry.Before, "old") || strings.Contains(entry.After, "new") {
+		t.Fatalf("secrets must be redacted, got before=%s after=%s", entry.Before, entry.After)
+	}
+
+	_, total, err = svc.GetLogs(&AuditFilter{ActorType: model.AuditActorTgBot, Action: "client."})
+	if err != nil || total != 1 {
+		t.Fatalf("expected 1 bot entry, got %d (%v)", total, err)
+	}
+	_, total, _ = svc.GetLogs(&AuditFilter{Target: "client_"})
+	if total != 0 {
+		t.Fatalf("LIKE wildcards must be escaped, got %d matches", total)
+	}
+}
+
+func TestAuditRedactsInboundSettings(t *testing.T) {
+	inbound := &model.Inbound{
+		Id:             7,
+		Remark:         "ss",
+		Protocol:       model.Shadowsocks,
+		Settings:       `{"method":"2022-blake3-aes-128-gcm","password":"server-key","clients":[{"email":"alice","password":"client-key","id":"4b1c5e4e-0c53-4a0e-9f7c-5d1a3e0b6a11"}]}`,
+		StreamSettings: `{"network":"tcp","security":"reality","realitySettings":{"privateKey":"reality-key"}}`,
+	}
+	_, after := auditDiff(nil, inbound)
+	for _, secret := range []string{"server-key", "client-key", "4b1c5e4e", "reality-key"} {
+		if strings.Contains(after, secret) {
+			t.Fatalf("expected %q to be redacted, got %s", secret, after)
+		}
+	}
+	if !strings.Contains(after, `"email":"alice"`) || !strings.Contains(after, `"id":7`) {
+		t.Fatalf("expected the other fields to be kept, got %s", after)
+	}
+}
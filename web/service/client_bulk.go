@@ -0,0 +1,442 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Actions of a bulk client operation.
+const (
+	ClientBulkExtendExpiry = "extendExpiry" // Extend the expiry time by Days
+	ClientBulkAddTraffic   = "addTraffic"   // Raise the traffic limit by GB
+	ClientBulkSetLimitIp   = "setLimitIp"   // Set the IP limit to LimitIP
+	ClientBulkEnable       = "enable"
+	ClientBulkDisable      = "disable"
+	ClientBulkResetTraffic = "resetTraffic"
+	ClientBulkDelete       = "delete"
+	ClientBulkMove         = "move" // Move the clients to the inbound InboundId
+)
+
+// States a bulk client operation can select clients by.
+const (
+	ClientStateExpired  = "expired"
+	ClientStateDepleted = "depleted"
+	ClientStateOffline  = "offline" // Not online for at least OfflineDays days
+)
+
+// ClientSelector selects the clients of a bulk operation. All given conditions must match.
+type ClientSelector struct {
+	InboundIds  []int  `json:"inboundIds"`
+	Email       string `json:"email"`   // Glob pattern of the email, * matches any text and ? one character
+	Comment     string `json:"comment"` // Text the comment contains
+	State       string `json:"state"`   // One of the ClientState constants
+	OfflineDays int    `json:"offlineDays"`
+	UserId      int    `json:"-"` // Restricts the clients to inbounds of the panel user, 0 for all inbounds
+}
+
+// ClientBulkRequest is a bulk client operation: an action applied to the selected clients.
+type ClientBulkRequest struct {
+	Selector  ClientSelector `json:"selector"`
+	Action    string         `json:"action"`    // One of the ClientBulk constants
+	Days      int            `json:"days"`      // For extendExpiry
+	GB        int64          `json:"gb"`        // For addTraffic
+	LimitIP   int            `json:"limitIp"`   // For setLimitIp
+	InboundId int            `json:"inboundId"` // Target inbound for move
+	DryRun    bool           `json:"dryRun"`    // Only return the affected clients
+}
+
+// ClientBulkResult lists the clients affected by a bulk operation, as they were before it.
+type ClientBulkResult struct {
+	DryRun  bool                   `json:"dryRun"`
+	Count   int                    `json:"count"`
+	Clients []*model.InboundClient `json:"clients"`
+}
+
+// bulkActiveClient is a client as served by Xray, see bulkActiveClients.
+type bulkActiveClient struct {
+	inbound *model.Inbound
+	client  *model.InboundClient
+}
+
+func (s *InboundService) checkClientBulkRequest(req *ClientBulkRequest) error {
+	sel := &req.Selector
+	if len(sel.InboundIds) == 0 && sel.Email == "" && sel.Comment == "" && sel.State == "" {
+		return common.NewError("empty client selector")
+	}
+	switch sel.State {
+	case "", ClientStateExpired, ClientStateDepleted:
+	case ClientStateOffline:
+		if sel.OfflineDays <= 0 {
+			return common.NewError("offlineDays must be positive")
+		}
+	default:
+		return common.NewError("unknown client state:", sel.State)
+	}
+	switch req.Action {
+	case ClientBulkExtendExpiry:
+		if req.Days <= 0 {
+			return common.NewError("days must be positive")
+		}
+	case ClientBulkAddTraffic:
+		if req.GB <= 0 {
+			return common.NewError("gb must be positive")
+		}
+	case ClientBulkSetLimitIp:
+		if req.LimitIP < 0 {
+			return common.NewError("limitIp must not be negative")
+		}
+	case ClientBulkMove:
+		if req.InboundId <= 0 {
+			return common.NewError("target inbound is required")
+		}
+	case ClientBulkEnable, ClientBulkDisable, ClientBulkResetTraffic, ClientBulkDelete:
+	default:
+		return common.NewError("unknown bulk action:", req.Action)
+	}
+	return nil
+}
+
+// selectClients finds the clients matching the selector.
+func (s *InboundService) selectClients(tx *gorm.DB, sel *ClientSelector) ([]*model.InboundClient, error) {
+	now := time.Now().UnixMilli()
+	query := tx.Model(model.InboundClient{}).Select("inbound_clients.*").
+		Joins("LEFT JOIN client_traffics ON client_traffics.email = inbound_clients.email")
+	if len(sel.InboundIds) > 0 {
+		query = query.Where("inbound_clients.inbound_id IN ?", sel.InboundIds)
+	}
+	if sel.UserId > 0 {
+		query = query.Where("inbound_clients.inbound_id IN (?)",
+			tx.Model(model.Inbound{}).Select("id").Where("user_id = ?", sel.UserId))
+	}
+	if sel.Email != "" {
+		pattern := strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(sel.Email))
+		query = query.Where(`inbound_clients.email LIKE ? ESCAPE '\'`, pattern)
+	}
+	if sel.Comment != "" {
+		query = query.Where(`inbound_clients.comment LIKE ? ESCAPE '\'`, "%"+escapeLike(sel.Comment)+"%")
+	}
+	switch sel.State {
+	case ClientStateExpired:
+		query = query.Where("client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?", now)
+	case ClientStateDepleted:
+		query = query.Where("client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total")
+	case ClientStateOffline:
+		query = query.Where("COALESCE(client_traffics.last_online, 0) <= ?", now-int64(sel.OfflineDays)*24*3600*1000)
+	}
+	var clients []*model.InboundClient
+	err := query.Order("inbound_clients.inbound_id, inbound_clients.position, inbound_clients.id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// bulkActiveClients returns the clients with the ids that Xray serves, by id.
+func (s *InboundService) bulkActiveClients(tx *gorm.DB, ids []int) (map[int]bulkActiveClient, error) {
+	var clients []*model.InboundClient
+	err := tx.Model(model.InboundClient{}).
+		Where("id IN ? AND enable = ?", ids, true).
+		Where("NOT EXISTS (SELECT 1 FROM client_traffics WHERE client_traffics.email = inbound_clients.email AND client_traffics.enable = ?)", false).
+		Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	inbounds := make(map[int]*model.Inbound)
+	active := make(map[int]bulkActiveClient, len(clients))
+	for _, client := range clients {
+		inbound, ok := inbounds[client.InboundId]
+		if !ok {
+			inbound = &model.Inbound{}
+			if err := tx.First(inbound, client.InboundId).Error; err != nil {
+				return nil, err
+			}
+			inbounds[client.InboundId] = inbound
+		}
+		if inbound.Enable {
+			active[client.Id] = bulkActiveClient{inbound: inbound, client: client}
+		}
+	}
+	return active, nil
+}
+
+// BulkClients applies an action to the clients matching a selector in one transaction, and
+// then updates Xray in a single API pass. With DryRun only the selected clients are returned.
+// It reports whether Xray needs a restart.
+func (s *InboundService) BulkClients(req *ClientBulkRequest) (*ClientBulkResult, bool, error) {
+	if err := s.checkClientBulkRequest(req); err != nil {
+		return nil, false, err
+	}
+	result := &ClientBulkResult{DryRun: req.DryRun}
+	var before, after map[int]bulkActiveClient
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		clients, err := s.selectClients(tx, &req.Selector)
+		if err != nil {
+			return err
+		}
+		result.Count, result.Clients = len(clients), clients
+		if req.DryRun || len(clients) == 0 {
+			return nil
+		}
+		ids := make([]int, len(clients))
+		for i, client := range clients {
+			ids[i] = client.Id
+		}
+		if before, err = s.bulkActiveClients(tx, ids); err != nil {
+			return err
+		}
+		if err = s.applyBulkAction(tx, req, clients); err != nil {
+			return err
+		}
+		after, err = s.bulkActiveClients(tx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if req.DryRun {
+		return result, false, nil
+	}
+
+	if req.Action == ClientBulkDisable {
+		events := &webhookBatch{}
+		for _, client := range result.Clients {
+			// The selected clients hold their state from before the update
+			if client.Enable {
+				s.emitClientDisabled(events, client.InboundId, client.Email, "manual")
+			}
+		}
+		events.emit()
+	}
+	return result, s.applyBulkToXray(before, after), nil
+}
+
+// applyBulkAction applies the action of a bulk operation to the selected clients.
+func (s *InboundService) applyBulkAction(tx *gorm.DB, req *ClientBulkRequest, clients []*model.InboundClient) error {
+	now := time.Now().UnixMilli()
+	ids := make([]int, 0, len(clients))
+	emails := make([]string, 0, len(clients))
+	inboundIds := make(map[int]bool)
+	for _, client := range clients {
+		ids = append(ids, client.Id)
+		if client.Email != "" {
+			emails = append(emails, client.Email)
+		}
+		inboundIds[client.InboundId] = true
+	}
+	rows := func() *gorm.DB { return tx.Model(model.InboundClient{}).Where("id IN ?", ids) }
+	traffics := func() *gorm.DB { return tx.Model(xray.ClientTraffic{}).Where("email IN ?", emails) }
+
+	var err error
+	switch req.Action {
+	case ClientBulkExtendExpiry:
+		// A negative expiry time is the duration of a client that has not connected yet
+		days := int64(req.Days) * 24 * 3600 * 1000
+		expr := gorm.Expr("CASE WHEN expiry_time > 0 THEN expiry_time + ? WHEN expiry_time < 0 THEN expiry_time - ? ELSE 0 END", days, days)
+		if err = rows().Updates(map[string]any{"expiry_time": expr, "updated_at": now}).Error; err == nil {
+			err = traffics().Update("expiry_time", expr).Error
+		}
+	case ClientBulkAddTraffic:
+		bytes := req.GB * 1024 * 1024 * 1024
+		err = rows().Where("total_gb > 0").Updates(map[string]any{"total_gb": gorm.Expr("total_gb + ?", bytes), "updated_at": now}).Error
+		if err == nil {
+			err = traffics().Where("total > 0").Update("total", gorm.Expr("total + ?", bytes)).Error
+		}
+	case ClientBulkSetLimitIp:
+		err = rows().Updates(map[string]any{"limit_ip": req.LimitIP, "updated_at": now}).Error
+	case ClientBulkEnable, ClientBulkDisable:
+		enable := req.Action == ClientBulkEnable
+		if err = rows().Updates(map[string]any{"enable": enable, "updated_at": now}).Error; err == nil {
+			err = traffics().Update("enable", enable).Error
+		}
+	case ClientBulkResetTraffic:
+		err = traffics().Updates(map[string]any{"up": 0, "down": 0}).Error
+	case ClientBulkDelete:
+		if err = s.checkBulkRemains(tx, clients); err == nil {
+			err = s.bulkDeleteClients(tx, ids, emails)
+		}
+	case ClientBulkMove:
+		err = s.bulkMoveClients(tx, clients, req.InboundId, req.Selector.UserId)
+		inboundIds[req.InboundId] = true
+	}
+	if err != nil {
+		return err
+	}
+
+	switch req.Action {
+	case ClientBulkExtendExpiry, ClientBulkAddTraffic, ClientBulkResetTraffic:
+		// Clients that were disabled for running out of time or traffic may connect again
+		if _, err := s.enableValidClients(tx, 0, emails...); err != nil {
+			return err
+		}
+	}
+	return s.saveInboundsClientSettings(tx, inboundIds)
+}
+
+// saveInboundsClientSettings regenerates the clients of the settings of the inbounds, see saveClientSettings.
+func (s *InboundService) saveInboundsClientSettings(tx *gorm.DB, inboundIds map[int]bool) error {
+	ids := make([]int, 0, len(inboundIds))
+	for id := range inboundIds {
+		ids = append(ids, id)
+	}
+	var inbounds []*model.Inbound
+	if err := tx.Where("id IN ?", ids).Find(&inbounds).Error; err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		if err := s.saveClientSettings(tx, inbound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBulkRemains makes sure every inbound the clients are taken from keeps at least one client.
+func (s *InboundService) checkBulkRemains(tx *gorm.DB, clients []*model.InboundClient) error {
+	taken := make(map[int]int64)
+	for _, client := range clients {
+		taken[client.InboundId]++
+	}
+	for inboundId, count := range taken {
+		var total int64
+		if err := tx.Model(model.InboundClient{}).Where("inbound_id = ?", inboundId).Count(&total).Error; err != nil {
+			return err
+		}
+		if total <= count {
+			return common.NewError("no client remained in Inbound", inboundId)
+		}
+	}
+	return nil
+}
+
+func (s *InboundService) bulkDeleteClients(tx *gorm.DB, ids []int, emails []string) error {
+	if err := tx.Where("id IN ?", ids).Delete(model.InboundClient{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("email IN ?", emails).Delete(xray.ClientTraffic{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("client_email IN ?", emails).Delete(model.InboundClientIps{}).Error
+}
+
+// bulkMoveClients moves clients to another inbound of the same protocol, keeping their traffic.
+func (s *InboundService) bulkMoveClients(tx *gorm.DB, clients []*model.InboundClient, targetId int, userId int) error {
+	target := &model.Inbound{}
+	query := tx.Where("id = ?", targetId)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err := query.First(target).Error; err != nil {
+		if database.IsNotFound(err) {
+			return common.NewError("inbound not found:", targetId)
+		}
+		return err
+	}
+	moving := make([]*model.InboundClient, 0, len(clients))
+	for _, client := range clients {
+		if client.InboundId != targetId {
+			moving = append(moving, client)
+		}
+	}
+	if err := s.checkBulkRemains(tx, moving); err != nil {
+		return err
+	}
+	position, err := s.nextClientPosition(tx, targetId)
+	if err != nil {
+		return err
+	}
+	inbounds := make(map[int]*model.Inbound)
+	for _, client := range moving {
+		source, ok := inbounds[client.InboundId]
+		if !ok {
+			source = &model.Inbound{}
+			if err := tx.First(source, client.InboundId).Error; err != nil {
+				return err
+			}
+			inbounds[client.InboundId] = source
+		}
+		if source.Protocol != target.Protocol {
+			return common.NewErrorf("cannot move %s from a %s to a %s inbound", client.Email, source.Protocol, target.Protocol)
+		}
+		err := tx.Model(client).Updates(map[string]any{
+			"inbound_id": targetId,
+			"position":   position,
+			"updated_at": time.Now().UnixMilli(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Update("inbound_id", targetId).Error
+		if err != nil {
+			return err
+		}
+		position++
+	}
+	return nil
+}
+
+// applyBulkToXray removes the clients Xray no longer serves and adds those it newly serves, or
+// that moved to another inbound, through the Xray API. It reports whether Xray needs a restart instead.
+func (s *InboundService) applyBulkToXray(before, after map[int]bulkActiveClient) bool {
+	var removed, added []bulkActiveClient
+	for id, old := range before {
+		if cur, ok := after[id]; !ok || cur.inbound.Id != old.inbound.Id {
+			removed = append(removed, old)
+		}
+	}
+	for id, cur := range after {
+		if old, ok := before[id]; !ok || cur.inbound.Id != old.inbound.Id {
+			added = append(added, cur)
+		}
+	}
+	if len(removed) == 0 && len(added) == 0 {
+		return false
+	}
+
+	canUseAPI, errInit := s.initXrayAPI()
+	if !canUseAPI {
+		if errInit != nil {
+			logger.Debug("Unable to initialize Xray API for bulk client operation:", errInit)
+		}
+		return true
+	}
+	defer s.xrayApi.Close()
+	needRestart := false
+	for _, c := range removed {
+		err := s.xrayApi.RemoveUser(c.inbound.Tag, c.client.Email)
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", c.client.Email)) {
+			logger.Debug("Error in removing client by api:", err)
+			needRestart = true
+		}
+	}
+	for _, c := range added {
+		cipher := ""
+		if c.inbound.Protocol == model.Shadowsocks {
+			var settings map[string]any
+			json.Unmarshal([]byte(c.inbound.Settings), &settings)
+			cipher, _ = settings["method"].(string)
+		}
+		err := s.xrayApi.AddUser(string(c.inbound.Protocol), c.inbound.Tag, map[string]any{
+			"email":    c.client.Email,
+			"id":       c.client.Uuid,
+			"security": c.client.Security,
+			"flow":     c.client.Flow,
+			"password": c.client.Password,
+			"cipher":   cipher,
+		})
+		if err != nil {
+			logger.Debug("Error in adding client by api:", err)
+			needRestart = true
+		}
+	}
+	return needRestart
+}
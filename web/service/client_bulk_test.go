@@ -0,0 +1,119 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestBulkClients(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	s := &InboundService{}
+	source := &model.Inbound{Enable: true, Port: 20001, Protocol: model.Trojan, Tag: "inbound-20001",
+		Settings: `{"clients":[
+			{"password":"a","email":"team-a","enable":true,"totalGB":1073741824,"comment":"vip"},
+			{"password":"b","email":"team-b","enable":true},
+			{"password":"c","email":"other","enable":true}
+		]}`}
+	target := &model.Inbound{Enable: true, Port: 20002, Protocol: model.Trojan, Tag: "inbound-20002",
+		Settings: `{"clients":[{"password":"d","email":"solo","enable":true}]}`}
+	for _, inbound := range []*model.Inbound{source, target} {
+		if _, _, err := s.AddInbound(inbound); err != nil {
+			t.Fatalf("add inbound: %v", err)
+		}
+	}
+	past := time.Now().Add(-time.Hour).UnixMilli()
+	db.Model(model.InboundClient{}).Where("email = ?", "team-b").Update("expiry_time", past)
+	db.Model(xray.ClientTraffic{}).Where("email = ?", "team-b").Update("expiry_time", past)
+	s.disableInvalidClients(db, &webhookBatch{})
+
+	if _, _, err := s.BulkClients(&ClientBulkRequest{Action: ClientBulkDelete}); err == nil {
+		t.Fatal("expected an empty selector to be rejected")
+	}
+
+	team := ClientSelector{Email: "team-*"}
+	result, _, err := s.BulkClients(&ClientBulkRequest{Selector: team, Action: ClientBulkDelete, DryRun: true})
+	if err != nil || result.Count != 2 {
+		t.Fatalf("dry run: %+v (%v)", result, err)
+	}
+	if clients, _ := s.GetInboundClients(source.Id); len(clients) != 3 {
+		t.Fatal("dry run must not change the clients")
+	}
+
+	result, _, err = s.BulkClients(&ClientBulkRequest{
+		Selector: ClientSelector{State: ClientStateExpired}, Action: ClientBulkExtendExpiry, Days: 30,
+	})
+	if err != nil || result.Count != 1 || result.Clients[0].Email != "team-b" {
+		t.Fatalf("extend expiry: %+v (%v)", result, err)
+	}
+	var traffic xray.ClientTraffic
+	db.Where("email = ?", "team-b").First(&traffic)
+	if !traffic.Enable || traffic.ExpiryTime != past+30*24*3600*1000 {
+		t.Fatalf("expected team-b to be enabled with a later expiry, got %+v", traffic)
+	}
+
+	result, _, err = s.BulkClients(&ClientBulkRequest{
+		Selector: ClientSelector{Comment: "vip"}, Action: ClientBulkAddTraffic, GB: 2,
+	})
+	if err != nil || result.Count != 1 {
+		t.Fatalf("add traffic: %+v (%v)", result, err)
+	}
+	client, _ := s.GetInboundClientByEmail("team-a")
+	if client.TotalGB != 3*1024*1024*1024 {
+		t.Fatalf("expected 3 GB for team-a, got %d", client.TotalGB)
+	}
+
+	if _, _, err := s.BulkClients(&ClientBulkRequest{
+		Selector: ClientSelector{InboundIds: []int{source.Id}}, Action: ClientBulkMove, InboundId: target.Id,
+	}); err == nil {
+		t.Fatal("expected moving every client of an inbound to be rejected")
+	}
+	if _, _, err := s.BulkClients(&ClientBulkRequest{Selector: team, Action: ClientBulkMove, InboundId: target.Id}); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	moved := settingsClients(t, s, target.Id)
+	if len(moved) != 3 || moved[1]["email"] != "team-a" || moved[2]["email"] != "team-b" {
+		t.Fatalf("expected the team to follow solo in the target inbound, got %v", moved)
+	}
+	if left := settingsClients(t, s, source.Id); len(left) != 1 || left[0]["email"] != "other" {
+		t.Fatalf("expected only other to remain, got %v", left)
+	}
+	var movedTraffic xray.ClientTraffic
+	db.Where("email = ?", "team-a").First(&movedTraffic)
+	if movedTraffic.InboundId != target.Id {
+		t.Fatalf("expected the traffic to move along, got inbound %d", movedTraffic.InboundId)
+	}
+
+	result, _, err = s.BulkClients(&ClientBulkRequest{Selector: team, Action: ClientBulkDisable})
+	if err != nil || result.Count != 2 {
+		t.Fatalf("disable: %+v (%v)", result, err)
+	}
+	active, _ := s.getActiveClients()
+	if len(active[target.Id]) != 1 {
+		t.Fatalf("expected only solo to stay active, got %v", active[target.Id])
+	}
+
+	offline := ClientSelector{State: ClientStateOffline, OfflineDays: 1}
+	db.Model(xray.ClientTraffic{}).Where("email = ?", "solo").Update("last_online", time.Now().UnixMilli())
+	if _, _, err := s.BulkClients(&ClientBulkRequest{Selector: offline, Action: ClientBulkDelete}); err == nil {
+		t.Fatal("expected deleting the last client of an inbound to be rejected")
+	}
+	offline.InboundIds = []int{target.Id}
+	result, _, err = s.BulkClients(&ClientBulkRequest{Selector: offline, Action: ClientBulkDelete})
+	if err != nil || result.Count != 2 {
+		t.Fatalf("delete: %+v (%v)", result, err)
+	}
+	if clients, _ := s.GetInboundClients(target.Id); len(clients) != 1 || clients[0].Email != "solo" {
+		t.Fatalf("expected only solo to remain, got %+v", clients)
+	}
+	var count int64
+	db.Model(xray.ClientTraffic{}).Where("email LIKE ?", "team-%").Count(&count)
+	if count != 0 {
+		t.Fatalf("expected the traffic of deleted clients to be removed, got %d", count)
+	}
+}
@@ -0,0 +1,634 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Formats of client export files.
+const (
+	ClientFormatCSV  = "csv"
+	ClientFormatJSON = "json"
+)
+
+// Policies for imported clients whose email or id is already taken.
+const (
+	ClientConflictSkip      = "skip"      // Leave the existing client, do not import the record
+	ClientConflictOverwrite = "overwrite" // Replace the existing client of the target inbound with the record
+	ClientConflictRename    = "rename"    // Import the record with a new email and id
+)
+
+// Actions of the records in a client import report.
+const (
+	ClientImportAdd       = "add"
+	ClientImportOverwrite = "overwrite"
+	ClientImportRename    = "rename"
+	ClientImportSkip      = "skip"
+	ClientImportError     = "error"
+)
+
+// clientRecordColumns are the columns of a client CSV file, in the order of export.
+var clientRecordColumns = []string{
+	"email", "id", "password", "flow", "totalGB", "expiryTime", "up", "down",
+	"limitIp", "enable", "subId", "tgId", "comment",
+}
+
+// ClientRecord is a client in an export file, independent of the inbound and the panel.
+type ClientRecord struct {
+	Email      string `json:"email"`
+	ID         string `json:"id"`
+	Password   string `json:"password"`
+	Flow       string `json:"flow"`
+	TotalGB    int64  `json:"totalGB"` // Traffic limit in bytes
+	ExpiryTime int64  `json:"expiryTime"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	LimitIP    int    `json:"limitIp"`
+	Enable     bool   `json:"enable"`
+	SubID      string `json:"subId"`
+	TgID       int64  `json:"tgId"`
+	Comment    string `json:"comment"`
+}
+
+// csvRow returns the record as the values of clientRecordColumns.
+func (r *ClientRecord) csvRow() []string {
+	return []string{
+		r.Email, r.ID, r.Password, r.Flow,
+		strconv.FormatInt(r.TotalGB, 10), strconv.FormatInt(r.ExpiryTime, 10),
+		strconv.FormatInt(r.Up, 10), strconv.FormatInt(r.Down, 10),
+		strconv.Itoa(r.LimitIP), strconv.FormatBool(r.Enable),
+		r.SubID, strconv.FormatInt(r.TgID, 10), r.Comment,
+	}
+}
+
+// setCSVValue sets the field of the record for a CSV column. Unknown columns are ignored.
+func (r *ClientRecord) setCSVValue(column string, value string) error {
+	value = strings.TrimSpace(value)
+	var err error
+	parseInt := func(v *int64) {
+		if value != "" {
+			*v, err = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	switch strings.ToLower(column) {
+	case "email":
+		r.Email = value
+	case "id":
+		r.ID = value
+	case "password":
+		r.Password = value
+	case "flow":
+		r.Flow = value
+	case "totalgb":
+		parseInt(&r.TotalGB)
+	case "expirytime":
+		parseInt(&r.ExpiryTime)
+	case "up":
+		parseInt(&r.Up)
+	case "down":
+		parseInt(&r.Down)
+	case "limitip":
+		if value != "" {
+			r.LimitIP, err = strconv.Atoi(value)
+		}
+	case "enable":
+		if value != "" {
+			r.Enable, err = strconv.ParseBool(value)
+		}
+	case "subid":
+		r.SubID = value
+	case "tgid":
+		parseInt(&r.TgID)
+	case "comment":
+		r.Comment = value
+	}
+	if err != nil {
+		return common.NewErrorf("invalid %s: %q", column, value)
+	}
+	return nil
+}
+
+// ExportClients exports the clients of the inbounds with their usage as CSV or JSON. All
+// inbounds are exported if inboundIds is empty. userId restricts the export to the inbounds
+// of a panel user, 0 for all inbounds.
+func (s *InboundService) ExportClients(inboundIds []int, format string, userId int) ([]byte, error) {
+	db := database.GetDB()
+	query := db.Model(model.InboundClient{}).Order("inbound_id, position, id")
+	if len(inboundIds) > 0 {
+		query = query.Where("inbound_id IN ?", inboundIds)
+	}
+	if userId > 0 {
+		query = query.Where("inbound_id IN (?)", db.Model(model.Inbound{}).Select("id").Where("user_id = ?", userId))
+	}
+	var clients []*model.InboundClient
+	if err := query.Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	var traffics []*xray.ClientTraffic
+	if err := db.Model(xray.ClientTraffic{}).Find(&traffics).Error; err != nil {
+		return nil, err
+	}
+	usage := make(map[string]*xray.ClientTraffic, len(traffics))
+	for _, traffic := range traffics {
+		usage[traffic.Email] = traffic
+	}
+
+	records := make([]*ClientRecord, 0, len(clients))
+	for _, client := range clients {
+		record := &ClientRecord{
+			Email:      client.Email,
+			ID:         client.Uuid,
+			Password:   client.Password,
+			Flow:       client.Flow,
+			TotalGB:    client.TotalGB,
+			ExpiryTime: client.ExpiryTime,
+			LimitIP:    client.LimitIP,
+			Enable:     client.Enable,
+			SubID:      client.SubID,
+			TgID:       client.TgID,
+			Comment:    client.Comment,
+		}
+		if traffic, ok := usage[client.Email]; ok {
+			record.Up, record.Down = traffic.Up, traffic.Down
+		}
+		records = append(records, record)
+	}
+
+	switch format {
+	case ClientFormatJSON:
+		return json.MarshalIndent(records, "", "  ")
+	case ClientFormatCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(clientRecordColumns)
+		for _, record := range records {
+			w.Write(record.csvRow())
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	}
+	return nil, common.NewError("unknown export format:", format)
+}
+
+// ClientImportRequest imports a client export file into an inbound.
+type ClientImportRequest struct {
+	InboundId int    `json:"inboundId" form:"inboundId"`
+	Format    string `json:"format" form:"format"` // csv or json, detected from the data if empty
+	Data      string `json:"data" form:"data"`
+	Policy    string `json:"policy" form:"policy"` // One of the ClientConflict constants, skip by default
+	DryRun    bool   `json:"dryRun" form:"dryRun"` // Only validate the records and report what would happen
+}
+
+// ClientImportRecord is the outcome of a record of an imported file.
+type ClientImportRecord struct {
+	Line     int    `json:"line"` // Line in a CSV file, or position in a JSON array starting at 1
+	Email    string `json:"email"`
+	Action   string `json:"action"` // One of the ClientImport constants
+	NewEmail string `json:"newEmail,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// ClientImportReport is the validation report of a client import. Nothing is imported if a
+// record is invalid.
+type ClientImportReport struct {
+	DryRun      bool                  `json:"dryRun"`
+	Committed   bool                  `json:"committed"`
+	Added       int                   `json:"added"`
+	Overwritten int                   `json:"overwritten"`
+	Renamed     int                   `json:"renamed"`
+	Skipped     int                   `json:"skipped"`
+	Invalid     int                   `json:"invalid"`
+	Records     []*ClientImportRecord `json:"records"`
+}
+
+// parsedClientRecord is a record of an imported file with the line it was read from.
+type parsedClientRecord struct {
+	line   int
+	record *ClientRecord
+	err    error
+}
+
+// parseClientRecords reads the records of a client export file.
+func parseClientRecords(data string, format string) ([]*parsedClientRecord, error) {
+	if format == "" {
+		format = ClientFormatCSV
+		if strings.HasPrefix(strings.TrimSpace(data), "[") {
+			format = ClientFormatJSON
+		}
+	}
+	var records []*parsedClientRecord
+	switch format {
+	case ClientFormatJSON:
+		var raws []json.RawMessage
+		if err := json.Unmarshal([]byte(data), &raws); err != nil {
+			return nil, err
+		}
+		for i, raw := range raws {
+			record := &ClientRecord{Enable: true}
+			err := json.Unmarshal(raw, record)
+			records = append(records, &parsedClientRecord{line: i + 1, record: record, err: err})
+		}
+	case ClientFormatCSV:
+		r := csv.NewReader(strings.NewReader(data))
+		r.FieldsPerRecord = -1
+		header, err := r.Read()
+		if err != nil {
+			return nil, common.NewError("missing CSV header:", err)
+		}
+		hasEmail := false
+		for i := range header {
+			header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+			hasEmail = hasEmail || strings.EqualFold(header[i], "email")
+		}
+		if !hasEmail {
+			return nil, common.NewError("missing email column in CSV header")
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			line, _ := r.FieldPos(0)
+			parsed := &parsedClientRecord{line: line, record: &ClientRecord{Enable: true}}
+			for i, value := range row {
+				if i < len(header) && parsed.err == nil {
+					parsed.err = parsed.record.setCSVValue(header[i], value)
+				}
+			}
+			records = append(records, parsed)
+		}
+	default:
+		return nil, common.NewError("unknown import format:", format)
+	}
+	return records, nil
+}
+
+// clientImportState tracks the emails and keys taken while the records of an import are checked.
+type clientImportState struct {
+	emails   map[string]*model.InboundClient // Lower case email of every client of the panel
+	keys     map[string]*model.InboundClient // Key of every client of the target inbound
+	imported map[string]bool                 // Lower case emails and keys of the records taken so far
+	keyLen   int                             // Key length of a Shadowsocks 2022 inbound, 0 for other inbounds
+	userSubs map[string]bool                 // Subscription ids of the client users
+}
+
+func (st *clientImportState) emailTaken(email string) bool {
+	lower := strings.ToLower(email)
+	return st.emails[lower] != nil || st.imported["email:"+lower]
+}
+
+func (st *clientImportState) keyTaken(key string) bool {
+	return st.keys[key] != nil || st.imported["key:"+key]
+}
+
+// shadowsocks2022KeyLen returns the key length of a Shadowsocks 2022 method, 0 for other methods.
+func shadowsocks2022KeyLen(method string) int {
+	switch method {
+	case "2022-blake3-aes-128-gcm":
+		return 16
+	case "2022-blake3-aes-256-gcm", "2022-blake3-chacha20-poly1305":
+		return 32
+	}
+	return 0
+}
+
+// ImportClients imports a client export file into an inbound. The records are validated
+// first and checked for emails and ids that are already taken, which are handled according
+// to the conflict policy. Overwritten clients of a client user keep the subscription id of the
+// user and no limits of their own, and no record joins the subscription of a user. The report
+// tells the outcome of every record. Nothing is imported with DryRun or if a record is invalid.
+// It reports whether Xray needs a restart.
+func (s *InboundService) ImportClients(req *ClientImportRequest) (*ClientImportReport, bool, error) {
+	if req.Policy == "" {
+		req.Policy = ClientConflictSkip
+	}
+	switch req.Policy {
+	case ClientConflictSkip, ClientConflictOverwrite, ClientConflictRename:
+	default:
+		return nil, false, common.NewError("unknown conflict policy:", req.Policy)
+	}
+	records, err := parseClientRecords(req.Data, req.Format)
+	if err != nil {
+		return nil, false, err
+	}
+	inbound, err := s.GetInbound(req.InboundId)
+	if err != nil {
+		return nil, false, err
+	}
+	switch inbound.Protocol {
+	case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+	default:
+		return nil, false, common.NewError("inbound has no clients:", inbound.Protocol)
+	}
+
+	report := &ClientImportReport{DryRun: req.DryRun, Records: make([]*ClientImportRecord, 0, len(records))}
+	needRestart := false
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var clients []*model.InboundClient
+		if err := tx.Find(&clients).Error; err != nil {
+			return err
+		}
+		st := &clientImportState{
+			emails:   make(map[string]*model.InboundClient, len(clients)),
+			keys:     make(map[string]*model.InboundClient),
+			imported: make(map[string]bool),
+		}
+		if inbound.Protocol == model.Shadowsocks {
+			var settings struct {
+				Method string `json:"method"`
+			}
+			json.Unmarshal([]byte(inbound.Settings), &settings)
+			st.keyLen = shadowsocks2022KeyLen(settings.Method)
+		}
+		var userSubIds []string
+		if err := tx.Model(model.ClientUser{}).Pluck("sub_id", &userSubIds).Error; err != nil {
+			return err
+		}
+		st.userSubs = make(map[string]bool, len(userSubIds))
+		for _, subId := range userSubIds {
+			st.userSubs[subId] = true
+		}
+		for _, client := range clients {
+			st.emails[strings.ToLower(client.Email)] = client
+			if client.InboundId == inbound.Id {
+				st.keys[client.Key(inbound.Protocol)] = client
+			}
+		}
+
+		var adds, overwrites []*ClientRecord
+		var replaced []*model.InboundClient
+		for _, parsed := range records {
+			result, existing := s.checkImportRecord(inbound, parsed, req.Policy, st)
+			report.Records = append(report.Records, result)
+			switch result.Action {
+			case ClientImportAdd, ClientImportRename:
+				adds = append(adds, parsed.record)
+			case ClientImportOverwrite:
+				overwrites = append(overwrites, parsed.record)
+				replaced = append(replaced, existing)
+			}
+			switch result.Action {
+			case ClientImportAdd:
+				report.Added++
+			case ClientImportRename:
+				report.Renamed++
+			case ClientImportOverwrite:
+				report.Overwritten++
+			case ClientImportSkip:
+				report.Skipped++
+			case ClientImportError:
+				report.Invalid++
+			}
+		}
+		if req.DryRun || report.Invalid > 0 || len(adds)+len(overwrites) == 0 {
+			return nil
+		}
+
+		if err := s.importClientRecords(tx, inbound, adds, overwrites, replaced); err != nil {
+			return err
+		}
+		report.Committed = true
+		// Xray only has to change for enabled clients of an enabled inbound
+		if inbound.Enable {
+			for _, record := range append(adds, overwrites...) {
+				needRestart = needRestart || record.Enable
+			}
+			for _, client := range replaced {
+				needRestart = needRestart || client.Enable
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return report, needRestart, nil
+}
+
+// checkImportRecord validates a record for the target inbound and applies the conflict policy.
+// For an overwrite it returns the client the record replaces.
+func (s *InboundService) checkImportRecord(inbound *model.Inbound, parsed *parsedClientRecord, policy string, st *clientImportState) (*ClientImportRecord, *model.InboundClient) {
+	record := parsed.record
+	result := &ClientImportRecord{Line: parsed.line, Email: record.Email, Action: ClientImportError}
+	if parsed.err != nil {
+		result.Message = parsed.err.Error()
+		return result, nil
+	}
+	if record.Email == "" {
+		result.Message = "email is required"
+		return result, nil
+	}
+	if record.TotalGB < 0 || record.Up < 0 || record.Down < 0 || record.LimitIP < 0 {
+		result.Message = "negative limit or usage"
+		return result, nil
+	}
+	if record.Flow != "" && (inbound.Protocol != model.VLESS || !strings.HasPrefix(record.Flow, "xtls-rprx-vision")) {
+		result.Message = "unsupported flow: " + record.Flow
+		return result, nil
+	}
+	key := func() *string {
+		switch inbound.Protocol {
+		case model.Trojan:
+			return &record.Password
+		case model.Shadowsocks:
+			return &record.Email
+		default:
+			return &record.ID
+		}
+	}
+	newKey := func() string {
+		if inbound.Protocol == model.Trojan {
+			return random.Seq(10)
+		}
+		return uuid.NewString()
+	}
+	switch inbound.Protocol {
+	case model.VMESS, model.VLESS:
+		if record.ID == "" {
+			record.ID = newKey()
+			result.Message = "generated id"
+		}
+	case model.Trojan:
+		if record.Password == "" {
+			record.Password = newKey()
+			result.Message = "generated password"
+		}
+	case model.Shadowsocks:
+		if record.Password == "" {
+			result.Message = "password is required"
+			return result, nil
+		}
+		if st.keyLen > 0 {
+			if key, err := base64.StdEncoding.DecodeString(record.Password); err != nil || len(key) != st.keyLen {
+				result.Message = fmt.Sprintf("password must be a base64 key of %d bytes", st.keyLen)
+				return result, nil
+			}
+		}
+	}
+
+	emailConflict := st.emailTaken(record.Email)
+	keyConflict := inbound.Protocol != model.Shadowsocks && st.keyTaken(*key())
+	result.Action = ClientImportAdd
+	var existing *model.InboundClient
+	if emailConflict || keyConflict {
+		switch policy {
+		case ClientConflictSkip:
+			result.Action = ClientImportSkip
+			result.Message = "already exists"
+			return result, nil
+		case ClientConflictOverwrite:
+			existing = st.emails[strings.ToLower(record.Email)]
+			if existing == nil {
+				existing = st.keys[*key()]
+			}
+			switch {
+			case existing == nil || st.imported["id:"+strconv.Itoa(existing.Id)]:
+				result.Action = ClientImportError
+				result.Message = "duplicate record in the file"
+				return result, nil
+			case existing.InboundId != inbound.Id:
+				result.Action = ClientImportError
+				result.Message = "email is used in another inbound"
+				return result, nil
+			case keyConflict && st.keys[*key()] != nil && st.keys[*key()] != existing:
+				result.Action = ClientImportError
+				result.Message = "id is used by another client"
+				return result, nil
+			}
+			result.Action = ClientImportOverwrite
+			st.imported["id:"+strconv.Itoa(existing.Id)] = true
+		case ClientConflictRename:
+			result.Action = ClientImportRename
+			if emailConflict {
+				base := record.Email
+				for i := 1; st.emailTaken(record.Email); i++ {
+					record.Email = fmt.Sprintf("%s-%d", base, i)
+				}
+				result.NewEmail = record.Email
+			}
+			if inbound.Protocol != model.Shadowsocks && st.keyTaken(*key()) {
+				*key() = newKey()
+				result.Message = "generated a new id"
+			}
+		}
+	}
+	note := func(message string) {
+		if result.Message != "" {
+			message = result.Message + ", " + message
+		}
+		result.Message = message
+	}
+	switch {
+	case existing != nil && existing.UserId != 0:
+		// Like AttachClient, a client of a user keeps the subscription of the user and its shared limits
+		if record.SubID != existing.SubID || record.TotalGB != 0 || record.ExpiryTime != 0 || record.LimitIP != 0 {
+			note("kept the subscription and limits of the client user")
+		}
+		record.SubID, record.TotalGB, record.ExpiryTime, record.LimitIP = existing.SubID, 0, 0, 0
+	case st.userSubs[record.SubID]:
+		// Clients that are not attached to a user must not show up in its subscription
+		record.SubID = ""
+		if existing != nil {
+			record.SubID = existing.SubID
+		}
+		note("subscription id belongs to a client user, not imported")
+	}
+	st.imported["email:"+strings.ToLower(record.Email)] = true
+	st.imported["key:"+*key()] = true
+	return result, existing
+}
+
+// importClientRecords adds and overwrites clients of an inbound with validated records,
+// including their usage, and regenerates the clients of the inbound settings.
+func (s *InboundService) importClientRecords(tx *gorm.DB, inbound *model.Inbound, adds, overwrites []*ClientRecord, replaced []*model.InboundClient) error {
+	now := time.Now().UnixMilli()
+	toClient := func(record *ClientRecord) *model.Client {
+		return &model.Client{
+			ID:         record.ID,
+			Password:   record.Password,
+			Flow:       record.Flow,
+			Email:      record.Email,
+			LimitIP:    record.LimitIP,
+			TotalGB:    record.TotalGB,
+			ExpiryTime: record.ExpiryTime,
+			Enable:     record.Enable,
+			TgID:       record.TgID,
+			SubID:      record.SubID,
+			Comment:    record.Comment,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+	}
+	setUsage := func(record *ClientRecord) error {
+		return tx.Model(xray.ClientTraffic{}).Where("email = ?", record.Email).
+			Updates(map[string]any{"up": record.Up, "down": record.Down, "all_time": record.Up + record.Down}).Error
+	}
+
+	for i, record := range overwrites {
+		old := replaced[i]
+		client := toClient(record)
+		client.Security = old.Security
+		client.Reset = old.Reset
+		client.CreatedAt = old.CreatedAt
+		row := &model.InboundClient{Id: old.Id, InboundId: inbound.Id, Position: old.Position}
+		row.SetSettings(old.ClientMap())
+		row.SetClient(client)
+		row.UserId = old.UserId
+		if err := tx.Save(row).Error; err != nil {
+			return err
+		}
+		if err := s.UpdateClientStat(tx, old.Email, client); err != nil {
+			return err
+		}
+		if err := s.UpdateClientIPs(tx, old.Email, client.Email); err != nil {
+			return err
+		}
+		if err := setUsage(record); err != nil {
+			return err
+		}
+	}
+
+	position, err := s.nextClientPosition(tx, inbound.Id)
+	if err != nil {
+		return err
+	}
+	for _, record := range adds {
+		client := toClient(record)
+		if client.SubID == "" {
+			client.SubID = random.Seq(16)
+		}
+		// Without original settings the settings only get the fields the record sets
+		row := &model.InboundClient{InboundId: inbound.Id, Position: position}
+		row.SetClient(client)
+		if err := tx.Create(row).Error; err != nil {
+			return err
+		}
+		position++
+		if err := s.AddClientStat(tx, inbound.Id, client); err != nil {
+			return err
+		}
+		if err := setUsage(record); err != nil {
+			return err
+		}
+	}
+	return s.saveClientSettings(tx, inbound)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestExportImportClients(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	s := &InboundService{}
+	source := &model.Inbound{Enable: true, Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001",
+		Settings: `{"clients":[
+			{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01","email":"alice","enable":true,"totalGB":1000,"comment":"a, \"quoted\" comment"},
+			{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","email":"bob","enable":false,"flow":"xtls-rprx-vision"}
+		],"decryption":"none"}`}
+	target := &model.Inbound{Enable: true, Port: 20002, Protocol: model.VLESS, Tag: "inbound-20002",
+		Settings: `{"clients":[{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","email":"carol","enable":true}],"decryption":"none"}`}
+	for _, inbound := range []*model.Inbound{source, target} {
+		if _, _, err := s.AddInbound(inbound); err != nil {
+			t.Fatalf("add inbound: %v", err)
+		}
+	}
+	db.Model(xray.ClientTraffic{}).Where("email = ?", "alice").Updates(map[string]any{"up": 10, "down": 20})
+
+	csvData, err := s.ExportClients([]int{source.Id}, ClientFormatCSV, 0)
+	if err != nil {
+		t.Fatalf("export csv: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(csvData)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "email,id,password") {
+		t.Fatalf("unexpected csv:\n%s", csvData)
+	}
+	jsonData, err := s.ExportClients([]int{source.Id}, ClientFormatJSON, 0)
+	if err != nil {
+		t.Fatalf("export json: %v", err)
+	}
+	var records []ClientRecord
+	if err := json.Unmarshal(jsonData, &records); err != nil || len(records) != 2 || records[0].Up != 10 || records[0].Down != 20 {
+		t.Fatalf("unexpected json export %s (%v)", jsonData, err)
+	}
+
+	// alice and bob exist in the source inbound, and bob's id is taken by carol in the target inbound
+	report, _, err := s.ImportClients(&ClientImportRequest{InboundId: target.Id, Data: string(csvData), DryRun: true})
+	if err != nil || report.Skipped != 2 || report.Committed {
+		t.Fatalf("dry run: %+v (%v)", report, err)
+	}
+	report, _, err = s.ImportClients(&ClientImportRequest{InboundId: target.Id, Data: string(csvData), Policy: ClientConflictOverwrite})
+	if err != nil || report.Invalid != 2 || report.Committed {
+		t.Fatalf("expected clients of another inbound not to be overwritten: %+v (%v)", report, err)
+	}
+	report, needRestart, err := s.ImportClients(&ClientImportRequest{InboundId: target.Id, Data: string(csvData), Policy: ClientConflictRename})
+	if err != nil || report.Renamed != 2 || !report.Committed || !needRestart {
+		t.Fatalf("rename: %+v (%v)", report, err)
+	}
+	if report.Records[0].NewEmail != "alice-1" || report.Records[1].NewEmail != "bob-1" {
+		t.Fatalf("unexpected new emails: %+v %+v", report.Records[0], report.Records[1])
+	}
+	imported, _ := s.GetInboundClientByEmail("alice-1")
+	if imported == nil || imported.InboundId != target.Id || imported.TotalGB != 1000 || imported.Comment != `a, "quoted" comment` {
+		t.Fatalf("unexpected imported client %+v", imported)
+	}
+	renamed, _ := s.GetInboundClientByEmail("bob-1")
+	if renamed.Uuid == records[1].ID || renamed.Enable || renamed.Flow != "xtls-rprx-vision" {
+		t.Fatalf("expected bob-1 to be disabled with a new id, got %+v", renamed)
+	}
+	var traffic xray.ClientTraffic
+	db.Where("email = ?", "alice-1").First(&traffic)
+	if traffic.Up != 10 || traffic.Down != 20 || traffic.InboundId != target.Id {
+		t.Fatalf("expected the usage to be imported, got %+v", traffic)
+	}
+	if clients := settingsClients(t, s, target.Id); len(clients) != 3 {
+		t.Fatalf("expected 3 clients in the target settings, got %v", clients)
+	}
+
+	report, _, err = s.ImportClients(&ClientImportRequest{InboundId: target.Id, Format: ClientFormatJSON, Policy: ClientConflictOverwrite,
+		Data: `[{"email":"carol","id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","totalGB":5000,"up":7}]`})
+	if err != nil || report.Overwritten != 1 || !report.Committed {
+		t.Fatalf("overwrite: %+v (%v)", report, err)
+	}
+	carol, _ := s.GetInboundClientByEmail("carol")
+	if carol.TotalGB != 5000 || !carol.Enable {
+		t.Fatalf("expected carol to be overwritten, got %+v", carol)
+	}
+
+	report, _, err = s.ImportClients(&ClientImportRequest{InboundId: target.Id,
+		Data: "email,totalGB\ndave,100\nerin,lots\n"})
+	if err != nil || report.Invalid != 1 || report.Records[1].Line != 3 || report.Committed {
+		t.Fatalf("expected the invalid record to stop the import: %+v (%v)", report, err)
+	}
+	if client, _ := s.GetInboundClientByEmail("dave"); client != nil {
+		t.Fatal("expected nothing to be imported")
+	}
+
+	// Clients of a user keep its subscription and shared limits, others can not join its subscription
+	users := &ClientUserService{}
+	user := &model.ClientUser{Name: "team", Enable: true}
+	if err := users.AddClientUser(user); err != nil {
+		t.Fatalf("add user: %v", err)
+	}
+	if _, err := users.AttachClient(user.Id, "carol"); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	report, _, err = s.ImportClients(&ClientImportRequest{InboundId: target.Id, Format: ClientFormatJSON, Policy: ClientConflictOverwrite,
+		Data: `[{"email":"carol","id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","totalGB":5000,"subId":"own","enable":true},
+			{"email":"dave","subId":"` + user.SubID + `","enable":true}]`})
+	if err != nil || report.Overwritten != 1 || report.Added != 1 || report.Records[0].Message == "" || report.Records[1].Message == "" {
+		t.Fatalf("import with a client user: %+v (%v)", report, err)
+	}
+	carol, _ = s.GetInboundClientByEmail("carol")
+	if carol.UserId != user.Id || carol.SubID != user.SubID || carol.TotalGB != 0 {
+		t.Fatalf("expected carol to stay with the user, got %+v", carol)
+	}
+	dave, _ := s.GetInboundClientByEmail("dave")
+	if dave == nil || dave.UserId != 0 || dave.SubID == "" || dave.SubID == user.SubID {
+		t.Fatalf("expected dave to get a subscription of its own, got %+v", dave)
+	}
+
+	// Shadowsocks 2022 clients need a key of the length of the method
+	ss := &model.Inbound{Enable: true, Port: 20003, Protocol: model.Shadowsocks, Tag: "inbound-20003",
+		Settings: `{"method":"2022-blake3-aes-128-gcm","password":"MDEyMzQ1Njc4OWFiY2RlZg==","network":"tcp,udp",
+			"clients":[{"email":"frank","password":"MDEyMzQ1Njc4OWFiY2RlZg==","enable":true}]}`}
+	if _, _, err := s.AddInbound(ss); err != nil {
+		t.Fatalf("add inbound: %v", err)
+	}
+	report, _, err = s.ImportClients(&ClientImportRequest{InboundId: ss.Id,
+		Data: "email,password\ngrace,short\nheidi,MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n"})
+	if err != nil || report.Invalid != 2 || report.Committed {
+		t.Fatalf("expected keys of the wrong length to be invalid: %+v (%v)", report, err)
+	}
+	report, needRestart, err = s.ImportClients(&ClientImportRequest{InboundId: ss.Id,
+		Data: "email,password,enable\ngrace,ZmVkY2JhOTg3NjU0MzIxMA==,false\n"})
+	if err != nil || report.Added != 1 || !report.Committed || needRestart {
+		t.Fatalf("expected a disabled client to be imported without a restart: %+v %v (%v)", report, needRestart, err)
+	}
+}
@@ -0,0 +1,438 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// ClientUserInfo is a client user with the traffic its clients used together.
+type ClientUserInfo struct {
+	model.ClientUser
+	Up      int64                  `json:"up"`
+	Down    int64                  `json:"down"`
+	Clients []*model.InboundClient `json:"clients" gorm:"-"`
+}
+
+// invalidReason returns why the clients of the user may not connect: "manual" if the user is
+// disabled, "expired" or "depleted". It returns an empty string if they may connect.
+func (u *ClientUserInfo) invalidReason(now int64) string {
+	switch {
+	case !u.Enable:
+		return "manual"
+	case u.ExpiryTime > 0 && u.ExpiryTime <= now:
+		return "expired"
+	case u.TotalGB > 0 && u.Up+u.Down >= u.TotalGB:
+		return "depleted"
+	}
+	return ""
+}
+
+// ClientUserService manages client users, which own clients on several inbounds, see model.ClientUser.
+type ClientUserService struct {
+	inboundService InboundService
+}
+
+// getClientUsersInfo retrieves the client users matching the conditions of query with their traffic.
+func getClientUsersInfo(tx *gorm.DB, query func(*gorm.DB) *gorm.DB) ([]*ClientUserInfo, error) {
+	var users []*ClientUserInfo
+	err := query(tx.Table("client_users").
+		Select("client_users.*, COALESCE(SUM(client_traffics.up), 0) AS up, COALESCE(SUM(client_traffics.down), 0) AS down").
+		Joins("LEFT JOIN inbound_clients ON inbound_clients.user_id = client_users.id").
+		Joins("LEFT JOIN client_traffics ON client_traffics.email = inbound_clients.email").
+		Group("client_users.id")).
+		Order("client_users.id").Scan(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetClientUsers retrieves all client users with their clients and traffic.
+func (s *ClientUserService) GetClientUsers() ([]*ClientUserInfo, error) {
+	db := database.GetDB()
+	users, err := getClientUsersInfo(db, func(q *gorm.DB) *gorm.DB { return q })
+	if err != nil {
+		return nil, err
+	}
+	var clients []*model.InboundClient
+	err = db.Where("user_id > 0").Order("inbound_id, position, id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[int][]*model.InboundClient)
+	for _, client := range clients {
+		byUser[client.UserId] = append(byUser[client.UserId], client)
+	}
+	for _, user := range users {
+		user.Clients = byUser[user.Id]
+	}
+	return users, nil
+}
+
+// GetClientUser retrieves a client user with its clients and traffic.
+func (s *ClientUserService) GetClientUser(id int) (*ClientUserInfo, error) {
+	return s.getClientUser(database.GetDB(), func(q *gorm.DB) *gorm.DB { return q.Where("client_users.id = ?", id) })
+}
+
+// GetClientUserBySubId retrieves the client user with the subscription id. Returns nil if there is none.
+func (s *ClientUserService) GetClientUserBySubId(subId string) (*ClientUserInfo, error) {
+	user, err := s.getClientUser(database.GetDB(), func(q *gorm.DB) *gorm.DB { return q.Where("client_users.sub_id = ?", subId) })
+	if database.IsNotFound(err) {
+		return nil, nil
+	}
+	return user, err
+}
+
+func (s *ClientUserService) getClientUser(tx *gorm.DB, query func(*gorm.DB) *gorm.DB) (*ClientUserInfo, error) {
+	users, err := getClientUsersInfo(tx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	user := users[0]
+	err = tx.Where("user_id = ?", user.Id).Order("inbound_id, position, id").Find(&user.Clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *ClientUserService) checkClientUser(tx *gorm.DB, user *model.ClientUser) error {
+	user.Name = strings.TrimSpace(user.Name)
+	user.SubID = strings.TrimSpace(user.SubID)
+	if user.Name == "" {
+		return common.NewError("user name is required")
+	}
+	if user.TotalGB < 0 || user.ExpiryTime < 0 || user.LimitIP < 0 {
+		return common.NewError("limits must not be negative")
+	}
+	if user.SubID == "" {
+		user.SubID = random.Seq(16)
+	}
+	var count int64
+	err := tx.Model(model.ClientUser{}).Where("id != ? AND lower(name) = lower(?)", user.Id, user.Name).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Duplicate user name:", user.Name)
+	}
+	err = tx.Model(model.ClientUser{}).Where("id != ? AND sub_id = ?", user.Id, user.SubID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Duplicate subscription id:", user.SubID)
+	}
+	// Clients that are not attached to the user must not show up in its subscription
+	err = tx.Model(model.InboundClient{}).Where("sub_id = ? AND user_id != ?", user.SubID, user.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Subscription id is used by other clients:", user.SubID)
+	}
+	return nil
+}
+
+// AddClientUser creates a client user. A subscription id is generated if none is given.
+func (s *ClientUserService) AddClientUser(user *model.ClientUser) error {
+	db := database.GetDB()
+	user.Id = 0
+	if err := s.checkClientUser(db, user); err != nil {
+		return err
+	}
+	return db.Create(user).Error
+}
+
+// UpdateClientUser updates a client user and applies its subscription id and limits to its clients.
+func (s *ClientUserService) UpdateClientUser(user *model.ClientUser) (bool, error) {
+	needRestart := false
+	events := &webhookBatch{}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		old := &model.ClientUser{}
+		if err := tx.First(old, user.Id).Error; err != nil {
+			return err
+		}
+		if err := s.checkClientUser(tx, user); err != nil {
+			return err
+		}
+		user.CreatedAt = old.CreatedAt
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		var err error
+		needRestart, err = s.applyClientUser(tx, user.Id, events)
+		return err
+	})
+	if err == nil {
+		events.emit()
+	}
+	return needRestart, err
+}
+
+// DelClientUser deletes a client user. Its clients are kept, see DetachClient.
+func (s *ClientUserService) DelClientUser(id int) (bool, error) {
+	needRestart := false
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		user, err := s.getClientUser(tx, func(q *gorm.DB) *gorm.DB { return q.Where("client_users.id = ?", id) })
+		if err != nil {
+			return err
+		}
+		emails := make([]string, 0, len(user.Clients))
+		for _, client := range user.Clients {
+			if err := s.detachClient(tx, &user.ClientUser, client); err != nil {
+				return err
+			}
+			emails = append(emails, client.Email)
+		}
+		if err := tx.Delete(model.ClientUser{}, id).Error; err != nil {
+			return err
+		}
+		needRestart, err = s.inboundService.enableValidClients(tx, 0, emails...)
+		return err
+	})
+	return needRestart, err
+}
+
+// AttachClient makes a client part of a user. The client takes over the subscription id of the
+// user, and its own limits are cleared in favour of the shared limits of the user.
+func (s *ClientUserService) AttachClient(id int, email string) (bool, error) {
+	needRestart := false
+	events := &webhookBatch{}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		user := &model.ClientUser{}
+		if err := tx.First(user, id).Error; err != nil {
+			return err
+		}
+		client, inbound, err := s.getClient(tx, email)
+		if err != nil {
+			return err
+		}
+		if client.UserId == id {
+			return nil
+		}
+		if client.UserId != 0 {
+			return common.NewError("Client belongs to another user:", email)
+		}
+		err = tx.Model(client).Updates(map[string]any{
+			"user_id":     id,
+			"sub_id":      user.SubID,
+			"total_gb":    0,
+			"expiry_time": 0,
+			"limit_ip":    0,
+			"updated_at":  time.Now().UnixMilli(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).
+			Updates(map[string]any{"total": 0, "expiry_time": 0}).Error
+		if err != nil {
+			return err
+		}
+		if err := s.inboundService.saveClientSettings(tx, inbound); err != nil {
+			return err
+		}
+		needRestart, err = s.applyClientUser(tx, id, events)
+		return err
+	})
+	if err == nil {
+		events.emit()
+	}
+	return needRestart, err
+}
+
+// DetachClient removes a client from a user. The client gets the limits of the user as its own
+// limits and a subscription id of its own.
+func (s *ClientUserService) DetachClient(id int, email string) (bool, error) {
+	needRestart := false
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		user := &model.ClientUser{}
+		if err := tx.First(user, id).Error; err != nil {
+			return err
+		}
+		client, _, err := s.getClient(tx, email)
+		if err != nil {
+			return err
+		}
+		if client.UserId != id {
+			return common.NewError("Client does not belong to the user:", email)
+		}
+		if err := s.detachClient(tx, user, client); err != nil {
+			return err
+		}
+		needRestart, err = s.inboundService.enableValidClients(tx, 0, client.Email)
+		return err
+	})
+	return needRestart, err
+}
+
+func (s *ClientUserService) detachClient(tx *gorm.DB, user *model.ClientUser, client *model.InboundClient) error {
+	err := tx.Model(client).Updates(map[string]any{
+		"user_id":     0,
+		"sub_id":      random.Seq(16),
+		"total_gb":    user.TotalGB,
+		"expiry_time": user.ExpiryTime,
+		"limit_ip":    user.LimitIP,
+		"updated_at":  time.Now().UnixMilli(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).
+		Updates(map[string]any{"total": user.TotalGB, "expiry_time": user.ExpiryTime}).Error
+	if err != nil {
+		return err
+	}
+	inbound := &model.Inbound{}
+	if err := tx.First(inbound, client.InboundId).Error; err != nil {
+		return err
+	}
+	return s.inboundService.saveClientSettings(tx, inbound)
+}
+
+// ResetClientUserTraffic resets the traffic of all clients of a user.
+func (s *ClientUserService) ResetClientUserTraffic(id int) (bool, error) {
+	needRestart := false
+	events := &webhookBatch{}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model.ClientUser{}, id).Error; err != nil {
+			return err
+		}
+		err := tx.Model(xray.ClientTraffic{}).
+			Where("email IN (?)", tx.Model(model.InboundClient{}).Select("email").Where("user_id = ?", id)).
+			Updates(map[string]any{"up": 0, "down": 0}).Error
+		if err != nil {
+			return err
+		}
+		needRestart, err = s.applyClientUser(tx, id, events)
+		return err
+	})
+	if err == nil {
+		events.emit()
+	}
+	return needRestart, err
+}
+
+// getClient finds a client and its inbound by the client email.
+func (s *ClientUserService) getClient(tx *gorm.DB, email string) (*model.InboundClient, *model.Inbound, error) {
+	client := &model.InboundClient{}
+	err := tx.Where("email = ?", email).First(client).Error
+	if database.IsNotFound(err) {
+		return nil, nil, common.NewError("Client Not Found For Email:", email)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	inbound := &model.Inbound{}
+	if err := tx.First(inbound, client.InboundId).Error; err != nil {
+		return nil, nil, err
+	}
+	return client, inbound, nil
+}
+
+// applyClientUser gives the clients of a user its subscription id and enables or disables them
+// depending on whether the user may connect.
+func (s *ClientUserService) applyClientUser(tx *gorm.DB, id int, events *webhookBatch) (bool, error) {
+	user := &model.ClientUser{}
+	if err := tx.First(user, id).Error; err != nil {
+		return false, err
+	}
+	var inboundIds []int
+	err := tx.Model(model.InboundClient{}).Where("user_id = ? AND sub_id != ?", id, user.SubID).
+		Distinct().Pluck("inbound_id", &inboundIds).Error
+	if err != nil {
+		return false, err
+	}
+	if len(inboundIds) > 0 {
+		err = tx.Model(model.InboundClient{}).Where("user_id = ?", id).Update("sub_id", user.SubID).Error
+		if err != nil {
+			return false, err
+		}
+		var inbounds []*model.Inbound
+		if err := tx.Where("id IN ?", inboundIds).Find(&inbounds).Error; err != nil {
+			return false, err
+		}
+		for _, inbound := range inbounds {
+			if err := s.inboundService.saveClientSettings(tx, inbound); err != nil {
+				return false, err
+			}
+		}
+	}
+	enabled, err := s.inboundService.enableValidClients(tx, id)
+	if err != nil {
+		return false, err
+	}
+	disabled, _, err := s.inboundService.disableInvalidClients(tx, events)
+	return enabled || disabled, err
+}
+
+// invalidUserClients returns the emails of the clients of users that may not connect, with
+// the reason as returned by ClientUserInfo.invalidReason.
+func (s *InboundService) invalidUserClients(tx *gorm.DB, now int64) (map[string]string, error) {
+	users, err := getClientUsersInfo(tx, func(q *gorm.DB) *gorm.DB { return q })
+	if err != nil {
+		return nil, err
+	}
+	reasons := make(map[int]string)
+	for _, user := range users {
+		if reason := user.invalidReason(now); reason != "" {
+			reasons[user.Id] = reason
+		}
+	}
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	var clients []*model.InboundClient
+	if err := tx.Select("email", "user_id").Where("user_id > 0").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	emails := make(map[string]string)
+	for _, client := range clients {
+		if reason, ok := reasons[client.UserId]; ok {
+			emails[client.Email] = reason
+		}
+	}
+	return emails, nil
+}
+
+// enableValidClients enables the traffic of clients which disableInvalidClients disabled but
+// which may connect again, e.g. after the limits of their user were raised. The clients are
+// those of the user, or those with the given emails if userId is 0. It reports whether any
+// client was enabled, in which case Xray needs a restart.
+func (s *InboundService) enableValidClients(tx *gorm.DB, userId int, emails ...string) (bool, error) {
+	now := time.Now().UnixMilli()
+	invalid, err := s.invalidUserClients(tx, now)
+	if err != nil {
+		return false, err
+	}
+	candidates := tx.Model(model.InboundClient{}).Select("email").Where("enable = ?", true)
+	if userId > 0 {
+		candidates = candidates.Where("user_id = ?", userId)
+	} else {
+		candidates = candidates.Where("email IN ?", emails)
+	}
+	query := tx.Model(xray.ClientTraffic{}).
+		Where("email IN (?) AND enable = ?", candidates, false).
+		Where("NOT ((total > 0 AND up + down >= total) OR (expiry_time > 0 AND expiry_time <= ?))", now)
+	if len(invalid) > 0 {
+		invalidEmails := make([]string, 0, len(invalid))
+		for email := range invalid {
+			invalidEmails = append(invalidEmails, email)
+		}
+		query = query.Where("email NOT IN ?", invalidEmails)
+	}
+	result := query.Update("enable", true)
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestClientUserSharedLimits(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	inboundService := &InboundService{}
+	for _, inbound := range []*model.Inbound{
+		{Enable: true, Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001",
+			Settings: `{"clients":[{"id":"4b1c5e4e-0c53-4a0e-9f7c-5d1a3e0b6a11","email":"bob-reality","enable":true,"totalGB":100}],"decryption":"none"}`},
+		{Enable: true, Port: 20002, Protocol: model.Trojan, Tag: "inbound-20002",
+			Settings: `{"clients":[{"password":"secret","email":"bob-ws","enable":true}]}`},
+	} {
+		if _, _, err := inboundService.AddInbound(inbound); err != nil {
+			t.Fatalf("add inbound: %v", err)
+		}
+	}
+
+	s := &ClientUserService{}
+	user := &model.ClientUser{Name: "bob", TotalGB: 1000, Enable: true}
+	if err := s.AddClientUser(user); err != nil || user.SubID == "" {
+		t.Fatalf("add user: %v (sub id %q)", err, user.SubID)
+	}
+	if err := s.AddClientUser(&model.ClientUser{Name: "BOB"}); err == nil {
+		t.Fatal("expected duplicate user name to be rejected")
+	}
+	for _, email := range []string{"bob-reality", "bob-ws"} {
+		if _, err := s.AttachClient(user.Id, email); err != nil {
+			t.Fatalf("attach %s: %v", email, err)
+		}
+	}
+	client, _ := inboundService.GetInboundClientByEmail("bob-reality")
+	if client.UserId != user.Id || client.SubID != user.SubID || client.TotalGB != 0 {
+		t.Fatalf("expected the client to take over the user, got %+v", client)
+	}
+
+	// Together the clients use up the shared limit, while each stays below it
+	db.Model(xray.ClientTraffic{}).Where("email = ?", "bob-reality").Update("up", 600)
+	db.Model(xray.ClientTraffic{}).Where("email = ?", "bob-ws").Update("down", 500)
+	if _, count, err := inboundService.disableInvalidClients(db, &webhookBatch{}); err != nil || count != 2 {
+		t.Fatalf("expected both clients to be disabled, got %d (%v)", count, err)
+	}
+	active, _ := inboundService.getActiveClients()
+	if len(active) != 0 {
+		t.Fatalf("expected no active clients, got %v", active)
+	}
+
+	user.TotalGB = 5000
+	needRestart, err := s.UpdateClientUser(user)
+	if err != nil || !needRestart {
+		t.Fatalf("update user: %v (restart %v)", err, needRestart)
+	}
+	active, _ = inboundService.getActiveClients()
+	if len(active) != 2 {
+		t.Fatalf("expected the clients to be enabled again, got %v", active)
+	}
+	info, err := s.GetClientUser(user.Id)
+	if err != nil || info.Up != 600 || info.Down != 500 || len(info.Clients) != 2 {
+		t.Fatalf("unexpected user info %+v (%v)", info, err)
+	}
+
+	user.Enable = false
+	if _, err := s.UpdateClientUser(user); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	active, _ = inboundService.getActiveClients()
+	if len(active) != 0 {
+		t.Fatalf("expected the clients of a disabled user to be disabled, got %v", active)
+	}
+
+	if _, err := s.DelClientUser(user.Id); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	client, _ = inboundService.GetInboundClientByEmail("bob-ws")
+	if client.UserId != 0 || client.SubID == user.SubID || client.TotalGB != 5000 {
+		t.Fatalf("expected the client to keep the limits of the deleted user, got %+v", client)
+	}
+	active, _ = inboundService.getActiveClients()
+	if len(active) != 2 {
+		t.Fatalf("expected detached clients to be enabled, got %v", active)
+	}
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// connectionBatchSize is the number of connections stored per insert while reading the access log.
+const connectionBatchSize = 500
+
+// accessLogRegex matches an accepted connection of the Xray access log, like
+// 2025/01/02 15:04:05.123456 from tcp:1.2.3.4:51234 accepted tcp:example.com:443 [inbound-443 -> direct] email: user
+var accessLogRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) from (?:tcp:|udp:)?(\S+?):\d+ accepted (?:(tcp|udp):)?(\S+):(\d+) \[(.*?)(?: (?:->|>>) (.*?))?\] email: (.+)$`)
+
+// accessLogTail is the read position in the Xray access log, shared by all ConnectionLogService values.
+var accessLogTail struct {
+	sync.Mutex
+	path   string
+	offset int64
+	since  int64 // Time of the newest connection stored before the panel started, older lines are skipped
+	loaded bool
+}
+
+// ConnectionFilter narrows down the client connections returned by GetConnections.
+type ConnectionFilter struct {
+	Email       string `form:"email"`
+	SourceIp    string `form:"sourceIp"`
+	Destination string `form:"destination"` // Destination IP, or domain which also matches its subdomains
+	From        int64  `form:"from"`        // Timestamp in milliseconds
+	To          int64  `form:"to"`          // Timestamp in milliseconds
+	Page        int    `form:"page"`
+	PageSize    int    `form:"pageSize"`
+}
+
+// DestinationFilter narrows down the destinations summarized by GetDestinations and GetTopDomains.
+type DestinationFilter struct {
+	Email string `form:"email"`
+	From  int64  `form:"from"` // Timestamp in milliseconds
+	To    int64  `form:"to"`   // Timestamp in milliseconds
+	Limit int    `form:"limit"`
+}
+
+// DestinationStats summarizes the connections to one destination.
+type DestinationStats struct {
+	Destination string `json:"destination"` // Domain, or IP if the connections had no domain
+	Connections int64  `json:"connections"`
+	Clients     int64  `json:"clients"`   // Distinct client emails
+	SourceIps   int64  `json:"sourceIps"` // Distinct source IPs
+	FirstSeen   int64  `json:"firstSeen"` // Timestamp in milliseconds
+	LastSeen    int64  `json:"lastSeen"`  // Timestamp in milliseconds
+}
+
+// ConnectionLogService stores the client connections of the Xray access log, so that the
+// destinations of a client can be looked up later, e.g. to answer abuse complaints.
+type ConnectionLogService struct {
+	settingService SettingService
+}
+
+// IngestAccessLog stores the connections written to the Xray access log since the last call and
+// returns their number. It reads whole lines only, a line that is still being written is read by
+// the next call. Nothing is read while the connection log is disabled.
+func (s *ConnectionLogService) IngestAccessLog() (int, error) {
+	accessLogTail.Lock()
+	defer accessLogTail.Unlock()
+	return s.ingestAccessLog()
+}
+
+// RotateAccessLog stores the connections not read yet, appends the Xray access log to the
+// persistent access log and truncates it. The read position stays locked throughout, so that no
+// connection written in between is skipped or stored twice.
+func (s *ConnectionLogService) RotateAccessLog() error {
+	accessLogTail.Lock()
+	defer accessLogTail.Unlock()
+	_, ingestErr := s.ingestAccessLog()
+
+	path, err := xray.GetAccessLogPath()
+	if err != nil {
+		return err
+	}
+	if path == "" || path == "none" {
+		return ingestErr
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	persistent, err := os.OpenFile(xray.GetAccessPersistentLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer persistent.Close()
+	if _, err := io.Copy(persistent, file); err != nil {
+		return err
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		return err
+	}
+	accessLogTail.path = path
+	accessLogTail.offset = 0
+	return ingestErr
+}
+
+// ingestAccessLog is IngestAccessLog for callers that hold the accessLogTail lock.
+func (s *ConnectionLogService) ingestAccessLog() (int, error) {
+	enable, err := s.settingService.GetConnectionLogEnable()
+	if err != nil || !enable {
+		return 0, err
+	}
+	path, err := xray.GetAccessLogPath()
+	if err != nil || path == "" || path == "none" {
+		return 0, nil
+	}
+
+	db := database.GetDB()
+	if !accessLogTail.loaded {
+		var since []int64
+		if err := db.Model(model.ClientConnection{}).Order("time desc").Limit(1).Pluck("time", &since).Error; err != nil {
+			return 0, err
+		}
+		if len(since) > 0 {
+			accessLogTail.since = since[0]
+		}
+		accessLogTail.loaded = true
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if path != accessLogTail.path || info.Size() < accessLogTail.offset {
+		accessLogTail.path = path
+		accessLogTail.offset = 0
+	}
+	if _, err := file.Seek(accessLogTail.offset, 0); err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	offset := accessLogTail.offset
+	batch := make([]*model.ClientConnection, 0, connectionBatchSize)
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := db.Create(&batch).Error; err != nil {
+				return err
+			}
+			stored += len(batch)
+			batch = batch[:0]
+		}
+		accessLogTail.offset = offset
+		return nil
+	}
+	reader := bufio.NewReaderSize(file, 64<<10)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		offset += int64(len(line))
+		connection := parseAccessLogLine(strings.TrimRight(line, "\r\n"))
+		if connection == nil || connection.Time <= accessLogTail.since {
+			continue
+		}
+		batch = append(batch, connection)
+		if len(batch) == connectionBatchSize {
+			if err := flush(); err != nil {
+				return stored, err
+			}
+		}
+	}
+	return stored, flush()
+}
+
+// GetConnections retrieves a page of client connections matching the filter, newest first, with
+// the total number of matching connections.
+func (s *ConnectionLogService) GetConnections(filter *ConnectionFilter) ([]*model.ClientConnection, int64, error) {
+	db := database.GetDB().Model(model.ClientConnection{})
+	if filter.Email != "" {
+		db = db.Where("email = ?", filter.Email)
+	}
+	if filter.SourceIp != "" {
+		db = db.Where("source_ip = ?", filter.SourceIp)
+	}
+	if filter.Destination != "" {
+		destination := strings.ToLower(strings.Trim(filter.Destination, "[]"))
+		db = db.Where("ip = ? OR domain = ? OR domain LIKE ?", destination, destination, "%."+destination)
+	}
+	if filter.From > 0 {
+		db = db.Where("time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		db = db.Where("time <= ?", filter.To)
+	}
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = 100
+	}
+	page := max(filter.Page, 1)
+	connections := make([]*model.ClientConnection, 0)
+	err := db.Order("time desc, id desc").Limit(pageSize).Offset((page - 1) * pageSize).Find(&connections).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return connections, total, nil
+}
+
+// GetDestinations summarizes the destinations of the connections matching the filter, the most
+// connected first. Connections without a domain are summarized by their destination IP.
+func (s *ConnectionLogService) GetDestinations(filter *DestinationFilter) ([]*DestinationStats, error) {
+	return s.getDestinations(filter, "CASE WHEN domain != '' THEN domain ELSE ip END")
+}
+
+// GetTopDomains summarizes the destination domains of the connections matching the filter, the
+// most connected first.
+func (s *ConnectionLogService) GetTopDomains(filter *DestinationFilter) ([]*DestinationStats, error) {
+	return s.getDestinations(filter, "domain")
+}
+
+func (s *ConnectionLogService) getDestinations(filter *DestinationFilter, destination string) ([]*DestinationStats, error) {
+	db := database.GetDB().Model(model.ClientConnection{}).
+		Select(destination + " AS destination, COUNT(*) AS connections, COUNT(DISTINCT email) AS clients, " +
+			"COUNT(DISTINCT source_ip) AS source_ips, MIN(time) AS first_seen, MAX(time) AS last_seen").
+		Where(destination + " != ''")
+	if filter.Email != "" {
+		db = db.Where("email = ?", filter.Email)
+	}
+	if filter.From > 0 {
+		db = db.Where("time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		db = db.Where("time <= ?", filter.To)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	stats := make([]*DestinationStats, 0)
+	err := db.Group("destination").Order("connections desc, destination").Limit(limit).Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// DelExpiredConnections removes the connections older than the configured retention period and
+// the oldest connections beyond the configured record limit.
+func (s *ConnectionLogService) DelExpiredConnections() (int64, error) {
+	db := database.GetDB()
+	var count int64
+	days, err := s.settingService.GetConnectionLogDays()
+	if err != nil {
+		return 0, err
+	}
+	if days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+		result := db.Where("time < ?", cutoff).Delete(model.ClientConnection{})
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		count += result.RowsAffected
+	}
+
+	maxRecords, err := s.settingService.GetConnectionLogMaxRecords()
+	if err != nil || maxRecords <= 0 {
+		return count, err
+	}
+	var ids []int
+	err = db.Model(model.ClientConnection{}).Order("id desc").Offset(maxRecords).Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return count, err
+	}
+	result := db.Where("id <= ?", ids[0]).Delete(model.ClientConnection{})
+	return count + result.RowsAffected, result.Error
+}
+
+// parseAccessLogLine parses an accepted connection of a client from a line of the Xray access
+// log, or returns nil if the line is no such connection.
+func parseAccessLogLine(line string) *model.ClientConnection {
+	matches := accessLogRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil
+	}
+	// Xray writes the access log in local time
+	logTime, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
+	if err != nil {
+		return nil
+	}
+	port, _ := strconv.Atoi(matches[5])
+	network := matches[3]
+	if network == "" {
+		network = "tcp"
+	}
+	connection := &model.ClientConnection{
+		Time:        logTime.UnixMilli(),
+		Email:       strings.TrimSpace(matches[8]),
+		SourceIp:    strings.Trim(matches[2], "[]"),
+		Network:     network,
+		Port:        port,
+		InboundTag:  strings.TrimSpace(matches[6]),
+		OutboundTag: strings.TrimSpace(matches[7]),
+	}
+	destination := strings.Trim(matches[4], "[]")
+	if net.ParseIP(destination) != nil {
+		connection.Ip = destination
+	} else {
+		connection.Domain = strings.ToLower(destination)
+	}
+	return connection
+}
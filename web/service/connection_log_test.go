@@ -0,0 +1,153 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestParseAccessLogLine(t *testing.T) {
+	connection := parseAccessLogLine("2025/01/02 15:04:05.123456 from tcp:[2001:db8::1]:51234 accepted udp:[2001:db8::2]:53 [inbound-443 >> direct] email: alice")
+	if connection == nil {
+		t.Fatal("expected a connection")
+	}
+	want := time.Date(2025, 1, 2, 15, 4, 5, 123000000, time.Local).UnixMilli()
+	if connection.Time != want || connection.Email != "alice" || connection.SourceIp != "2001:db8::1" ||
+		connection.Network != "udp" || connection.Ip != "2001:db8::2" || connection.Domain != "" || connection.Port != 53 ||
+		connection.InboundTag != "inbound-443" || connection.OutboundTag != "direct" {
+		t.Fatalf("unexpected connection %+v", connection)
+	}
+
+	connection = parseAccessLogLine("2025/01/02 15:04:05 from 1.2.3.4:51234 accepted tcp:WWW.Example.com:443 [inbound-443 -> blocked] email: bob")
+	if connection == nil || connection.Domain != "www.example.com" || connection.Ip != "" || connection.SourceIp != "1.2.3.4" || connection.OutboundTag != "blocked" {
+		t.Fatalf("unexpected connection %+v", connection)
+	}
+
+	for _, line := range []string{
+		"2025/01/02 15:04:05 from 1.2.3.4:51234 accepted tcp:example.com:443 [api -> api]",
+		"2025/01/02 15:04:05 from 1.2.3.4:51234 rejected  proxy/vless/encoding: invalid request user id",
+		"2025/01/02 15:04:05 [Info] app/dispatcher: taking detour [direct] for [tcp:example.com:443]",
+	} {
+		if connection := parseAccessLogLine(line); connection != nil {
+			t.Fatalf("expected no connection for %q, got %+v", line, connection)
+		}
+	}
+}
+
+func TestConnectionLog(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("XUI_BIN_FOLDER", t.TempDir())
+	resetAccessLogTail := func() {
+		accessLogTail.path, accessLogTail.offset, accessLogTail.since, accessLogTail.loaded = "", 0, 0, false
+	}
+	resetAccessLogTail()
+	defer resetAccessLogTail()
+
+	accessLog := filepath.Join(t.TempDir(), "access.log")
+	config := `{"log": {"access": "` + accessLog + `"}}`
+	if err := os.WriteFile(xray.GetConfigPath(), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lines := "2025/01/02 10:00:00 from 1.2.3.4:1000 accepted tcp:www.example.com:443 [in -> direct] email: alice\n" +
+		"2025/01/02 10:00:01 from 1.2.3.4:1001 accepted tcp:example.com:443 [in -> direct] email: alice\n" +
+		"2025/01/02 10:00:02 from 5.6.7.8:1002 accepted tcp:93.184.216.34:80 [in -> direct] email: alice\n" +
+		"2025/01/02 10:00:03 from 9.9.9.9:1003 accepted tcp:www.example.com:443 [in -> direct] email: bob\n" +
+		"2025/01/02 10:00:04 from 9.9.9.9:1004 accepted tcp:other.org:443 [in -> direct] email: b"
+	if err := os.WriteFile(accessLog, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ConnectionLogService{}
+	if count, err := s.IngestAccessLog(); err != nil || count != 0 {
+		t.Fatalf("expected nothing to be read while disabled, got %d (%v)", count, err)
+	}
+	if err := s.settingService.saveSetting("connectionLogEnable", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := s.IngestAccessLog(); err != nil || count != 4 {
+		t.Fatalf("expected 4 connections, got %d (%v)", count, err)
+	}
+
+	// the unfinished line is read once it is complete
+	file, err := os.OpenFile(accessLog, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("ob\n")
+	file.Close()
+	if count, err := s.IngestAccessLog(); err != nil || count != 1 {
+		t.Fatalf("expected 1 connection, got %d (%v)", count, err)
+	}
+	if count, err := s.IngestAccessLog(); err != nil || count != 0 {
+		t.Fatalf("expected no new connections, got %d (%v)", count, err)
+	}
+
+	// a truncated log is read from the beginning
+	if err := os.WriteFile(accessLog, []byte("2025/01/02 10:00:05 from 1.2.3.4:1005 accepted udp:8.8.8.8:53 [in -> direct] email: alice\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := s.IngestAccessLog(); err != nil || count != 1 {
+		t.Fatalf("expected 1 connection after truncation, got %d (%v)", count, err)
+	}
+
+	connections, total, err := s.GetConnections(&ConnectionFilter{Destination: "example.com"})
+	if err != nil || total != 3 || len(connections) != 3 || connections[0].Email != "bob" {
+		t.Fatalf("unexpected connections to example.com %+v, total %d (%v)", connections, total, err)
+	}
+	connections, total, err = s.GetConnections(&ConnectionFilter{Email: "alice", Destination: "93.184.216.34"})
+	if err != nil || total != 1 || connections[0].SourceIp != "5.6.7.8" {
+		t.Fatalf("unexpected connections of alice to the IP %+v (%v)", connections, err)
+	}
+
+	destinations, err := s.GetDestinations(&DestinationFilter{Email: "alice"})
+	if err != nil || len(destinations) != 4 {
+		t.Fatalf("unexpected destinations of alice %+v (%v)", destinations, err)
+	}
+	domains, err := s.GetTopDomains(&DestinationFilter{})
+	if err != nil || len(domains) != 3 || domains[0].Destination != "www.example.com" || domains[0].Connections != 2 ||
+		domains[0].Clients != 2 || domains[0].SourceIps != 2 {
+		t.Fatalf("unexpected top domains %+v (%v)", domains, err)
+	}
+
+	// the connections are older than the default retention period
+	if err := s.settingService.saveSetting("connectionLogDays", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.settingService.saveSetting("connectionLogMaxRecords", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := s.DelExpiredConnections(); err != nil || count != 4 {
+		t.Fatalf("expected 4 connections beyond the limit to be removed, got %d (%v)", count, err)
+	}
+	if _, total, _ := s.GetConnections(&ConnectionFilter{}); total != 2 {
+		t.Fatalf("expected 2 connections to be kept, got %d", total)
+	}
+
+	// rotating stores the unread lines before the log is moved to the persistent log
+	line := "2025/01/02 10:00:06 from 1.2.3.4:1006 accepted tcp:rotated.org:443 [in -> direct] email: alice\n"
+	file, err = os.OpenFile(accessLog, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(line)
+	file.Close()
+	if err := s.RotateAccessLog(); err != nil {
+		t.Fatalf("rotate access log: %v", err)
+	}
+	if info, err := os.Stat(accessLog); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the access log to be truncated (%v)", err)
+	}
+	if persistent, err := os.ReadFile(xray.GetAccessPersistentLogPath()); err != nil || !strings.HasSuffix(string(persistent), line) {
+		t.Fatalf("expected the access log in the persistent log (%v)", err)
+	}
+	if _, total, _ := s.GetConnections(&ConnectionFilter{Destination: "rotated.org"}); total != 1 {
+		t.Fatalf("expected the rotated connection to be stored once, got %d", total)
+	}
+	if count, err := s.IngestAccessLog(); err != nil || count != 0 {
+		t.Fatalf("expected no new connections after rotation, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// EndpointProfileService manages endpoint profiles, which add links with another server address,
+// e.g. of a CDN or relay, to subscriptions, see model.EndpointProfile.
+type EndpointProfileService struct {
+	clientUserService ClientUserService
+}
+
+// GetProfiles retrieves all endpoint profiles.
+func (s *EndpointProfileService) GetProfiles() ([]*model.EndpointProfile, error) {
+	profiles := make([]*model.EndpointProfile, 0)
+	err := database.GetDB().Model(model.EndpointProfile{}).Order("id").Find(&profiles).Error
+	if err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// GetProfile retrieves an endpoint profile by its ID.
+func (s *EndpointProfileService) GetProfile(id int) (*model.EndpointProfile, error) {
+	profile := &model.EndpointProfile{}
+	err := database.GetDB().Model(model.EndpointProfile{}).First(profile, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// AddProfile creates an endpoint profile.
+func (s *EndpointProfileService) AddProfile(profile *model.EndpointProfile) error {
+	if err := s.checkProfile(profile); err != nil {
+		return err
+	}
+	profile.Id = 0
+	return database.GetDB().Create(profile).Error
+}
+
+// UpdateProfile changes an endpoint profile.
+func (s *EndpointProfileService) UpdateProfile(profile *model.EndpointProfile) error {
+	if err := s.checkProfile(profile); err != nil {
+		return err
+	}
+	old, err := s.GetProfile(profile.Id)
+	if err != nil {
+		return err
+	}
+	profile.CreatedAt = old.CreatedAt
+	return database.GetDB().Save(profile).Error
+}
+
+// DelProfile deletes an endpoint profile by its ID.
+func (s *EndpointProfileService) DelProfile(id int) error {
+	result := database.GetDB().Delete(model.EndpointProfile{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("endpoint profile not found:", id)
+	}
+	return nil
+}
+
+// GetSubProfiles retrieves the enabled endpoint profiles that apply to the clients of a
+// subscription. Whether they also apply to an inbound is left to AppliesToInbound.
+func (s *EndpointProfileService) GetSubProfiles(subId string) ([]*model.EndpointProfile, error) {
+	var profiles []*model.EndpointProfile
+	err := database.GetDB().Model(model.EndpointProfile{}).Where("enable = ?", true).Order("id").Find(&profiles).Error
+	if err != nil || len(profiles) == 0 {
+		return nil, err
+	}
+	userId := 0
+	user, err := s.clientUserService.GetClientUserBySubId(subId)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		userId = user.Id
+	}
+	result := make([]*model.EndpointProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.AppliesToClientUser(userId) {
+			result = append(result, profile)
+		}
+	}
+	return result, nil
+}
+
+func (s *EndpointProfileService) checkProfile(profile *model.EndpointProfile) error {
+	profile.Name = strings.TrimSpace(profile.Name)
+	if profile.Name == "" {
+		return common.NewError("endpoint profile name can not be empty")
+	}
+	profile.Address = strings.TrimSpace(profile.Address)
+	if strings.ContainsAny(profile.Address, "/ ") {
+		return common.NewError("invalid endpoint profile address:", profile.Address)
+	}
+	if profile.Port < 0 || profile.Port > 65535 {
+		return common.NewError("invalid endpoint profile port:", profile.Port)
+	}
+	switch profile.Security {
+	case "":
+		profile.Security = model.EndpointSecuritySame
+	case model.EndpointSecuritySame, model.EndpointSecurityTls, model.EndpointSecurityNone:
+	default:
+		return common.NewError("invalid endpoint profile security:", profile.Security)
+	}
+
+	alpn := make([]string, 0)
+	for _, item := range strings.Split(profile.Alpn, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			alpn = append(alpn, item)
+		}
+	}
+	profile.Alpn = strings.Join(alpn, ",")
+
+	db := database.GetDB()
+	inboundIds, err := normalizeIds(profile.InboundIds)
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err := db.Model(model.Inbound{}).Where("id IN ?", inboundIds).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(inboundIds) {
+		return common.NewError("endpoint profile is attached to unknown inbounds:", profile.InboundIds)
+	}
+	clientUserIds, err := normalizeIds(profile.ClientUserIds)
+	if err != nil {
+		return err
+	}
+	if err := db.Model(model.ClientUser{}).Where("id IN ?", clientUserIds).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(clientUserIds) {
+		return common.NewError("endpoint profile is attached to unknown client users:", profile.ClientUserIds)
+	}
+	profile.InboundIds = joinIds(inboundIds)
+	profile.ClientUserIds = joinIds(clientUserIds)
+	return nil
+}
+
+// normalizeIds parses a comma-separated list of IDs and drops duplicates.
+func normalizeIds(list string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, err := strconv.Atoi(item)
+		if err != nil || id <= 0 {
+			return nil, common.NewError("invalid ID:", item)
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// joinIds formats IDs as a comma-separated list.
+func joinIds(ids []int) string {
+	items := make([]string, len(ids))
+	for i, id := range ids {
+		items[i] = strconv.Itoa(id)
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestEndpointProfiles(t *testing.T) {
+	setupTestDB(t)
+
+	inbound := &model.Inbound{Enable: true, Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001",
+		Settings: `{"clients":[{"id":"4b1c5e4e-0c53-4a0e-9f7c-5d1a3e0b6a11","email":"alice","subId":"s1","enable":true}],"decryption":"none"}`}
+	if _, _, err := (&InboundService{}).AddInbound(inbound); err != nil {
+		t.Fatalf("add inbound: %v", err)
+	}
+	user := &model.ClientUser{Name: "premium", Enable: true}
+	if err := (&ClientUserService{}).AddClientUser(user); err != nil {
+		t.Fatalf("add user: %v", err)
+	}
+
+	s := &EndpointProfileService{}
+	cdn := &model.EndpointProfile{Name: "cdn", Address: "cdn.example.com", Port: 443, Security: "tls", Alpn: " h2, http/1.1 ,",
+		InboundIds: "1, 1", Enable: true}
+	if err := s.AddProfile(cdn); err != nil {
+		t.Fatalf("add profile: %v", err)
+	}
+	if cdn.InboundIds != "1" || cdn.Alpn != "h2,http/1.1" {
+		t.Fatalf("expected normalized lists, got %+v", cdn)
+	}
+	relay := &model.EndpointProfile{Name: "relay", Address: "relay.example.com", ClientUserIds: "1", Enable: true}
+	if err := s.AddProfile(relay); err != nil || relay.Security != model.EndpointSecuritySame {
+		t.Fatalf("add relay: %v (%+v)", err, relay)
+	}
+	for _, invalid := range []*model.EndpointProfile{
+		{Name: "", Address: "a.example.com"},
+		{Name: "port", Port: 70000},
+		{Name: "security", Security: "reality"},
+		{Name: "inbound", InboundIds: "2"},
+		{Name: "user", ClientUserIds: "x"},
+	} {
+		if err := s.AddProfile(invalid); err == nil {
+			t.Fatalf("expected profile %+v to be rejected", invalid)
+		}
+	}
+
+	// the relay is only attached to the client user
+	profiles, err := s.GetSubProfiles("s1")
+	if err != nil || len(profiles) != 1 || profiles[0].Name != "cdn" {
+		t.Fatalf("unexpected profiles for s1 %+v (%v)", profiles, err)
+	}
+	profiles, err = s.GetSubProfiles(user.SubID)
+	if err != nil || len(profiles) != 2 {
+		t.Fatalf("unexpected profiles for the client user %+v (%v)", profiles, err)
+	}
+	if !profiles[0].AppliesToInbound(inbound.Id) || profiles[0].AppliesToInbound(inbound.Id+1) || !profiles[1].AppliesToInbound(inbound.Id+1) {
+		t.Fatal("unexpected inbound attachment")
+	}
+
+	cdn.Enable = false
+	if err := s.UpdateProfile(cdn); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	if profiles, _ := s.GetSubProfiles("s1"); len(profiles) != 0 {
+		t.Fatalf("expected disabled profile to be skipped, got %+v", profiles)
+	}
+	if err := s.DelProfile(relay.Id); err != nil {
+		t.Fatalf("delete profile: %v", err)
+	}
+	if err := s.DelProfile(relay.Id); err == nil {
+		t.Fatal("expected error for deleted profile")
+	}
+}
@@ -101,6 +101,47 @@ func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 	return inbounds, nil
 }
 
+// GetInboundsForUser retrieves the inbounds visible to the given user.
+// Resellers only see the inbounds they own, every other role sees all of them.
+func (s *InboundService) GetInboundsForUser(user *model.User) ([]*model.Inbound, error) {
+	if user.CanAccessAllInbounds() {
+		return s.GetAllInbounds()
+	}
+	return s.GetInbounds(user.Id)
+}
+
+// CheckInboundAccess returns an error if the user is not allowed to access the inbound.
+func (s *InboundService) CheckInboundAccess(user *model.User, inboundId int) error {
+	if user.CanAccessAllInbounds() {
+		return nil
+	}
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.Inbound{}).Where("id = ? AND user_id = ?", inboundId, user.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.NewError("inbound not found:", inboundId)
+	}
+	return nil
+}
+
+// CheckClientAccess returns an error if the user is not allowed to access the client with the given email.
+func (s *InboundService) CheckClientAccess(user *model.User, email string) error {
+	if user.CanAccessAllInbounds() {
+		return nil
+	}
+	_, inbound, err := s.GetClientInboundByEmail(email)
+	if err != nil {
+		return err
+	}
+	if inbound == nil || inbound.UserId != user.Id {
+		return common.NewError("client not found:", email)
+	}
+	return nil
+}
+
 func (s *InboundService) GetInboundsByTrafficReset(period string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
@@ -155,45 +196,60 @@ func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, err
 	return clients, nil
 }
 
+// GetInboundClient finds a client of an inbound by the protocol-specific client key
+// (password for trojan, email for shadowsocks, id otherwise). Returns nil if there is no match.
+func (s *InboundService) GetInboundClient(inboundId int, clientId string) (*model.Client, error) {
+	inbound, err := s.GetInbound(inboundId)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.findInboundClient(database.GetDB(), inbound, clientId)
+	if err != nil || client == nil {
+		return nil, err
+	}
+	c := client.Client()
+	return &c, nil
+}
+
 func (s *InboundService) getAllEmails() ([]string, error) {
 	db := database.GetDB()
 	var emails []string
-	err := db.Raw(`
-		SELECT JSON_EXTRACT(client.value, '$.email')
-		FROM inbounds,
-			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		`).Scan(&emails).Error
+	err := db.Model(model.InboundClient{}).Pluck("email", &emails).Error
 	if err != nil {
 		return nil, err
 	}
 	return emails, nil
 }
 
-func (s *InboundService) contains(slice []string, str string) bool {
-	lowerStr := strings.ToLower(str)
-	for _, s := range slice {
-		if strings.ToLower(s) == lowerStr {
-			return true
+// checkEmailsExistForClients returns the first email of the clients that is used twice or
+// already taken by another client, ignoring case.
+func (s *InboundService) checkEmailsExistForClients(clients []model.Client) (string, error) {
+	lowerEmails := make([]string, 0, len(clients))
+	for _, client := range clients {
+		if client.Email != "" {
+			lowerEmails = append(lowerEmails, strings.ToLower(client.Email))
 		}
 	}
-	return false
-}
-
-func (s *InboundService) checkEmailsExistForClients(clients []model.Client) (string, error) {
-	allEmails, err := s.getAllEmails()
+	if len(lowerEmails) == 0 {
+		return "", nil
+	}
+	var taken []string
+	err := database.GetDB().Model(model.InboundClient{}).Where("lower(email) IN ?", lowerEmails).
+		Pluck("lower(email)", &taken).Error
 	if err != nil {
 		return "", err
 	}
-	var emails []string
+	seen := make(map[string]bool, len(lowerEmails)+len(taken))
+	for _, email := range taken {
+		seen[email] = true
+	}
 	for _, client := range clients {
 		if client.Email != "" {
-			if s.contains(emails, client.Email) {
-				return client.Email, nil
-			}
-			if s.contains(allEmails, client.Email) {
+			lower := strings.ToLower(client.Email)
+			if seen[lower] {
 				return client.Email, nil
 			}
-			emails = append(emails, client.Email)
+			seen[lower] = true
 		}
 	}
 	return "", nil
@@ -204,23 +260,7 @@ func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (stri
 	if err != nil {
 		return "", err
 	}
-	allEmails, err := s.getAllEmails()
-	if err != nil {
-		return "", err
-	}
-	var emails []string
-	for _, client := range clients {
-		if client.Email != "" {
-			if s.contains(emails, client.Email) {
-				return client.Email, nil
-			}
-			if s.contains(allEmails, client.Email) {
-				return client.Email, nil
-			}
-			emails = append(emails, client.Email)
-		}
-	}
-	return "", nil
+	return s.checkEmailsExistForClients(clients)
 }
 
 // AddInbound creates a new inbound configuration.
@@ -228,6 +268,9 @@ func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (stri
 // then saves the inbound to the database and optionally adds it to the running Xray instance.
 // Returns the created inbound, whether Xray needs restart, and any error.
 func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+	if err := s.checkSubTemplates(inbound); err != nil {
+		return inbound, false, err
+	}
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, 0)
 	if err != nil {
 		return inbound, false, err
@@ -293,9 +336,12 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 
 	db := database.GetDB()
 	tx := db.Begin()
+	events := &webhookBatch{}
 	defer func() {
 		if err == nil {
-			tx.Commit()
+			if tx.Commit().Error == nil {
+				events.emit()
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -311,6 +357,10 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 	} else {
 		return inbound, false, err
 	}
+	err = s.syncInboundClients(tx, inbound)
+	if err != nil {
+		return inbound, false, err
+	}
 
 	needRestart := false
 	if inbound.Enable {
@@ -337,6 +387,7 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		}
 	}
 
+	s.emitClientsCreated(events, inbound, clients)
 	return inbound, needRestart, err
 }
 
@@ -376,19 +427,18 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	inbound, err := s.GetInbound(id)
+	_, err = s.GetInbound(id)
 	if err != nil {
 		return false, err
 	}
-	clients, err := s.GetClients(inbound)
+	err = db.Where("client_email IN (?)", db.Model(model.InboundClient{}).Select("email").Where("inbound_id = ?", id)).
+		Delete(model.InboundClientIps{}).Error
 	if err != nil {
 		return false, err
 	}
-	for _, client := range clients {
-		err := s.DelClientIPs(db, client.Email)
-		if err != nil {
-			return false, err
-		}
+	err = db.Where("inbound_id = ?", id).Delete(model.InboundClient{}).Error
+	if err != nil {
+		return false, err
 	}
 
 	return needRestart, db.Delete(model.Inbound{}, id).Error
@@ -408,6 +458,9 @@ func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
 // It validates changes, updates the database, and syncs with the running Xray instance.
 // Returns the updated inbound, whether Xray needs restart, and any error.
 func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+	if err := s.checkSubTemplates(inbound); err != nil {
+		return inbound, false, err
+	}
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, inbound.Id)
 	if err != nil {
 		return inbound, false, err
@@ -426,11 +479,12 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	db := database.GetDB()
 	tx := db.Begin()
 
+	events := &webhookBatch{}
 	defer func() {
 		if err != nil {
 			tx.Rollback()
-		} else {
-			tx.Commit()
+		} else if tx.Commit().Error == nil {
+			events.emit()
 		}
 	}()
 
@@ -511,6 +565,8 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	oldInbound.Settings = inbound.Settings
 	oldInbound.StreamSettings = inbound.StreamSettings
 	oldInbound.Sniffing = inbound.Sniffing
+	oldInbound.SubRemarkTemplate = inbound.SubRemarkTemplate
+	oldInbound.SubJsonTemplate = inbound.SubJsonTemplate
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		oldInbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
 	} else {
@@ -544,7 +600,20 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		s.xrayApi.Close()
 	}
 
-	return inbound, needRestart, tx.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	if err == nil {
+		err = s.syncInboundClients(tx, oldInbound)
+	}
+	if err == nil {
+		events.add(model.WebhookEventInboundUpdated, map[string]any{
+			"inboundId": oldInbound.Id,
+			"tag":       oldInbound.Tag,
+			"oldTag":    tag,
+			"remark":    oldInbound.Remark,
+			"enable":    oldInbound.Enable,
+		})
+	}
+	return inbound, needRestart, err
 }
 
 func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inbound, newInbound *model.Inbound) error {
@@ -653,29 +722,32 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		return false, err
 	}
 
-	oldClients := oldSettings["clients"].([]any)
-	oldClients = append(oldClients, interfaceClients...)
-
-	oldSettings["clients"] = oldClients
-
-	newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-
-	oldInbound.Settings = string(newSettings)
-
 	db := database.GetDB()
 	tx := db.Begin()
 
+	events := &webhookBatch{}
 	defer func() {
 		if err != nil {
 			tx.Rollback()
-		} else {
-			tx.Commit()
+		} else if tx.Commit().Error == nil {
+			events.emit()
 		}
 	}()
 
+	position, err := s.nextClientPosition(tx, oldInbound.Id)
+	if err != nil {
+		return false, err
+	}
+	for _, interfaceClient := range interfaceClients {
+		if c, ok := interfaceClient.(map[string]any); ok {
+			err = tx.Create(model.NewInboundClient(oldInbound.Id, position, c)).Error
+			if err != nil {
+				return false, err
+			}
+			position++
+		}
+	}
+
 	needRestart := false
 	canUseAPI, errInit := s.initXrayAPI()
 	if !canUseAPI {
@@ -716,7 +788,11 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		s.xrayApi.Close()
 	}
 
-	return needRestart, tx.Save(oldInbound).Error
+	err = s.saveClientSettings(tx, oldInbound)
+	if err == nil {
+		s.emitClientsCreated(events, oldInbound, clients)
+	}
+	return needRestart, err
 }
 
 func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
@@ -725,48 +801,19 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 		logger.Error("Load Old Data Error")
 		return false, err
 	}
-	var settings map[string]any
-	err = json.Unmarshal([]byte(oldInbound.Settings), &settings)
+	db := database.GetDB()
+	client, err := s.findInboundClient(db, oldInbound, clientId)
 	if err != nil {
 		return false, err
 	}
-
-	email := ""
-	client_key := "id"
-	if oldInbound.Protocol == "trojan" {
-		client_key = "password"
-	}
-	if oldInbound.Protocol == "shadowsocks" {
-		client_key = "email"
-	}
-
-	interfaceClients := settings["clients"].([]any)
-	var newClients []any
-	needApiDel := false
-	for _, client := range interfaceClients {
-		c := client.(map[string]any)
-		c_id := c[client_key].(string)
-		if c_id == clientId {
-			email, _ = c["email"].(string)
-			needApiDel, _ = c["enable"].(bool)
-		} else {
-			newClients = append(newClients, client)
-		}
+	if client == nil {
+		return false, common.NewError("Client Not Found:", clientId)
 	}
-
-	if len(newClients) == 0 {
-		return false, common.NewError("no client remained in Inbound")
-	}
-
-	settings["clients"] = newClients
-	newSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
+	if err = s.checkClientRemains(db, oldInbound.Id); err != nil {
 		return false, err
 	}
-
-	oldInbound.Settings = string(newSettings)
-
-	db := database.GetDB()
+	email := client.Email
+	needApiDel := client.Enable
 
 	err = s.DelClientIPs(db, email)
 	if err != nil {
@@ -809,7 +856,29 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			}
 		}
 	}
-	return needRestart, db.Save(oldInbound).Error
+	return needRestart, s.delInboundClientRow(db, oldInbound, client)
+}
+
+// checkClientRemains returns an error if deleting a client would leave the inbound without clients.
+func (s *InboundService) checkClientRemains(tx *gorm.DB, inboundId int) error {
+	var count int64
+	if err := tx.Model(model.InboundClient{}).Where("inbound_id = ?", inboundId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count <= 1 {
+		return common.NewError("no client remained in Inbound")
+	}
+	return nil
+}
+
+// delInboundClientRow deletes a client row and regenerates the clients of the inbound settings.
+func (s *InboundService) delInboundClientRow(db *gorm.DB, inbound *model.Inbound, client *model.InboundClient) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(client).Error; err != nil {
+			return err
+		}
+		return s.saveClientSettings(tx, inbound)
+	})
 }
 
 func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (bool, error) {
@@ -832,38 +901,22 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		return false, err
 	}
 
-	oldClients, err := s.GetClients(oldInbound)
+	db := database.GetDB()
+	oldClient, err := s.findInboundClient(db, oldInbound, clientId)
 	if err != nil {
 		return false, err
 	}
-
-	oldEmail := ""
-	newClientId := ""
-	clientIndex := -1
-	for index, oldClient := range oldClients {
-		oldClientId := ""
-		switch oldInbound.Protocol {
-		case "trojan":
-			oldClientId = oldClient.Password
-			newClientId = clients[0].Password
-		case "shadowsocks":
-			oldClientId = oldClient.Email
-			newClientId = clients[0].Email
-		default:
-			oldClientId = oldClient.ID
-			newClientId = clients[0].ID
-		}
-		if clientId == oldClientId {
-			oldEmail = oldClient.Email
-			clientIndex = index
-			break
-		}
+	newClient, ok := interfaceClients[0].(map[string]any)
+	if !ok {
+		return false, common.NewError("invalid client format")
 	}
+	client := model.NewInboundClient(oldInbound.Id, 0, newClient)
 
 	// Validate new client ID
-	if newClientId == "" || clientIndex == -1 {
+	if oldClient == nil || client.Key(oldInbound.Protocol) == "" {
 		return false, common.NewError("empty client ID")
 	}
+	oldEmail := oldClient.Email
 
 	if len(clients[0].Email) > 0 && clients[0].Email != oldEmail {
 		existEmail, err := s.checkEmailsExistForClients(clients)
@@ -880,43 +933,24 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	if err != nil {
 		return false, err
 	}
-	settingsClients := oldSettings["clients"].([]any)
-	// Preserve created_at and set updated_at for the replacing client
-	var preservedCreated any
-	if clientIndex >= 0 && clientIndex < len(settingsClients) {
-		if oldMap, ok := settingsClients[clientIndex].(map[string]any); ok {
-			if v, ok2 := oldMap["created_at"]; ok2 {
-				preservedCreated = v
-			}
-		}
-	}
-	if len(interfaceClients) > 0 {
-		if newMap, ok := interfaceClients[0].(map[string]any); ok {
-			if preservedCreated == nil {
-				preservedCreated = time.Now().Unix() * 1000
-			}
-			newMap["created_at"] = preservedCreated
-			newMap["updated_at"] = time.Now().Unix() * 1000
-			interfaceClients[0] = newMap
-		}
-	}
-	settingsClients[clientIndex] = interfaceClients[0]
-	oldSettings["clients"] = settingsClients
-
-	newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
-	if err != nil {
-		return false, err
+	// Keep the row, its position, user and created_at, and set updated_at for the replacing client
+	client.Id = oldClient.Id
+	client.Position = oldClient.Position
+	client.UserId = oldClient.UserId
+	client.CreatedAt = oldClient.CreatedAt
+	if client.CreatedAt == 0 {
+		client.CreatedAt = time.Now().Unix() * 1000
 	}
+	client.UpdatedAt = time.Now().Unix() * 1000
 
-	oldInbound.Settings = string(newSettings)
-	db := database.GetDB()
 	tx := db.Begin()
 
+	events := &webhookBatch{}
 	defer func() {
 		if err != nil {
 			tx.Rollback()
-		} else {
-			tx.Commit()
+		} else if tx.Commit().Error == nil {
+			events.emit()
 		}
 	}()
 
@@ -954,7 +988,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			}
 			needRestart = true
 		} else {
-			if oldClients[clientIndex].Enable {
+			if oldClient.Enable {
 				if err1 := s.xrayApi.RemoveUser(oldInbound.Tag, oldEmail); err1 == nil {
 					logger.Debug("Old client deleted by api:", oldEmail)
 				} else if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", oldEmail)) {
@@ -989,7 +1023,14 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		logger.Debug("Client old email not found")
 		needRestart = true
 	}
-	return needRestart, tx.Save(oldInbound).Error
+	err = tx.Save(client).Error
+	if err == nil {
+		err = s.saveClientSettings(tx, oldInbound)
+	}
+	if err == nil && oldClient.Enable && !client.Enable {
+		s.emitClientDisabled(events, oldInbound.Id, clients[0].Email, "manual")
+	}
+	return needRestart, err
 }
 
 func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
@@ -997,11 +1038,12 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 	db := database.GetDB()
 	tx := db.Begin()
 
+	events := &webhookBatch{}
 	defer func() {
 		if err != nil {
 			tx.Rollback()
-		} else {
-			tx.Commit()
+		} else if tx.Commit().Error == nil {
+			events.emit()
 		}
 	}()
 	err = s.addInboundTraffic(tx, inboundTraffics)
@@ -1020,7 +1062,7 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 		logger.Debugf("%v clients renewed", count)
 	}
 
-	needRestart1, count, err := s.disableInvalidClients(tx)
+	needRestart1, count, err := s.disableInvalidClients(tx, events)
 	if err != nil {
 		logger.Warning("Error in disabling invalid clients:", err)
 	} else if count > 0 {
@@ -1120,8 +1162,16 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 
 func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.ClientTraffic) ([]*xray.ClientTraffic, error) {
 	inboundIds := make([]int, 0, len(dbClientTraffics))
+	now := time.Now().Unix() * 1000
 	for _, dbClientTraffic := range dbClientTraffics {
 		if dbClientTraffic.ExpiryTime < 0 {
+			// A negative expiry time is a duration that starts with the first use
+			dbClientTraffic.ExpiryTime = now - dbClientTraffic.ExpiryTime
+			err := tx.Model(model.InboundClient{}).Where("email = ?", dbClientTraffic.Email).
+				Updates(map[string]any{"expiry_time": dbClientTraffic.ExpiryTime, "updated_at": now}).Error
+			if err != nil {
+				return nil, err
+			}
 			inboundIds = append(inboundIds, dbClientTraffic.InboundId)
 		}
 	}
@@ -1132,45 +1182,11 @@ func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.Cl
 		if err != nil {
 			return nil, err
 		}
-		for inbound_index := range inbounds {
-			settings := map[string]any{}
-			json.Unmarshal([]byte(inbounds[inbound_index].Settings), &settings)
-			clients, ok := settings["clients"].([]any)
-			if ok {
-				var newClients []any
-				for client_index := range clients {
-					c := clients[client_index].(map[string]any)
-					for traffic_index := range dbClientTraffics {
-						if dbClientTraffics[traffic_index].ExpiryTime < 0 && c["email"] == dbClientTraffics[traffic_index].Email {
-							oldExpiryTime := c["expiryTime"].(float64)
-							newExpiryTime := (time.Now().Unix() * 1000) - int64(oldExpiryTime)
-							c["expiryTime"] = newExpiryTime
-							c["updated_at"] = time.Now().Unix() * 1000
-							dbClientTraffics[traffic_index].ExpiryTime = newExpiryTime
-							break
-						}
-					}
-					// Backfill created_at and updated_at
-					if _, ok := c["created_at"]; !ok {
-						c["created_at"] = time.Now().Unix() * 1000
-					}
-					c["updated_at"] = time.Now().Unix() * 1000
-					newClients = append(newClients, any(c))
-				}
-				settings["clients"] = newClients
-				modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-				if err != nil {
-					return nil, err
-				}
-
-				inbounds[inbound_index].Settings = string(modifiedSettings)
+		for _, inbound := range inbounds {
+			if err := s.saveClientSettings(tx, inbound); err != nil {
+				logger.Warning("AddClientTraffic update inbounds ", err)
 			}
 		}
-		err = tx.Save(inbounds).Error
-		if err != nil {
-			logger.Warning("AddClientTraffic update inbounds ", err)
-			logger.Error(inbounds)
-		}
 	}
 
 	return dbClientTraffics, nil
@@ -1207,50 +1223,53 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 	if err != nil {
 		return false, 0, err
 	}
-	for inbound_index := range inbounds {
-		settings := map[string]any{}
-		json.Unmarshal([]byte(inbounds[inbound_index].Settings), &settings)
-		clients := settings["clients"].([]any)
-		for client_index := range clients {
-			c := clients[client_index].(map[string]any)
-			for traffic_index, traffic := range traffics {
-				if traffic.Email == c["email"].(string) {
-					newExpiryTime := traffic.ExpiryTime
-					for newExpiryTime < now {
-						newExpiryTime += (int64(traffic.Reset) * 86400000)
-					}
-					c["expiryTime"] = newExpiryTime
-					traffics[traffic_index].ExpiryTime = newExpiryTime
-					traffics[traffic_index].Down = 0
-					traffics[traffic_index].Up = 0
-					if !traffic.Enable {
-						traffics[traffic_index].Enable = true
-						clientsToAdd = append(clientsToAdd,
-							struct {
-								protocol string
-								tag      string
-								client   map[string]any
-							}{
-								protocol: string(inbounds[inbound_index].Protocol),
-								tag:      inbounds[inbound_index].Tag,
-								client:   c,
-							})
-					}
-					clients[client_index] = any(c)
-					break
+	inboundMap := make(map[int]*model.Inbound, len(inbounds))
+	for _, inbound := range inbounds {
+		inboundMap[inbound.Id] = inbound
+	}
+	for traffic_index, traffic := range traffics {
+		newExpiryTime := traffic.ExpiryTime
+		for newExpiryTime < now {
+			newExpiryTime += (int64(traffic.Reset) * 86400000)
+		}
+		var clients []*model.InboundClient
+		err = tx.Where("email = ?", traffic.Email).Find(&clients).Error
+		if err != nil {
+			return false, 0, err
+		}
+		err = tx.Model(model.InboundClient{}).Where("email = ?", traffic.Email).Update("expiry_time", newExpiryTime).Error
+		if err != nil {
+			return false, 0, err
+		}
+		traffics[traffic_index].ExpiryTime = newExpiryTime
+		traffics[traffic_index].Down = 0
+		traffics[traffic_index].Up = 0
+		if !traffic.Enable {
+			traffics[traffic_index].Enable = true
+			for _, client := range clients {
+				inbound, ok := inboundMap[client.InboundId]
+				if !ok {
+					continue
 				}
+				client.ExpiryTime = newExpiryTime
+				clientsToAdd = append(clientsToAdd,
+					struct {
+						protocol string
+						tag      string
+						client   map[string]any
+					}{
+						protocol: string(inbound.Protocol),
+						tag:      inbound.Tag,
+						client:   client.ClientMap(),
+					})
 			}
 		}
-		settings["clients"] = clients
-		newSettings, err := json.MarshalIndent(settings, "", "  ")
+	}
+	for _, inbound := range inbounds {
+		err = s.saveClientSettings(tx, inbound)
 		if err != nil {
 			return false, 0, err
 		}
-		inbounds[inbound_index].Settings = string(newSettings)
-	}
-	err = tx.Save(inbounds).Error
-	if err != nil {
-		return false, 0, err
 	}
 	err = tx.Save(traffics).Error
 	if err != nil {
@@ -1313,10 +1332,26 @@ func (s *InboundService) disableInvalidInbounds(tx *gorm.DB) (bool, int64, error
 	return needRestart, count, err
 }
 
-func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error) {
+func (s *InboundService) disableInvalidClients(tx *gorm.DB, events *webhookBatch) (bool, int64, error) {
 	now := time.Now().Unix() * 1000
 	needRestart := false
 
+	// Clients of users are also disabled by the shared limits of their user
+	userClients, err := s.invalidUserClients(tx, now)
+	if err != nil {
+		return false, 0, err
+	}
+	userEmails := make([]string, 0, len(userClients))
+	for email := range userClients {
+		userEmails = append(userEmails, email)
+	}
+	invalid := "((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?))"
+	invalidArgs := []any{now}
+	if len(userEmails) > 0 {
+		invalid = "(" + invalid + " OR client_traffics.email IN ?)"
+		invalidArgs = append(invalidArgs, userEmails)
+	}
+
 	if p != nil {
 		var results []struct {
 			Tag   string
@@ -1326,7 +1361,8 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 		err := tx.Table("inbounds").
 			Select("inbounds.tag, client_traffics.email").
 			Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
-			Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
+			Where(invalid, invalidArgs...).
+			Where("client_traffics.enable = ?", true).
 			Scan(&results).Error
 		if err != nil {
 			return false, 0, err
@@ -1351,14 +1387,69 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 			needRestart = true
 		}
 	}
+	var disabled []*xray.ClientTraffic
+	err = tx.Model(xray.ClientTraffic{}).
+		Where(invalid, invalidArgs...).
+		Where("enable = ?", true).
+		Find(&disabled).Error
+	if err != nil {
+		return needRestart, 0, err
+	}
 	result := tx.Model(xray.ClientTraffic{}).
-		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
+		Where(invalid, invalidArgs...).
+		Where("enable = ?", true).
 		Update("enable", false)
-	err := result.Error
+	err = result.Error
 	count := result.RowsAffected
+	if err == nil {
+		for _, traffic := range disabled {
+			reason, ok := userClients[traffic.Email]
+			if !ok {
+				reason = "expired"
+				if traffic.Total > 0 && traffic.Up+traffic.Down >= traffic.Total {
+					reason = "depleted"
+				}
+			}
+			event := model.WebhookEventClientExpired
+			if reason == "depleted" {
+				event = model.WebhookEventClientDepleted
+			}
+			if reason != "manual" {
+				events.add(event, map[string]any{
+					"inboundId":  traffic.InboundId,
+					"email":      traffic.Email,
+					"up":         traffic.Up,
+					"down":       traffic.Down,
+					"total":      traffic.Total,
+					"expiryTime": traffic.ExpiryTime,
+				})
+			}
+			s.emitClientDisabled(events, traffic.InboundId, traffic.Email, reason)
+		}
+	}
 	return needRestart, count, err
 }
 
+// emitClientsCreated notifies webhooks about clients added to an inbound.
+func (s *InboundService) emitClientsCreated(events *webhookBatch, inbound *model.Inbound, clients []model.Client) {
+	for _, client := range clients {
+		events.add(model.WebhookEventClientCreated, map[string]any{
+			"inboundId": inbound.Id,
+			"tag":       inbound.Tag,
+			"client":    client,
+		})
+	}
+}
+
+// emitClientDisabled notifies webhooks about a disabled client. reason is "manual", "depleted" or "expired".
+func (s *InboundService) emitClientDisabled(events *webhookBatch, inboundId int, email string, reason string) {
+	events.add(model.WebhookEventClientDisabled, map[string]any{
+		"inboundId": inboundId,
+		"email":     email,
+		"reason":    reason,
+	})
+}
+
 func (s *InboundService) GetInboundTags() (string, error) {
 	db := database.GetDB()
 	var inboundTags []string
@@ -1374,11 +1465,7 @@ func (s *InboundService) MigrationRemoveOrphanedTraffics() {
 	db := database.GetDB()
 	db.Exec(`
 		DELETE FROM client_traffics
-		WHERE email NOT IN (
-			SELECT JSON_EXTRACT(client.value, '$.email')
-			FROM inbounds,
-				JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		)
+		WHERE email NOT IN (SELECT email FROM inbound_clients)
 	`)
 }
 
@@ -1454,174 +1541,69 @@ func (s *InboundService) GetClientInboundByEmail(email string) (traffic *xray.Cl
 }
 
 func (s *InboundService) GetClientByEmail(clientEmail string) (*xray.ClientTraffic, *model.Client, error) {
-	traffic, inbound, err := s.GetClientInboundByEmail(clientEmail)
+	traffic, err := s.getClientTraffic(clientEmail)
 	if err != nil {
 		return nil, nil, err
 	}
-	if inbound == nil {
+	if traffic == nil {
 		return nil, nil, common.NewError("Inbound Not Found For Email:", clientEmail)
 	}
 
-	clients, err := s.GetClients(inbound)
+	client, err := s.GetInboundClientByEmail(clientEmail)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	for _, client := range clients {
-		if client.Email == clientEmail {
-			return traffic, &client, nil
-		}
+	if client == nil {
+		return nil, nil, common.NewError("Client Not Found In Inbound For Email:", clientEmail)
 	}
+	c := client.Client()
+	return traffic, &c, nil
+}
 
-	return nil, nil, common.NewError("Client Not Found In Inbound For Email:", clientEmail)
+// getClientTraffic retrieves the traffic record of a client. Returns nil if there is none.
+func (s *InboundService) getClientTraffic(email string) (*xray.ClientTraffic, error) {
+	var traffics []*xray.ClientTraffic
+	err := database.GetDB().Model(xray.ClientTraffic{}).Where("email = ?", email).Limit(1).Find(&traffics).Error
+	if err != nil || len(traffics) == 0 {
+		return nil, err
+	}
+	return traffics[0], nil
 }
 
 func (s *InboundService) SetClientTelegramUserID(trafficId int, tgId int64) (bool, error) {
-	traffic, inbound, err := s.GetClientInboundByTrafficID(trafficId)
+	traffic, _, err := s.GetClientInboundByTrafficID(trafficId)
 	if err != nil {
 		return false, err
 	}
-	if inbound == nil {
+	if traffic == nil {
 		return false, common.NewError("Inbound Not Found For Traffic ID:", trafficId)
 	}
-
-	clientEmail := traffic.Email
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal([]byte(inbound.Settings), &settings)
-	if err != nil {
-		return false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["tgId"] = tgId
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-	inbound.Settings = string(modifiedSettings)
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return s.updateClientByEmail(traffic.Email, func(c map[string]any) {
+		c["tgId"] = tgId
+		c["updated_at"] = time.Now().Unix() * 1000
+	})
 }
 
 func (s *InboundService) checkIsEnabledByEmail(clientEmail string) (bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
+	client, err := s.GetInboundClientByEmail(clientEmail)
 	if err != nil {
 		return false, err
 	}
-	if inbound == nil {
+	if client == nil {
 		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
 	}
-
-	clients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	isEnable := false
-
-	for _, client := range clients {
-		if client.Email == clientEmail {
-			isEnable = client.Enable
-			break
-		}
-	}
-
-	return isEnable, err
+	return client.Enable, nil
 }
 
 func (s *InboundService) ToggleClientEnableByEmail(clientEmail string) (bool, bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
+	clientOldEnabled, err := s.checkIsEnabledByEmail(clientEmail)
 	if err != nil {
 		return false, false, err
 	}
-	if inbound == nil {
-		return false, false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, false, err
-	}
-
-	clientId := ""
-	clientOldEnabled := false
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			clientOldEnabled = oldClient.Enable
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal([]byte(inbound.Settings), &settings)
-	if err != nil {
-		return false, false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["enable"] = !clientOldEnabled
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, false, err
-	}
-	inbound.Settings = string(modifiedSettings)
-
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
+	needRestart, err := s.updateClientByEmail(clientEmail, func(c map[string]any) {
+		c["enable"] = !clientOldEnabled
+		c["updated_at"] = time.Now().Unix() * 1000
+	})
 	if err != nil {
 		return false, needRestart, err
 	}
@@ -1646,183 +1628,27 @@ func (s *InboundService) SetClientEnableByEmail(clientEmail string, enable bool)
 }
 
 func (s *InboundService) ResetClientIpLimitByEmail(clientEmail string, count int) (bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
-	if err != nil {
-		return false, err
-	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal([]byte(inbound.Settings), &settings)
-	if err != nil {
-		return false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["limitIp"] = count
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-	inbound.Settings = string(modifiedSettings)
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return s.updateClientByEmail(clientEmail, func(c map[string]any) {
+		c["limitIp"] = count
+		c["updated_at"] = time.Now().Unix() * 1000
+	})
 }
 
 func (s *InboundService) ResetClientExpiryTimeByEmail(clientEmail string, expiry_time int64) (bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
-	if err != nil {
-		return false, err
-	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal([]byte(inbound.Settings), &settings)
-	if err != nil {
-		return false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["expiryTime"] = expiry_time
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-	inbound.Settings = string(modifiedSettings)
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return s.updateClientByEmail(clientEmail, func(c map[string]any) {
+		c["expiryTime"] = expiry_time
+		c["updated_at"] = time.Now().Unix() * 1000
+	})
 }
 
 func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, totalGB int) (bool, error) {
 	if totalGB < 0 {
 		return false, common.NewError("totalGB must be >= 0")
 	}
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
-	if err != nil {
-		return false, err
-	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal([]byte(inbound.Settings), &settings)
-	if err != nil {
-		return false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["totalGB"] = totalGB * 1024 * 1024 * 1024
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-	inbound.Settings = string(modifiedSettings)
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return s.updateClientByEmail(clientEmail, func(c map[string]any) {
+		c["totalGB"] = totalGB * 1024 * 1024 * 1024
+		c["updated_at"] = time.Now().Unix() * 1000
+	})
 }
 
 func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
@@ -1996,37 +1822,17 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 		if err != nil {
 			return err
 		}
-		var oldSettings map[string]any
-		err = json.Unmarshal([]byte(oldInbound.Settings), &oldSettings)
+		var remaining int64
+		err = tx.Model(model.InboundClient{}).Where("inbound_id = ? AND email NOT IN ?", oldInbound.Id, emails).Count(&remaining).Error
 		if err != nil {
 			return err
 		}
-
-		oldClients := oldSettings["clients"].([]any)
-		var newClients []any
-		for _, client := range oldClients {
-			deplete := false
-			c := client.(map[string]any)
-			for _, email := range emails {
-				if email == c["email"].(string) {
-					deplete = true
-					break
-				}
-			}
-			if !deplete {
-				newClients = append(newClients, client)
-			}
-		}
-		if len(newClients) > 0 {
-			oldSettings["clients"] = newClients
-
-			newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
+		if remaining > 0 {
+			err = tx.Where("inbound_id = ? AND email IN ?", oldInbound.Id, emails).Delete(model.InboundClient{}).Error
 			if err != nil {
 				return err
 			}
-
-			oldInbound.Settings = string(newSettings)
-			err = tx.Save(oldInbound).Error
+			err = s.saveClientSettings(tx, oldInbound)
 			if err != nil {
 				return err
 			}
@@ -2047,27 +1853,11 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 
 func (s *InboundService) GetClientTrafficTgBot(tgId int64) ([]*xray.ClientTraffic, error) {
 	db := database.GetDB()
-	var inbounds []*model.Inbound
-
-	// Retrieve inbounds where settings contain the given tgId
-	err := db.Model(model.Inbound{}).Where("settings LIKE ?", fmt.Sprintf(`%%"tgId": %d%%`, tgId)).Find(&inbounds).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
-		logger.Errorf("Error retrieving inbounds with tgId %d: %v", tgId, err)
-		return nil, err
-	}
-
 	var emails []string
-	for _, inbound := range inbounds {
-		clients, err := s.GetClients(inbound)
-		if err != nil {
-			logger.Errorf("Error retrieving clients for inbound %d: %v", inbound.Id, err)
-			continue
-		}
-		for _, client := range clients {
-			if client.TgID == tgId {
-				emails = append(emails, client.Email)
-			}
-		}
+	err := db.Model(model.InboundClient{}).Where("tg_id = ? AND email <> ''", tgId).Pluck("email", &emails).Error
+	if err != nil {
+		logger.Errorf("Error retrieving clients with tgId %d: %v", tgId, err)
+		return nil, err
 	}
 
 	var traffics []*xray.ClientTraffic
@@ -2128,13 +1918,9 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 	db := database.GetDB()
 	var traffics []xray.ClientTraffic
 
-	err := db.Model(xray.ClientTraffic{}).Where(`email IN(
-		SELECT JSON_EXTRACT(client.value, '$.email') as email
-		FROM inbounds,
-	  	JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		WHERE
-	  	JSON_EXTRACT(client.value, '$.id') in (?)
-		)`, id).Find(&traffics).Error
+	err := db.Model(xray.ClientTraffic{}).
+		Where("email IN (?)", db.Model(model.InboundClient{}).Select("email").Where("uuid = ?", id)).
+		Find(&traffics).Error
 
 	if err != nil {
 		logger.Debug(err)
@@ -2153,41 +1939,22 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 
 func (s *InboundService) SearchClientTraffic(query string) (traffic *xray.ClientTraffic, err error) {
 	db := database.GetDB()
-	inbound := &model.Inbound{}
 	traffic = &xray.ClientTraffic{}
 
-	// Search for inbound settings that contain the query
-	err = db.Model(model.Inbound{}).Where("settings LIKE ?", "%\""+query+"\"%").First(inbound).Error
+	// Search for a client with the query as id or password
+	client := &model.InboundClient{}
+	err = db.Model(model.InboundClient{}).Where("(uuid = ? OR password = ?) AND email <> ''", query, query).
+		Order("inbound_id, position").First(client).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			logger.Warningf("Inbound settings containing query %s not found: %v", query, err)
+			logger.Warningf("No client found with query %s", query)
 			return nil, err
 		}
-		logger.Errorf("Error searching for inbound settings with query %s: %v", query, err)
+		logger.Errorf("Error searching for client with query %s: %v", query, err)
 		return nil, err
 	}
-
-	traffic.InboundId = inbound.Id
-
-	// Unmarshal settings to get clients
-	settings := map[string][]model.Client{}
-	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
-		logger.Errorf("Error unmarshalling inbound settings for inbound ID %d: %v", inbound.Id, err)
-		return nil, err
-	}
-
-	clients := settings["clients"]
-	for _, client := range clients {
-		if (client.ID == query || client.Password == query) && client.Email != "" {
-			traffic.Email = client.Email
-			break
-		}
-	}
-
-	if traffic.Email == "" {
-		logger.Warningf("No client found with query %s in inbound ID %d", query, inbound.Id)
-		return nil, gorm.ErrRecordNotFound
-	}
+	traffic.InboundId = client.InboundId
+	traffic.Email = client.Email
 
 	// Retrieve ClientTraffic based on the found email
 	err = db.Model(xray.ClientTraffic{}).Where("email = ?", traffic.Email).First(traffic).Error
@@ -2341,6 +2108,12 @@ func (s *InboundService) MigrationRequirements() {
 	}
 	tx.Save(inbounds)
 
+	// Move clients from the inbound settings into the clients table
+	err = s.migrateInboundClients(tx)
+	if err != nil {
+		return
+	}
+
 	// Remove orphaned traffics
 	tx.Where("inbound_id = 0").Delete(xray.ClientTraffic{})
 
@@ -2455,50 +2228,20 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 		return false, err
 	}
 
-	var settings map[string]any
-	if err := json.Unmarshal([]byte(oldInbound.Settings), &settings); err != nil {
+	db := database.GetDB()
+	var clients []*model.InboundClient
+	err = db.Where("inbound_id = ? AND email = ?", inboundId, email).Limit(1).Find(&clients).Error
+	if err != nil {
 		return false, err
 	}
-
-	interfaceClients, ok := settings["clients"].([]any)
-	if !ok {
-		return false, common.NewError("invalid clients format in inbound settings")
-	}
-
-	var newClients []any
-	needApiDel := false
-	found := false
-
-	for _, client := range interfaceClients {
-		c, ok := client.(map[string]any)
-		if !ok {
-			continue
-		}
-		if cEmail, ok := c["email"].(string); ok && cEmail == email {
-			// matched client, drop it
-			found = true
-			needApiDel, _ = c["enable"].(bool)
-		} else {
-			newClients = append(newClients, client)
-		}
-	}
-
-	if !found {
+	if len(clients) == 0 {
 		return false, common.NewError(fmt.Sprintf("client with email %s not found", email))
 	}
-	if len(newClients) == 0 {
-		return false, common.NewError("no client remained in Inbound")
-	}
-
-	settings["clients"] = newClients
-	newSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
+	if err = s.checkClientRemains(db, inboundId); err != nil {
 		return false, err
 	}
-
-	oldInbound.Settings = string(newSettings)
-
-	db := database.GetDB()
+	client := clients[0]
+	needApiDel := client.Enable
 
 	// remove IP bindings
 	if err := s.DelClientIPs(db, email); err != nil {
@@ -2544,5 +2287,5 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 		}
 	}
 
-	return needRestart, db.Save(oldInbound).Error
+	return needRestart, s.delInboundClientRow(db, oldInbound, client)
 }
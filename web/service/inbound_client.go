@@ -0,0 +1,240 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// clientSyncKey identifies a client across edits of the whole inbound settings.
+func clientSyncKey(client *model.InboundClient, protocol model.Protocol) string {
+	if client.Email != "" {
+		return "email:" + strings.ToLower(client.Email)
+	}
+	return "key:" + client.Key(protocol)
+}
+
+// syncInboundClients makes the client rows of an inbound match the clients array of its
+// settings. It is used where the settings are written as a whole, e.g. by the inbound editor.
+// Rows of clients that are still present keep their id and user.
+func (s *InboundService) syncInboundClients(tx *gorm.DB, inbound *model.Inbound) error {
+	clients, err := model.ParseInboundClients(inbound)
+	if err != nil {
+		return err
+	}
+	var existing []*model.InboundClient
+	if err := tx.Where("inbound_id = ?", inbound.Id).Find(&existing).Error; err != nil {
+		return err
+	}
+	stale := make(map[string]*model.InboundClient, len(existing))
+	for _, client := range existing {
+		stale[clientSyncKey(client, inbound.Protocol)] = client
+	}
+	unchanged := make(map[*model.InboundClient]bool)
+	for _, client := range clients {
+		key := clientSyncKey(client, inbound.Protocol)
+		if old, ok := stale[key]; ok {
+			client.Id = old.Id
+			client.UserId = old.UserId
+			unchanged[client] = *client == *old
+			delete(stale, key)
+		}
+	}
+	if len(stale) > 0 {
+		ids := make([]int, 0, len(stale))
+		for _, client := range stale {
+			ids = append(ids, client.Id)
+		}
+		if err := tx.Where("id IN ?", ids).Delete(model.InboundClient{}).Error; err != nil {
+			return err
+		}
+	}
+	for _, client := range clients {
+		if unchanged[client] {
+			continue
+		}
+		if client.Id != 0 {
+			err = tx.Save(client).Error
+		} else {
+			err = tx.Create(client).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveClientSettings regenerates the clients array of the inbound settings from its client
+// rows and saves the settings. inbound.Settings is updated in place.
+func (s *InboundService) saveClientSettings(tx *gorm.DB, inbound *model.Inbound) error {
+	var clients []*model.InboundClient
+	err := tx.Where("inbound_id = ?", inbound.Id).Order("position, id").Find(&clients).Error
+	if err != nil {
+		return err
+	}
+	settings := map[string]any{}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return err
+	}
+	rawClients := make([]any, 0, len(clients))
+	for _, client := range clients {
+		rawClients = append(rawClients, client.ClientMap())
+	}
+	settings["clients"] = rawClients
+	newSettings, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = string(newSettings)
+	return tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", inbound.Settings).Error
+}
+
+// nextClientPosition returns the position after the last client of an inbound.
+func (s *InboundService) nextClientPosition(tx *gorm.DB, inboundId int) (int, error) {
+	var position int
+	err := tx.Model(model.InboundClient{}).Where("inbound_id = ?", inboundId).
+		Select("COALESCE(MAX(position), -1) + 1").Scan(&position).Error
+	return position, err
+}
+
+// GetInboundClients retrieves the clients of an inbound in the order of its settings.
+func (s *InboundService) GetInboundClients(inboundId int) ([]*model.InboundClient, error) {
+	var clients []*model.InboundClient
+	err := database.GetDB().Where("inbound_id = ?", inboundId).Order("position, id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// findInboundClient finds a client of an inbound by its protocol specific key, see model.InboundClient.Key.
+// Returns nil if there is no match.
+func (s *InboundService) findInboundClient(tx *gorm.DB, inbound *model.Inbound, clientId string) (*model.InboundClient, error) {
+	var clients []*model.InboundClient
+	err := tx.Where("inbound_id = ? AND "+model.ClientKeyColumn(inbound.Protocol)+" = ?", inbound.Id, clientId).
+		Limit(1).Find(&clients).Error
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return clients[0], nil
+}
+
+// GetInboundClientByEmail finds a client by its email. Returns nil if there is no match.
+func (s *InboundService) GetInboundClientByEmail(email string) (*model.InboundClient, error) {
+	var clients []*model.InboundClient
+	err := database.GetDB().Where("email = ?", email).Limit(1).Find(&clients).Error
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return clients[0], nil
+}
+
+// SearchClients finds clients whose email, id, password, subscription id or comment contain the query.
+func (s *InboundService) SearchClients(query string) ([]*model.InboundClient, error) {
+	like := "%" + escapeLike(query) + "%"
+	var clients []*model.InboundClient
+	err := database.GetDB().
+		Where(`email LIKE ? ESCAPE '\' OR uuid LIKE ? ESCAPE '\' OR password LIKE ? ESCAPE '\' OR sub_id LIKE ? ESCAPE '\' OR comment LIKE ? ESCAPE '\'`,
+			like, like, like, like, like).
+		Order("inbound_id, position, id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// getActiveClients retrieves the enabled clients that have neither used up their traffic
+// nor expired, grouped by inbound id and in the order of the inbound settings.
+func (s *InboundService) getActiveClients() (map[int][]*model.InboundClient, error) {
+	var clients []*model.InboundClient
+	err := database.GetDB().Model(model.InboundClient{}).
+		Where("enable = ?", true).
+		Where("NOT EXISTS (SELECT 1 FROM client_traffics WHERE client_traffics.email = inbound_clients.email AND client_traffics.enable = ?)", false).
+		Order("inbound_id, position, id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[int][]*model.InboundClient)
+	for _, client := range clients {
+		active[client.InboundId] = append(active[client.InboundId], client)
+	}
+	return active, nil
+}
+
+// AddClients adds clients to an inbound through AddInboundClient.
+func (s *InboundService) AddClients(inboundId int, clients []model.Client) (bool, error) {
+	settings, err := json.Marshal(map[string]any{"clients": clients})
+	if err != nil {
+		return false, err
+	}
+	return s.AddInboundClient(&model.Inbound{Id: inboundId, Settings: string(settings)})
+}
+
+// SetClientsEnable enables or disables clients of an inbound by email in one transaction.
+// Xray picks up the change on its next restart.
+func (s *InboundService) SetClientsEnable(inboundId int, emails []string, enable bool) error {
+	inbound, err := s.GetInbound(inboundId)
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(model.InboundClient{}).Where("inbound_id = ? AND email IN ?", inboundId, emails).
+			Updates(map[string]any{"enable": enable, "updated_at": time.Now().UnixMilli()}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(xray.ClientTraffic{}).Where("inbound_id = ? AND email IN ?", inboundId, emails).
+			Update("enable", enable).Error
+		if err != nil {
+			return err
+		}
+		return s.saveClientSettings(tx, inbound)
+	})
+}
+
+// updateClientByEmail applies update to the settings object of a client and saves it through UpdateInboundClient.
+func (s *InboundService) updateClientByEmail(email string, update func(client map[string]any)) (bool, error) {
+	client, err := s.GetInboundClientByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	if client == nil {
+		return false, common.NewError("Client Not Found For Email:", email)
+	}
+	inbound, err := s.GetInbound(client.InboundId)
+	if err != nil {
+		return false, err
+	}
+	clientMap := client.ClientMap()
+	update(clientMap)
+	settings, err := json.Marshal(map[string]any{"clients": []any{clientMap}})
+	if err != nil {
+		return false, err
+	}
+	inbound.Settings = string(settings)
+	return s.UpdateInboundClient(inbound, client.Key(inbound.Protocol))
+}
+
+// migrateInboundClients syncs the client rows of all inbounds with their settings. It fills
+// the clients table after an upgrade or after restoring an older database.
+func (s *InboundService) migrateInboundClients(tx *gorm.DB) error {
+	var inbounds []*model.Inbound
+	if err := tx.Model(model.Inbound{}).Find(&inbounds).Error; err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		if err := s.syncInboundClients(tx, inbound); err != nil {
+			logger.Warningf("Unable to migrate the clients of inbound %d: %v", inbound.Id, err)
+		}
+	}
+	return nil
+}
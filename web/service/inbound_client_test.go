@@ -0,0 +1,104 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// settingsClients returns the clients array of the stored inbound settings.
+func settingsClients(t *testing.T, s *InboundService, id int) []map[string]any {
+	t.Helper()
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		t.Fatalf("get inbound: %v", err)
+	}
+	var settings struct {
+		Clients []map[string]any `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	return settings.Clients
+}
+
+func TestInboundClientsTable(t *testing.T) {
+	setupTestDB(t)
+
+	s := &InboundService{}
+	inbound := &model.Inbound{
+		Enable:   true,
+		Port:     20001,
+		Protocol: model.Trojan,
+		Tag:      "inbound-20001",
+		Settings: `{"clients":[
+			{"password":"p1","email":"alice","enable":true},
+			{"password":"p2","email":"bob","enable":true}
+		],"fallbacks":[]}`,
+	}
+	if _, _, err := s.AddInbound(inbound); err != nil {
+		t.Fatalf("add inbound: %v", err)
+	}
+	clients, err := s.GetInboundClients(inbound.Id)
+	if err != nil || len(clients) != 2 || clients[0].Email != "alice" || clients[1].Password != "p2" {
+		t.Fatalf("expected rows for both clients, got %+v (%v)", clients, err)
+	}
+
+	if _, err := s.AddClients(inbound.Id, []model.Client{{Password: "p3", Email: "carol", Enable: true}}); err != nil {
+		t.Fatalf("add client: %v", err)
+	}
+	if _, err := s.AddClients(inbound.Id, []model.Client{{Password: "p4", Email: "ALICE", Enable: true}}); err == nil {
+		t.Fatal("expected duplicate email to be rejected")
+	}
+
+	if _, _, err := s.ToggleClientEnableByEmail("alice"); err != nil {
+		t.Fatalf("toggle client: %v", err)
+	}
+	if _, err := s.DelInboundClient(inbound.Id, "p2"); err != nil {
+		t.Fatalf("del client: %v", err)
+	}
+
+	raw := settingsClients(t, s, inbound.Id)
+	if len(raw) != 2 || raw[0]["email"] != "alice" || raw[1]["email"] != "carol" {
+		t.Fatalf("unexpected settings clients: %v", raw)
+	}
+	if raw[0]["enable"] != false {
+		t.Fatalf("expected alice to be disabled, got %v", raw[0])
+	}
+
+	found, err := s.SearchClients("car")
+	if err != nil || len(found) != 1 || found[0].Email != "carol" {
+		t.Fatalf("search: %+v (%v)", found, err)
+	}
+	active, err := s.getActiveClients()
+	if err != nil || len(active[inbound.Id]) != 1 || active[inbound.Id][0].Email != "carol" {
+		t.Fatalf("expected only carol to be active, got %+v (%v)", active[inbound.Id], err)
+	}
+
+	// Editing the whole inbound keeps the rows of unchanged clients
+	before, _ := s.GetInboundClientByEmail("carol")
+	inbound.Settings = `{"clients":[{"password":"p3","email":"carol","enable":true,"level":3},{"password":"p5","email":"dave","enable":true}]}`
+	if _, _, err := s.UpdateInbound(inbound); err != nil {
+		t.Fatalf("update inbound: %v", err)
+	}
+	after, _ := s.GetInboundClientByEmail("carol")
+	if after == nil || after.Id != before.Id {
+		t.Fatalf("expected carol to keep row %d, got %+v", before.Id, after)
+	}
+	if old, _ := s.GetInboundClientByEmail("alice"); old != nil {
+		t.Fatal("expected alice to be removed")
+	}
+
+	// Fields without a column survive client updates
+	if _, _, err := s.ToggleClientEnableByEmail("carol"); err != nil {
+		t.Fatalf("toggle client: %v", err)
+	}
+	raw = settingsClients(t, s, inbound.Id)
+	if len(raw) != 2 || raw[0]["enable"] != false || raw[0]["level"] != float64(3) {
+		t.Fatalf("expected carol disabled with her extra fields kept, got %v", raw)
+	}
+	if _, ok := raw[1]["id"]; ok {
+		t.Fatalf("trojan client must not get an id field: %v", raw[1])
+	}
+}
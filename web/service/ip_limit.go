@@ -0,0 +1,276 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// IpBanOutboundTag is the tag of the blackhole outbound that receives the connections of banned IPs.
+const IpBanOutboundTag = "ip-limit-ban"
+
+// IpLimitService bans the source IPs of clients beyond their IP limit when the xray IP limit
+// backend is selected. Bans are enforced by routing rules in the generated Xray config, which
+// reach the running Xray without a restart through its RoutingService API.
+type IpLimitService struct {
+	settingService SettingService
+	xrayService    XrayService
+}
+
+// IsXrayBackend reports whether IP limits are enforced by the panel with Xray routing rules
+// instead of by Fail2Ban.
+func (s *IpLimitService) IsXrayBackend() bool {
+	backend, err := s.settingService.GetIpLimitBackend()
+	return err == nil && backend == "xray"
+}
+
+// BanIps bans IPs of a client for the configured ban duration, extending the bans of IPs that are
+// banned already. It returns the number of IPs that were not banned before.
+func (s *IpLimitService) BanIps(email string, ips []string) (int, error) {
+	minutes, err := s.settingService.GetIpLimitBanMinutes()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(max(minutes, 1)) * time.Minute).UnixMilli()
+	added := 0
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, ip := range ips {
+			ban := &model.IpBan{}
+			err := tx.Where("email = ? AND ip = ?", email, ip).First(ban).Error
+			if err == gorm.ErrRecordNotFound {
+				ban = &model.IpBan{Email: email, Ip: ip, CreatedAt: now.UnixMilli()}
+			} else if err != nil {
+				return err
+			}
+			if ban.ExpiresAt <= now.UnixMilli() {
+				ban.CreatedAt = now.UnixMilli()
+				added++
+			}
+			ban.ExpiresAt = expiresAt
+			if err := tx.Save(ban).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if added > 0 {
+		logger.Infof("Banned %d IPs of %s beyond its IP limit", added, email)
+		s.xrayService.SetToNeedRestart()
+	}
+	return added, nil
+}
+
+// GetBans retrieves the active bans of all clients, or of the client with the email if it is not
+// empty, newest first.
+func (s *IpLimitService) GetBans(email string) ([]*model.IpBan, error) {
+	bans := make([]*model.IpBan, 0)
+	db := database.GetDB().Where("expires_at > ?", time.Now().UnixMilli())
+	if email != "" {
+		db = db.Where("email = ?", email)
+	}
+	err := db.Order("created_at desc, id desc").Find(&bans).Error
+	return bans, err
+}
+
+// Unban lifts the bans of a client, or only the ban of an IP if ip is not empty, and returns the
+// number of lifted bans.
+func (s *IpLimitService) Unban(email string, ip string) (int64, error) {
+	db := database.GetDB().Where("email = ?", email)
+	if ip != "" {
+		db = db.Where("ip = ?", ip)
+	}
+	result := db.Delete(model.IpBan{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		s.xrayService.SetToNeedRestart()
+	}
+	return result.RowsAffected, nil
+}
+
+// DelExpiredBans removes the expired bans, so that the Xray config stops blocking them.
+func (s *IpLimitService) DelExpiredBans() (int64, error) {
+	result := database.GetDB().Where("expires_at <= ?", time.Now().UnixMilli()).Delete(model.IpBan{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		s.xrayService.SetToNeedRestart()
+	}
+	return result.RowsAffected, nil
+}
+
+// applyIpBans adds the routing rules of the active IP bans to a generated Xray config when the
+// xray IP limit backend is selected. The connections of a client from its banned IPs are routed
+// to a blackhole outbound. The rules change without restarting Xray through the RoutingService
+// API, see enableRoutingService. Bans the config has already, e.g. a last good config, are
+// replaced.
+func (s *XrayService) applyIpBans(xrayConfig *xray.Config) error {
+	if err := stripIpBans(xrayConfig); err != nil {
+		return err
+	}
+	backend, err := s.settingService.GetIpLimitBackend()
+	if err != nil || backend != "xray" {
+		return err
+	}
+
+	var bans []*model.IpBan
+	err = database.GetDB().Where("expires_at > ?", time.Now().UnixMilli()).Order("email, ip").Find(&bans).Error
+	if err != nil || len(bans) == 0 {
+		return err
+	}
+	var outbounds []json_util.RawMessage
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+			return err
+		}
+	}
+	if len(outbounds) == 0 {
+		// the blackhole outbound would become the default outbound
+		logger.Warning("IP bans are not enforced without an outbound")
+		return nil
+	}
+	outbounds = append(outbounds, json_util.RawMessage(`{"tag": "`+IpBanOutboundTag+`", "protocol": "blackhole"}`))
+	data, err := json.MarshalIndent(outbounds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	routing := map[string]json_util.RawMessage{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	var rules []json_util.RawMessage
+	for i := 0; i < len(bans); {
+		email := bans[i].Email
+		var ips []string
+		for ; i < len(bans) && bans[i].Email == email; i++ {
+			ips = append(ips, bans[i].Ip)
+		}
+		rule, err := json.Marshal(map[string]any{
+			"type":        "field",
+			"user":        []string{email},
+			"source":      ips,
+			"outboundTag": IpBanOutboundTag,
+		})
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	var templateRules []json_util.RawMessage
+	if len(routing["rules"]) > 0 {
+		if err := json.Unmarshal(routing["rules"], &templateRules); err != nil {
+			return err
+		}
+	}
+	routing["rules"], err = json.Marshal(append(rules, templateRules...))
+	if err != nil {
+		return err
+	}
+	routerConfig, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.OutboundConfigs = data
+	xrayConfig.RouterConfig = routerConfig
+	return nil
+}
+
+// stripIpBans removes the blackhole outbound and the routing rules of IP bans from an Xray config.
+// Outbounds and routing that are set are rewritten even without bans, so that a config stripped of
+// its bans is the same as one that never had them.
+func stripIpBans(xrayConfig *xray.Config) error {
+	var outbounds []json_util.RawMessage
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+			return err
+		}
+	}
+	if outbounds != nil {
+		kept := make([]json_util.RawMessage, 0, len(outbounds))
+		for _, outbound := range outbounds {
+			var tagged struct {
+				Tag string `json:"tag"`
+			}
+			if json.Unmarshal(outbound, &tagged) == nil && tagged.Tag == IpBanOutboundTag {
+				continue
+			}
+			kept = append(kept, outbound)
+		}
+		data, err := json.MarshalIndent(kept, "", "  ")
+		if err != nil {
+			return err
+		}
+		xrayConfig.OutboundConfigs = data
+	}
+
+	var routing map[string]json_util.RawMessage
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	if routing == nil {
+		return nil
+	}
+	var rules []json_util.RawMessage
+	if len(routing["rules"]) > 0 {
+		if err := json.Unmarshal(routing["rules"], &rules); err != nil {
+			return err
+		}
+	}
+	kept := make([]json_util.RawMessage, 0, len(rules))
+	for _, rule := range rules {
+		var routed struct {
+			OutboundTag string `json:"outboundTag"`
+		}
+		if json.Unmarshal(rule, &routed) == nil && routed.OutboundTag == IpBanOutboundTag {
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	delete(routing, "rules")
+	if len(kept) > 0 {
+		data, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+		routing["rules"] = data
+	}
+	if len(routing) == 0 {
+		xrayConfig.RouterConfig = nil
+		return nil
+	}
+	data, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = data
+	return nil
+}
+
+// xrayConfigHash returns the hash of an Xray config without its IP bans. Bans come and go while
+// Xray runs, they are applied through the RoutingService API and must not count as a new config
+// for the supervisor, which would restart the stable time with every ban.
+func xrayConfigHash(xrayConfig *xray.Config) string {
+	stripped := *xrayConfig
+	if err := stripIpBans(&stripped); err != nil {
+		return xrayConfig.Hash()
+	}
+	return stripped.Hash()
+}
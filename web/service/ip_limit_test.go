@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestIpBans(t *testing.T) {
+	setupTestDB(t)
+
+	s := &IpLimitService{}
+	if s.IsXrayBackend() {
+		t.Fatal("expected Fail2Ban to be the default backend")
+	}
+	if err := s.settingService.saveSetting("ipLimitBackend", "xray"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsXrayBackend() {
+		t.Fatal("expected the xray backend")
+	}
+
+	if added, err := s.BanIps("alice", []string{"1.2.3.4", "5.6.7.8"}); err != nil || added != 2 {
+		t.Fatalf("expected 2 bans, got %d (%v)", added, err)
+	}
+	if added, err := s.BanIps("alice", []string{"5.6.7.8"}); err != nil || added != 0 {
+		t.Fatalf("expected the ban to be extended, got %d (%v)", added, err)
+	}
+	if added, err := s.BanIps("bob", []string{"5.6.7.8"}); err != nil || added != 1 {
+		t.Fatalf("expected 1 ban, got %d (%v)", added, err)
+	}
+	if bans, err := s.GetBans("alice"); err != nil || len(bans) != 2 {
+		t.Fatalf("unexpected bans of alice %+v (%v)", bans, err)
+	}
+
+	template := func() *xray.Config {
+		return &xray.Config{
+			API:             []byte(`{"tag": "api", "services": ["HandlerService"]}`),
+			OutboundConfigs: []byte(`[{"tag": "direct", "protocol": "freedom"}]`),
+			RouterConfig:    []byte(`{"rules": [{"type": "field", "inboundTag": ["api"], "outboundTag": "api"}], "domainStrategy": "AsIs"}`),
+		}
+	}
+	config := template()
+	x := &XrayService{}
+	if err := x.applyIpBans(config); err != nil {
+		t.Fatal(err)
+	}
+	// applying the bans again, like to a rolled back config, replaces them
+	if err := x.applyIpBans(config); err != nil {
+		t.Fatal(err)
+	}
+	var outbounds []struct {
+		Tag string `json:"tag"`
+	}
+	if err := json.Unmarshal(config.OutboundConfigs, &outbounds); err != nil || len(outbounds) != 2 ||
+		outbounds[0].Tag != "direct" || outbounds[1].Tag != IpBanOutboundTag {
+		t.Fatalf("unexpected outbounds %s (%v)", config.OutboundConfigs, err)
+	}
+	var routing struct {
+		DomainStrategy string `json:"domainStrategy"`
+		Rules          []struct {
+			User        []string `json:"user"`
+			Source      []string `json:"source"`
+			OutboundTag string   `json:"outboundTag"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(config.RouterConfig, &routing); err != nil || routing.DomainStrategy != "AsIs" || len(routing.Rules) != 3 {
+		t.Fatalf("unexpected routing %s (%v)", config.RouterConfig, err)
+	}
+	if rule := routing.Rules[0]; rule.User[0] != "alice" || strings.Join(rule.Source, ",") != "1.2.3.4,5.6.7.8" || rule.OutboundTag != IpBanOutboundTag {
+		t.Fatalf("unexpected rule of alice %+v", rule)
+	}
+	if rule := routing.Rules[1]; rule.User[0] != "bob" || strings.Join(rule.Source, ",") != "5.6.7.8" {
+		t.Fatalf("unexpected rule of bob %+v", rule)
+	}
+	if routing.Rules[2].OutboundTag != "api" {
+		t.Fatal("expected the template rules to follow the bans")
+	}
+
+	if count, err := s.Unban("alice", "1.2.3.4"); err != nil || count != 1 {
+		t.Fatalf("expected 1 lifted ban, got %d (%v)", count, err)
+	}
+	if count, err := s.Unban("alice", ""); err != nil || count != 1 {
+		t.Fatalf("expected 1 lifted ban, got %d (%v)", count, err)
+	}
+
+	past := time.Now().Add(-time.Minute).UnixMilli()
+	if err := database.GetDB().Model(model.IpBan{}).Where("email = ?", "bob").Update("expires_at", past).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bans, err := s.GetBans(""); err != nil || len(bans) != 0 {
+		t.Fatalf("expected no active bans, got %+v (%v)", bans, err)
+	}
+	if count, err := s.DelExpiredBans(); err != nil || count != 1 {
+		t.Fatalf("expected 1 expired ban, got %d (%v)", count, err)
+	}
+
+	// bans do not change the hash the supervisor tracks the config by
+	unbanned := template()
+	if err := x.applyIpBans(unbanned); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(unbanned.OutboundConfigs), IpBanOutboundTag) {
+		t.Fatalf("expected no ban outbound without bans, got %s", unbanned.OutboundConfigs)
+	}
+	if xrayConfigHash(unbanned) != xrayConfigHash(config) {
+		t.Fatal("expected the same hash with and without bans")
+	}
+}
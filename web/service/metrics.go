@@ -0,0 +1,253 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// MetricsContentType is the content type of the OpenMetrics text exposition format.
+const MetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// jobStat accumulates the run durations of a scheduled job.
+type jobStat struct {
+	runs  int64
+	total time.Duration
+	last  time.Duration
+}
+
+var (
+	jobStatsLock sync.Mutex
+	jobStats     = map[string]*jobStat{}
+)
+
+// ObserveJobRun records the duration of one run of a scheduled job.
+func ObserveJobRun(name string, duration time.Duration) {
+	jobStatsLock.Lock()
+	defer jobStatsLock.Unlock()
+	stat, ok := jobStats[name]
+	if !ok {
+		stat = &jobStat{}
+		jobStats[name] = stat
+	}
+	stat.runs++
+	stat.total += duration
+	stat.last = duration
+}
+
+// MetricsService renders panel, host, Xray and traffic statistics in OpenMetrics format.
+type MetricsService struct {
+	settingService SettingService
+	serverService  ServerService
+	xrayService    XrayService
+	inboundService InboundService
+
+	mu         sync.Mutex
+	lastStatus *Status
+}
+
+// metricsWriter writes metric families, remembering the first write error.
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (m *metricsWriter) printf(format string, args ...any) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+// family writes the metadata of a metric family.
+func (m *metricsWriter) family(name string, typ string, help string) {
+	m.printf("# TYPE %s %s\n# HELP %s %s\n", name, typ, name, help)
+}
+
+// sample writes one sample. labels are name/value pairs.
+func (m *metricsWriter) sample(name string, value any, labels ...string) {
+	if len(labels) == 0 {
+		m.printf("%s %v\n", name, value)
+		return
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeMetricLabel(labels[i+1])+`"`)
+	}
+	m.printf("%s{%s} %v\n", name, strings.Join(pairs, ","), value)
+}
+
+// gauge writes a single unlabeled gauge family.
+func (m *metricsWriter) gauge(name string, help string, value any) {
+	m.family(name, "gauge", help)
+	m.sample(name, value)
+}
+
+// WriteMetrics writes all metrics to w, terminated by the OpenMetrics EOF marker.
+func (s *MetricsService) WriteMetrics(w io.Writer) error {
+	m := &metricsWriter{w: w}
+
+	s.mu.Lock()
+	status := s.serverService.GetStatus(s.lastStatus)
+	s.lastStatus = status
+	s.mu.Unlock()
+	s.writeHostMetrics(m, status)
+	s.writeXrayMetrics(m, status)
+	if err := s.writeTrafficMetrics(m); err != nil {
+		return err
+	}
+	writeJobMetrics(m)
+
+	m.printf("# EOF\n")
+	return m.err
+}
+
+func (s *MetricsService) writeHostMetrics(m *metricsWriter, status *Status) {
+	m.gauge("xui_cpu_usage_percent", "Host CPU usage in percent.", status.Cpu)
+	m.gauge("xui_cpu_cores", "Number of physical CPU cores.", status.CpuCores)
+
+	m.family("xui_memory_bytes", "gauge", "Host memory.")
+	m.sample("xui_memory_bytes", status.Mem.Current, "state", "used")
+	m.sample("xui_memory_bytes", status.Mem.Total, "state", "total")
+	m.family("xui_swap_bytes", "gauge", "Host swap.")
+	m.sample("xui_swap_bytes", status.Swap.Current, "state", "used")
+	m.sample("xui_swap_bytes", status.Swap.Total, "state", "total")
+	m.family("xui_disk_bytes", "gauge", "Disk space of the root filesystem.")
+	m.sample("xui_disk_bytes", status.Disk.Current, "state", "used")
+	m.sample("xui_disk_bytes", status.Disk.Total, "state", "total")
+
+	m.family("xui_load_average", "gauge", "Host load average.")
+	for i, period := range []string{"1m", "5m", "15m"} {
+		if i < len(status.Loads) {
+			m.sample("xui_load_average", status.Loads[i], "period", period)
+		}
+	}
+
+	m.family("xui_connections", "gauge", "Open host connections.")
+	m.sample("xui_connections", status.TcpCount, "protocol", "tcp")
+	m.sample("xui_connections", status.UdpCount, "protocol", "udp")
+
+	m.family("xui_network_bytes", "counter", "Bytes transferred by the host network interfaces.")
+	m.sample("xui_network_bytes_total", status.NetTraffic.Sent, "direction", "sent")
+	m.sample("xui_network_bytes_total", status.NetTraffic.Recv, "direction", "received")
+
+	m.gauge("xui_host_uptime_seconds", "Host uptime.", status.Uptime)
+	m.gauge("xui_panel_uptime_seconds", "Panel process uptime.", status.AppStats.Uptime)
+	m.gauge("xui_panel_memory_bytes", "Memory used by the panel process.", status.AppStats.Mem)
+	m.gauge("xui_panel_threads", "Threads of the panel process.", status.AppStats.Threads)
+}
+
+func (s *MetricsService) writeXrayMetrics(m *metricsWriter, status *Status) {
+	m.family("xui_xray", "info", "Xray version.")
+	m.sample("xui_xray_info", 1, "version", status.Xray.Version)
+
+	m.family("xui_xray_state", "stateset", "Xray process state.")
+	for _, state := range []ProcessState{Running, Stop, Error} {
+		value := 0
+		if status.Xray.State == state {
+			value = 1
+		}
+		m.sample("xui_xray_state", value, "xui_xray_state", string(state))
+	}
+
+	var uptime uint64
+	if s.xrayService.IsXrayRunning() {
+		uptime = p.GetUptime()
+	}
+	m.gauge("xui_xray_uptime_seconds", "Uptime of the Xray process.", uptime)
+
+	m.family("xui_xray_starts", "counter", "Times Xray was started by the panel.")
+	m.sample("xui_xray_starts_total", s.xrayService.GetXrayStartCount())
+	m.family("xui_xray_crashes", "counter", "Times Xray was found crashed and restarted.")
+	m.sample("xui_xray_crashes_total", s.xrayService.GetXrayCrashCount())
+	m.family("xui_xray_hot_applies", "counter", "Times a config was applied to Xray without a restart.")
+	m.sample("xui_xray_hot_applies_total", s.xrayService.GetXrayHotApplyCount())
+
+	online := 0
+	if s.xrayService.IsXrayRunning() {
+		online = len(s.inboundService.GetOnlineClients())
+	}
+	m.gauge("xui_online_clients", "Clients with traffic in the last collection interval.", online)
+}
+
+func (s *MetricsService) writeTrafficMetrics(m *metricsWriter) error {
+	db := database.GetDB()
+
+	var inbounds []*model.Inbound
+	if err := db.Model(model.Inbound{}).Select("tag, protocol, up, down").Order("id").Find(&inbounds).Error; err != nil {
+		return err
+	}
+	m.family("xui_inbound_traffic_bytes", "counter", "Traffic of an inbound.")
+	for _, inbound := range inbounds {
+		m.sample("xui_inbound_traffic_bytes_total", inbound.Up, "tag", inbound.Tag, "protocol", string(inbound.Protocol), "direction", "up")
+		m.sample("xui_inbound_traffic_bytes_total", inbound.Down, "tag", inbound.Tag, "protocol", string(inbound.Protocol), "direction", "down")
+	}
+
+	var outbounds []*model.OutboundTraffics
+	if err := db.Model(model.OutboundTraffics{}).Order("id").Find(&outbounds).Error; err != nil {
+		return err
+	}
+	m.family("xui_outbound_traffic_bytes", "counter", "Traffic of an outbound.")
+	for _, outbound := range outbounds {
+		m.sample("xui_outbound_traffic_bytes_total", outbound.Up, "tag", outbound.Tag, "direction", "up")
+		m.sample("xui_outbound_traffic_bytes_total", outbound.Down, "tag", outbound.Tag, "direction", "down")
+	}
+
+	limit, err := s.settingService.GetMetricsClientLimit()
+	if err != nil {
+		return err
+	}
+	var total int64
+	if err := db.Model(xray.ClientTraffic{}).Count(&total).Error; err != nil {
+		return err
+	}
+	var clients []*xray.ClientTraffic
+	if limit > 0 {
+		err = db.Model(xray.ClientTraffic{}).Order("up + down desc, id").Limit(limit).Find(&clients).Error
+		if err != nil {
+			return err
+		}
+	}
+	m.family("xui_client_traffic_bytes", "counter", "Traffic of a client, limited to the busiest clients.")
+	for _, client := range clients {
+		m.sample("xui_client_traffic_bytes_total", client.Up, "email", client.Email, "direction", "up")
+		m.sample("xui_client_traffic_bytes_total", client.Down, "email", client.Email, "direction", "down")
+	}
+	m.gauge("xui_clients", "Number of clients.", total)
+	m.gauge("xui_client_series_dropped", "Clients left out of xui_client_traffic_bytes by the client limit.", total-int64(len(clients)))
+	return nil
+}
+
+func writeJobMetrics(m *metricsWriter) {
+	jobStatsLock.Lock()
+	defer jobStatsLock.Unlock()
+	names := make([]string, 0, len(jobStats))
+	for name := range jobStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.family("xui_job_runs", "counter", "Runs of a scheduled job.")
+	for _, name := range names {
+		m.sample("xui_job_runs_total", jobStats[name].runs, "job", name)
+	}
+	m.family("xui_job_run_seconds", "counter", "Total time spent running a scheduled job.")
+	for _, name := range names {
+		m.sample("xui_job_run_seconds_total", jobStats[name].total.Seconds(), "job", name)
+	}
+	m.family("xui_job_last_run_seconds", "gauge", "Duration of the last run of a scheduled job.")
+	for _, name := range names {
+		m.sample("xui_job_last_run_seconds", jobStats[name].last.Seconds(), "job", name)
+	}
+}
+
+// escapeMetricLabel escapes a label value for the text exposition format.
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
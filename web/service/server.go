@@ -572,6 +572,12 @@ func (s *ServerService) StopXrayService() error {
 }
 
 func (s *ServerService) RestartXrayService() error {
+	// A restart from the panel keeps a running Xray if the config would not start
+	if s.xrayService.IsXrayRunning() {
+		if err := s.xrayService.ValidateXrayConfig(); err != nil {
+			return err
+		}
+	}
 	err := s.xrayService.RestartXray(true)
 	if err != nil {
 		logger.Error("start xray failed:", err)
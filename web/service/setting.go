@@ -52,6 +52,8 @@ var defaultValueMap = map[string]string{
 	"twoFactorToken":              "",
 	"subEnable":                   "true",
 	"subJsonEnable":               "false",
+	"subClashEnable":              "false",
+	"subSingboxEnable":            "false",
 	"subTitle":                    "",
 	"subListen":                   "",
 	"subPort":                     "2096",
@@ -69,10 +71,43 @@ var defaultValueMap = map[string]string{
 	"subJsonNoises":               "",
 	"subJsonMux":                  "",
 	"subJsonRules":                "",
+	"subClashPath":                "/clash/",
+	"subClashURI":                 "",
+	"subSingboxPath":              "/singbox/",
+	"subSingboxURI":               "",
+	"subSingboxDns":               "",
+	"subSingboxRules":             "",
+	"subRemarkTemplate":           "",
+	"subTitleTemplate":            "",
+	"subJsonTemplate":             "",
+	"subNodes":                    "",
+	"subNodeCacheTtl":             "60",
+	"subPageLogo":                 "",
+	"subPageColor":                "",
+	"subPageSupport":              "",
+	"subPageAnnouncement":         "",
+	"subPageTranslations":         "",
+	"subSignEnable":               "false",
+	"subTrustedProxies":           "127.0.0.1,::1",
+	"subSignKey":                  "",
+	"subFormatRules":              "",
 	"datepicker":                  "gregorian",
 	"warp":                        "",
-	"externalTrafficInformEnable": "false",
-	"externalTrafficInformURI":    "",
+	"auditRetentionDays":          "90",
+	"trafficHistoryDays":          "365",
+	"subAccessLogDays":            "30",
+	"subAnomalyIps":               "10",
+	"connectionLogEnable":         "false",
+	"connectionLogDays":           "7",
+	"connectionLogMaxRecords":     "1000000",
+	"xrayStableMinutes":           "5",
+	"xrayCrashLoopCount":          "5",
+	"xrayRollbackEnable":          "true",
+	"ipLimitBackend":              "fail2ban",
+	"ipLimitBanMinutes":           "30",
+	"metricsEnable":               "false",
+	"metricsToken":                "",
+	"metricsClientLimit":          "500",
 	// LDAP defaults
 	"ldapEnable":                  "false",
 	"ldapHost":                    "",
@@ -455,6 +490,14 @@ func (s *SettingService) GetSubJsonEnable() (bool, error) {
 	return s.getBool("subJsonEnable")
 }
 
+func (s *SettingService) GetSubClashEnable() (bool, error) {
+	return s.getBool("subClashEnable")
+}
+
+func (s *SettingService) GetSubSingboxEnable() (bool, error) {
+	return s.getBool("subSingboxEnable")
+}
+
 func (s *SettingService) GetSubTitle() (string, error) {
 	return s.getString("subTitle")
 }
@@ -475,6 +518,14 @@ func (s *SettingService) GetSubJsonPath() (string, error) {
 	return s.getString("subJsonPath")
 }
 
+func (s *SettingService) GetSubClashPath() (string, error) {
+	return s.getString("subClashPath")
+}
+
+func (s *SettingService) GetSubSingboxPath() (string, error) {
+	return s.getString("subSingboxPath")
+}
+
 func (s *SettingService) GetSubDomain() (string, error) {
 	return s.getString("subDomain")
 }
@@ -527,6 +578,94 @@ func (s *SettingService) GetSubJsonRules() (string, error) {
 	return s.getString("subJsonRules")
 }
 
+func (s *SettingService) GetSubClashURI() (string, error) {
+	return s.getString("subClashURI")
+}
+
+func (s *SettingService) GetSubSingboxURI() (string, error) {
+	return s.getString("subSingboxURI")
+}
+
+func (s *SettingService) GetSubSingboxDns() (string, error) {
+	return s.getString("subSingboxDns")
+}
+
+func (s *SettingService) GetSubSingboxRules() (string, error) {
+	return s.getString("subSingboxRules")
+}
+
+func (s *SettingService) GetSubFormatRules() (string, error) {
+	return s.getString("subFormatRules")
+}
+
+func (s *SettingService) GetSubRemarkTemplate() (string, error) {
+	return s.getString("subRemarkTemplate")
+}
+
+func (s *SettingService) GetSubTitleTemplate() (string, error) {
+	return s.getString("subTitleTemplate")
+}
+
+func (s *SettingService) GetSubJsonTemplate() (string, error) {
+	return s.getString("subJsonTemplate")
+}
+
+func (s *SettingService) GetSubNodes() (string, error) {
+	return s.getString("subNodes")
+}
+
+func (s *SettingService) GetXrayStableMinutes() (int, error) {
+	return s.getInt("xrayStableMinutes")
+}
+
+func (s *SettingService) GetXrayCrashLoopCount() (int, error) {
+	return s.getInt("xrayCrashLoopCount")
+}
+
+func (s *SettingService) GetXrayRollbackEnable() (bool, error) {
+	return s.getBool("xrayRollbackEnable")
+}
+
+func (s *SettingService) GetIpLimitBackend() (string, error) {
+	return s.getString("ipLimitBackend")
+}
+
+func (s *SettingService) GetIpLimitBanMinutes() (int, error) {
+	return s.getInt("ipLimitBanMinutes")
+}
+
+func (s *SettingService) GetSubNodeCacheTtl() (int, error) {
+	return s.getInt("subNodeCacheTtl")
+}
+
+func (s *SettingService) GetSubSignEnable() (bool, error) {
+	return s.getBool("subSignEnable")
+}
+
+func (s *SettingService) GetSubTrustedProxies() (string, error) {
+	return s.getString("subTrustedProxies")
+}
+
+func (s *SettingService) GetSubPageLogo() (string, error) {
+	return s.getString("subPageLogo")
+}
+
+func (s *SettingService) GetSubPageColor() (string, error) {
+	return s.getString("subPageColor")
+}
+
+func (s *SettingService) GetSubPageSupport() (string, error) {
+	return s.getString("subPageSupport")
+}
+
+func (s *SettingService) GetSubPageAnnouncement() (string, error) {
+	return s.getString("subPageAnnouncement")
+}
+
+func (s *SettingService) GetSubPageTranslations() (string, error) {
+	return s.getString("subPageTranslations")
+}
+
 func (s *SettingService) GetDatepicker() (string, error) {
 	return s.getString("datepicker")
 }
@@ -539,20 +678,52 @@ func (s *SettingService) SetWarp(data string) error {
 	return s.setString("warp", data)
 }
 
-func (s *SettingService) GetExternalTrafficInformEnable() (bool, error) {
-	return s.getBool("externalTrafficInformEnable")
+func (s *SettingService) GetAuditRetentionDays() (int, error) {
+	return s.getInt("auditRetentionDays")
+}
+
+func (s *SettingService) GetTrafficHistoryDays() (int, error) {
+	return s.getInt("trafficHistoryDays")
 }
 
-func (s *SettingService) SetExternalTrafficInformEnable(value bool) error {
-	return s.setBool("externalTrafficInformEnable", value)
+func (s *SettingService) GetSubAccessLogDays() (int, error) {
+	return s.getInt("subAccessLogDays")
 }
 
-func (s *SettingService) GetExternalTrafficInformURI() (string, error) {
-	return s.getString("externalTrafficInformURI")
+func (s *SettingService) GetSubAnomalyIps() (int, error) {
+	return s.getInt("subAnomalyIps")
 }
 
-func (s *SettingService) SetExternalTrafficInformURI(InformURI string) error {
-	return s.setString("externalTrafficInformURI", InformURI)
+func (s *SettingService) GetConnectionLogEnable() (bool, error) {
+	return s.getBool("connectionLogEnable")
+}
+
+func (s *SettingService) GetConnectionLogDays() (int, error) {
+	return s.getInt("connectionLogDays")
+}
+
+func (s *SettingService) GetConnectionLogMaxRecords() (int, error) {
+	return s.getInt("connectionLogMaxRecords")
+}
+
+func (s *SettingService) GetMetricsEnable() (bool, error) {
+	return s.getBool("metricsEnable")
+}
+
+func (s *SettingService) GetMetricsToken() (string, error) {
+	return s.getString("metricsToken")
+}
+
+func (s *SettingService) SetMetricsEnable(value bool) error {
+	return s.setBool("metricsEnable", value)
+}
+
+func (s *SettingService) SetMetricsToken(value string) error {
+	return s.setString("metricsToken", value)
+}
+
+func (s *SettingService) GetMetricsClientLimit() (int, error) {
+	return s.getInt("metricsClientLimit")
 }
 
 func (s *SettingService) GetIpLimitEnable() (bool, error) {
@@ -648,6 +819,11 @@ func (s *SettingService) UpdateAllSetting(allSetting *entity.AllSetting) error {
 	if err := allSetting.CheckValid(); err != nil {
 		return err
 	}
+	for _, text := range []string{allSetting.SubRemarkTemplate, allSetting.SubTitleTemplate, allSetting.SubJsonTemplate} {
+		if err := CheckSubTemplate(text); err != nil {
+			return err
+		}
+	}
 
 	v := reflect.ValueOf(allSetting).Elem()
 	t := reflect.TypeOf(allSetting).Elem()
@@ -677,20 +853,24 @@ func (s *SettingService) GetDefaultXrayConfig() (any, error) {
 func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 	type settingFunc func() (any, error)
 	settings := map[string]settingFunc{
-		"expireDiff":    func() (any, error) { return s.GetExpireDiff() },
-		"trafficDiff":   func() (any, error) { return s.GetTrafficDiff() },
-		"pageSize":      func() (any, error) { return s.GetPageSize() },
-		"defaultCert":   func() (any, error) { return s.GetCertFile() },
-		"defaultKey":    func() (any, error) { return s.GetKeyFile() },
-		"tgBotEnable":   func() (any, error) { return s.GetTgbotEnabled() },
-		"subEnable":     func() (any, error) { return s.GetSubEnable() },
-		"subJsonEnable": func() (any, error) { return s.GetSubJsonEnable() },
-		"subTitle":      func() (any, error) { return s.GetSubTitle() },
-		"subURI":        func() (any, error) { return s.GetSubURI() },
-		"subJsonURI":    func() (any, error) { return s.GetSubJsonURI() },
-		"remarkModel":   func() (any, error) { return s.GetRemarkModel() },
-		"datepicker":    func() (any, error) { return s.GetDatepicker() },
-		"ipLimitEnable": func() (any, error) { return s.GetIpLimitEnable() },
+		"expireDiff":       func() (any, error) { return s.GetExpireDiff() },
+		"trafficDiff":      func() (any, error) { return s.GetTrafficDiff() },
+		"pageSize":         func() (any, error) { return s.GetPageSize() },
+		"defaultCert":      func() (any, error) { return s.GetCertFile() },
+		"defaultKey":       func() (any, error) { return s.GetKeyFile() },
+		"tgBotEnable":      func() (any, error) { return s.GetTgbotEnabled() },
+		"subEnable":        func() (any, error) { return s.GetSubEnable() },
+		"subJsonEnable":    func() (any, error) { return s.GetSubJsonEnable() },
+		"subClashEnable":   func() (any, error) { return s.GetSubClashEnable() },
+		"subSingboxEnable": func() (any, error) { return s.GetSubSingboxEnable() },
+		"subTitle":         func() (any, error) { return s.GetSubTitle() },
+		"subURI":           func() (any, error) { return s.GetSubURI() },
+		"subJsonURI":       func() (any, error) { return s.GetSubJsonURI() },
+		"subClashURI":      func() (any, error) { return s.GetSubClashURI() },
+		"subSingboxURI":    func() (any, error) { return s.GetSubSingboxURI() },
+		"remarkModel":      func() (any, error) { return s.GetRemarkModel() },
+		"datepicker":       func() (any, error) { return s.GetDatepicker() },
+		"ipLimitEnable":    func() (any, error) { return s.GetIpLimitEnable() },
 	}
 
 	result := make(map[string]any)
@@ -710,12 +890,17 @@ func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 			subJsonEnable = b
 		}
 	}
-	if (subEnable && result["subURI"].(string) == "") || (subJsonEnable && result["subJsonURI"].(string) == "") {
+	subClashEnable, _ := result["subClashEnable"].(bool)
+	subSingboxEnable, _ := result["subSingboxEnable"].(bool)
+	if (subEnable && result["subURI"].(string) == "") || (subJsonEnable && result["subJsonURI"].(string) == "") ||
+		(subClashEnable && result["subClashURI"].(string) == "") || (subSingboxEnable && result["subSingboxURI"].(string) == "") {
 		subURI := ""
 		subTitle, _ := s.GetSubTitle()
 		subPort, _ := s.GetSubPort()
 		subPath, _ := s.GetSubPath()
 		subJsonPath, _ := s.GetSubJsonPath()
+		subClashPath, _ := s.GetSubClashPath()
+		subSingboxPath, _ := s.GetSubSingboxPath()
 		subDomain, _ := s.GetSubDomain()
 		subKeyFile, _ := s.GetSubKeyFile()
 		subCertFile, _ := s.GetSubCertFile()
@@ -745,6 +930,12 @@ func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 		if subJsonEnable && result["subJsonURI"].(string) == "" {
 			result["subJsonURI"] = subURI + subJsonPath
 		}
+		if subClashEnable && result["subClashURI"].(string) == "" {
+			result["subClashURI"] = subURI + subClashPath
+		}
+		if subSingboxEnable && result["subSingboxURI"].(string) == "" {
+			result["subSingboxURI"] = subURI + subSingboxPath
+		}
 	}
 
 	return result, nil
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/op/go-logging"
+)
+
+// setupTestDB initializes the logger and a database in temporary folders for the test,
+// and closes both when the test finishes.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("XUI_LOG_FOLDER", t.TempDir())
+	logger.InitLogger(logging.ERROR)
+	t.Cleanup(logger.CloseLogger)
+	if err := database.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	t.Cleanup(func() { database.CloseDB() })
+}
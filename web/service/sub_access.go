@@ -0,0 +1,242 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+const (
+	subAnomalyWindow      = 24 * time.Hour // Period over which the distinct source IPs of a subscription are counted
+	subAccessIpRate       = 30             // Fetches recorded per source IP and minute, further fetches are not recorded
+	subAccessUserAgentMax = 256            // Longest User-Agent recorded, in bytes
+)
+
+// subAccessRate counts the recorded fetches per source IP in the current minute, shared by all
+// SubAccessService values.
+var subAccessRate struct {
+	sync.Mutex
+	minute int64
+	counts map[string]int
+}
+
+// SubAccessFilter narrows down the subscription access log entries returned by GetClientReport.
+type SubAccessFilter struct {
+	From     int64 `form:"from"` // Timestamp in milliseconds
+	To       int64 `form:"to"`   // Timestamp in milliseconds
+	Page     int   `form:"page"`
+	PageSize int   `form:"pageSize"`
+}
+
+// SubAccessStats summarizes the fetches of a subscription over the last day. Networks counts
+// distinct /24 (IPv4) or /48 (IPv6) source networks. They stand in for the source ASNs, which
+// would need an ASN database the panel does not ship.
+type SubAccessStats struct {
+	SubId     string   `json:"subId"`
+	Emails    []string `json:"emails,omitempty" gorm:"-"`
+	Fetches   int64    `json:"fetches"`
+	Ips       int64    `json:"ips"`
+	Networks  int64    `json:"networks"`
+	Anomalous bool     `json:"anomalous"` // Fetched from at least subAnomalyIps distinct IPs
+}
+
+// SubAccessReport holds the access log of a client's subscription together with its stats.
+type SubAccessReport struct {
+	Stats *SubAccessStats       `json:"stats"`
+	Logs  []*model.SubAccessLog `json:"logs"`
+	Total int64                 `json:"total"`
+}
+
+// SubAccessService records subscription fetches and flags subscriptions that are fetched
+// from suspiciously many places, which usually means the link was leaked or resold.
+type SubAccessService struct {
+	settingService SettingService
+	webhookService WebhookService
+}
+
+// Record stores a subscription fetch and emits a sub.anomaly webhook event when the fetch makes
+// the subscription anomalous. Failures are logged but never returned, logging must not break
+// the subscription. Only subAccessIpRate fetches per source IP and minute are recorded, and the
+// User-Agent is cut to subAccessUserAgentMax bytes, so that the log can not be flooded.
+func (s *SubAccessService) Record(entry *model.SubAccessLog) {
+	now := time.Now()
+	if !allowSubAccess(entry.Ip, now) {
+		return
+	}
+	entry.Time = now.UnixMilli()
+	entry.Network = subAccessNetwork(entry.Ip)
+	if len(entry.UserAgent) > subAccessUserAgentMax {
+		entry.UserAgent = strings.ToValidUTF8(entry.UserAgent[:subAccessUserAgentMax], "")
+	}
+	db := database.GetDB()
+	var seen int64
+	since := entry.Time - subAnomalyWindow.Milliseconds()
+	err := db.Model(model.SubAccessLog{}).Where("sub_id = ? AND ip = ? AND time >= ?", entry.SubId, entry.Ip, since).Count(&seen).Error
+	if err != nil {
+		logger.Warning("Unable to write subscription access log:", err)
+		return
+	}
+	if err := db.Create(entry).Error; err != nil {
+		logger.Warning("Unable to write subscription access log:", err)
+		return
+	}
+	if seen > 0 {
+		return
+	}
+
+	// a new IP can only make the subscription anomalous when it reaches the limit
+	limit, err := s.settingService.GetSubAnomalyIps()
+	if err != nil || limit <= 0 {
+		return
+	}
+	stats, err := s.getStats(entry.SubId, since)
+	if err != nil || stats.Ips != int64(limit) {
+		return
+	}
+	stats.Anomalous = true
+	logger.Warningf("Subscription %s was fetched from %d IPs in %d networks within a day", entry.SubId, stats.Ips, stats.Networks)
+	s.webhookService.Emit(model.WebhookEventSubAnomaly, stats)
+}
+
+// GetClientReport retrieves the access log of the subscription of a client, newest first, with
+// the stats of its last day.
+func (s *SubAccessService) GetClientReport(email string, filter *SubAccessFilter) (*SubAccessReport, error) {
+	var clients []*model.InboundClient
+	err := database.GetDB().Where("email = ?", email).Limit(1).Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, common.NewError("Client Not Found For Email:", email)
+	}
+	subId := clients[0].SubID
+	report := &SubAccessReport{Logs: []*model.SubAccessLog{}}
+	if subId == "" {
+		report.Stats = &SubAccessStats{}
+		return report, nil
+	}
+
+	report.Stats, err = s.getStats(subId, time.Now().Add(-subAnomalyWindow).UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	limit, _ := s.settingService.GetSubAnomalyIps()
+	report.Stats.Anomalous = limit > 0 && report.Stats.Ips >= int64(limit)
+
+	db := database.GetDB().Model(model.SubAccessLog{}).Where("sub_id = ?", subId)
+	if filter.From > 0 {
+		db = db.Where("time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		db = db.Where("time <= ?", filter.To)
+	}
+	if err := db.Count(&report.Total).Error; err != nil {
+		return nil, err
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = 100
+	}
+	page := max(filter.Page, 1)
+	err = db.Order("time desc, id desc").Limit(pageSize).Offset((page - 1) * pageSize).Find(&report.Logs).Error
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetAnomalies retrieves the subscriptions fetched from at least subAnomalyIps distinct IPs
+// within the last day, together with the emails of their clients.
+func (s *SubAccessService) GetAnomalies() ([]*SubAccessStats, error) {
+	anomalies := make([]*SubAccessStats, 0)
+	limit, err := s.settingService.GetSubAnomalyIps()
+	if err != nil || limit <= 0 {
+		return anomalies, err
+	}
+	db := database.GetDB()
+	err = db.Model(model.SubAccessLog{}).
+		Select("sub_id, COUNT(*) AS fetches, COUNT(DISTINCT ip) AS ips, COUNT(DISTINCT network) AS networks").
+		Where("time >= ?", time.Now().Add(-subAnomalyWindow).UnixMilli()).
+		Group("sub_id").
+		Having("COUNT(DISTINCT ip) >= ?", limit).
+		Order("ips desc").
+		Scan(&anomalies).Error
+	if err != nil || len(anomalies) == 0 {
+		return anomalies, err
+	}
+
+	bySubId := make(map[string]*SubAccessStats, len(anomalies))
+	subIds := make([]string, 0, len(anomalies))
+	for _, anomaly := range anomalies {
+		anomaly.Anomalous = true
+		bySubId[anomaly.SubId] = anomaly
+		subIds = append(subIds, anomaly.SubId)
+	}
+	var clients []*model.InboundClient
+	if err := db.Select("email, sub_id").Where("sub_id IN ?", subIds).Order("email").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		bySubId[client.SubID].Emails = append(bySubId[client.SubID].Emails, client.Email)
+	}
+	return anomalies, nil
+}
+
+// DelExpiredLogs removes access log entries older than the configured retention period.
+func (s *SubAccessService) DelExpiredLogs() (int64, error) {
+	days, err := s.settingService.GetSubAccessLogDays()
+	if err != nil || days <= 0 {
+		return 0, err
+	}
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+	result := database.GetDB().Where("time < ?", cutoff).Delete(model.SubAccessLog{})
+	return result.RowsAffected, result.Error
+}
+
+// getStats counts the fetches, source IPs and source networks of a subscription since the given time.
+func (s *SubAccessService) getStats(subId string, since int64) (*SubAccessStats, error) {
+	stats := &SubAccessStats{SubId: subId}
+	err := database.GetDB().Model(model.SubAccessLog{}).
+		Select("COUNT(*) AS fetches, COUNT(DISTINCT ip) AS ips, COUNT(DISTINCT network) AS networks").
+		Where("sub_id = ? AND time >= ?", subId, since).
+		Scan(stats).Error
+	if err != nil {
+		return nil, err
+	}
+	stats.SubId = subId
+	return stats, nil
+}
+
+// allowSubAccess reports whether another fetch of the source IP may be recorded in the minute of now.
+func allowSubAccess(ip string, now time.Time) bool {
+	subAccessRate.Lock()
+	defer subAccessRate.Unlock()
+	if minute := now.Unix() / 60; minute != subAccessRate.minute || subAccessRate.counts == nil {
+		subAccessRate.minute = minute
+		subAccessRate.counts = make(map[string]int)
+	}
+	if subAccessRate.counts[ip] >= subAccessIpRate {
+		return false
+	}
+	subAccessRate.counts[ip]++
+	return true
+}
+
+// subAccessNetwork returns the /24 network of an IPv4 address or the /48 network of an IPv6
+// address, or the address itself if it can not be parsed.
+func subAccessNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
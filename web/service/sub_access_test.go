@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestSubAccessLog(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	inbounds := &InboundService{}
+	inbound := &model.Inbound{Enable: true, Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001",
+		Settings: `{"clients":[
+			{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01","email":"alice","subId":"leaked","enable":true},
+			{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","email":"bob","subId":"quiet","enable":true}
+		],"decryption":"none"}`}
+	if _, _, err := inbounds.AddInbound(inbound); err != nil {
+		t.Fatalf("add inbound: %v", err)
+	}
+	settings := &SettingService{}
+	if err := settings.setInt("subAnomalyIps", 3); err != nil {
+		t.Fatalf("set anomaly ips: %v", err)
+	}
+
+	s := &SubAccessService{}
+	for i := range 4 {
+		s.Record(&model.SubAccessLog{SubId: "leaked", Ip: fmt.Sprintf("198.51.100.%d", i), UserAgent: "v2rayNG", Format: "base64", Status: 200, Size: 10})
+	}
+	s.Record(&model.SubAccessLog{SubId: "quiet", Ip: "2001:db8:1:2::1", Format: "clash", Status: 200, Size: 20})
+	s.Record(&model.SubAccessLog{SubId: "quiet", Ip: "2001:db8:1:3::1", Format: "clash", Status: 200, Size: 20})
+	db.Create(&model.SubAccessLog{SubId: "quiet", Ip: "192.0.2.1", Time: time.Now().AddDate(0, 0, -40).UnixMilli()})
+
+	report, err := s.GetClientReport("bob", &SubAccessFilter{})
+	if err != nil {
+		t.Fatalf("client report: %v", err)
+	}
+	if report.Total != 3 || report.Stats.Fetches != 2 || report.Stats.Ips != 2 || report.Stats.Networks != 1 || report.Stats.Anomalous {
+		t.Fatalf("unexpected report %+v %+v", report, report.Stats)
+	}
+	if report.Logs[0].Network != "2001:db8:1::/48" {
+		t.Fatalf("unexpected network %q", report.Logs[0].Network)
+	}
+
+	anomalies, err := s.GetAnomalies()
+	if err != nil {
+		t.Fatalf("anomalies: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].SubId != "leaked" || anomalies[0].Ips != 4 || anomalies[0].Networks != 1 ||
+		len(anomalies[0].Emails) != 1 || anomalies[0].Emails[0] != "alice" {
+		t.Fatalf("unexpected anomalies %+v", anomalies)
+	}
+
+	count, err := s.DelExpiredLogs()
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 expired entry, got %d (%v)", count, err)
+	}
+}
+
+func TestSubAccessRecordBounds(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	s := &SubAccessService{}
+	agent := strings.Repeat("é", subAccessUserAgentMax)
+	for range subAccessIpRate + 5 {
+		s.Record(&model.SubAccessLog{SubId: "flood", Ip: "203.0.113.7", UserAgent: agent, Format: "base64", Status: 200})
+	}
+	s.Record(&model.SubAccessLog{SubId: "flood", Ip: "203.0.113.8", Format: "base64", Status: 200})
+
+	var logs []model.SubAccessLog
+	if err := db.Where("sub_id = ?", "flood").Find(&logs).Error; err != nil {
+		t.Fatalf("load logs: %v", err)
+	}
+	if len(logs) != subAccessIpRate+1 {
+		t.Fatalf("expected %d recorded fetches, got %d", subAccessIpRate+1, len(logs))
+	}
+	for _, log := range logs {
+		if len(log.UserAgent) > subAccessUserAgentMax || !utf8.ValidString(log.UserAgent) {
+			t.Fatalf("user agent not bounded: %d bytes", len(log.UserAgent))
+		}
+	}
+}
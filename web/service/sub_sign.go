@@ -0,0 +1,65 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// SubSignKey is the public part of the key pair that signs subscription bodies.
+type SubSignKey struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"keyId"`
+	PublicKey string `json:"publicKey"` // Standard base64 of the raw 32-byte Ed25519 public key
+}
+
+// NewSubSignKey describes the public key of a subscription signing key pair.
+func NewSubSignKey(key ed25519.PrivateKey) *SubSignKey {
+	publicKey := key.Public().(ed25519.PublicKey)
+	return &SubSignKey{
+		Algorithm: "ed25519",
+		KeyId:     SubSignKeyId(publicKey),
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+	}
+}
+
+// SubSignKeyId returns the ID of a subscription signing key: the hex of the first 8 bytes of the
+// SHA-256 of its public key, so clients can tell which key made a signature after a rotation.
+func SubSignKeyId(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetSubSignKey retrieves the Ed25519 key pair that signs subscription bodies. The panel manages
+// the key: it is generated and saved on first use and whenever the stored one is unusable.
+func (s *SettingService) GetSubSignKey() (ed25519.PrivateKey, error) {
+	seed, err := s.getString("subSignKey")
+	if err != nil {
+		return nil, err
+	}
+	if seed != "" {
+		decoded, err := base64.StdEncoding.DecodeString(seed)
+		if err == nil && len(decoded) == ed25519.SeedSize {
+			return ed25519.NewKeyFromSeed(decoded), nil
+		}
+		logger.Warning("invalid subscription signing key, generating a new one")
+	}
+	return s.ResetSubSignKey()
+}
+
+// ResetSubSignKey replaces the subscription signing key with a new one. The subscription server
+// picks it up on its next restart; clients must then fetch the new public key.
+func (s *SettingService) ResetSubSignKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveSetting("subSignKey", base64.StdEncoding.EncodeToString(key.Seed())); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSubSignKey(t *testing.T) {
+	setupTestDB(t)
+
+	s := &SettingService{}
+	key, err := s.GetSubSignKey()
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	again, err := s.GetSubSignKey()
+	if err != nil || !key.Equal(again) {
+		t.Fatalf("expected the generated key to be saved (%v)", err)
+	}
+
+	public := NewSubSignKey(key)
+	decoded, err := base64.StdEncoding.DecodeString(public.PublicKey)
+	if err != nil || !ed25519.Verify(decoded, []byte("body"), ed25519.Sign(key, []byte("body"))) {
+		t.Fatalf("public key does not verify signatures (%v)", err)
+	}
+	if len(public.KeyId) != 16 {
+		t.Fatalf("unexpected key ID %q", public.KeyId)
+	}
+
+	reset, err := s.ResetSubSignKey()
+	if err != nil || reset.Equal(key) {
+		t.Fatalf("expected a new key (%v)", err)
+	}
+	if NewSubSignKey(reset).KeyId == public.KeyId {
+		t.Fatal("expected a new key ID")
+	}
+
+	if err := s.saveSetting("subSignKey", "broken"); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := s.GetSubSignKey(); err != nil || key.Equal(reset) {
+		t.Fatalf("expected an unusable key to be replaced (%v)", err)
+	}
+}
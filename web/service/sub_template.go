@@ -0,0 +1,233 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Kinds of subscription templates.
+const (
+	SubTemplateRemark = "remark"
+	SubTemplateTitle  = "title"
+	SubTemplateJson   = "json"
+)
+
+// SubTemplateInbound holds the inbound variables of subscription templates.
+type SubTemplateInbound struct {
+	Id       int
+	Remark   string
+	Protocol string
+	Port     int
+	Tag      string
+}
+
+// SubTemplateClient holds the client variables of subscription templates.
+type SubTemplateClient struct {
+	Email   string
+	SubID   string
+	Comment string
+	TgID    int64
+	LimitIP int
+	Enable  bool
+}
+
+// SubTemplateTraffic holds the traffic variables of subscription templates in bytes.
+// Total and Remaining are 0 if the traffic is unlimited.
+type SubTemplateTraffic struct {
+	Up        int64
+	Down      int64
+	Used      int64
+	Total     int64
+	Remaining int64
+	Unlimited bool
+	Depleted  bool
+}
+
+// SubTemplateExpiry holds the expiry variables of subscription templates. Time is the expiry time
+// in milliseconds, Days, Hours and Minutes the time left, or for a delayed start the duration.
+type SubTemplateExpiry struct {
+	Time    int64
+	Days    int64
+	Hours   int64
+	Minutes int64
+	Never   bool
+	Delayed bool
+	Expired bool
+}
+
+// SubTemplateData holds the variables of subscription templates. Extra is the remark of an
+// external proxy. Remark, Outbound and Outbounds are only set for JSON profile templates and hold
+// the link remark, the proxy outbound and all outbounds of the default profile as JSON.
+type SubTemplateData struct {
+	SubID     string
+	Host      string
+	Extra     string
+	Remark    string
+	Inbound   SubTemplateInbound
+	Client    SubTemplateClient
+	Traffic   SubTemplateTraffic
+	Expiry    SubTemplateExpiry
+	Outbound  string
+	Outbounds string
+}
+
+var subTemplateFuncs = template.FuncMap{
+	"bytes": common.FormatTraffic,
+	"date": func(ms int64, layout string) string {
+		if ms <= 0 {
+			return ""
+		}
+		return time.UnixMilli(ms).Format(layout)
+	},
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// NewSubTemplateData builds the variables of subscription templates. inbound and client may be
+// nil, e.g. for profile titles.
+func NewSubTemplateData(subId string, host string, inbound *model.Inbound, client *model.Client, traffic xray.ClientTraffic) *SubTemplateData {
+	data := &SubTemplateData{SubID: subId, Host: host}
+	if inbound != nil {
+		data.Inbound = SubTemplateInbound{
+			Id:       inbound.Id,
+			Remark:   inbound.Remark,
+			Protocol: string(inbound.Protocol),
+			Port:     inbound.Port,
+			Tag:      inbound.Tag,
+		}
+	}
+	if client != nil {
+		data.Client = SubTemplateClient{
+			Email:   client.Email,
+			SubID:   client.SubID,
+			Comment: client.Comment,
+			TgID:    client.TgID,
+			LimitIP: client.LimitIP,
+			Enable:  client.Enable,
+		}
+	}
+
+	used := traffic.Up + traffic.Down
+	data.Traffic = SubTemplateTraffic{
+		Up:        traffic.Up,
+		Down:      traffic.Down,
+		Used:      used,
+		Total:     traffic.Total,
+		Unlimited: traffic.Total <= 0,
+		Depleted:  traffic.Total > 0 && used >= traffic.Total,
+	}
+	if traffic.Total > 0 {
+		data.Traffic.Remaining = max(traffic.Total-used, 0)
+	}
+
+	expiry := &data.Expiry
+	var left int64
+	switch {
+	case traffic.ExpiryTime == 0:
+		expiry.Never = true
+	case traffic.ExpiryTime < 0:
+		expiry.Delayed = true
+		left = -traffic.ExpiryTime
+	default:
+		expiry.Time = traffic.ExpiryTime
+		left = traffic.ExpiryTime - time.Now().UnixMilli()
+		if left <= 0 {
+			expiry.Expired = true
+			left = 0
+		}
+	}
+	expiry.Days = left / 86400000
+	expiry.Hours = left % 86400000 / 3600000
+	expiry.Minutes = left % 3600000 / 60000
+	return data
+}
+
+// parseSubTemplate parses a subscription template with the template functions.
+func parseSubTemplate(text string) (*template.Template, error) {
+	return template.New("sub").Funcs(subTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// CheckSubTemplate checks the syntax of a subscription template. Empty templates are valid.
+func CheckSubTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+	if _, err := parseSubTemplate(text); err != nil {
+		return common.NewError("invalid subscription template:", err)
+	}
+	return nil
+}
+
+// checkSubTemplates checks the subscription template overrides of an inbound.
+func (s *InboundService) checkSubTemplates(inbound *model.Inbound) error {
+	if err := CheckSubTemplate(inbound.SubRemarkTemplate); err != nil {
+		return err
+	}
+	return CheckSubTemplate(inbound.SubJsonTemplate)
+}
+
+// RenderSubTemplate renders a subscription template. JSON profile templates must render to valid JSON.
+func RenderSubTemplate(kind string, text string, data *SubTemplateData) (string, error) {
+	tmpl, err := parseSubTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var result strings.Builder
+	if err := tmpl.Execute(&result, data); err != nil {
+		return "", err
+	}
+	if kind == SubTemplateJson {
+		if !json.Valid([]byte(result.String())) {
+			return "", common.NewError("subscription template did not render valid JSON")
+		}
+		return result.String(), nil
+	}
+	return strings.TrimSpace(result.String()), nil
+}
+
+// PreviewSubTemplate renders a subscription template for the client with the given email.
+// For JSON profile templates the outbounds are placeholders, as the proxy outbound is only built
+// by the subscription server.
+func (s *InboundService) PreviewSubTemplate(kind string, text string, email string, host string) (string, error) {
+	switch kind {
+	case SubTemplateRemark, SubTemplateTitle, SubTemplateJson:
+	default:
+		return "", common.NewError("unknown subscription template kind:", kind)
+	}
+	clientRow, err := s.GetInboundClientByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if clientRow == nil {
+		return "", common.NewError("Client Not Found For Email:", email)
+	}
+	inbound, err := s.GetInbound(clientRow.InboundId)
+	if err != nil {
+		return "", err
+	}
+	traffic, err := s.GetClientTrafficByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if traffic == nil {
+		traffic = &xray.ClientTraffic{}
+	}
+	client := clientRow.Client()
+	data := NewSubTemplateData(client.SubID, host, inbound, &client, *traffic)
+	if kind == SubTemplateJson {
+		data.Remark = inbound.Remark + "-" + client.Email
+		data.Outbound = `{"tag": "proxy", "protocol": "` + string(inbound.Protocol) + `"}`
+		data.Outbounds = "[" + data.Outbound + `, {"tag": "direct", "protocol": "freedom"}]`
+	}
+	return RenderSubTemplate(kind, text, data)
+}
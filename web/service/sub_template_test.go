@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestRenderSubTemplate(t *testing.T) {
+	inbound := &model.Inbound{Id: 3, Remark: "de", Protocol: model.VLESS, Port: 443}
+	client := &model.Client{Email: "alice", SubID: "s1"}
+	data := NewSubTemplateData("s1", "example.com", inbound, client, xray.ClientTraffic{Up: 1024, Down: 1024, Total: 4096})
+
+	result, err := RenderSubTemplate(SubTemplateRemark, ` {{ .Inbound.Remark }}-{{ upper .Client.Email }} {{ bytes .Traffic.Remaining }}{{ if .Expiry.Never }} ∞{{ end }} `, data)
+	if err != nil || result != "de-ALICE 2.00KB ∞" {
+		t.Fatalf("unexpected remark %q (%v)", result, err)
+	}
+	if _, err := RenderSubTemplate(SubTemplateRemark, "{{ .Client.Missing }}", data); err == nil {
+		t.Fatal("expected error for unknown variable")
+	}
+
+	data.Outbounds = `[{"tag":"proxy"}]`
+	result, err = RenderSubTemplate(SubTemplateJson, `{"remarks": {{ json .Client.Email }}, "outbounds": {{ .Outbounds }}}`, data)
+	if err != nil || result != `{"remarks": "alice", "outbounds": [{"tag":"proxy"}]}` {
+		t.Fatalf("unexpected json profile %q (%v)", result, err)
+	}
+	if _, err := RenderSubTemplate(SubTemplateJson, `{"remarks": {{ .Client.Email }}}`, data); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+
+	if err := CheckSubTemplate("{{ .Client.Email "); err == nil {
+		t.Fatal("expected syntax error")
+	}
+}
+
+func TestPreviewSubTemplate(t *testing.T) {
+	setupTestDB(t)
+
+	s := &InboundService{}
+	inbound := &model.Inbound{Enable: true, Remark: "de", Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001",
+		Settings: `{"clients":[{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01","email":"alice","subId":"s1","enable":true}],"decryption":"none"}`}
+	if _, _, err := s.AddInbound(inbound); err != nil {
+		t.Fatalf("add inbound: %v", err)
+	}
+
+	result, err := s.PreviewSubTemplate(SubTemplateRemark, "{{ .Inbound.Remark }}/{{ .Client.Email }}/{{ .SubID }}@{{ .Host }}", "alice", "example.com")
+	if err != nil || result != "de/alice/s1@example.com" {
+		t.Fatalf("unexpected preview %q (%v)", result, err)
+	}
+	if _, err := s.PreviewSubTemplate(SubTemplateJson, `{"outbounds": {{ .Outbounds }}}`, "alice", "example.com"); err != nil {
+		t.Fatalf("json preview: %v", err)
+	}
+	if _, err := s.PreviewSubTemplate(SubTemplateRemark, "{{ .Client.Email }}", "bob", "example.com"); err == nil {
+		t.Fatal("expected error for unknown client")
+	}
+
+	inbound.SubRemarkTemplate = "{{ .Client.Email"
+	if _, _, err := s.UpdateInbound(inbound); err == nil {
+		t.Fatal("expected invalid inbound template to be rejected")
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"gorm.io/gorm"
+)
+
+// SubRotation is the result of rotating the subscription ID of a client.
+type SubRotation struct {
+	OldSubId  string   `json:"oldSubId"`
+	SubId     string   `json:"subId"`
+	ExpiresAt int64    `json:"expiresAt"` // Timestamp in milliseconds until the old ID stays valid, 0 if it was revoked right away
+	Emails    []string `json:"emails"`    // Clients sharing the subscription, all of them got the new ID
+}
+
+// RotateSubId gives the subscription of a client a new random ID. All clients and the client user
+// sharing the subscription are updated in one transaction. With graceHours the old ID keeps
+// working for that many hours, otherwise it is revoked right away. Former IDs that are still in
+// their grace period move on to the new ID.
+func (s *InboundService) RotateSubId(email string, graceHours int) (*SubRotation, error) {
+	if graceHours < 0 {
+		return nil, common.NewError("grace hours can not be negative:", graceHours)
+	}
+	client, err := s.GetInboundClientByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, common.NewError("Client Not Found For Email:", email)
+	}
+	if client.SubID == "" {
+		return nil, common.NewError("client has no subscription:", email)
+	}
+
+	now := time.Now()
+	rotation := &SubRotation{OldSubId: client.SubID, SubId: random.Seq(16), Emails: []string{}}
+	if graceHours > 0 {
+		rotation.ExpiresAt = now.Add(time.Duration(graceHours) * time.Hour).UnixMilli()
+	}
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var clients []*model.InboundClient
+		if err := tx.Where("sub_id = ?", rotation.OldSubId).Order("email").Find(&clients).Error; err != nil {
+			return err
+		}
+		inboundIds := make(map[int]bool)
+		for _, client := range clients {
+			rotation.Emails = append(rotation.Emails, client.Email)
+			inboundIds[client.InboundId] = true
+		}
+		err := tx.Model(model.InboundClient{}).Where("sub_id = ?", rotation.OldSubId).
+			Updates(map[string]any{"sub_id": rotation.SubId, "updated_at": now.UnixMilli()}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model.ClientUser{}).Where("sub_id = ?", rotation.OldSubId).Update("sub_id", rotation.SubId).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model.SubToken{}).Where("sub_id = ?", rotation.OldSubId).Update("sub_id", rotation.SubId).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where("token = ?", rotation.OldSubId).Delete(model.SubToken{}).Error; err != nil {
+			return err
+		}
+		if rotation.ExpiresAt > 0 {
+			token := &model.SubToken{Token: rotation.OldSubId, SubId: rotation.SubId, ExpiresAt: rotation.ExpiresAt}
+			if err = tx.Create(token).Error; err != nil {
+				return err
+			}
+		}
+		return s.saveInboundsClientSettings(tx, inboundIds)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// GetSubTokens retrieves the former subscription IDs of a client that are still in their grace period.
+func (s *InboundService) GetSubTokens(email string) ([]*model.SubToken, error) {
+	client, err := s.GetInboundClientByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, common.NewError("Client Not Found For Email:", email)
+	}
+	tokens := make([]*model.SubToken, 0)
+	if client.SubID == "" {
+		return tokens, nil
+	}
+	err = database.GetDB().Where("sub_id = ? AND expires_at > ?", client.SubID, time.Now().UnixMilli()).
+		Order("expires_at desc").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeSubToken ends the grace period of a former subscription ID of a client. Revoking the
+// current subscription ID is done by rotating it without a grace period.
+func (s *InboundService) RevokeSubToken(email string, token string) error {
+	client, err := s.GetInboundClientByEmail(email)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return common.NewError("Client Not Found For Email:", email)
+	}
+	result := database.GetDB().Where("token = ? AND sub_id = ?", token, client.SubID).Delete(model.SubToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("subscription token not found:", token)
+	}
+	return nil
+}
+
+// ResolveSubId returns the current subscription ID of a former one that is still in its grace
+// period. Any other ID is returned as it is, also one that a client uses again.
+func (s *InboundService) ResolveSubId(subId string) string {
+	db := database.GetDB()
+	var tokens []*model.SubToken
+	err := db.Where("token = ? AND expires_at > ?", subId, time.Now().UnixMilli()).Limit(1).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return subId
+	}
+	var count int64
+	if err := db.Model(model.InboundClient{}).Where("sub_id = ?", subId).Count(&count).Error; err != nil || count > 0 {
+		return subId
+	}
+	return tokens[0].SubId
+}
+
+// DelExpiredSubTokens removes the former subscription IDs whose grace period is over.
+func (s *InboundService) DelExpiredSubTokens() (int64, error) {
+	result := database.GetDB().Where("expires_at <= ?", time.Now().UnixMilli()).Delete(model.SubToken{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestRotateSubId(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	s := &InboundService{}
+	first := &model.Inbound{Enable: true, Port: 20001, Protocol: model.VLESS, Tag: "inbound-20001",
+		Settings: `{"clients":[{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d01","email":"alice","subId":"shared","enable":true}],"decryption":"none"}`}
+	second := &model.Inbound{Enable: true, Port: 20002, Protocol: model.VLESS, Tag: "inbound-20002",
+		Settings: `{"clients":[{"id":"0b7c3d0e-7f0b-4d1e-9a53-0c8b5f3a6d02","email":"alice-2","subId":"shared","enable":true}],"decryption":"none"}`}
+	for _, inbound := range []*model.Inbound{first, second} {
+		if _, _, err := s.AddInbound(inbound); err != nil {
+			t.Fatalf("add inbound: %v", err)
+		}
+	}
+
+	rotation, err := s.RotateSubId("alice", 24)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if rotation.OldSubId != "shared" || rotation.SubId == "shared" || rotation.ExpiresAt <= time.Now().UnixMilli() ||
+		len(rotation.Emails) != 2 {
+		t.Fatalf("unexpected rotation %+v", rotation)
+	}
+	for _, id := range []int{first.Id, second.Id} {
+		inbound, err := s.GetInbound(id)
+		if err != nil {
+			t.Fatalf("get inbound: %v", err)
+		}
+		if !strings.Contains(inbound.Settings, rotation.SubId) || strings.Contains(inbound.Settings, `"shared"`) {
+			t.Fatalf("inbound %d settings not updated: %s", id, inbound.Settings)
+		}
+	}
+	if got := s.ResolveSubId("shared"); got != rotation.SubId {
+		t.Fatalf("expected old ID to resolve during grace, got %q", got)
+	}
+
+	// a second rotation moves the first grace token on to the newest ID
+	again, err := s.RotateSubId("alice-2", 0)
+	if err != nil {
+		t.Fatalf("rotate again: %v", err)
+	}
+	if got := s.ResolveSubId("shared"); got != again.SubId {
+		t.Fatalf("expected grace token to follow the rotation, got %q", got)
+	}
+	if got := s.ResolveSubId(rotation.SubId); got != rotation.SubId {
+		t.Fatalf("expected ID rotated without grace to be revoked, got %q", got)
+	}
+	tokens, err := s.GetSubTokens("alice")
+	if err != nil || len(tokens) != 1 || tokens[0].Token != "shared" {
+		t.Fatalf("unexpected tokens %+v (%v)", tokens, err)
+	}
+
+	if err := s.RevokeSubToken("alice", "unknown"); err == nil {
+		t.Fatal("expected error for unknown token")
+	}
+	if err := s.RevokeSubToken("alice", "shared"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if got := s.ResolveSubId("shared"); got != "shared" {
+		t.Fatalf("expected revoked token to stop resolving, got %q", got)
+	}
+
+	db.Create(&model.SubToken{Token: "stale", SubId: again.SubId, ExpiresAt: time.Now().Add(-time.Hour).UnixMilli()})
+	count, err := s.DelExpiredSubTokens()
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 expired token, got %d (%v)", count, err)
+	}
+}
@@ -100,6 +100,7 @@ type Tgbot struct {
 	settingService SettingService
 	serverService  ServerService
 	xrayService    XrayService
+	auditService   AuditService
 	lastStatus     *Status
 }
 
@@ -108,6 +109,12 @@ func (t *Tgbot) NewTgbot() *Tgbot {
 	return new(Tgbot)
 }
 
+// audit records an administrative action performed through the bot by the given chat.
+func (t *Tgbot) audit(chatId int64, action string, target string, after any) {
+	actor := AuditActor{Type: model.AuditActorTgBot, Name: strconv.FormatInt(chatId, 10)}
+	t.auditService.Record(actor, action, target, nil, after)
+}
+
 // I18nBot retrieves a localized message for the bot interface.
 func (t *Tgbot) I18nBot(name string, params ...string) string {
 	return locale.I18n(locale.Bot, name, params...)
@@ -514,6 +521,7 @@ func (t *Tgbot) OnReceive() {
 						if err != nil {
 							output += t.I18nBot("tgbot.messages.selectUserFailed")
 						} else {
+							t.audit(message.Chat.ID, "client.setTgId", fmt.Sprintf("clientTraffic:%d", message.UsersShared.RequestID), map[string]any{"tgId": userID})
 							output += t.I18nBot("tgbot.messages.userSaved")
 						}
 						t.SendMsgToTgbot(message.Chat.ID, output, tu.ReplyKeyboardRemove())
@@ -584,6 +592,7 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 					if err != nil {
 						msg += t.I18nBot("tgbot.commands.restartFailed", "Error=="+err.Error())
 					} else {
+						t.audit(chatId, "xray.restart", "xray", nil)
 						msg += t.I18nBot("tgbot.commands.restartSuccess")
 					}
 				} else {
@@ -736,11 +745,42 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 			case "reset_traffic_c":
 				err := t.inboundService.ResetClientTrafficByEmail(email)
 				if err == nil {
+					t.audit(chatId, "client.resetTraffic", "client:"+email, nil)
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.resetTrafficSuccess", "Email=="+email))
 					t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
 				} else {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 				}
+			case "rotate_sub":
+				inlineKeyboard := tu.InlineKeyboard(
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.cancel")).WithCallbackData(t.encodeQuery("client_cancel "+email)),
+					),
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.rotateSubNow")).WithCallbackData(t.encodeQuery("rotate_sub_c "+email+" 0")),
+					),
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.rotateSubGrace", "Hours==24")).WithCallbackData(t.encodeQuery("rotate_sub_c "+email+" 24")),
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.rotateSubGrace", "Hours==72")).WithCallbackData(t.encodeQuery("rotate_sub_c "+email+" 72")),
+					),
+				)
+				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
+			case "rotate_sub_c":
+				if len(dataArray) == 3 {
+					graceHours, err := strconv.Atoi(dataArray[2])
+					if err == nil {
+						rotation, err := t.inboundService.RotateSubId(email, graceHours)
+						if err == nil {
+							t.audit(chatId, "client.rotateSub", "client:"+email, map[string]any{"subId": rotation.SubId, "graceHours": graceHours, "emails": rotation.Emails})
+							t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.rotateSubSuccess", "Email=="+email))
+							t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
+							t.sendClientSubLinks(chatId, email)
+							return
+						}
+						logger.Warning(err)
+					}
+				}
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 			case "limit_traffic":
 				inlineKeyboard := tu.InlineKeyboard(
 					tu.InlineKeyboardRow(
@@ -781,6 +821,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 							t.xrayService.SetToNeedRestart()
 						}
 						if err == nil {
+							t.audit(chatId, "client.update", "client:"+email, map[string]any{"totalGB": limitTraffic})
 							t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.setTrafficLimitSuccess", "Email=="+email))
 							t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
 							return
@@ -990,6 +1031,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 							t.xrayService.SetToNeedRestart()
 						}
 						if err == nil {
+							t.audit(chatId, "client.update", "client:"+email, map[string]any{"expiryTime": date})
 							t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.expireResetSuccess", "Email=="+email))
 							t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
 							return
@@ -1187,6 +1229,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 							t.xrayService.SetToNeedRestart()
 						}
 						if err == nil {
+							t.audit(chatId, "client.update", "client:"+email, map[string]any{"limitIp": count})
 							t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.resetIpSuccess", "Email=="+email, "Count=="+strconv.Itoa(count)))
 							t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
 							return
@@ -1382,6 +1425,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 					t.xrayService.SetToNeedRestart()
 				}
 				if err == nil {
+					t.audit(chatId, "client.setTgId", "client:"+email, map[string]any{"tgId": EmptyTelegramUserID})
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.removedTGUserSuccess", "Email=="+email))
 					t.clientTelegramUserInfo(chatId, email, callbackQuery.Message.GetMessageID())
 				} else {
@@ -1403,6 +1447,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 					t.xrayService.SetToNeedRestart()
 				}
 				if err == nil {
+					t.audit(chatId, "client.update", "client:"+email, map[string]any{"enable": enabled})
 					if enabled {
 						t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.enableSuccess", "Email=="+email))
 					} else {
@@ -1817,6 +1862,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 			errorMessage := fmt.Sprintf("%v", err)
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+errorMessage), tu.ReplyKeyboardRemove())
 		} else {
+			t.audit(chatId, "client.add", "client:"+client_Email, map[string]any{"inboundId": receiver_inbound_ID, "enable": client_Enable})
 			t.deleteMessageTgBot(chatId, callbackQuery.Message.GetMessageID())
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.successfulOperation"), tu.ReplyKeyboardRemove())
 		}
@@ -1827,6 +1873,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 			errorMessage := fmt.Sprintf("%v", err)
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+errorMessage), tu.ReplyKeyboardRemove())
 		} else {
+			t.audit(chatId, "client.add", "client:"+client_Email, map[string]any{"inboundId": receiver_inbound_ID, "enable": client_Enable})
 			t.deleteMessageTgBot(chatId, callbackQuery.Message.GetMessageID())
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.successfulOperation"), tu.ReplyKeyboardRemove())
 		}
@@ -1854,6 +1901,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		for _, email := range emails {
 			err := t.inboundService.ResetClientTrafficByEmail(email)
 			if err == nil {
+				t.audit(chatId, "client.resetTraffic", "client:"+email, nil)
 				msg := t.I18nBot("tgbot.messages.SuccessResetTraffic", "ClientEmail=="+email)
 				t.SendMsgToTgbot(chatId, msg, tu.ReplyKeyboardRemove())
 			} else {
@@ -3098,6 +3146,9 @@ func (t *Tgbot) searchClient(chatId int64, email string, messageID ...int) {
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.setTGUser")).WithCallbackData(t.encodeQuery("tg_user "+email)),
 		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.rotateSub")).WithCallbackData(t.encodeQuery("rotate_sub "+email)),
+		),
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.toggle")).WithCallbackData(t.encodeQuery("toggle_enable "+email)),
 		),
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Resolutions of the stored traffic history, in seconds. Traffic is written into
+// 5-minute buckets which are rolled up into hourly and daily buckets.
+const (
+	trafficStepFine   int64 = 5 * 60
+	trafficStepHourly int64 = 60 * 60
+	trafficStepDaily  int64 = 24 * 60 * 60
+)
+
+// Retention of the finer resolutions; daily buckets use the trafficHistoryDays setting.
+const (
+	trafficFineRetention   = 2 * 24 * time.Hour
+	trafficHourlyRetention = 31 * 24 * time.Hour
+)
+
+// trafficSteps lists the stored resolutions from the coarsest to the finest.
+var trafficSteps = []int64{trafficStepDaily, trafficStepHourly, trafficStepFine}
+
+// TrafficHistoryQuery selects the time range and resolution of a traffic history series.
+type TrafficHistoryQuery struct {
+	From int64  `form:"from"` // Timestamp in milliseconds, defaults to 24 hours before To
+	To   int64  `form:"to"`   // Timestamp in milliseconds, defaults to now
+	Step string `form:"step"` // Bucket width as seconds or a duration like 1h, picked from the range if empty
+}
+
+// TrafficPoint is the traffic of one bucket of a traffic history series.
+type TrafficPoint struct {
+	Time int64 `json:"time"` // Bucket start timestamp in milliseconds
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// TrafficHistoryService stores traffic deltas as time series and serves them at different resolutions.
+type TrafficHistoryService struct {
+	settingService SettingService
+}
+
+// AddTraffic adds the traffic deltas collected from Xray to the current 5-minute buckets.
+func (s *TrafficHistoryService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) error {
+	now := time.Now().UnixMilli()
+	bucket := now - now%(trafficStepFine*1000)
+	rows := make([]*model.TrafficHistory, 0, len(traffics)+len(clientTraffics))
+	for _, traffic := range traffics {
+		if traffic.Up+traffic.Down == 0 {
+			continue
+		}
+		kind := model.TrafficKindInbound
+		if traffic.IsOutbound {
+			kind = model.TrafficKindOutbound
+		} else if !traffic.IsInbound {
+			continue
+		}
+		rows = append(rows, &model.TrafficHistory{
+			Kind: kind, Name: traffic.Tag, Step: trafficStepFine, Time: bucket, Up: traffic.Up, Down: traffic.Down,
+		})
+	}
+	for _, traffic := range clientTraffics {
+		if traffic.Up+traffic.Down == 0 {
+			continue
+		}
+		rows = append(rows, &model.TrafficHistory{
+			Kind: model.TrafficKindClient, Name: traffic.Email, Step: trafficStepFine, Time: bucket, Up: traffic.Up, Down: traffic.Down,
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return database.GetDB().Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "name"}, {Name: "step"}, {Name: "time"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"up":   gorm.Expr("up + excluded.up"),
+			"down": gorm.Expr("down + excluded.down"),
+		}),
+	}).CreateInBatches(rows, 100).Error
+}
+
+// Rollup recomputes the recent hourly buckets from the 5-minute buckets and the recent
+// daily buckets from the hourly ones. Buckets are overwritten, so running it again is harmless.
+func (s *TrafficHistoryService) Rollup() error {
+	now := time.Now().UnixMilli()
+	db := database.GetDB()
+	err := s.rollupStep(db, trafficStepFine, trafficStepHourly, now-2*trafficStepHourly*1000)
+	if err != nil {
+		return err
+	}
+	return s.rollupStep(db, trafficStepHourly, trafficStepDaily, now-trafficStepDaily*1000)
+}
+
+// rollupStep aggregates the buckets of resolution from into buckets of resolution to, starting at the bucket containing since.
+func (s *TrafficHistoryService) rollupStep(db *gorm.DB, from int64, to int64, since int64) error {
+	width := to * 1000
+	since -= since % width
+	return db.Exec(`INSERT INTO traffic_histories (kind, name, step, time, up, down)
+		SELECT kind, name, ?, (time / ?) * ?, SUM(up), SUM(down) FROM traffic_histories
+		WHERE step = ? AND time >= ?
+		GROUP BY kind, name, (time / ?) * ?
+		ON CONFLICT (kind, name, step, time) DO UPDATE SET up = excluded.up, down = excluded.down`,
+		to, width, width, from, since, width, width).Error
+}
+
+// DelExpiredHistory removes buckets older than the retention of their resolution.
+func (s *TrafficHistoryService) DelExpiredHistory() error {
+	now := time.Now()
+	db := database.GetDB()
+	err := db.Where("step = ? AND time < ?", trafficStepFine, now.Add(-trafficFineRetention).UnixMilli()).
+		Delete(model.TrafficHistory{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("step = ? AND time < ?", trafficStepHourly, now.Add(-trafficHourlyRetention).UnixMilli()).
+		Delete(model.TrafficHistory{}).Error
+	if err != nil {
+		return err
+	}
+	days, err := s.settingService.GetTrafficHistoryDays()
+	if err != nil || days <= 0 {
+		return err
+	}
+	return db.Where("step = ? AND time < ?", trafficStepDaily, now.AddDate(0, 0, -days).UnixMilli()).
+		Delete(model.TrafficHistory{}).Error
+}
+
+// GetHistory retrieves the traffic series of a client, inbound or outbound, aggregated into buckets of the requested step.
+func (s *TrafficHistoryService) GetHistory(kind string, name string, query *TrafficHistoryQuery) ([]*TrafficPoint, error) {
+	to := query.To
+	if to <= 0 {
+		to = time.Now().UnixMilli()
+	}
+	from := query.From
+	if from <= 0 {
+		from = to - trafficStepDaily*1000
+	}
+	if from > to {
+		return nil, common.NewError("invalid time range:", from, "-", to)
+	}
+	step, err := parseTrafficStep(query.Step, to-from)
+	if err != nil {
+		return nil, err
+	}
+
+	source := trafficStepFine
+	for _, candidate := range trafficSteps {
+		if step%candidate == 0 {
+			source = candidate
+			break
+		}
+	}
+	width := step * 1000
+	bucket := fmt.Sprintf("(time / %d) * %d", width, width)
+	points := make([]*TrafficPoint, 0)
+	err = database.GetDB().Model(model.TrafficHistory{}).
+		Select(bucket+" AS time, SUM(up) AS up, SUM(down) AS down").
+		Where("kind = ? AND name = ? AND step = ? AND time >= ? AND time <= ?", kind, name, source, from-from%width, to).
+		Group(bucket).
+		Order("time").
+		Scan(&points).Error
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// parseTrafficStep parses a bucket width given as seconds or a duration. An empty
+// value picks the finest resolution that is still stored for a range of this length.
+func parseTrafficStep(value string, rangeMs int64) (int64, error) {
+	if value == "" {
+		switch {
+		case rangeMs <= trafficFineRetention.Milliseconds():
+			return trafficStepFine, nil
+		case rangeMs <= trafficHourlyRetention.Milliseconds():
+			return trafficStepHourly, nil
+		default:
+			return trafficStepDaily, nil
+		}
+	}
+	step, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, common.NewError("invalid step:", value)
+		}
+		step = int64(duration / time.Second)
+	}
+	if step <= 0 || step%trafficStepFine != 0 {
+		return 0, common.NewError("step must be a multiple of 5 minutes:", value)
+	}
+	return step, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestTrafficHistoryRollup(t *testing.T) {
+	setupTestDB(t)
+
+	svc := TrafficHistoryService{}
+	clients := []*xray.ClientTraffic{{Email: "alice", Up: 100, Down: 1000}, {Email: "bob"}}
+	inbounds := []*xray.Traffic{{IsInbound: true, Tag: "in-443", Up: 100, Down: 1000}}
+	for range 2 {
+		if err := svc.AddTraffic(inbounds, clients); err != nil {
+			t.Fatalf("add traffic: %v", err)
+		}
+	}
+
+	var count int64
+	database.GetDB().Model(model.TrafficHistory{}).Where("name = ?", "bob").Count(&count)
+	if count != 0 {
+		t.Fatalf("idle clients must not create buckets, got %d", count)
+	}
+
+	if err := svc.Rollup(); err != nil {
+		t.Fatalf("rollup: %v", err)
+	}
+	// Rolling up again must not count the traffic twice.
+	if err := svc.Rollup(); err != nil {
+		t.Fatalf("rollup: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, step := range []string{"", "1h", "86400"} {
+		points, err := svc.GetHistory(model.TrafficKindClient, "alice", &TrafficHistoryQuery{From: now - 3600*1000, To: now, Step: step})
+		if err != nil {
+			t.Fatalf("get history with step %q: %v", step, err)
+		}
+		var up, down int64
+		for _, p := range points {
+			up += p.Up
+			down += p.Down
+		}
+		if up != 200 || down != 2000 {
+			t.Fatalf("step %q: expected 200/2000, got %d/%d", step, up, down)
+		}
+	}
+
+	if _, err := svc.GetHistory(model.TrafficKindInbound, "in-443", &TrafficHistoryQuery{Step: "90s"}); err == nil {
+		t.Fatalf("expected step below the finest resolution to be rejected")
+	}
+}
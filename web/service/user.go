@@ -6,6 +6,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
     ldaputil "github.com/mhsanaei/3x-ui/v2/util/ldap"
 	"github.com/xlzd/gotp"
@@ -154,3 +155,167 @@ func (s *UserService) UpdateFirstUser(username string, password string) error {
 	user.Password = hashedPassword
 	return db.Save(user).Error
 }
+
+// GetUserById retrieves a user by its ID.
+func (s *UserService) GetUserById(id int) (*model.User, error) {
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("id = ?", id).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUsers returns all panel users with their password hashes stripped.
+func (s *UserService) GetUsers() ([]*model.User, error) {
+	db := database.GetDB()
+	var users []*model.User
+	err := db.Model(model.User{}).Order("id").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		user.Password = ""
+	}
+	return users, nil
+}
+
+// AddUser creates a new panel user with the given role.
+func (s *UserService) AddUser(username string, password string, role string) (*model.User, error) {
+	if username == "" {
+		return nil, errors.New("username can not be empty")
+	} else if password == "" {
+		return nil, errors.New("password can not be empty")
+	}
+	if !model.IsValidRole(role) {
+		return nil, common.NewError("invalid role:", role)
+	}
+	exist, err := s.checkUsernameExist(username, 0)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, common.NewError("username already exists:", username)
+	}
+	hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		Username: username,
+		Password: hashedPassword,
+		Role:     role,
+	}
+	err = database.GetDB().Create(user).Error
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// UpdateUserInfo changes the username, role and optionally the password of a user.
+// An empty password keeps the current one. The last owner can not be demoted.
+func (s *UserService) UpdateUserInfo(id int, username string, password string, role string) error {
+	if username == "" {
+		return errors.New("username can not be empty")
+	}
+	if !model.IsValidRole(role) {
+		return common.NewError("invalid role:", role)
+	}
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	exist, err := s.checkUsernameExist(username, id)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return common.NewError("username already exists:", username)
+	}
+	if user.Role == model.RoleOwner && role != model.RoleOwner {
+		owners, err := s.countOwners()
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return errors.New("can not change the role of the last owner")
+		}
+	}
+
+	updates := map[string]any{"username": username, "role": role}
+	if password != "" {
+		hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
+		if err != nil {
+			return err
+		}
+		updates["password"] = hashedPassword
+	}
+	return database.GetDB().Model(model.User{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DelUser deletes a user with its API tokens and hands its inbounds and outbounds over to heirId.
+// The last owner can not be deleted.
+func (s *UserService) DelUser(id int, heirId int) error {
+	if id == heirId {
+		return errors.New("can not delete the current user")
+	}
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == model.RoleOwner {
+		owners, err := s.countOwners()
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return errors.New("can not delete the last owner")
+		}
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Model(model.Inbound{}).Where("user_id = ?", id).Update("user_id", heirId).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(model.Outbound{}).Where("user_id = ?", id).Update("user_id", heirId).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Where("user_id = ?", id).Delete(model.APIToken{}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Delete(model.User{}, id).Error
+	return err
+}
+
+func (s *UserService) checkUsernameExist(username string, ignoreId int) (bool, error) {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.User{}).Where("username = ? AND id != ?", username, ignoreId).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *UserService) countOwners() (int64, error) {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.User{}).Where("role = ?", model.RoleOwner).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,458 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"gorm.io/gorm"
+)
+
+const (
+	webhookMaxAttempts  = 8                // Attempts before a delivery is marked as failed
+	webhookRetryBase    = 30 * time.Second // Delay before the first retry, doubled on every further attempt
+	webhookRetryMax     = 6 * time.Hour
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 50
+	webhookLogRetention = 30 * 24 * time.Hour
+)
+
+// webhookEvent is an event waiting to be turned into deliveries.
+type webhookEvent struct {
+	event     string
+	data      any
+	time      time.Time
+	webhookId int // Only deliver to this webhook if set
+}
+
+var (
+	webhookQueue     = make(chan webhookEvent, 1024)
+	webhookQueueOnce sync.Once
+	webhookDeliverMu sync.Mutex
+	webhookClient    = &http.Client{Timeout: webhookTimeout}
+)
+
+// WebhookDeliveryFilter narrows down the deliveries returned by GetDeliveries.
+type WebhookDeliveryFilter struct {
+	WebhookId int    `form:"webhookId"`
+	Event     string `form:"event"`
+	Status    string `form:"status"`
+	Page      int    `form:"page"`
+	PageSize  int    `form:"pageSize"`
+}
+
+// WebhookService manages webhooks and delivers events to them.
+//
+// Every delivery is a POST of a JSON body {"event", "time", "data"} with the headers
+// X-XUI-Event, X-XUI-Delivery, X-XUI-Timestamp (unix seconds) and
+// X-XUI-Signature: "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+// Legacy webhooks only get the data as body and none of these headers.
+// Failed deliveries are retried with exponential backoff, except for the frequent traffic
+// updates, which are sent once and not logged.
+type WebhookService struct{}
+
+// GetWebhooks retrieves all webhooks.
+func (s *WebhookService) GetWebhooks() ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	err := database.GetDB().Model(model.Webhook{}).Order("id").Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetWebhook retrieves a webhook by its ID.
+func (s *WebhookService) GetWebhook(id int) (*model.Webhook, error) {
+	webhook := &model.Webhook{}
+	err := database.GetDB().Model(model.Webhook{}).First(webhook, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// AddWebhook creates a webhook. A random secret is generated if none is given.
+func (s *WebhookService) AddWebhook(webhook *model.Webhook) error {
+	if err := s.checkWebhook(webhook); err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = random.Seq(32)
+	}
+	webhook.Id = 0
+	return database.GetDB().Create(webhook).Error
+}
+
+// UpdateWebhook changes a webhook. An empty secret keeps the current one.
+func (s *WebhookService) UpdateWebhook(webhook *model.Webhook) error {
+	if err := s.checkWebhook(webhook); err != nil {
+		return err
+	}
+	old, err := s.GetWebhook(webhook.Id)
+	if err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = old.Secret
+	}
+	webhook.CreatedAt = old.CreatedAt
+	return database.GetDB().Save(webhook).Error
+}
+
+// DelWebhook deletes a webhook together with its deliveries.
+func (s *WebhookService) DelWebhook(id int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(model.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(model.Webhook{}, id).Error
+	})
+}
+
+func (s *WebhookService) checkWebhook(webhook *model.Webhook) error {
+	if webhook.Name == "" {
+		return common.NewError("webhook name can not be empty")
+	}
+	u, err := url.Parse(webhook.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return common.NewError("invalid webhook url:", webhook.Url)
+	}
+	events := strings.Split(webhook.Events, ",")
+	for i, event := range events {
+		events[i] = strings.TrimSpace(event)
+		if !model.IsValidWebhookEvent(events[i]) {
+			return common.NewError("invalid webhook event:", event)
+		}
+	}
+	webhook.Events = strings.Join(events, ",")
+	return nil
+}
+
+// Emit queues an event for all enabled webhooks subscribed to it. It never blocks and never
+// touches the database itself. Events of database transactions are collected in a webhookBatch
+// instead, so that webhooks are not notified of changes that are rolled back.
+func (s *WebhookService) Emit(event string, data any) {
+	s.queue(webhookEvent{event: event, data: data, time: time.Now()})
+}
+
+// webhookBatch collects the webhook events raised inside a database transaction, so that they
+// are only emitted once the transaction is committed.
+type webhookBatch struct {
+	events []webhookEvent
+}
+
+// add collects an event of the transaction.
+func (b *webhookBatch) add(event string, data any) {
+	b.events = append(b.events, webhookEvent{event: event, data: data, time: time.Now()})
+}
+
+// emit queues the collected events for all enabled webhooks subscribed to them.
+func (b *webhookBatch) emit() {
+	s := &WebhookService{}
+	for _, e := range b.events {
+		s.queue(e)
+	}
+	b.events = nil
+}
+
+// Ping queues a test event for a single webhook.
+func (s *WebhookService) Ping(id int) error {
+	if _, err := s.GetWebhook(id); err != nil {
+		return err
+	}
+	s.queue(webhookEvent{event: model.WebhookEventPing, data: map[string]any{}, time: time.Now(), webhookId: id})
+	return nil
+}
+
+func (s *WebhookService) queue(e webhookEvent) {
+	webhookQueueOnce.Do(func() {
+		go s.processQueue()
+	})
+	select {
+	case webhookQueue <- e:
+	default:
+		logger.Warning("webhook queue is full, dropping event", e.event)
+	}
+}
+
+// processQueue turns queued events into deliveries and attempts them right away.
+func (s *WebhookService) processQueue() {
+	for e := range webhookQueue {
+		if e.event == model.WebhookEventTrafficUpdated {
+			s.deliverNow(e)
+			continue
+		}
+		count, err := s.enqueue(e)
+		if err != nil {
+			logger.Warning("Unable to queue webhook event", e.event+":", err)
+			continue
+		}
+		if count > 0 {
+			s.DeliverPending()
+		}
+	}
+}
+
+// subscribedWebhooks returns the webhooks the event is delivered to.
+func (s *WebhookService) subscribedWebhooks(e webhookEvent) ([]*model.Webhook, error) {
+	webhooks, err := s.GetWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	subscribed := webhooks[:0]
+	for _, webhook := range webhooks {
+		if e.webhookId != 0 && webhook.Id != e.webhookId {
+			continue
+		}
+		if e.webhookId == 0 && (!webhook.Enable || !webhook.Subscribes(e.event)) {
+			continue
+		}
+		subscribed = append(subscribed, webhook)
+	}
+	return subscribed, nil
+}
+
+// webhookPayloads marshals the body of an event for a webhook, once per body format.
+type webhookPayloads struct {
+	e       webhookEvent
+	payload []byte
+	legacy  []byte
+}
+
+func (p *webhookPayloads) get(webhook *model.Webhook) ([]byte, error) {
+	var err error
+	if webhook.Legacy {
+		if p.legacy == nil {
+			p.legacy, err = json.Marshal(p.e.data)
+		}
+		return p.legacy, err
+	}
+	if p.payload == nil {
+		p.payload, err = json.Marshal(map[string]any{"event": p.e.event, "time": p.e.time.UnixMilli(), "data": p.e.data})
+	}
+	return p.payload, err
+}
+
+// deliverNow sends the event once to every subscribed webhook without storing deliveries.
+func (s *WebhookService) deliverNow(e webhookEvent) {
+	webhooks, err := s.subscribedWebhooks(e)
+	if err != nil {
+		logger.Warning("Unable to load webhooks:", err)
+		return
+	}
+	payloads := &webhookPayloads{e: e}
+	for _, webhook := range webhooks {
+		payload, err := payloads.get(webhook)
+		if err != nil {
+			logger.Warning("Unable to marshal webhook event", e.event+":", err)
+			return
+		}
+		delivery := &model.WebhookDelivery{WebhookId: webhook.Id, Event: e.event, Payload: string(payload)}
+		if _, err := s.send(webhook, delivery); err != nil {
+			logger.Debugf("webhook %s: delivery of %s failed: %v", webhook.Name, e.event, err)
+		}
+	}
+}
+
+// enqueue stores a pending delivery of the event for every subscribed webhook.
+func (s *WebhookService) enqueue(e webhookEvent) (int, error) {
+	webhooks, err := s.subscribedWebhooks(e)
+	if err != nil {
+		return 0, err
+	}
+	var deliveries []*model.WebhookDelivery
+	payloads := &webhookPayloads{e: e}
+	for _, webhook := range webhooks {
+		payload, err := payloads.get(webhook)
+		if err != nil {
+			return 0, err
+		}
+		deliveries = append(deliveries, &model.WebhookDelivery{
+			WebhookId:   webhook.Id,
+			Event:       e.event,
+			Payload:     string(payload),
+			Status:      model.WebhookDeliveryPending,
+			NextAttempt: e.time.UnixMilli(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+	return len(deliveries), database.GetDB().Create(deliveries).Error
+}
+
+// DeliverPending attempts all deliveries that are due. Concurrent calls return immediately.
+func (s *WebhookService) DeliverPending() {
+	if !webhookDeliverMu.TryLock() {
+		return
+	}
+	defer webhookDeliverMu.Unlock()
+
+	db := database.GetDB()
+	for {
+		var deliveries []*model.WebhookDelivery
+		err := db.Model(model.WebhookDelivery{}).
+			Where("status = ? AND next_attempt <= ?", model.WebhookDeliveryPending, time.Now().UnixMilli()).
+			Order("next_attempt, id").Limit(webhookBatchSize).Find(&deliveries).Error
+		if err != nil {
+			logger.Warning("Unable to load pending webhook deliveries:", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+		webhooks := make(map[int]*model.Webhook)
+		for _, delivery := range deliveries {
+			webhook, ok := webhooks[delivery.WebhookId]
+			if !ok {
+				webhook, _ = s.GetWebhook(delivery.WebhookId)
+				webhooks[delivery.WebhookId] = webhook
+			}
+			s.attempt(webhook, delivery)
+			if err := db.Save(delivery).Error; err != nil {
+				logger.Warning("Unable to update webhook delivery:", err)
+				return
+			}
+		}
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// attempt sends a delivery once and updates its state.
+func (s *WebhookService) attempt(webhook *model.Webhook, delivery *model.WebhookDelivery) {
+	if webhook == nil {
+		delivery.Status = model.WebhookDeliveryFailed
+		delivery.LastError = "webhook not found"
+		return
+	}
+	delivery.Attempts++
+	code, err := s.send(webhook, delivery)
+	delivery.ResponseCode = code
+	if err == nil {
+		delivery.Status = model.WebhookDeliverySuccess
+		delivery.LastError = ""
+		return
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = model.WebhookDeliveryFailed
+		logger.Warningf("webhook %s: giving up delivery %d of %s: %v", webhook.Name, delivery.Id, delivery.Event, err)
+		return
+	}
+	delivery.NextAttempt = time.Now().Add(webhookBackoff(delivery.Attempts)).UnixMilli()
+}
+
+// send posts the signed payload of a delivery to the webhook URL.
+func (s *WebhookService) send(webhook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("User-Agent", "3x-ui-webhook")
+	if !webhook.Legacy {
+		req.Header.Set("X-XUI-Event", delivery.Event)
+		req.Header.Set("X-XUI-Delivery", strconv.Itoa(delivery.Id))
+		req.Header.Set("X-XUI-Timestamp", timestamp)
+		req.Header.Set("X-XUI-Signature", "sha256="+SignWebhookPayload(webhook.Secret, timestamp, []byte(delivery.Payload)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload computes the hex HMAC-SHA256 signature of a payload sent at the given unix timestamp.
+func SignWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before the next attempt after the given number of attempts.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+// GetDeliveries retrieves deliveries matching the filter, newest first, along with the total match count.
+func (s *WebhookService) GetDeliveries(filter *WebhookDeliveryFilter) ([]*model.WebhookDelivery, int64, error) {
+	db := database.GetDB().Model(model.WebhookDelivery{})
+	if filter.WebhookId != 0 {
+		db = db.Where("webhook_id = ?", filter.WebhookId)
+	}
+	if filter.Event != "" {
+		db = db.Where("event = ?", filter.Event)
+	}
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = 100
+	}
+	page := max(filter.Page, 1)
+	var deliveries []*model.WebhookDelivery
+	err := db.Order("id desc").Limit(pageSize).Offset((page - 1) * pageSize).Find(&deliveries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// RetryDelivery queues a finished delivery for another round of attempts.
+func (s *WebhookService) RetryDelivery(id int) error {
+	result := database.GetDB().Model(model.WebhookDelivery{}).Where("id = ? AND status <> ?", id, model.WebhookDeliveryPending).
+		Updates(map[string]any{"status": model.WebhookDeliveryPending, "attempts": 0, "next_attempt": time.Now().UnixMilli()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("delivery not found or already pending:", id)
+	}
+	go s.DeliverPending()
+	return nil
+}
+
+// DelExpiredDeliveries removes finished deliveries older than the delivery log retention.
+func (s *WebhookService) DelExpiredDeliveries() (int64, error) {
+	cutoff := time.Now().Add(-webhookLogRetention).UnixMilli()
+	result := database.GetDB().Where("status <> ? AND created_at < ?", model.WebhookDeliveryPending, cutoff).
+		Delete(model.WebhookDelivery{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestWebhookDeliveryAndRetry(t *testing.T) {
+	setupTestDB(t)
+
+	var fail atomic.Bool
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get("X-XUI-Timestamp")
+		if r.Header.Get("X-XUI-Signature") != "sha256="+SignWebhookPayload("s3cret", timestamp, body) {
+			t.Errorf("bad signature for body %s", body)
+		}
+		if r.Header.Get("X-XUI-Event") != model.WebhookEventClientCreated {
+			t.Errorf("unexpected event header %q", r.Header.Get("X-XUI-Event"))
+		}
+		received.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	svc := WebhookService{}
+	hook := &model.Webhook{Name: "test", Url: server.URL, Secret: "s3cret", Events: "client.created", Enable: true}
+	if err := svc.AddWebhook(hook); err != nil {
+		t.Fatalf("add webhook: %v", err)
+	}
+	if err := svc.AddWebhook(&model.Webhook{Name: "bad", Url: server.URL, Events: "nope"}); err == nil {
+		t.Fatal("expected unknown event to be rejected")
+	}
+
+	fail.Store(true)
+	count, err := svc.enqueue(webhookEvent{event: model.WebhookEventClientCreated, data: map[string]any{"email": "alice"}, time: time.Now()})
+	if err != nil || count != 1 {
+		t.Fatalf("enqueue: count=%d err=%v", count, err)
+	}
+	if count, _ := svc.enqueue(webhookEvent{event: model.WebhookEventXrayCrashed, time: time.Now()}); count != 0 {
+		t.Fatalf("unsubscribed event queued %d deliveries", count)
+	}
+	svc.DeliverPending()
+
+	deliveries, total, err := svc.GetDeliveries(&WebhookDeliveryFilter{WebhookId: hook.Id})
+	if err != nil || total != 1 {
+		t.Fatalf("get deliveries: total=%d err=%v", total, err)
+	}
+	delivery := deliveries[0]
+	if delivery.Status != model.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusBadGateway {
+		t.Fatalf("expected failed attempt to stay pending, got %+v", delivery)
+	}
+	if wait := time.Until(time.UnixMilli(delivery.NextAttempt)); wait < 20*time.Second {
+		t.Fatalf("expected retry to be backed off, next attempt in %v", wait)
+	}
+
+	// Not due yet, so nothing is sent
+	svc.DeliverPending()
+	if received.Load() != 1 {
+		t.Fatalf("expected 1 request before the retry is due, got %d", received.Load())
+	}
+
+	fail.Store(false)
+	database.GetDB().Model(delivery).Update("next_attempt", 0)
+	svc.DeliverPending()
+	deliveries, _, _ = svc.GetDeliveries(&WebhookDeliveryFilter{Status: model.WebhookDeliverySuccess})
+	if len(deliveries) != 1 || deliveries[0].Attempts != 2 || deliveries[0].ResponseCode != http.StatusOK {
+		t.Fatalf("expected successful retry, got %+v", deliveries)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	if webhookBackoff(1) != 30*time.Second || webhookBackoff(3) != 2*time.Minute {
+		t.Fatalf("unexpected backoff: %v, %v", webhookBackoff(1), webhookBackoff(3))
+	}
+	if webhookBackoff(50) != webhookRetryMax {
+		t.Fatalf("backoff not capped: %v", webhookBackoff(50))
+	}
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	all := &model.Webhook{Events: "*"}
+	if !all.Subscribes(model.WebhookEventClientCreated) || all.Subscribes(model.WebhookEventPing) ||
+		all.Subscribes(model.WebhookEventTrafficUpdated) {
+		t.Fatal("expected * to cover all events but ping and traffic.updated")
+	}
+	traffic := &model.Webhook{Events: "client.created, traffic.updated"}
+	if !traffic.Subscribes(model.WebhookEventTrafficUpdated) || traffic.Subscribes(model.WebhookEventClientExpired) {
+		t.Fatal("expected events subscribed to by name")
+	}
+}
+
+func TestWebhookTrafficUpdated(t *testing.T) {
+	setupTestDB(t)
+
+	bodies := make(map[string]string)
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies[r.URL.Path] = string(body)
+		mu.Unlock()
+		if r.URL.Path == "/legacy" && r.Header.Get("X-XUI-Signature") != "" {
+			t.Errorf("unexpected signature header for the legacy webhook")
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	svc := WebhookService{}
+	for _, hook := range []*model.Webhook{
+		{Name: "legacy", Url: server.URL + "/legacy", Events: "traffic.updated", Legacy: true, Enable: true},
+		{Name: "signed", Url: server.URL + "/signed", Events: "traffic.updated", Enable: true},
+	} {
+		if err := svc.AddWebhook(hook); err != nil {
+			t.Fatalf("add webhook: %v", err)
+		}
+	}
+	svc.deliverNow(webhookEvent{event: model.WebhookEventTrafficUpdated, data: map[string]any{"clientTraffics": []any{}}, time: time.UnixMilli(5)})
+
+	if bodies["/legacy"] != `{"clientTraffics":[]}` {
+		t.Fatalf("expected the bare data for the legacy webhook, got %s", bodies["/legacy"])
+	}
+	if bodies["/signed"] != `{"data":{"clientTraffics":[]},"event":"traffic.updated","time":5}` {
+		t.Fatalf("unexpected payload %s", bodies["/signed"])
+	}
+	if _, total, _ := svc.GetDeliveries(&WebhookDeliveryFilter{}); total != 0 {
+		t.Fatalf("expected traffic updates not to be stored, got %d deliveries", total)
+	}
+}
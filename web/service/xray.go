@@ -5,6 +5,7 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/xray"
@@ -15,8 +16,11 @@ import (
 var (
 	p                 *xray.Process
 	lock              sync.Mutex
-	isNeedXrayRestart atomic.Bool // Indicates that restart was requested for Xray
-	isManuallyStopped atomic.Bool // Indicates that Xray was stopped manually from the panel
+	isNeedXrayRestart atomic.Bool  // Indicates that restart was requested for Xray
+	isManuallyStopped atomic.Bool  // Indicates that Xray was stopped manually from the panel
+	xrayStarts        atomic.Int64 // Number of times Xray was started by the panel
+	xrayCrashes       atomic.Int64 // Number of times Xray was found crashed
+	xrayHotApplies    atomic.Int64 // Number of times a config was applied to the running Xray without a restart
 	result            string
 )
 
@@ -107,6 +111,10 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	activeClients, err := s.inboundService.getActiveClients()
+	if err != nil {
+		return nil, err
+	}
 	for _, inbound := range inbounds {
 		if !inbound.Enable {
 			continue
@@ -114,40 +122,18 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		// get settings clients
 		settings := map[string]any{}
 		json.Unmarshal([]byte(inbound.Settings), &settings)
-		clients, ok := settings["clients"].([]any)
-		if ok {
-			// check users active or not
-			clientStats := inbound.ClientStats
-			for _, clientTraffic := range clientStats {
-				indexDecrease := 0
-				for index, client := range clients {
-					c := client.(map[string]any)
-					if c["email"] == clientTraffic.Email {
-						if !clientTraffic.Enable {
-							clients = RemoveIndex(clients, index-indexDecrease)
-							indexDecrease++
-							logger.Infof("Remove Inbound User %s due to expiration or traffic limit", c["email"])
-						}
-					}
-				}
-			}
-
-			// clear client config for additional parameters
+		if _, ok := settings["clients"].([]any); ok {
+			// only pass active clients, without the additional parameters of the panel
 			var final_clients []any
-			for _, client := range clients {
-				c := client.(map[string]any)
-				if c["enable"] != nil {
-					if enable, ok := c["enable"].(bool); ok && !enable {
-						continue
-					}
-				}
+			for _, client := range activeClients[inbound.Id] {
+				c := client.ClientMap()
 				for key := range c {
 					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
 						delete(c, key)
 					}
-					if c["flow"] == "xtls-rprx-vision-udp443" {
-						c["flow"] = "xtls-rprx-vision"
-					}
+				}
+				if c["flow"] == "xtls-rprx-vision-udp443" {
+					c["flow"] = "xtls-rprx-vision"
 				}
 				final_clients = append(final_clients, any(c))
 			}
@@ -246,6 +232,13 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		}
 	}
 
+	if err := enableRoutingService(xrayConfig); err != nil {
+		return nil, err
+	}
+	if err := s.applyIpBans(xrayConfig); err != nil {
+		return nil, err
+	}
+
 	return xrayConfig, nil
 }
 
@@ -269,6 +262,8 @@ func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic,
 }
 
 // RestartXray restarts the Xray process, optionally forcing a restart even if config unchanged.
+// Unless forced, a running Xray is only replaced by a config that passes validation. A stopped
+// Xray is always started, so that the supervisor handles a config that does not run.
 func (s *XrayService) RestartXray(isForce bool) error {
 	lock.Lock()
 	defer lock.Unlock()
@@ -279,25 +274,54 @@ func (s *XrayService) RestartXray(isForce bool) error {
 	if err != nil {
 		return err
 	}
+	hash := xrayConfigHash(xrayConfig)
 
 	if s.IsXrayRunning() {
+		if !isForce && s.isRolledBackFrom(hash) {
+			logger.Debug("Xray keeps the last good config until the config changes")
+			return nil
+		}
 		if !isForce && p.GetConfig().Equals(xrayConfig) && !isNeedXrayRestart.Load() {
 			logger.Debug("It does not need to restart Xray")
 			return nil
 		}
+		if !isForce {
+			// Keep the running Xray if the new config would not start
+			if errs := validateXrayConfig(xrayConfig); len(errs) > 0 {
+				return errs
+			}
+			err := s.applyXrayConfig(xrayConfig, hash)
+			if err == nil {
+				return nil
+			}
+			logger.Info("Restarting Xray to apply the config:", err)
+		}
 		p.Stop()
 	}
 
 	p = xray.NewProcess(xrayConfig)
 	result = ""
+	supervisor.Lock()
+	supervisor.runningHash = hash
+	supervisor.appliedAt = time.Time{}
+	supervisor.badHash = ""
+	supervisor.Unlock()
 	err = p.Start()
 	if err != nil {
 		return err
 	}
+	xrayStarts.Inc()
 
 	return nil
 }
 
+// isRolledBackFrom reports whether the supervisor rolled Xray back from the configuration with the hash.
+func (s *XrayService) isRolledBackFrom(hash string) bool {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	return supervisor.badHash != "" && supervisor.badHash == hash
+}
+
 // StopXray stops the running Xray process.
 func (s *XrayService) StopXray() error {
 	lock.Lock()
@@ -320,6 +344,22 @@ func (s *XrayService) IsNeedRestartAndSetFalse() bool {
 	return isNeedXrayRestart.CompareAndSwap(true, false)
 }
 
+// GetXrayStartCount returns how many times Xray was started since the panel started.
+func (s *XrayService) GetXrayStartCount() int64 {
+	return xrayStarts.Load()
+}
+
+// GetXrayCrashCount returns how many Xray crashes were detected since the panel started.
+func (s *XrayService) GetXrayCrashCount() int64 {
+	return xrayCrashes.Load()
+}
+
+// GetXrayHotApplyCount returns how many times a config was applied to the running Xray without a
+// restart since the panel started.
+func (s *XrayService) GetXrayHotApplyCount() int64 {
+	return xrayHotApplies.Load()
+}
+
 // DidXrayCrash checks if Xray crashed by verifying it's not running and wasn't manually stopped.
 func (s *XrayService) DidXrayCrash() bool {
 	return !s.IsXrayRunning() && !isManuallyStopped.Load()
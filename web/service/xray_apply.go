@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// enableRoutingService adds the RoutingService to the API services of a generated Xray config, so
+// that routing rules change without a restart also with templates stored before it was a default.
+func enableRoutingService(xrayConfig *xray.Config) error {
+	api := map[string]any{}
+	if json.Unmarshal(xrayConfig.API, &api) != nil || len(api) == 0 {
+		return nil
+	}
+	services, _ := api["services"].([]any)
+	if slices.Contains(services, any("RoutingService")) {
+		return nil
+	}
+	api["services"] = append(services, "RoutingService")
+	data, err := json.MarshalIndent(api, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.API = data
+	return nil
+}
+
+// applyXrayConfig applies a new configuration to the running Xray through its API, so that only
+// the inbounds and outbounds that changed are disturbed. It returns an error if the configuration
+// needs a restart or could not be applied, in which case the caller restarts Xray. The caller must
+// hold the Xray lock.
+func (s *XrayService) applyXrayConfig(xrayConfig *xray.Config, hash string) error {
+	diff, err := p.GetConfig().Diff(xrayConfig)
+	if err != nil {
+		return err
+	}
+	if !diff.IsEmpty() {
+		if err := s.xrayAPI.Init(p.GetAPIPort()); err != nil {
+			return err
+		}
+		defer s.xrayAPI.Close()
+		if err := s.xrayAPI.ApplyConfigDiff(diff); err != nil {
+			return err
+		}
+	}
+	if err := p.SetConfig(xrayConfig); err != nil {
+		logger.Warning("Unable to save the applied Xray config:", err)
+	}
+	xrayHotApplies.Inc()
+
+	supervisor.Lock()
+	if supervisor.runningHash != hash {
+		supervisor.appliedAt = time.Now()
+	}
+	supervisor.runningHash = hash
+	supervisor.badHash = ""
+	supervisor.Unlock()
+	logger.Infof("Xray config applied without restart: %d inbounds and %d outbounds removed, %d inbounds and %d outbounds added, routing changed: %v",
+		len(diff.RemovedInbounds), len(diff.RemovedOutbounds), len(diff.AddedInbounds), len(diff.AddedOutbounds), diff.Routing != nil)
+	return nil
+}
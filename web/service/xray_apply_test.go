@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	routerService "github.com/xtls/xray-core/app/router/command"
+	"google.golang.org/grpc"
+)
+
+// fakeXrayAPI records the calls of the handler and routing services.
+type fakeXrayAPI struct {
+	command.UnimplementedHandlerServiceServer
+	routerService.UnimplementedRoutingServiceServer
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeXrayAPI) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeXrayAPI) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeXrayAPI) AddInbound(_ context.Context, req *command.AddInboundRequest) (*command.AddInboundResponse, error) {
+	f.record("addInbound " + req.Inbound.Tag)
+	return &command.AddInboundResponse{}, nil
+}
+
+func (f *fakeXrayAPI) RemoveInbound(_ context.Context, req *command.RemoveInboundRequest) (*command.RemoveInboundResponse, error) {
+	f.record("removeInbound " + req.Tag)
+	return &command.RemoveInboundResponse{}, nil
+}
+
+func (f *fakeXrayAPI) AddOutbound(_ context.Context, req *command.AddOutboundRequest) (*command.AddOutboundResponse, error) {
+	f.record("addOutbound " + req.Outbound.Tag)
+	return &command.AddOutboundResponse{}, nil
+}
+
+func (f *fakeXrayAPI) RemoveOutbound(_ context.Context, req *command.RemoveOutboundRequest) (*command.RemoveOutboundResponse, error) {
+	f.record("removeOutbound " + req.Tag)
+	return &command.RemoveOutboundResponse{}, nil
+}
+
+func (f *fakeXrayAPI) AddRule(_ context.Context, req *routerService.AddRuleRequest) (*routerService.AddRuleResponse, error) {
+	f.record("addRule " + strconv.FormatBool(req.ShouldAppend))
+	return &routerService.AddRuleResponse{}, nil
+}
+
+func TestXrayHotApply(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Xray binary is a shell script")
+	}
+	setupTestDB(t)
+	t.Setenv("XUI_BIN_FOLDER", t.TempDir())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeXrayAPI{}
+	server := grpc.NewServer()
+	command.RegisterHandlerServiceServer(server, api)
+	routerService.RegisterRoutingServiceServer(server, api)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	// Xray that runs until it is stopped
+	script := "#!/bin/sh\n[ \"$1\" = -version ] && echo 'Xray 1.0.0' && exit 0\nexec sleep 60\n"
+	if err := os.WriteFile(xray.GetBinaryPath(), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	apiPort := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	// a template stored before the RoutingService was a default
+	template := func(logLevel string, rules string) string {
+		return `{
+  "log": {"loglevel": "` + logLevel + `"},
+  "api": {"tag": "api", "services": ["HandlerService", "StatsService"]},
+  "inbounds": [{"tag": "api", "listen": "127.0.0.1", "port": ` + apiPort + `, "protocol": "tunnel", "settings": {"address": "127.0.0.1"}}],
+  "outbounds": [{"tag": "direct", "protocol": "freedom"}, {"tag": "blocked", "protocol": "blackhole"}],
+  "routing": {"domainStrategy": "AsIs", "rules": [` + rules + `]}
+}`
+	}
+	apiRule := `{"type": "field", "inboundTag": ["api"], "outboundTag": "api"}`
+	settings := &SettingService{}
+	if err := settings.saveSetting("xrayTemplateConfig", template("warning", apiRule)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if p != nil {
+			p.Stop()
+		}
+		p = nil
+	}()
+
+	s := &XrayService{}
+	if err := s.RestartXray(true); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	started := p
+	applies := s.GetXrayHotApplyCount()
+	expect := func(step string, want ...string) {
+		t.Helper()
+		if err := s.RestartXray(false); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if got := api.takeCalls(); strings.Join(got, ", ") != strings.Join(want, ", ") {
+			t.Fatalf("%s: expected calls %v, got %v", step, want, got)
+		}
+	}
+
+	inbound := &model.Inbound{
+		Enable:   true,
+		Port:     20000,
+		Protocol: model.Mixed,
+		Settings: `{"auth": "noauth"}`,
+		Tag:      "inbound-20000",
+	}
+	if err := database.GetDB().Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	expect("add inbound", "removeInbound inbound-20000", "addInbound inbound-20000")
+
+	if err := database.GetDB().Model(inbound).Update("port", 20001).Error; err != nil {
+		t.Fatal(err)
+	}
+	expect("change inbound", "removeInbound inbound-20000", "addInbound inbound-20000")
+
+	blockRule := `{"type": "field", "protocol": ["bittorrent"], "outboundTag": "blocked"}`
+	if err := settings.saveSetting("xrayTemplateConfig", template("warning", apiRule+", "+blockRule)); err != nil {
+		t.Fatal(err)
+	}
+	expect("change routing", "addRule false")
+
+	if err := database.GetDB().Delete(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	expect("remove inbound", "removeInbound inbound-20000")
+
+	if p != started || s.GetXrayHotApplyCount() != applies+4 {
+		t.Fatal("expected the changes to be applied without a restart")
+	}
+	if supervisor.appliedAt.IsZero() {
+		t.Fatal("expected the stable time to count from the last apply")
+	}
+	if !p.GetConfig().Equals(mustXrayConfig(t, s)) {
+		t.Fatal("expected the applied config to be kept")
+	}
+
+	if err := settings.saveSetting("xrayTemplateConfig", template("info", apiRule+", "+blockRule)); err != nil {
+		t.Fatal(err)
+	}
+	expect("change log level")
+	if p == started {
+		t.Fatal("expected a log level change to restart Xray")
+	}
+}
+
+func mustXrayConfig(t *testing.T, s *XrayService) *xray.Config {
+	t.Helper()
+	config, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
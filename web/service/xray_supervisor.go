@@ -0,0 +1,276 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+const (
+	xrayBackoffMax    = 5 * time.Minute // Longest delay between restarts of a crash-looping Xray
+	xrayCrashesKept   = 100             // Crashes kept in the crash history
+	xrayCrashLogLimit = 8 << 10         // Longest output kept for a crash, in bytes
+)
+
+// supervisor is the state of the Xray supervisor, shared like the Xray process itself.
+var supervisor struct {
+	sync.Mutex
+	recorded    *xray.Process    // Last process whose crash was recorded
+	lastCrash   *model.XrayCrash // Crash recorded for that process
+	crashes     int              // Crashes since the last stable run
+	nextRestart time.Time        // Earliest time of the next restart
+	tripped     bool             // The crash loop tripped since the last stable run
+	goodHash    string           // Hash of the last configuration that ran stable, loaded lazily
+	goodLoaded  bool
+	runningHash string    // Hash of the configuration of the current process
+	appliedAt   time.Time // Time the configuration was applied through the API, zero if the process started with it
+	badHash     string    // Hash of the configuration rolled back from, not restarted unless forced
+}
+
+// XraySupervisorStatus describes the state of the Xray supervisor.
+type XraySupervisorStatus struct {
+	Crashes     int    `json:"crashes"`     // Crashes since the last stable run
+	NextRestart int64  `json:"nextRestart"` // Timestamp in milliseconds of the pending restart, 0 if none is pending
+	CrashLoop   bool   `json:"crashLoop"`   // The crash loop limit was reached since the last stable run
+	RolledBack  bool   `json:"rolledBack"`  // Xray runs the last good configuration instead of the current one
+	ConfigHash  string `json:"configHash"`  // Hash of the configuration of the current process
+	GoodHash    string `json:"goodHash"`    // Hash of the last configuration that ran stable
+}
+
+// XrayCrashReport is the crash history together with the state of the supervisor.
+type XrayCrashReport struct {
+	Status  XraySupervisorStatus `json:"status"`
+	Crashes []*model.XrayCrash   `json:"crashes"`
+}
+
+// GetXraySupervisorStatus returns the state of the Xray supervisor.
+func (s *XrayService) GetXraySupervisorStatus() XraySupervisorStatus {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	s.loadGoodHash()
+	status := XraySupervisorStatus{
+		Crashes:    supervisor.crashes,
+		CrashLoop:  supervisor.tripped,
+		RolledBack: supervisor.badHash != "",
+		ConfigHash: supervisor.runningHash,
+		GoodHash:   supervisor.goodHash,
+	}
+	if s.DidXrayCrash() && !supervisor.nextRestart.IsZero() {
+		status.NextRestart = supervisor.nextRestart.UnixMilli()
+	}
+	return status
+}
+
+// GetXrayCrashReport retrieves the crash history, newest first, with the state of the supervisor.
+func (s *XrayService) GetXrayCrashReport(limit int) (*XrayCrashReport, error) {
+	crashes := make([]*model.XrayCrash, 0)
+	err := database.GetDB().Model(model.XrayCrash{}).Order("id desc").Limit(limit).Find(&crashes).Error
+	if err != nil {
+		return nil, err
+	}
+	return &XrayCrashReport{Status: s.GetXraySupervisorStatus(), Crashes: crashes}, nil
+}
+
+// CheckXrayStable keeps the configuration of a running Xray as the last good one once it has
+// run for the configured stable minutes, which also ends a crash loop. A configuration applied
+// through the API counts from the time it was applied.
+func (s *XrayService) CheckXrayStable() {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	if !s.IsXrayRunning() || supervisor.runningHash == "" {
+		return
+	}
+	s.loadGoodHash()
+	if supervisor.crashes == 0 && supervisor.runningHash == supervisor.goodHash {
+		return
+	}
+	minutes, err := s.settingService.GetXrayStableMinutes()
+	if err != nil {
+		return
+	}
+	stable := time.Duration(max(minutes, 1)) * time.Minute
+	if time.Duration(p.GetUptime())*time.Second < stable || time.Since(supervisor.appliedAt) < stable {
+		return
+	}
+
+	if supervisor.runningHash != supervisor.goodHash {
+		data, err := json.MarshalIndent(p.GetConfig(), "", "  ")
+		if err == nil {
+			err = os.WriteFile(xray.GetLastGoodConfigPath(), data, 0o600)
+		}
+		if err != nil {
+			logger.Warning("Unable to save the last good Xray config:", err)
+			return
+		}
+		supervisor.goodHash = supervisor.runningHash
+		logger.Info("Xray config", supervisor.goodHash, "is the last good config")
+	}
+	supervisor.crashes = 0
+	supervisor.tripped = false
+}
+
+// RecordXrayCrash records a crash of the current Xray process in the crash history, once per
+// process, and schedules the next restart with an exponential backoff. It returns the recorded
+// crash, nil if it was already recorded, and whether the crash loop limit was just reached.
+func (s *XrayService) RecordXrayCrash() (*model.XrayCrash, bool) {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	if p == nil || supervisor.recorded == p {
+		return nil, false
+	}
+	supervisor.recorded = p
+	supervisor.lastCrash = nil
+	xrayCrashes.Inc()
+
+	crash := &model.XrayCrash{
+		Time:       time.Now().UnixMilli(),
+		ExitCode:   p.GetExitCode(),
+		Error:      s.GetXrayResult(),
+		LastLines:  strings.Join(p.GetLastLines(), "\n"),
+		ConfigHash: supervisor.runningHash,
+	}
+	if err := p.GetErr(); err != nil && crash.Error == "" {
+		crash.Error = err.Error()
+	}
+	if len(crash.LastLines) > xrayCrashLogLimit {
+		crash.LastLines = crash.LastLines[len(crash.LastLines)-xrayCrashLogLimit:]
+	}
+
+	supervisor.crashes++
+	backoff := xrayBackoffMax
+	if supervisor.crashes <= 16 {
+		backoff = min(time.Second<<(supervisor.crashes-1), xrayBackoffMax)
+	}
+	supervisor.nextRestart = time.Now().Add(backoff)
+
+	tripped := false
+	if limit, err := s.settingService.GetXrayCrashLoopCount(); err == nil && limit > 0 && supervisor.crashes >= limit && !supervisor.tripped {
+		supervisor.tripped = true
+		tripped = true
+	}
+	logger.Warningf("Xray crashed (%d in a row), restarting in %v", supervisor.crashes, backoff)
+
+	db := database.GetDB()
+	if err := db.Create(crash).Error; err != nil {
+		logger.Warning("Unable to record the Xray crash:", err)
+		return crash, tripped
+	}
+	supervisor.lastCrash = crash
+	err := db.Where("id <= ?", crash.Id-xrayCrashesKept).Delete(model.XrayCrash{}).Error
+	if err != nil {
+		logger.Warning("Unable to clean the Xray crash history:", err)
+	}
+	return crash, tripped
+}
+
+// IsXrayRestartDue reports whether the backoff delay after the last crash has passed.
+func (s *XrayService) IsXrayRestartDue() bool {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	return !time.Now().Before(supervisor.nextRestart)
+}
+
+// RestartCrashedXray restarts a crashed Xray. While the crash loop limit is reached, or when the
+// current configuration fails validation, it starts the last good configuration instead of the
+// current one if rollback is enabled and one exists. It returns whether it rolled back from
+// another configuration.
+func (s *XrayService) RestartCrashedXray() (bool, error) {
+	var configErrors XrayConfigErrors
+	invalid := errors.As(s.ValidateXrayConfig(), &configErrors)
+	if invalid {
+		logger.Warning("The current Xray config is invalid:", configErrors)
+	}
+
+	supervisor.Lock()
+	s.loadGoodHash()
+	rollback := (supervisor.tripped || invalid) && supervisor.goodHash != ""
+	changed := supervisor.runningHash != supervisor.goodHash
+	crash := supervisor.lastCrash
+	supervisor.Unlock()
+	if rollback {
+		if enable, err := s.settingService.GetXrayRollbackEnable(); err != nil || !enable {
+			rollback = false
+		}
+	}
+	if !rollback {
+		return false, s.RestartXray(false)
+	}
+
+	err := s.rollbackXray()
+	if err != nil {
+		logger.Warning("Xray rollback failed, restarting with the current config:", err)
+		return false, s.RestartXray(false)
+	}
+	if changed && crash != nil {
+		database.GetDB().Model(crash).Update("rolled_back", true)
+	}
+	return changed, nil
+}
+
+// rollbackXray starts Xray with the last good configuration and the current IP bans. The current
+// configuration is remembered as bad, so that RestartXray keeps the good one until it changes or a
+// restart is forced.
+func (s *XrayService) rollbackXray() error {
+	data, err := os.ReadFile(xray.GetLastGoodConfigPath())
+	if err != nil {
+		return err
+	}
+	goodConfig := &xray.Config{}
+	if err := json.Unmarshal(data, goodConfig); err != nil {
+		return err
+	}
+	if err := s.applyIpBans(goodConfig); err != nil {
+		return err
+	}
+	currentConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if s.IsXrayRunning() {
+		p.Stop()
+	}
+	p = xray.NewProcess(goodConfig)
+	result = ""
+	if err := p.Start(); err != nil {
+		return err
+	}
+	xrayStarts.Inc()
+
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	supervisor.runningHash = xrayConfigHash(goodConfig)
+	supervisor.appliedAt = time.Time{}
+	if badHash := xrayConfigHash(currentConfig); badHash != supervisor.runningHash {
+		supervisor.badHash = badHash
+	}
+	logger.Warning("Xray was rolled back to the last good config", supervisor.runningHash)
+	return nil
+}
+
+// loadGoodHash loads the hash of the last good configuration saved by a previous run of the panel.
+// The caller must hold the supervisor lock.
+func (s *XrayService) loadGoodHash() {
+	if supervisor.goodLoaded {
+		return
+	}
+	supervisor.goodLoaded = true
+	data, err := os.ReadFile(xray.GetLastGoodConfigPath())
+	if err != nil {
+		return
+	}
+	goodConfig := &xray.Config{}
+	if err := json.Unmarshal(data, goodConfig); err == nil {
+		supervisor.goodHash = xrayConfigHash(goodConfig)
+	}
+}
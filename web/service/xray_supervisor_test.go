@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestXraySupervisorRollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Xray binary is a shell script")
+	}
+	setupTestDB(t)
+	t.Setenv("XUI_BIN_FOLDER", t.TempDir())
+
+	// Xray that crashes right after it started
+	script := "#!/bin/sh\n[ \"$1\" = -version ] && echo 'Xray 1.0.0' && exit 0\necho 'panic: boom'\nexit 3\n"
+	if err := os.WriteFile(xray.GetBinaryPath(), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	good := &xray.Config{LogConfig: []byte(`{"loglevel":"none"}`)}
+	data, _ := json.Marshal(good)
+	if err := os.WriteFile(xray.GetLastGoodConfigPath(), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	settings := &SettingService{}
+	if err := settings.saveSetting("xrayCrashLoopCount", "2"); err != nil {
+		t.Fatal(err)
+	}
+	supervisor.recorded, supervisor.lastCrash, supervisor.crashes, supervisor.tripped = nil, nil, 0, false
+	supervisor.goodHash, supervisor.goodLoaded, supervisor.badHash = "", false, ""
+	defer func() { p = nil }()
+
+	s := &XrayService{}
+	waitCrash := func() {
+		for deadline := time.Now().Add(5 * time.Second); s.IsXrayRunning(); {
+			if time.Now().After(deadline) {
+				t.Fatal("xray did not exit")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := s.RestartXray(true); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitCrash()
+	crash, tripped := s.RecordXrayCrash()
+	if crash == nil || tripped || crash.ExitCode != 3 || !strings.Contains(crash.LastLines, "panic: boom") {
+		t.Fatalf("unexpected first crash %+v (tripped %v)", crash, tripped)
+	}
+	if again, _ := s.RecordXrayCrash(); again != nil {
+		t.Fatal("expected a crash to be recorded once")
+	}
+	if s.IsXrayRestartDue() {
+		t.Fatal("expected the restart to be backed off")
+	}
+
+	if rolledBack, err := s.RestartCrashedXray(); err != nil || rolledBack {
+		t.Fatalf("expected a plain restart (%v)", err)
+	}
+	waitCrash()
+	if crash, tripped = s.RecordXrayCrash(); crash == nil || !tripped {
+		t.Fatal("expected the crash loop to trip")
+	}
+
+	rolledBack, err := s.RestartCrashedXray()
+	if err != nil || !rolledBack {
+		t.Fatalf("expected a rollback (%v)", err)
+	}
+	status := s.GetXraySupervisorStatus()
+	if !status.RolledBack || !status.CrashLoop || status.ConfigHash != xrayConfigHash(good) || status.Crashes != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	waitCrash()
+
+	report, err := s.GetXrayCrashReport(10)
+	if err != nil || len(report.Crashes) != 2 {
+		t.Fatalf("unexpected report %+v (%v)", report, err)
+	}
+	if !report.Crashes[0].RolledBack || report.Crashes[1].RolledBack || report.Crashes[0].ConfigHash == xrayConfigHash(good) {
+		t.Fatalf("unexpected crash history %+v", report.Crashes)
+	}
+}
+
+func TestXrayInvalidConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Xray binary is a shell script")
+	}
+	setupTestDB(t)
+	t.Setenv("XUI_BIN_FOLDER", t.TempDir())
+
+	// Xray that runs until it is stopped
+	script := "#!/bin/sh\n[ \"$1\" = -version ] && echo 'Xray 1.0.0' && exit 0\nexec sleep 60\n"
+	if err := os.WriteFile(xray.GetBinaryPath(), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	good := &xray.Config{LogConfig: []byte(`{"loglevel":"none"}`)}
+	data, _ := json.Marshal(good)
+	if err := os.WriteFile(xray.GetLastGoodConfigPath(), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	supervisor.recorded, supervisor.lastCrash, supervisor.crashes, supervisor.tripped = nil, nil, 0, false
+	supervisor.goodHash, supervisor.goodLoaded, supervisor.badHash = "", false, ""
+	defer func() {
+		if p != nil {
+			p.Stop()
+		}
+		p = nil
+	}()
+
+	settings := &SettingService{}
+	invalid := strings.Replace(xrayTemplateConfig, `"outboundTag": "blocked"`, `"outboundTag": "missing"`, 1)
+	if invalid == xrayTemplateConfig {
+		t.Fatal("expected a routing rule to the blocked outbound in the template")
+	}
+	if err := settings.saveSetting("xrayTemplateConfig", invalid); err != nil {
+		t.Fatal(err)
+	}
+	s := &XrayService{}
+	if err := s.RestartXray(true); err != nil || !s.IsXrayRunning() {
+		t.Fatalf("expected a forced start with an invalid config (%v)", err)
+	}
+
+	if err := settings.saveSetting("xrayTemplateConfig", xrayTemplateConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestartXray(true); err != nil {
+		t.Fatal(err)
+	}
+	running := p
+	if err := settings.saveSetting("xrayTemplateConfig", invalid); err != nil {
+		t.Fatal(err)
+	}
+	var configErrors XrayConfigErrors
+	if err := s.RestartXray(false); !errors.As(err, &configErrors) || p != running {
+		t.Fatalf("expected the running Xray to be kept, got %v", err)
+	}
+
+	// a crashed Xray with an invalid config is rolled back without waiting for a crash loop
+	p.Stop()
+	rolledBack, err := s.RestartCrashedXray()
+	if err != nil || !rolledBack || s.GetXraySupervisorStatus().ConfigHash != xrayConfigHash(good) {
+		t.Fatalf("expected a rollback (%v)", err)
+	}
+}
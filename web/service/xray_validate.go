@@ -0,0 +1,292 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// XrayConfigError is a problem with one field of the generated Xray config.
+type XrayConfigError struct {
+	Field   string `json:"field"` // e.g. inbounds[inbound-443].streamSettings.realitySettings.privateKey
+	Message string `json:"message"`
+}
+
+// XrayConfigErrors is the error of an invalid generated Xray config, listing all its problems.
+type XrayConfigErrors []XrayConfigError
+
+func (e XrayConfigErrors) Error() string {
+	items := make([]string, len(e))
+	for i, item := range e {
+		items[i] = item.Field + ": " + item.Message
+	}
+	return "invalid xray config: " + strings.Join(items, "; ")
+}
+
+// ValidateXrayConfig generates the Xray config from the template and the database and checks it
+// like RestartXray does before it replaces the running Xray.
+func (s *XrayService) ValidateXrayConfig() error {
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+	if errs := validateXrayConfig(xrayConfig); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateXrayConfig checks a generated Xray config in-process: inbounds and outbounds are built
+// with the config builders of xray-core, and the config is checked for duplicate tags, inbounds
+// that listen on the same port, routing rules to unknown outbounds and invalid REALITY keys.
+func validateXrayConfig(config *xray.Config) (errs XrayConfigErrors) {
+	xray.WithConfBuilders(func() {
+		errs = checkXrayConfig(config)
+	})
+	return errs
+}
+
+func checkXrayConfig(config *xray.Config) XrayConfigErrors {
+	var errs XrayConfigErrors
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, XrayConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	inboundTags := make(map[string]bool)
+	for i := range config.InboundConfigs {
+		inbound := &config.InboundConfigs[i]
+		field := configItemField("inbounds", i, inbound.Tag)
+		if inbound.Tag != "" {
+			if inboundTags[inbound.Tag] {
+				add(field+".tag", "duplicate inbound tag %q", inbound.Tag)
+			}
+			inboundTags[inbound.Tag] = true
+		}
+		for j := range i {
+			if other := &config.InboundConfigs[j]; inboundsConflict(inbound, other) {
+				add(field+".port", "port %d is already used by %s", inbound.Port, configItemField("inbounds", j, other.Tag))
+			}
+		}
+		if reality := checkRealityKeys(inbound.StreamSettings); len(reality) > 0 {
+			for _, key := range slices.Sorted(maps.Keys(reality)) {
+				add(field+".streamSettings.realitySettings."+key, "%s", reality[key])
+			}
+			continue
+		}
+		data, err := json.Marshal(inbound)
+		if err != nil {
+			add(field, "%v", err)
+			continue
+		}
+		detour := &conf.InboundDetourConfig{}
+		if err := json.Unmarshal(data, detour); err != nil {
+			add(field, "%v", err)
+			continue
+		}
+		if _, err := detour.Build(); err != nil && !isUnknownProtocol(err) {
+			add(field, "%v", err)
+		}
+	}
+
+	// The API and reverse portals can be routed to like outbounds
+	outboundTags := make(map[string]bool)
+	var api struct {
+		Tag string `json:"tag"`
+	}
+	if json.Unmarshal(config.API, &api) == nil && api.Tag != "" {
+		outboundTags[api.Tag] = true
+	}
+	var reverse struct {
+		Portals []struct {
+			Tag string `json:"tag"`
+		} `json:"portals"`
+	}
+	if json.Unmarshal(config.Reverse, &reverse) == nil {
+		for _, portal := range reverse.Portals {
+			outboundTags[portal.Tag] = true
+		}
+	}
+
+	var outbounds []json.RawMessage
+	if len(config.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(config.OutboundConfigs, &outbounds); err != nil {
+			add("outbounds", "%v", err)
+		}
+	}
+	seenOutbounds := make(map[string]bool)
+	for i, data := range outbounds {
+		detour := &conf.OutboundDetourConfig{}
+		if err := json.Unmarshal(data, detour); err != nil {
+			add(configItemField("outbounds", i, ""), "%v", err)
+			continue
+		}
+		field := configItemField("outbounds", i, detour.Tag)
+		if detour.Tag != "" {
+			if seenOutbounds[detour.Tag] {
+				add(field+".tag", "duplicate outbound tag %q", detour.Tag)
+			}
+			seenOutbounds[detour.Tag] = true
+			outboundTags[detour.Tag] = true
+		}
+		if _, err := detour.Build(); err != nil && !isUnknownProtocol(err) {
+			add(field, "%v", err)
+		}
+	}
+
+	var routing struct {
+		Rules []struct {
+			OutboundTag string `json:"outboundTag"`
+			BalancerTag string `json:"balancerTag"`
+		} `json:"rules"`
+		Balancers []struct {
+			Tag string `json:"tag"`
+		} `json:"balancers"`
+	}
+	if len(config.RouterConfig) > 0 {
+		if err := json.Unmarshal(config.RouterConfig, &routing); err != nil {
+			add("routing", "%v", err)
+		}
+	}
+	balancerTags := make(map[string]bool)
+	for _, balancer := range routing.Balancers {
+		balancerTags[balancer.Tag] = true
+	}
+	for i, rule := range routing.Rules {
+		field := "routing.rules[" + strconv.Itoa(i) + "]"
+		if rule.OutboundTag != "" && !outboundTags[rule.OutboundTag] {
+			add(field+".outboundTag", "unknown outbound tag %q", rule.OutboundTag)
+		}
+		if rule.BalancerTag != "" && !balancerTags[rule.BalancerTag] {
+			add(field+".balancerTag", "unknown balancer tag %q", rule.BalancerTag)
+		}
+	}
+	return errs
+}
+
+// configItemField names an inbound or outbound in a field path by its tag, or by its index if it
+// has none.
+func configItemField(section string, index int, tag string) string {
+	if tag != "" {
+		return section + "[" + tag + "]"
+	}
+	return section + "[" + strconv.Itoa(index) + "]"
+}
+
+// isUnknownProtocol reports whether a build error is about a protocol or transport unknown to the
+// builders linked into the panel. The installed Xray may be newer than them, so these are left to it.
+func isUnknownProtocol(err error) bool {
+	return strings.Contains(err.Error(), "unknown config id")
+}
+
+// checkRealityKeys checks the keys of the REALITY settings of an inbound, returning the problems
+// by settings key.
+func checkRealityKeys(streamSettings []byte) map[string]string {
+	var stream struct {
+		Security        string `json:"security"`
+		RealitySettings struct {
+			PrivateKey string   `json:"privateKey"`
+			ShortIds   []string `json:"shortIds"`
+		} `json:"realitySettings"`
+	}
+	if len(streamSettings) == 0 || json.Unmarshal(streamSettings, &stream) != nil || stream.Security != "reality" {
+		return nil
+	}
+	problems := make(map[string]string)
+	key, err := base64.RawURLEncoding.DecodeString(stream.RealitySettings.PrivateKey)
+	if err != nil || len(key) != 32 {
+		problems["privateKey"] = "must be a base64url-encoded X25519 private key of 32 bytes"
+	}
+	for i, shortId := range stream.RealitySettings.ShortIds {
+		if _, err := hex.DecodeString(shortId); err != nil || len(shortId) > 16 {
+			problems["shortIds["+strconv.Itoa(i)+"]"] = "must be an even number of up to 16 hex digits"
+		}
+	}
+	return problems
+}
+
+// inboundsConflict reports whether two inbounds would listen on the same port and network.
+func inboundsConflict(a *xray.InboundConfig, b *xray.InboundConfig) bool {
+	listenA, listenB := inboundListen(a), inboundListen(b)
+	if isUnixListen(listenA) || isUnixListen(listenB) {
+		return listenA == listenB
+	}
+	if a.Port <= 0 || a.Port != b.Port {
+		return false
+	}
+	if listenA != listenB && !isAnyListen(listenA) && !isAnyListen(listenB) {
+		return false
+	}
+	for network := range inboundNetworks(a) {
+		if inboundNetworks(b)[network] {
+			return true
+		}
+	}
+	return false
+}
+
+// inboundListen returns the listen address of an inbound, empty for all addresses.
+func inboundListen(inbound *xray.InboundConfig) string {
+	var listen string
+	json.Unmarshal(inbound.Listen, &listen)
+	return listen
+}
+
+func isUnixListen(listen string) bool {
+	return strings.HasPrefix(listen, "/") || strings.HasPrefix(listen, "@")
+}
+
+func isAnyListen(listen string) bool {
+	return listen == "" || listen == "0.0.0.0" || listen == "::" || listen == "[::]"
+}
+
+// inboundNetworks returns the networks an inbound listens on. Optional UDP listeners are only
+// counted if they are enabled, so that two inbounds are never reported to conflict when they can
+// share a port.
+func inboundNetworks(inbound *xray.InboundConfig) map[string]bool {
+	var settings struct {
+		Network string `json:"network"`
+		Udp     bool   `json:"udp"`
+	}
+	json.Unmarshal(inbound.Settings, &settings)
+	var stream struct {
+		Network string `json:"network"`
+	}
+	json.Unmarshal(inbound.StreamSettings, &stream)
+
+	networks := make(map[string]bool)
+	switch inbound.Protocol {
+	case "wireguard", "hysteria":
+		networks["udp"] = true
+	case "dokodemo-door", "tunnel", "shadowsocks":
+		for network := range strings.SplitSeq(settings.Network, ",") {
+			if network = strings.TrimSpace(network); network != "" {
+				networks[network] = true
+			}
+		}
+		if len(networks) == 0 {
+			networks["tcp"] = true
+		}
+	case "socks", "mixed":
+		networks["tcp"] = true
+		if settings.Udp {
+			networks["udp"] = true
+		}
+	default:
+		switch stream.Network {
+		case "kcp", "mkcp", "quic":
+			networks["udp"] = true
+		default:
+			networks["tcp"] = true
+		}
+	}
+	return networks
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func TestValidateXrayConfig(t *testing.T) {
+	config := &xray.Config{}
+	if err := json.Unmarshal([]byte(xrayTemplateConfig), config); err != nil {
+		t.Fatalf("parse template: %v", err)
+	}
+	if errs := validateXrayConfig(config); len(errs) != 0 {
+		t.Fatalf("expected the default template to be valid, got %v", errs)
+	}
+
+	vless := func(tag string, listen string, port int, stream string) xray.InboundConfig {
+		inbound := xray.InboundConfig{Port: port, Protocol: "vless", Tag: tag,
+			Settings:       []byte(`{"clients":[{"id":"4b1c5e4e-0c53-4a0e-9f7c-5d1a3e0b6a11","email":"alice"}],"decryption":"none"}`),
+			StreamSettings: []byte(stream)}
+		if listen != "" {
+			inbound.Listen = []byte(`"` + listen + `"`)
+		}
+		return inbound
+	}
+	tcp := `{"network":"tcp","security":"none"}`
+	config.InboundConfigs = append(config.InboundConfigs,
+		vless("in-443", "", 443, tcp),
+		vless("in-443", "1.2.3.4", 443, tcp),
+		vless("in-kcp", "", 443, `{"network":"kcp","security":"none"}`),
+		vless("in-local", "127.0.0.1", 8443, tcp),
+		vless("in-other", "127.0.0.2", 8443, tcp),
+		vless("in-reality", "", 9443, `{"network":"tcp","security":"reality","realitySettings":{"target":"example.com:443","serverNames":["example.com"],"privateKey":"short","shortIds":["abc","0123456789abcdef"]}}`),
+		vless("in-unix", "@fallback", 0, tcp),
+		vless("in-unix-2", "@fallback", 0, tcp),
+		xray.InboundConfig{Port: 9000, Protocol: "vless", Tag: "in-bad", Settings: []byte(`{"clients":[{"id":"x","flow":"nope"}]}`), StreamSettings: []byte(tcp)},
+		xray.InboundConfig{Port: 9001, Protocol: "future-protocol", Tag: "in-future", Settings: []byte(`{}`)},
+	)
+	var routing map[string]any
+	json.Unmarshal(config.RouterConfig, &routing)
+	routing["rules"] = append(routing["rules"].([]any), map[string]any{"type": "field", "outboundTag": "missing"}, map[string]any{"type": "field", "balancerTag": "nobalancer"})
+	config.RouterConfig, _ = json.Marshal(routing)
+
+	errs := validateXrayConfig(config)
+	fields := make([]string, len(errs))
+	for i, err := range errs {
+		fields[i] = err.Field
+	}
+	expected := []string{
+		"inbounds[in-443].tag",
+		"inbounds[in-443].port",
+		"inbounds[in-reality].streamSettings.realitySettings.privateKey",
+		"inbounds[in-reality].streamSettings.realitySettings.shortIds[0]",
+		"inbounds[in-unix-2].port",
+		"inbounds[in-bad]",
+		"routing.rules[3].outboundTag",
+		"routing.rules[4].balancerTag",
+	}
+	if strings.Join(fields, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected errors:\n%v", errs)
+	}
+	if !strings.HasPrefix(errs.Error(), "invalid xray config: inbounds[in-443].tag: duplicate inbound tag") {
+		t.Fatalf("unexpected message %q", errs.Error())
+	}
+}
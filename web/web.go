@@ -6,6 +6,7 @@ import (
 	"context"
 	"crypto/tls"
 	"embed"
+	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
@@ -95,9 +96,10 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 
-	index *controller.IndexController
-	panel *controller.XUIController
-	api   *controller.APIController
+	index   *controller.IndexController
+	panel   *controller.XUIController
+	api     *controller.APIController
+	metrics *controller.MetricsController
 
 	xrayService    service.XrayService
 	settingService service.SettingService
@@ -265,6 +267,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.index = controller.NewIndexController(g)
 	s.panel = controller.NewXUIController(g)
 	s.api = controller.NewAPIController(g)
+	s.metrics = controller.NewMetricsController(g)
 
 	// Chrome DevTools endpoint for debugging web apps
 	engine.GET("/.well-known/appspecific/com.chrome.devtools.json", func(c *gin.Context) {
@@ -279,6 +282,21 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	return engine, nil
 }
 
+// observeJob wraps scheduled jobs to record their run durations for the metrics endpoint.
+func observeJob(j cron.Job) cron.Job {
+	name := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", j), "*job."), "Job")
+	if _, ok := j.(cron.FuncJob); ok {
+		name = "func"
+	}
+	return cron.FuncJob(func() {
+		start := time.Now()
+		defer func() {
+			service.ObserveJobRun(name, time.Since(start))
+		}()
+		j.Run()
+	})
+}
+
 // startTask schedules background jobs (Xray checks, traffic jobs, cron
 // jobs) which the panel relies on for periodic maintenance and monitoring.
 func (s *Server) startTask() {
@@ -305,12 +323,36 @@ func (s *Server) startTask() {
 		s.cron.AddJob("@every 10s", job.NewXrayTrafficJob())
 	}()
 
+	// roll up the traffic history into hourly and daily buckets
+	s.cron.AddJob("@every 5m", job.NewTrafficHistoryJob())
+
+	// retry failed webhook deliveries every 10 sec
+	s.cron.AddJob("@every 10s", job.NewWebhookJob())
+
 	// check client ips from log file every 10 sec
 	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
 
+	// store client connections from the access log every 10 sec
+	s.cron.AddJob("@every 10s", job.NewConnectionLogJob())
+
 	// check client ips from log file every day
 	s.cron.AddJob("@daily", job.NewClearLogsJob())
 
+	// drop audit log entries past the retention period every day
+	s.cron.AddJob("@daily", job.NewAuditCleanJob())
+
+	// drop subscription access log entries past the retention period every day
+	s.cron.AddJob("@daily", job.NewSubAccessCleanJob())
+
+	// drop client connections past the retention period or record limit every 10 min
+	s.cron.AddJob("@every 10m", job.NewConnectionLogCleanJob())
+
+	// lift IP bans past the ban duration every minute
+	s.cron.AddJob("@every 1m", job.NewIpBanCleanJob())
+
+	// drop rotated subscription IDs past their grace period every hour
+	s.cron.AddJob("@hourly", job.NewSubTokenCleanJob())
+
 	// Inbound traffic reset jobs
 	// Run once a day, midnight
 	s.cron.AddJob("@daily", job.NewPeriodicTrafficResetJob("daily"))
@@ -372,7 +414,7 @@ func (s *Server) Start() (err error) {
 	if err != nil {
 		return err
 	}
-	s.cron = cron.New(cron.WithLocation(loc), cron.WithSeconds())
+	s.cron = cron.New(cron.WithLocation(loc), cron.WithSeconds(), cron.WithChain(observeJob))
 	s.cron.Start()
 
 	engine, err := s.initRouter()
@@ -9,12 +9,14 @@ import (
 	"fmt"
 	"math"
 	"regexp"
+	"slices"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 
 	"github.com/xtls/xray-core/app/proxyman/command"
+	routerService "github.com/xtls/xray-core/app/router/command"
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
@@ -26,17 +28,19 @@ import (
 	"github.com/xtls/xray-core/proxy/vmess"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
 )
 
 // XrayAPI is a gRPC client for managing Xray core configuration, inbounds, outbounds, and statistics.
 type XrayAPI struct {
 	HandlerServiceClient *command.HandlerServiceClient
+	RoutingServiceClient *routerService.RoutingServiceClient
 	StatsServiceClient   *statsService.StatsServiceClient
 	grpcClient           *grpc.ClientConn
 	isConnected          bool
 }
 
-// Init connects to the Xray API server and initializes handler and stats service clients.
+// Init connects to the Xray API server and initializes handler, routing and stats service clients.
 func (x *XrayAPI) Init(apiPort int) error {
 	if apiPort <= 0 || apiPort > math.MaxUint16 {
 		return fmt.Errorf("invalid Xray API port: %d", apiPort)
@@ -52,9 +56,11 @@ func (x *XrayAPI) Init(apiPort int) error {
 	x.isConnected = true
 
 	hsClient := command.NewHandlerServiceClient(conn)
+	rsClient := routerService.NewRoutingServiceClient(conn)
 	ssClient := statsService.NewStatsServiceClient(conn)
 
 	x.HandlerServiceClient = &hsClient
+	x.RoutingServiceClient = &rsClient
 	x.StatsServiceClient = &ssClient
 
 	return nil
@@ -66,6 +72,7 @@ func (x *XrayAPI) Close() {
 		x.grpcClient.Close()
 	}
 	x.HandlerServiceClient = nil
+	x.RoutingServiceClient = nil
 	x.StatsServiceClient = nil
 	x.isConnected = false
 }
@@ -101,6 +108,115 @@ func (x *XrayAPI) DelInbound(tag string) error {
 	return err
 }
 
+// AddOutbound adds a new outbound configuration to the Xray core via gRPC.
+func (x *XrayAPI) AddOutbound(outbound []byte) error {
+	client := *x.HandlerServiceClient
+
+	conf := new(conf.OutboundDetourConfig)
+	err := json.Unmarshal(outbound, conf)
+	if err != nil {
+		logger.Debug("Failed to unmarshal outbound:", err)
+		return err
+	}
+	config, err := conf.Build()
+	if err != nil {
+		logger.Debug("Failed to build outbound Detour:", err)
+		return err
+	}
+
+	_, err = client.AddOutbound(context.Background(), &command.AddOutboundRequest{Outbound: config})
+	return err
+}
+
+// DelOutbound removes an outbound configuration from the Xray core by tag.
+func (x *XrayAPI) DelOutbound(tag string) error {
+	client := *x.HandlerServiceClient
+	_, err := client.RemoveOutbound(context.Background(), &command.RemoveOutboundRequest{
+		Tag: tag,
+	})
+	return err
+}
+
+// SetRouting replaces the routing rules and balancers of the Xray core with those of a routing
+// configuration. The domain strategy of the running router is kept.
+func (x *XrayAPI) SetRouting(routing []byte) error {
+	client := *x.RoutingServiceClient
+
+	routerConfig := new(conf.RouterConfig)
+	err := json.Unmarshal(routing, routerConfig)
+	if err != nil {
+		logger.Debug("Failed to unmarshal routing:", err)
+		return err
+	}
+	config, err := buildRouting(routerConfig)
+	if err != nil {
+		logger.Debug("Failed to build routing:", err)
+		return err
+	}
+	message := serial.ToTypedMessage(config)
+	if size := proto.Size(message); size > maxRoutingSize {
+		return fmt.Errorf("routing of %d bytes is too large for the Xray API", size)
+	}
+
+	_, err = client.AddRule(context.Background(), &routerService.AddRuleRequest{
+		Config:       message,
+		ShouldAppend: false,
+	})
+	return err
+}
+
+// ApplyConfigDiff applies the changes of a configuration diff to the Xray core. Inbounds are
+// removed first, so that changed ones can listen on their ports again, and removed outbounds are
+// removed last, after the routing no longer uses them. Every inbound and outbound that is added
+// is removed first and errors of removals are ignored, since clients and inbounds may have been
+// added or removed through the API since the running configuration was written.
+func (x *XrayAPI) ApplyConfigDiff(diff *ConfigDiff) error {
+	for _, tag := range diff.RemovedInbounds {
+		x.delIgnoringError("inbound", tag, x.DelInbound)
+	}
+	for _, inbound := range diff.AddedInbounds {
+		if !slices.Contains(diff.RemovedInbounds, inbound.Tag) {
+			x.delIgnoringError("inbound", inbound.Tag, x.DelInbound)
+		}
+	}
+	added := make(map[string]bool, len(diff.AddedOutbounds))
+	for _, outbound := range diff.AddedOutbounds {
+		tag := outboundTag(outbound)
+		added[tag] = true
+		x.delIgnoringError("outbound", tag, x.DelOutbound)
+		if err := x.AddOutbound(outbound); err != nil {
+			return fmt.Errorf("failed to add outbound %s: %w", tag, err)
+		}
+	}
+	if diff.Routing != nil {
+		if err := x.SetRouting(diff.Routing); err != nil {
+			return fmt.Errorf("failed to replace routing rules: %w", err)
+		}
+	}
+	for _, tag := range diff.RemovedOutbounds {
+		if !added[tag] {
+			x.delIgnoringError("outbound", tag, x.DelOutbound)
+		}
+	}
+	for _, inbound := range diff.AddedInbounds {
+		data, err := json.Marshal(inbound)
+		if err != nil {
+			return err
+		}
+		if err := x.AddInbound(data); err != nil {
+			return fmt.Errorf("failed to add inbound %s: %w", inbound.Tag, err)
+		}
+	}
+	return nil
+}
+
+// delIgnoringError removes an inbound or outbound, only logging an error.
+func (x *XrayAPI) delIgnoringError(kind string, tag string, del func(string) error) {
+	if err := del(tag); err != nil {
+		logger.Debug("Failed to remove", kind, tag+":", err)
+	}
+}
+
 // AddUser adds a user to an inbound in the Xray core using the specified protocol and user data.
 func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]any) error {
 	var account *serial.TypedMessage
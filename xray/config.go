@@ -2,6 +2,9 @@ package xray
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
 )
@@ -25,6 +28,16 @@ type Config struct {
 	Metrics          json_util.RawMessage `json:"metrics"`
 }
 
+// Hash returns the hex SHA-256 of the JSON of the configuration, which identifies it in crash reports.
+func (c *Config) Hash() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 // Equals compares two Config instances for deep equality.
 func (c *Config) Equals(other *Config) bool {
 	if len(c.InboundConfigs) != len(other.InboundConfigs) {
@@ -0,0 +1,269 @@
+package xray
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// maxRoutingSize is the largest routing message sent to the Xray API, below the 4 MiB that
+// gRPC servers receive by default. Routing that expands large geo lists is applied by a restart.
+const maxRoutingSize = 4<<20 - 64<<10
+
+// ConfigDiff lists the changes between two configurations that can be applied to a running
+// Xray through its API. A changed inbound or outbound is removed and added again.
+type ConfigDiff struct {
+	RemovedInbounds  []string               // Tags of the inbounds to remove
+	AddedInbounds    []InboundConfig        // Inbounds to add
+	RemovedOutbounds []string               // Tags of the outbounds to remove
+	AddedOutbounds   []json_util.RawMessage // Outbounds to add
+	Routing          json_util.RawMessage   // Routing whose rules and balancers replace the running ones, nil if unchanged
+}
+
+// IsEmpty reports whether the diff has no changes.
+func (d *ConfigDiff) IsEmpty() bool {
+	return len(d.RemovedInbounds) == 0 && len(d.AddedInbounds) == 0 &&
+		len(d.RemovedOutbounds) == 0 && len(d.AddedOutbounds) == 0 && d.Routing == nil
+}
+
+// Diff compares the configuration of a running Xray with a new one and returns the changes that
+// turn one into the other through the API. It returns an error describing the first change that
+// needs a restart instead: changes outside the inbounds, outbounds and routing rules, changes to
+// the API inbound or the default outbound, untagged inbounds or outbounds, or changes that need
+// an API service that is not enabled.
+func (c *Config) Diff(other *Config) (*ConfigDiff, error) {
+	sections := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"log", c.LogConfig, other.LogConfig},
+		{"dns", c.DNSConfig, other.DNSConfig},
+		{"transport", c.Transport, other.Transport},
+		{"policy", c.Policy, other.Policy},
+		{"api", c.API, other.API},
+		{"stats", c.Stats, other.Stats},
+		{"reverse", c.Reverse, other.Reverse},
+		{"fakedns", c.FakeDNS, other.FakeDNS},
+		{"observatory", c.Observatory, other.Observatory},
+		{"burstObservatory", c.BurstObservatory, other.BurstObservatory},
+		{"metrics", c.Metrics, other.Metrics},
+	}
+	for _, section := range sections {
+		if !bytes.Equal(section.old, section.new) {
+			return nil, common.NewErrorf("the %s config changed", section.name)
+		}
+	}
+
+	var api struct {
+		Tag      string   `json:"tag"`
+		Services []string `json:"services"`
+	}
+	json.Unmarshal(c.API, &api)
+	diff := &ConfigDiff{}
+
+	oldInbounds, err := inboundsByTag(c.InboundConfigs)
+	if err != nil {
+		return nil, err
+	}
+	newInbounds, err := inboundsByTag(other.InboundConfigs)
+	if err != nil {
+		return nil, err
+	}
+	for tag, inbound := range oldInbounds {
+		if newInbound, ok := newInbounds[tag]; !ok || !inbound.Equals(newInbound) {
+			diff.RemovedInbounds = append(diff.RemovedInbounds, tag)
+		}
+	}
+	for _, inbound := range other.InboundConfigs {
+		if oldInbound, ok := oldInbounds[inbound.Tag]; !ok || !inbound.Equals(oldInbound) {
+			diff.AddedInbounds = append(diff.AddedInbounds, inbound)
+		}
+	}
+	if api.Tag != "" {
+		oldAPI, newAPI := oldInbounds[api.Tag], newInbounds[api.Tag]
+		if (oldAPI == nil) != (newAPI == nil) || oldAPI != nil && !oldAPI.Equals(newAPI) {
+			return nil, common.NewError("the API inbound changed")
+		}
+	}
+	slices.Sort(diff.RemovedInbounds)
+
+	if !bytes.Equal(c.OutboundConfigs, other.OutboundConfigs) {
+		oldOutbounds, oldDefault, err := outboundsByTag(c.OutboundConfigs)
+		if err != nil {
+			return nil, err
+		}
+		newOutbounds, newDefault, err := outboundsByTag(other.OutboundConfigs)
+		if err != nil {
+			return nil, err
+		}
+		if oldDefault != newDefault || !bytes.Equal(oldOutbounds[oldDefault], newOutbounds[newDefault]) {
+			return nil, common.NewError("the default outbound changed")
+		}
+		for tag, outbound := range oldOutbounds {
+			if newOutbound, ok := newOutbounds[tag]; !ok || !bytes.Equal(outbound, newOutbound) {
+				diff.RemovedOutbounds = append(diff.RemovedOutbounds, tag)
+			}
+		}
+		var outbounds []json_util.RawMessage
+		json.Unmarshal(other.OutboundConfigs, &outbounds)
+		for _, outbound := range outbounds {
+			tag := outboundTag(outbound)
+			if oldOutbound, ok := oldOutbounds[tag]; !ok || !bytes.Equal(compactJSON(outbound), oldOutbound) {
+				diff.AddedOutbounds = append(diff.AddedOutbounds, outbound)
+			}
+		}
+		slices.Sort(diff.RemovedOutbounds)
+	}
+
+	if !bytes.Equal(c.RouterConfig, other.RouterConfig) {
+		oldRouter, oldRules, err := splitRouting(c.RouterConfig)
+		if err != nil {
+			return nil, err
+		}
+		newRouter, newRules, err := splitRouting(other.RouterConfig)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(oldRouter, newRouter) {
+			return nil, common.NewError("the routing settings changed")
+		}
+		if !bytes.Equal(oldRules, newRules) {
+			if !slices.Contains(api.Services, "RoutingService") {
+				return nil, common.NewError("the routing rules changed and the RoutingService API is not enabled")
+			}
+			diff.Routing = other.RouterConfig
+		}
+	}
+
+	changesHandlers := len(diff.RemovedInbounds) > 0 || len(diff.AddedInbounds) > 0 ||
+		len(diff.RemovedOutbounds) > 0 || len(diff.AddedOutbounds) > 0
+	if changesHandlers && !slices.Contains(api.Services, "HandlerService") {
+		return nil, common.NewError("the inbounds or outbounds changed and the HandlerService API is not enabled")
+	}
+	return diff, nil
+}
+
+// inboundsByTag maps inbounds by their tags, which must be set and unique.
+func inboundsByTag(inbounds []InboundConfig) (map[string]*InboundConfig, error) {
+	byTag := make(map[string]*InboundConfig, len(inbounds))
+	for i := range inbounds {
+		tag := inbounds[i].Tag
+		if tag == "" {
+			return nil, common.NewError("an inbound has no tag")
+		}
+		if byTag[tag] != nil {
+			return nil, common.NewErrorf("the inbound tag %q is not unique", tag)
+		}
+		byTag[tag] = &inbounds[i]
+	}
+	return byTag, nil
+}
+
+// outboundsByTag maps the compacted outbounds by their tags, which must be set and unique, and
+// returns the tag of the first outbound, which Xray uses by default.
+func outboundsByTag(data []byte) (map[string]json_util.RawMessage, string, error) {
+	var outbounds []json_util.RawMessage
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &outbounds); err != nil {
+			return nil, "", err
+		}
+	}
+	byTag := make(map[string]json_util.RawMessage, len(outbounds))
+	defaultTag := ""
+	for i, outbound := range outbounds {
+		tag := outboundTag(outbound)
+		if tag == "" {
+			return nil, "", common.NewError("an outbound has no tag")
+		}
+		if byTag[tag] != nil {
+			return nil, "", common.NewErrorf("the outbound tag %q is not unique", tag)
+		}
+		byTag[tag] = compactJSON(outbound)
+		if i == 0 {
+			defaultTag = tag
+		}
+	}
+	return byTag, defaultTag, nil
+}
+
+// compactJSON removes the insignificant space of JSON, so that differently formatted outbounds compare equal.
+func compactJSON(data []byte) []byte {
+	var buffer bytes.Buffer
+	if json.Compact(&buffer, data) != nil {
+		return data
+	}
+	return buffer.Bytes()
+}
+
+func outboundTag(outbound []byte) string {
+	var item struct {
+		Tag string `json:"tag"`
+	}
+	json.Unmarshal(outbound, &item)
+	return item.Tag
+}
+
+// splitRouting splits a routing configuration into its settings and its rules and balancers,
+// which the API can replace.
+func splitRouting(data []byte) ([]byte, []byte, error) {
+	routing := make(map[string]json_util.RawMessage)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &routing); err != nil {
+			return nil, nil, err
+		}
+	}
+	rules, err := json.Marshal(map[string]json_util.RawMessage{
+		"rules":     routing["rules"],
+		"balancers": routing["balancers"],
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	delete(routing, "rules")
+	delete(routing, "balancers")
+	settings, err := json.Marshal(routing)
+	if err != nil {
+		return nil, nil, err
+	}
+	return settings, rules, nil
+}
+
+// confBuilders serializes the use of the xray-core config builders, which share process-wide
+// state: the asset location and the geo data caches.
+var confBuilders sync.Mutex
+
+// WithConfBuilders runs fn while no other goroutine of the panel uses the xray-core config builders.
+func WithConfBuilders(fn func()) {
+	confBuilders.Lock()
+	defer confBuilders.Unlock()
+	fn()
+}
+
+// buildRouting builds a routing configuration like Xray does, reading geo data from the folder of
+// the Xray binary. The asset location and the geo data caches of the builders are restored
+// afterwards, so the panel does not keep the geo files in memory and reads them again after they
+// are updated.
+func buildRouting(routing *conf.RouterConfig) (built *router.Config, err error) {
+	WithConfBuilders(func() {
+		if os.Getenv("XRAY_LOCATION_ASSET") == "" && os.Getenv("xray.location.asset") == "" {
+			os.Setenv("XRAY_LOCATION_ASSET", config.GetBinFolderPath())
+			defer os.Unsetenv("XRAY_LOCATION_ASSET")
+		}
+		defer func() {
+			conf.FileCache = make(map[string][]byte)
+			conf.IPCache = make(map[string]*router.GeoIP)
+			conf.SiteCache = make(map[string]*router.GeoSite)
+		}()
+		built, err = routing.Build()
+	})
+	return built, err
+}
@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
 )
@@ -13,9 +14,36 @@ func NewLogWriter() *LogWriter {
 	return &LogWriter{}
 }
 
+// logWriterKeptLines is how many of the last output lines a LogWriter keeps for crash reports.
+const logWriterKeptLines = 30
+
 // LogWriter processes and filters log output from the Xray process, handling crash detection and message filtering.
 type LogWriter struct {
 	lastLine string
+
+	mu        sync.Mutex
+	lastLines []string
+}
+
+// LastLines returns the last lines the Xray process wrote, oldest first.
+func (lw *LogWriter) LastLines() []string {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return append([]string(nil), lw.lastLines...)
+}
+
+// keepLines adds output lines to the last lines, dropping the oldest ones.
+func (lw *LogWriter) keepLines(message string) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	for line := range strings.SplitSeq(message, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lw.lastLines = append(lw.lastLines, line)
+		}
+	}
+	if extra := len(lw.lastLines) - logWriterKeptLines; extra > 0 {
+		lw.lastLines = append(lw.lastLines[:0], lw.lastLines[extra:]...)
+	}
 }
 
 // Write processes and filters log output from the Xray process, handling crash detection and message filtering.
@@ -30,15 +58,12 @@ func (lw *LogWriter) Write(m []byte) (n int, err error) {
 	if runtime.GOOS == "windows" && strings.Contains(msgLowerAll, "exit status 1") {
 		return len(m), nil
 	}
+	lw.keepLines(message)
 
-	// Check if the message contains a crash
+	// Check if the message contains a crash, the supervisor records it with the last lines
 	if crashRegex.MatchString(message) {
 		logger.Debug("Core crash detected:\n", message)
 		lw.lastLine = message
-		err1 := writeCrashReport(m)
-		if err1 != nil {
-			logger.Error("Unable to write crash report:", err1)
-		}
 		return len(m), nil
 	}
 
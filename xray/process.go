@@ -33,6 +33,12 @@ func GetConfigPath() string {
 	return config.GetBinFolderPath() + "/config.json"
 }
 
+// GetLastGoodConfigPath returns the path to the copy of the last Xray configuration that ran stable,
+// which the supervisor rolls back to when a new configuration crash-loops.
+func GetLastGoodConfigPath() string {
+	return config.GetBinFolderPath() + "/config.good.json"
+}
+
 // GetGeositePath returns the path to the geosite data file used by Xray.
 func GetGeositePath() string {
 	return config.GetBinFolderPath() + "/geosite.dat"
@@ -158,6 +164,20 @@ func (p *process) GetResult() string {
 	return p.logWriter.lastLine
 }
 
+// GetExitCode returns the exit code of the Xray process, or -1 if it is still running or was
+// terminated by a signal.
+func (p *process) GetExitCode() int {
+	if p.cmd == nil || p.cmd.ProcessState == nil {
+		return -1
+	}
+	return p.cmd.ProcessState.ExitCode()
+}
+
+// GetLastLines returns the last lines the Xray process wrote, oldest first.
+func (p *process) GetLastLines() []string {
+	return p.logWriter.LastLines()
+}
+
 // GetVersion returns the version string of the Xray process.
 func (p *process) GetVersion() string {
 	return p.version
@@ -173,6 +193,21 @@ func (p *Process) GetConfig() *Config {
 	return p.config
 }
 
+// SetConfig replaces the configuration of a running Xray process after it was applied through the
+// API, and writes it to the configuration file like Start does.
+func (p *Process) SetConfig(xrayConfig *Config) error {
+	data, err := json.MarshalIndent(xrayConfig, "", "  ")
+	if err != nil {
+		return common.NewErrorf("Failed to generate XRAY configuration files: %v", err)
+	}
+	p.config = xrayConfig
+	err = os.WriteFile(GetConfigPath(), data, fs.ModePerm)
+	if err != nil {
+		return common.NewErrorf("Failed to write configuration file: %v", err)
+	}
+	return nil
+}
+
 // GetOnlineClients returns the list of online clients for the Xray process.
 func (p *Process) GetOnlineClients() []string {
 	return p.onlineClients
@@ -284,9 +319,3 @@ func (p *process) Stop() error {
 		return p.cmd.Process.Signal(syscall.SIGTERM)
 	}
 }
-
-// writeCrashReport writes a crash report to the binary folder with a timestamped filename.
-func writeCrashReport(m []byte) error {
-	crashReportPath := config.GetBinFolderPath() + "/core_crash_" + time.Now().Format("20060102_150405") + ".log"
-	return os.WriteFile(crashReportPath, m, os.ModePerm)
-}